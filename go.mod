@@ -3,17 +3,21 @@ module github.com/rafaelespinoza/notexfr
 go 1.23.7
 
 require (
+	filippo.io/age v1.2.1
+	github.com/apache/thrift v0.13.0
 	github.com/dreampuf/evernote-sdk-golang v0.0.0-20200205091351-d2ad936dfa1c
 	github.com/google/uuid v1.1.1
 	github.com/joho/godotenv v1.3.0
 	github.com/macrat/go-enex v0.0.0-20190325124011-11ac7b8c8c4c
+	github.com/mrjones/oauth v0.0.0-20180629183705-f4e24b6d100c
 	github.com/spf13/cobra v1.8.1
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.37.0
 	golang.org/x/net v0.39.0
+	golang.org/x/term v0.31.0
 )
 
 require (
-	github.com/apache/thrift v0.13.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/mrjones/oauth v0.0.0-20180629183705-f4e24b6d100c // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.32.0 // indirect
 )
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
 
 	"github.com/rafaelespinoza/notexfr/internal/interactor"
@@ -37,19 +39,49 @@ Evernote using the EDAM API and save the results to local JSON files:
 --input-en-notes=<output of "edam notes">
 --input-en-tags=<output of "edam tags">
 
+Alternatively, pass the output of "edam fetch-all" instead of those three:
+
+--input-en-bundle=<output directory of "edam fetch-all">
+
 The input flag --input-sn is a StandardNotes export file. For example, the
 one used to initally import your data from Evernote.
 
-Results are written to new files where you can inspect them yourself.`,
+Results are written to new files where you can inspect them yourself. With
+--ledger, the Evernote ID and StandardNotes UUID of each backfilled note are
+also recorded in a ledger file, see "convert --help". Notes that haven't
+changed since they were recorded are left out, and notes in the ledger that
+weren't backfilled are logged as orphans.
+
+With --dry-run, nothing is written but a diff of the backfilled notes against
+the --input-sn file, showing the references each note would gain.
+
+With --output-notebooks, each Evernote notebook is also written as a tag that
+refers to its backfilled notes.
+
+` + collisionsFlagHelp + ` Here, a collision is a notebook with
+the same name as a tag in the --input-sn file. With merge, notes refer to that
+tag instead of the notebook. With prefix or nest, the notebook tags from
+--output-notebooks change.
+
+` + filterFlagHelp + `
+
+` + keepGoingFlagsHelp + ` Here, a note fails if it can't be matched,
+such as an item of the wrong kind in an input file.`,
 	}
 	{
 		enToSN.Flags().StringP("input-sn", "", "", "path to StandardNotes data file")
 		enToSN.Flags().StringP("input-en-notebooks", "", "", "path to Evernote notebooks data file")
 		enToSN.Flags().StringP("input-en-notes", "", "", "path to Evernote notes data file")
 		enToSN.Flags().StringP("input-en-tags", "", "", "path to Evernote tags data file")
+		enToSN.Flags().StringP("input-en-bundle", "", "", "path to Evernote bundle directory, instead of the other Evernote input files")
 		enToSN.Flags().StringP("output-notebooks", "", "", "write notebooks json to this file")
 		enToSN.Flags().StringP("output-notes", "", "", "write notes json to this file")
 		enToSN.Flags().StringP("output-tags", "", "", "write tags json to this file")
+		enToSN.Flags().StringP("ledger", "", "", "optional path to ledger file, created if it doesn't exist")
+		enToSN.Flags().StringP("collisions", "", interactor.CollisionPolicies[0], fmt.Sprintf("what to do with a notebook and a tag of the same name, one of %q", interactor.CollisionPolicies))
+		setupDryRunFlags(enToSN.Flags())
+		enToSN.Flags().StringP("filter", "", "", filterFlagUsage)
+		setupKeepGoingFlags(enToSN.Flags())
 
 		enToSN.RunE = func(cmd *cobra.Command, args []string) error {
 			var opts interactor.BackfillParams
@@ -61,9 +93,13 @@ Results are written to new files where you can inspect them yourself.`,
 				{name: "input-en-notebooks", val: &opts.EvernoteFilenames.Notebooks},
 				{name: "input-en-notes", val: &opts.EvernoteFilenames.Notes},
 				{name: "input-en-tags", val: &opts.EvernoteFilenames.Tags},
+				{name: "input-en-bundle", val: &opts.EvernoteBundleDir},
 				{name: "output-notebooks", val: &opts.OutputFilenames.Notebooks},
 				{name: "output-notes", val: &opts.OutputFilenames.Notes},
 				{name: "output-tags", val: &opts.OutputFilenames.Tags},
+				{name: "ledger", val: &opts.LedgerFilename},
+				{name: "filter", val: &opts.Filter},
+				{name: "collisions", val: &opts.CollisionPolicy},
 			}
 			cmdFlags := cmd.Flags()
 			for _, tuple := range tuples {
@@ -73,6 +109,12 @@ Results are written to new files where you can inspect them yourself.`,
 				}
 				*tuple.val = val
 			}
+			if err := getDryRunFlags(cmdFlags, &opts.DryRunParams); err != nil {
+				return err
+			}
+			if err := getKeepGoingFlags(cmdFlags, &opts.KeepGoingParams); err != nil {
+				return err
+			}
 			_, err := interactor.BackfillSN(cmd.Context(), &opts)
 			return err
 		}
@@ -3,14 +3,19 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"strings"
 
+	"github.com/rafaelespinoza/notexfr/internal/interactor"
 	"github.com/rafaelespinoza/notexfr/internal/log"
+	"github.com/rafaelespinoza/notexfr/internal/progress"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
 )
 
 const (
@@ -24,8 +29,28 @@ type Root interface {
 	ExecuteContext(ctx context.Context) error
 }
 
-// New establishes the root comand and its subcommands.
+// root shows a summary of the run after the command, unless it's quiet.
+type root struct {
+	*cobra.Command
+	summarize bool
+	// warnings is the number of warnings from before the run.
+	warnings int
+}
+
+func (r *root) ExecuteContext(ctx context.Context) error {
+	err := r.Command.ExecuteContext(ctx)
+	progress.Stop()
+	if summary := progress.Summarize(); r.summarize && len(summary.Stages) > 0 {
+		summary.Warnings = log.Warnings() - r.warnings
+		_ = summary.WriteText(os.Stderr)
+	}
+	return err
+}
+
+// New establishes the root comand and its subcommands. Progress is displayed
+// on stderr while a command runs, if it's a terminal, followed by a summary.
 func New() Root {
+	r := &root{}
 	out := cobra.Command{
 		Use:   mainName,
 		Short: "main command for " + mainName,
@@ -54,11 +79,19 @@ Currently supported services
 			return err
 		}
 
-		handler, err := newLogHandler(os.Stderr, loggingOff, logLevel, logFormat)
+		// The progress display and the logs share stderr, so the display is
+		// cleared before each log.
+		var display, logs io.Writer = nil, os.Stderr
+		if !loggingOff && term.IsTerminal(int(os.Stderr.Fd())) {
+			display, logs = os.Stderr, progress.Writer(os.Stderr)
+		}
+		handler, err := newLogHandler(logs, loggingOff, logLevel, logFormat)
 		if err != nil {
 			return err
 		}
 		log.Init(handler)
+		progress.Init(display)
+		r.summarize, r.warnings = !loggingOff, log.Warnings()
 		return nil
 	}
 
@@ -67,12 +100,100 @@ Currently supported services
 		makeConvert("convert"),
 		makeEdam("edam"),
 		makeEnex("enex"),
+		makeVerify("verify"),
 		makeVersion("version"),
 	)
 	out.AddGroup(
 		&cobra.Group{ID: dataGroupID, Title: "Data Commands:"},
 	)
-	return &out
+	r.Command = &out
+	return r
+}
+
+// ExitCodeSkipped is the exit code when notes were skipped with --keep-going,
+// but nothing else went wrong.
+const ExitCodeSkipped = 3
+
+// ExitCode is the exit code for the error of Root.ExecuteContext.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	} else if errors.Is(err, interactor.ErrSkipped) {
+		return ExitCodeSkipped
+	}
+	return 1
+}
+
+// filterFlagHelp describes the --filter flag, which selects notes in several
+// commands.
+const filterFlagHelp = `With --filter, only the notes matching an expression are used. Terms are
+separated by whitespace, a note must match all of them, or any of them if the
+first term is any:. Put values with whitespace in double quotes. Start a term
+with - to exclude notes that match it. The terms are:
+
+  notebook:<name>  the name of the notebook of the note
+  stack:<name>     the stack of the notebook of the note
+  tag:<name>       the name of any tag of the note
+  source:<value>   the source attribute of the note, such as web.clip
+  created:<date>   created on or after the date, or before it if excluded
+  updated:<date>   updated on or after the date, or before it if excluded
+  title:<pattern>  a regular expression to find in the title
+
+Names and sources ignore case, and match by prefix when they end in *. Dates
+are like 20200131, 2020-01-31, or relative such as day-7, week, month-1, year.
+For example: --filter 'stack:Sales -tag:draft created:2020-01-01'`
+
+const filterFlagUsage = "expression to select notes, see help"
+
+// rulesFlagHelp describes the --rules flag, for cleaning up tags and notebooks
+// while converting.
+const rulesFlagHelp = `With --rules, a JSON file of rules renames, merges, drops or moves tags and
+notebooks before they're written. Each rule has a "name" to match exactly, or a
+"pattern", which is a regular expression. The first rule to match is applied.
+A rule has a new name in "rename", which may refer to submatches of the
+pattern such as $1, or "drop": true, or a "parent", which is the name of a
+parent tag or the stack of a notebook. Tags or notebooks that end up with the
+same name are merged. For example:
+
+  {
+    "tags": [
+      {"pattern": "(?i)^to-?do$", "rename": "todo"},
+      {"name": "misc", "drop": true},
+      {"name": "Chicago", "parent": "cities"}
+    ],
+    "notebooks": [
+      {"name": "Inbox", "rename": "Unsorted"}
+    ]
+  }`
+
+// collisionsFlagHelp describes the --collisions flag, for notebooks and tags
+// with the same name.
+const collisionsFlagHelp = `Notebooks become tags in StandardNotes, so a notebook and a tag with the same
+name would look alike. Each of these is logged as a warning. With --collisions,
+pick what to do: keep both as they are, prefix the title of the notebook tag
+with "Notebook: ", nest the notebook tag under a tag titled "Notebooks", or
+merge the notebook and the tag into one tag.`
+
+const rulesFlagUsage = "optional path to rules for renaming tags and notebooks, see help"
+
+// keepGoingFlagsHelp describes the --keep-going and --error-report flags, for
+// skipping notes that fail.
+const keepGoingFlagsHelp = `By default, a note that can't be fetched, read or converted stops everything.
+With --keep-going, it's skipped and logged as a warning instead, and the exit
+code is 3 if any notes were skipped. With --error-report, the skipped notes are
+also written to a JSON file, with the ID, title, stage and error of each.`
+
+func setupKeepGoingFlags(flags *pflag.FlagSet) {
+	flags.BoolP("keep-going", "", false, "skip notes that fail, rather than stopping")
+	flags.StringP("error-report", "", "", "optional path to write skipped notes to, for --keep-going")
+}
+
+func getKeepGoingFlags(flags *pflag.FlagSet, out *interactor.KeepGoingParams) (err error) {
+	if out.KeepGoing, err = flags.GetBool("keep-going"); err != nil {
+		return
+	}
+	out.ErrorReportFilename, err = flags.GetString("error-report")
+	return
 }
 
 var (
@@ -14,6 +14,7 @@ const (
 	_FixturesDir       = "../fixtures"
 	_StubNotebooksFile = "edam_notebooks.json"
 	_StubNotesFile     = "edam_notes.json"
+	_StubSearchesFile  = "edam_searches.json"
 	_StubTagsFile      = "edam_tags.json"
 	_StubENEXFile      = "test_export.enex"
 	_StubENtoSNFile    = "evernote-to-sn.txt"
@@ -32,6 +33,7 @@ func TestBackfill(t *testing.T) {
 		outputNotebooks := makeOutputFilenamePrefix(t) + "-notebooks.json"
 		outputNotes := makeOutputFilenamePrefix(t) + "-notes.json"
 		outputTags := makeOutputFilenamePrefix(t) + "-tags.json"
+		errorReport := makeOutputFilenamePrefix(t) + "-errors.json"
 		args := []string{
 			"backfill", "en-to-sn",
 			"--input-en-notebooks", _FixturesDir + "/" + _StubNotebooksFile,
@@ -41,8 +43,15 @@ func TestBackfill(t *testing.T) {
 			"--output-notebooks", outputNotebooks,
 			"--output-notes", outputNotes,
 			"--output-tags", outputTags,
+			"--keep-going",
+			"--error-report", errorReport,
 		}
 		runOrDie(t, args)
+		if data, err := os.ReadFile(errorReport); err != nil {
+			t.Fatal(err)
+		} else if strings.TrimSpace(string(data)) != "[]" {
+			t.Errorf("expected no skipped notes; got %s", data)
+		}
 		t.Logf("check outputs at %q", outputNotebooks)
 		t.Logf("check outputs at %q", outputNotes)
 		t.Logf("check outputs at %q", outputTags)
@@ -63,6 +72,20 @@ func TestConvert(t *testing.T) {
 		t.Logf("check output at %q", outputFilename)
 	})
 
+	t.Run("edam-to-sn-with-searches", func(t *testing.T) {
+		outputFilename := makeOutputFilenamePrefix(t) + "-output.json"
+		args := []string{
+			"convert", "edam-to-sn",
+			"--input-en-notebooks", _FixturesDir + "/" + _StubNotebooksFile,
+			"--input-en-notes", _FixturesDir + "/" + _StubNotesFile,
+			"--input-en-tags", _FixturesDir + "/" + _StubTagsFile,
+			"--input-en-searches", _FixturesDir + "/" + _StubSearchesFile,
+			"--output", outputFilename,
+		}
+		runOrDie(t, args)
+		t.Logf("check output at %q", outputFilename)
+	})
+
 	t.Run("enex-to-sn", func(t *testing.T) {
 		outputFilename := makeOutputFilenamePrefix(t) + "-output.json"
 		args := []string{
@@ -73,6 +96,29 @@ func TestConvert(t *testing.T) {
 		runOrDie(t, args)
 		t.Logf("check output at %q", outputFilename)
 	})
+
+	t.Run("enex-to-sn-deterministic-uuids", func(t *testing.T) {
+		outputFilename := makeOutputFilenamePrefix(t) + "-output.json"
+		args := []string{
+			"convert", "enex-to-sn",
+			"--input", _FixturesDir + "/" + _StubENEXFile,
+			"--output", outputFilename,
+			"--deterministic-uuids",
+		}
+		runOrDie(t, args)
+		t.Logf("check output at %q", outputFilename)
+	})
+
+	t.Run("from-edam-to-enex", func(t *testing.T) {
+		outputFilename := makeOutputFilenamePrefix(t) + "-output.enex"
+		args := []string{
+			"convert", "--from", "edam", "--to", "enex",
+			"--input", _FixturesDir,
+			"--output", outputFilename,
+		}
+		runOrDie(t, args)
+		t.Logf("check output at %q", outputFilename)
+	})
 }
 
 func TestEDAM(t *testing.T) {
@@ -1,9 +1,15 @@
 package cmd
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/rafaelespinoza/notexfr/internal/interactor"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
 )
 
 func makeConvert(cmdName string) *cobra.Command {
@@ -11,13 +17,82 @@ func makeConvert(cmdName string) *cobra.Command {
 		Use:     cmdName,
 		GroupID: dataGroupID,
 		Short:   "convert data",
-		Long:    "converts data from one service format to another",
+		Long: `Converts data from one service format to another.
+
+With --from and --to, data in any readable format is converted to any writable
+format, by way of a common model. The formats are:
+
+` + describeFormats() + `
+Options for a format are passed as --option name=value, and may be repeated.
+The subcommands are shortcuts for particular conversions. Every conversion,
+with --from and --to or with a subcommand, has the flags below. Notes are
+read, selected, rewritten and written one at a time, except that the sn format
+is read all at once.
+
+` + filterFlagHelp + `
+Only the notebooks and tags of the selected notes are converted.
+
+` + rulesFlagHelp + `
+
+` + keepGoingFlagsHelp + `
+
+` + decryptFlagsHelp + `
+
+The rest are for writing the sn format. The flags that are also options of the
+format take precedence over --option.
+
+` + noteVersionsFlagHelp + `
+
+` + noteTextFlagHelp + `
+
+` + collisionsFlagHelp + `
+
+` + uuidFlagsHelp + `
+
+` + ledgerFlagHelp + `
+
+` + dryRunFlagsHelp + `
+
+` + lossyReportFlagHelp,
+	}
+	{
+		cmd.Flags().StringP("from", "", "", "name of input format")
+		cmd.Flags().StringP("to", "", "", "name of output format")
+		cmd.Flags().StringP("input", "i", "", "path to input file or directory")
+		cmd.Flags().StringToStringP("option", "", nil, "format option as name=value")
+		setupConvertFlags(cmd.PersistentFlags())
+		cmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
+			flags := cmd.Flags()
+			params := interactor.ConvertParams{StreamOnly: true}
+			if params.From, err = flags.GetString("from"); err != nil {
+				return err
+			}
+			if params.To, err = flags.GetString("to"); err != nil {
+				return err
+			}
+			if params.From == "" && params.To == "" {
+				return cmd.Help()
+			}
+			if params.InputPath, err = flags.GetString("input"); err != nil {
+				return err
+			}
+			if params.Options, err = flags.GetStringToString("option"); err != nil {
+				return err
+			}
+			if err = getConvertFlags(cmd, &params); err != nil {
+				return err
+			}
+
+			_, err = interactor.Convert(cmd.Context(), params)
+			return err
+		}
 	}
 
 	edamToSN := cobra.Command{
 		Use:   "edam-to-sn",
 		Short: "convert EDAM (Evernote) data to StandardNotes format",
-		Long: `Parse, read local Evernote data, convert to StandardNotes JSON format.
+		Long: `Parse, read local Evernote data, convert to StandardNotes JSON format. This
+is the same as --from edam --to sn.
 
 There are several input files to this subcommand. The following inputs are
 created from the edam subcommand. This is, you should fetch your data from
@@ -25,34 +100,54 @@ Evernote using the EDAM API and save the results to local JSON files:
 
 --input-en-notebooks=<output of "edam notebooks">
 --input-en-notes=<output of "edam notes">
---input-en-tags=<output of "edam tags">`,
+--input-en-tags=<output of "edam tags">
+
+Optionally, saved searches are converted to StandardNotes smart views:
+
+--input-en-searches=<output of "edam searches">
+
+Alternatively, pass the output of "edam fetch-all" instead of all of the above:
+
+--input-en-bundle=<output directory of "edam fetch-all">
+
+Only a subset of the Evernote search grammar is translated: the terms tag:,
+notebook:, intitle:, created:, updated:, their negations and any:. Searches
+using anything else are skipped and logged as warnings.
+
+The other flags are described by "convert --help".`,
 	}
 	{
 		edamToSN.Flags().StringP("input-en-notebooks", "", "", "path to Evernote notebooks data file")
 		edamToSN.Flags().StringP("input-en-notes", "", "", "path to Evernote notes data file")
 		edamToSN.Flags().StringP("input-en-tags", "", "", "path to Evernote tags data file")
-		edamToSN.Flags().StringP("output", "o", "", "path to output file")
+		edamToSN.Flags().StringP("input-en-searches", "", "", "optional path to Evernote saved searches data file")
+		edamToSN.Flags().StringP("input-en-bundle", "", "", "path to Evernote bundle directory, instead of the other input files")
 		edamToSN.RunE = func(cmd *cobra.Command, args []string) (err error) {
 			flags := cmd.Flags()
-			var params interactor.ConvertParams
-			params.InputFilenames.Notebooks, err = flags.GetString("input-en-notebooks")
-			if err != nil {
-				return err
+			params := interactor.ConvertParams{From: "edam", To: "sn", Options: make(map[string]string), StreamOnly: true}
+			// The input files are options of the edam format.
+			for _, tuple := range []struct{ flag, option string }{
+				{flag: "input-en-notebooks", option: "notebooks"},
+				{flag: "input-en-notes", option: "notes"},
+				{flag: "input-en-tags", option: "tags"},
+				{flag: "input-en-searches", option: "searches"},
+			} {
+				val, ferr := flags.GetString(tuple.flag)
+				if ferr != nil {
+					return ferr
+				}
+				if val != "" {
+					params.Options[tuple.option] = val
+				}
 			}
-			params.InputFilenames.Notes, err = flags.GetString("input-en-notes")
-			if err != nil {
+			if params.InputBundleDir, err = flags.GetString("input-en-bundle"); err != nil {
 				return err
 			}
-			params.InputFilenames.Tags, err = flags.GetString("input-en-tags")
-			if err != nil {
-				return err
-			}
-			params.OutputFilename, err = flags.GetString("output")
-			if err != nil {
+			if err = getConvertFlags(cmd, &params); err != nil {
 				return err
 			}
 
-			_, err = interactor.ConvertEDAMToStandardNotes(cmd.Context(), params)
+			_, err = interactor.Convert(cmd.Context(), params)
 			return err
 		}
 	}
@@ -60,24 +155,23 @@ Evernote using the EDAM API and save the results to local JSON files:
 	enexToSN := cobra.Command{
 		Use:   "enex-to-sn",
 		Short: "convert an Evernote export file to StandardNotes format",
-		Long:  "Parse, read an Evernote ENEX file, convert to StandardNotes JSON format",
+		Long: `Parse, read an Evernote ENEX file, convert to StandardNotes JSON format. This
+is the same as --from enex --to sn.
+
+The other flags are described by "convert --help".`,
 	}
 	{
 		enexToSN.Flags().StringP("input", "i", "", "path to evernote export file")
-		enexToSN.Flags().StringP("output", "o", "", "path to output file")
 		enexToSN.RunE = func(cmd *cobra.Command, args []string) (err error) {
-			flags := cmd.Flags()
-			var params interactor.ConvertParams
-			params.InputFilename, err = flags.GetString("input")
-			if err != nil {
+			params := interactor.ConvertParams{From: "enex", To: "sn", StreamOnly: true}
+			if params.InputPath, err = cmd.Flags().GetString("input"); err != nil {
 				return err
 			}
-			params.OutputFilename, err = flags.GetString("output")
-			if err != nil {
+			if err = getConvertFlags(cmd, &params); err != nil {
 				return err
 			}
 
-			_, err = interactor.ConvertENEXToStandardNotes(cmd.Context(), params)
+			_, err = interactor.Convert(cmd.Context(), params)
 			return err
 		}
 	}
@@ -85,3 +179,189 @@ Evernote using the EDAM API and save the results to local JSON files:
 	cmd.AddCommand(&edamToSN, &enexToSN)
 	return &cmd
 }
+
+// setupConvertFlags adds the flags that every conversion has.
+func setupConvertFlags(flags *pflag.FlagSet) {
+	flags.StringP("output", "o", "", "path to output file")
+	flags.StringP("filter", "", "", filterFlagUsage)
+	flags.StringP("rules", "", "", rulesFlagUsage)
+	setupKeepGoingFlags(flags)
+	setupDecryptFlags(flags)
+	flags.StringP("note-versions", "", interactor.NoteVersionsOptions[0], fmt.Sprintf("how to convert earlier revisions of notes, one of %q", interactor.NoteVersionsOptions))
+	flags.StringP("note-text", "", interactor.NoteTextFormats[0], fmt.Sprintf("format of the text of notes, one of %q", interactor.NoteTextFormats))
+	flags.StringP("collisions", "", interactor.CollisionPolicies[0], fmt.Sprintf("what to do with a notebook and a tag of the same name, one of %q", interactor.CollisionPolicies))
+	setupUUIDFlags(flags)
+	flags.StringP("ledger", "", "", "optional path to ledger file, created if it doesn't exist")
+	setupDryRunFlags(flags)
+	flags.StringP("compare", "", "", "optional path to existing StandardNotes data, for --dry-run")
+	flags.StringP("lossy-report", "", "", "optional path to write notes with features that StandardNotes doesn't have")
+}
+
+// getConvertFlags reads the flags of setupConvertFlags. The ones that are also
+// options of the sn format are only read when they're set, so that their
+// defaults don't take precedence over --option.
+func getConvertFlags(cmd *cobra.Command, out *interactor.ConvertParams) (err error) {
+	flags := cmd.Flags()
+	for _, tuple := range []struct {
+		name string
+		val  *string
+		opt  bool
+	}{
+		{name: "output", val: &out.OutputFilename},
+		{name: "filter", val: &out.Filter},
+		{name: "rules", val: &out.RulesFilename},
+		{name: "note-versions", val: &out.NoteVersions, opt: true},
+		{name: "note-text", val: &out.NoteText, opt: true},
+		{name: "collisions", val: &out.CollisionPolicy, opt: true},
+		{name: "ledger", val: &out.LedgerFilename},
+		{name: "compare", val: &out.CompareFilename},
+		{name: "lossy-report", val: &out.LossyReportFilename},
+	} {
+		if tuple.opt && !flags.Changed(tuple.name) {
+			continue
+		}
+		if *tuple.val, err = flags.GetString(tuple.name); err != nil {
+			return
+		}
+	}
+	if out.UUIDNamespace, err = getUUIDNamespace(flags); err != nil {
+		return
+	}
+	if err = getKeepGoingFlags(flags, &out.KeepGoingParams); err != nil {
+		return
+	}
+	if err = getDecryptFlags(cmd, &out.DecryptParams); err != nil {
+		return
+	}
+	err = getDryRunFlags(flags, &out.DryRunParams)
+	return
+}
+
+const noteVersionsFlagHelp = `Earlier revisions of notes, fetched with "edam notes --include-versions", are
+kept in the appData of each note by default. Use --note-versions=archive to
+make each revision a separate, archived note instead.`
+
+const noteTextFlagHelp = `The text of each note is HTML by default, with checkboxes as ☐ and ☑
+characters. Use --note-text=markdown for Markdown, where checkboxes are task
+lists, "- [ ]" and "- [x]", or --note-text=text for plain text. The number of
+open and done checkboxes of a note is kept in its appData.`
+
+const uuidFlagsHelp = `UUIDs of StandardNotes items are random by default, so converting the same
+data twice makes different items, and importing both makes duplicates. With
+--deterministic-uuids, UUIDs are derived from the input instead, so that a
+repeated conversion updates the items already imported. Pass --uuid-namespace
+to derive them from another UUID, such as to keep separate migrations apart.`
+
+const ledgerFlagHelp = `With --ledger, the ID of each converted resource and the UUID of its item
+are recorded in a ledger file, of JSON lines. A later conversion with the same
+ledger reuses those UUIDs, so that importing it updates the items in place.
+Notes that haven't changed since are left out, unless the settings that change
+the output of notes have changed, such as --note-text. The ledger is only
+written once the output is complete. Resources in the ledger that weren't
+converted, perhaps since they were deleted, are logged as orphans. Use
+one ledger per source of data, since resources from an ENEX file are
+identified differently than those fetched from the Evernote API.`
+
+const lossyReportFlagHelp = `Some features of Evernote notes don't convert to StandardNotes: encrypted
+text, attachments, handwriting, checkboxes unless the text is Markdown, tasks,
+reminders, and tables with merged cells or nested tables. They're dropped or
+approximated, and each one is logged as a warning. With --lossy-report, the
+notes that have them are also written to a JSON file, with the ID, title and
+features of each, so that important notes can be fixed by hand.`
+
+const decryptFlagsHelp = `Text encrypted in Evernote is kept as it is, unless there's a passphrase for
+it. Pass --passphrases for a JSON file with a list of passphrases, and with
+passphrases for particular hints, such as:
+
+	{"passphrases": ["one", "two"], "hints": {"the usual": "three"}}
+
+With --ask-passphrase, the passphrase is prompted for, once for each hint, when
+none of the others decrypt some text. Decrypted text is inlined into the note.
+Text that no passphrase decrypts is kept as it is, and logged as a warning.`
+
+func setupDecryptFlags(flags *pflag.FlagSet) {
+	flags.StringP("passphrases", "", "", "optional path to passphrases for encrypted text")
+	flags.BoolP("ask-passphrase", "", false, "prompt for passphrases for encrypted text")
+}
+
+func getDecryptFlags(cmd *cobra.Command, out *interactor.DecryptParams) (err error) {
+	flags := cmd.Flags()
+	if out.PassphrasesFilename, err = flags.GetString("passphrases"); err != nil {
+		return
+	}
+	ask, err := flags.GetBool("ask-passphrase")
+	if err != nil || !ask {
+		return
+	}
+	out.Prompt = func(hint string) (string, error) {
+		if hint == "" {
+			return readSecret(cmd, "passphrase for encrypted text")
+		}
+		return readSecret(cmd, fmt.Sprintf("passphrase for encrypted text, hint %q", hint))
+	}
+	return
+}
+
+const dryRunFlagsHelp = `With --dry-run, nothing is written except for a diff: the items that would be
+created, and how existing items would change. Existing items are read from
+--compare, such as an export of your StandardNotes account. Without it, every
+item would be created. Comparing requires --uuid-namespace or --ledger, so that
+items get the UUIDs of an earlier conversion. The diff is written as text, or
+as JSON with --diff-format=json, to standard output or to --diff-output.`
+
+func setupDryRunFlags(flags *pflag.FlagSet) {
+	flags.BoolP("dry-run", "", false, "write a diff instead of making changes")
+	flags.StringP("diff-format", "", interactor.DiffFormats[0], fmt.Sprintf("format of diff, one of %q", interactor.DiffFormats))
+	flags.StringP("diff-output", "", "", "path to diff file, instead of standard output")
+}
+
+func getDryRunFlags(flags *pflag.FlagSet, out *interactor.DryRunParams) (err error) {
+	if out.DryRun, err = flags.GetBool("dry-run"); err != nil {
+		return
+	}
+	if out.DiffFormat, err = flags.GetString("diff-format"); err != nil {
+		return
+	}
+	out.DiffFilename, err = flags.GetString("diff-output")
+	return
+}
+
+func setupUUIDFlags(flags *pflag.FlagSet) {
+	flags.BoolP("deterministic-uuids", "", false, "derive UUIDs from the input, so that conversions are repeatable")
+	flags.StringP("uuid-namespace", "", "", "UUID to derive UUIDs from, implies --deterministic-uuids")
+}
+
+func getUUIDNamespace(flags *pflag.FlagSet) (out string, err error) {
+	if out, err = flags.GetString("uuid-namespace"); err != nil || out != "" {
+		return
+	}
+	deterministic, err := flags.GetBool("deterministic-uuids")
+	if err == nil && deterministic {
+		out = interactor.DefaultUUIDNamespace
+	}
+	return
+}
+
+// describeFormats lists the registered formats for help text.
+func describeFormats() string {
+	var bld strings.Builder
+	for _, format := range repo.Formats() {
+		var modes []string
+		if format.Read != nil {
+			modes = append(modes, "read")
+		}
+		if format.NewEncoder != nil {
+			modes = append(modes, "write")
+		}
+		fmt.Fprintf(&bld, "  %s (%s): %s\n", format.Name, strings.Join(modes, ", "), format.Description)
+		names := make([]string, 0, len(format.Options))
+		for name := range format.Options {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&bld, "    option %s: %s\n", name, format.Options[name])
+		}
+	}
+	return bld.String()
+}
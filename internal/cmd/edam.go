@@ -2,13 +2,22 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
 
 	"github.com/rafaelespinoza/notexfr/internal/interactor"
+	"github.com/rafaelespinoza/notexfr/internal/log"
 	"github.com/rafaelespinoza/notexfr/internal/repo/edam"
+	"github.com/rafaelespinoza/notexfr/internal/repo/edam/fake"
 )
 
 func makeEdam(cmdName string) *cobra.Command {
@@ -32,54 +41,90 @@ default to using sandbox credentials.`,
 	makeEnv := cobra.Command{
 		Use:   "make-env",
 		Short: "init an env var file unless it already exists",
-		Long:  `Create an environment variable file to store Evernote sandbox and production credentials`,
+		Long: `Create an environment variable file to store Evernote sandbox and production credentials.
+
+By default, the file is a template in plain text. With the -encrypt flag,
+the credentials are prompted for and the file is encrypted with a passphrase,
+in the format of the age encryption tool.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			flags := cmd.Flags()
 			envfile, err := flags.GetString("envfile")
 			if err != nil {
 				return err
 			}
-			return interactor.MakeEDAMEnvFile(envfile)
+			encrypt, err := flags.GetBool("encrypt")
+			if err != nil {
+				return err
+			}
+			if !encrypt {
+				return interactor.MakeEDAMEnvFile(envfile)
+			}
+
+			passphrase, err := readSecret(cmd, "passphrase")
+			if err != nil {
+				return err
+			}
+			if confirm, err := readSecret(cmd, "confirm passphrase"); err != nil {
+				return err
+			} else if confirm != passphrase {
+				return fmt.Errorf("passphrases do not match")
+			}
+			values := make(map[string]string, len(edam.CredentialNames))
+			for _, name := range edam.CredentialNames {
+				if values[name], err = readSecret(cmd, name+" (may be empty)"); err != nil {
+					return err
+				}
+			}
+			return interactor.MakeEncryptedEDAMEnvFile(envfile, passphrase, values)
 		},
 	}
 	{
 		makeEnv.Flags().StringP("envfile", "e", "", "path to env var file")
+		makeEnv.Flags().BoolP("encrypt", "", false, "prompt for credentials and write them to a passphrase-encrypted file")
 	}
 
 	notebooks := cobra.Command{
 		Use:   "notebooks",
 		Short: "fetch Notebooks and write data to JSON file",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx, err := newEDAMCtx(cmd)
-			if err != nil {
-				return err
-			}
 			opts, err := buildEDAMFetchWriteParams(cmd)
 			if err != nil {
 				return err
 			}
-			return interactor.FetchWriteNotebooks(ctx, &opts)
+			return interactor.FetchWriteNotebooks(cmd.Context(), &opts)
 		},
 	}
 	setupEDAMSubcmd(&notebooks)
+	setupEDAMLinkedFlag(&notebooks)
 
 	notes := cobra.Command{
 		Use:   "notes",
 		Short: "fetch Notes and write data to JSON file",
+		Long: `Fetch Notes from your Evernote account and write data to a JSON file.
+
+` + keepGoingFlagsHelp,
 	}
 	setupEDAMSubcmd(&notes)
+	setupEDAMLinkedFlag(&notes)
 	{
 		notesFlags := notes.Flags()
 		notesFlags.Int32P("lo-index", "L", 0, "start index for paginating notes")
 		notesFlags.Int32P("hi-index", "H", -1, "end index for paginating notes, if negative go until there are no more")
 		notesFlags.Int32P("page-size", "S", 100, "number of results to fetch at once")
+		notesFlags.StringP("notebook", "", "", "only fetch notes in the notebook with this name or GUID, which may be a linked notebook")
+		notesFlags.StringSliceP("tag", "", nil, "only fetch notes with this tag name or GUID, may be repeated")
+		notesFlags.StringP("query", "", "", "search expression in the Evernote search grammar, see https://dev.evernote.com/doc/articles/search_grammar.php")
+		notesFlags.StringP("created-after", "", "", "only fetch notes created at or after this date, as YYYY-MM-DD or RFC3339")
+		notesFlags.StringP("created-before", "", "", "only fetch notes created before this date, as YYYY-MM-DD or RFC3339")
+		notesFlags.StringP("updated-after", "", "", "only fetch notes updated at or after this date, as YYYY-MM-DD or RFC3339")
+		notesFlags.StringP("updated-before", "", "", "only fetch notes updated before this date, as YYYY-MM-DD or RFC3339")
+		notesFlags.StringP("sort", "", edam.NoteSortOrders[0], fmt.Sprintf("sort order of results, should be one of %q", edam.NoteSortOrders))
+		notesFlags.BoolP("descending", "", false, "reverse the sort order")
+		notesFlags.BoolP("include-trashed", "", false, "also fetch notes in the trash")
+		notesFlags.BoolP("include-versions", "", false, "also fetch earlier revisions of each note, only kept for premium and business accounts")
+		setupKeepGoingFlags(notesFlags)
 
 		notes.RunE = func(cmd *cobra.Command, args []string) error {
-			ctx, err := newEDAMCtx(cmd)
-			if err != nil {
-				return err
-			}
-
 			opts, err := buildEDAMFetchWriteParams(cmd)
 			if err != nil {
 				return err
@@ -98,8 +143,57 @@ default to using sandbox credentials.`,
 			if err != nil {
 				return err
 			}
+			rpq.Notebook, err = flags.GetString("notebook")
+			if err != nil {
+				return err
+			}
+			rpq.Tags, err = flags.GetStringSlice("tag")
+			if err != nil {
+				return err
+			}
+			rpq.Query, err = flags.GetString("query")
+			if err != nil {
+				return err
+			}
+			dates := []struct {
+				name string
+				val  *time.Time
+			}{
+				{name: "created-after", val: &rpq.CreatedAfter},
+				{name: "created-before", val: &rpq.CreatedBefore},
+				{name: "updated-after", val: &rpq.UpdatedAfter},
+				{name: "updated-before", val: &rpq.UpdatedBefore},
+			}
+			for _, date := range dates {
+				if *date.val, err = getDateFlag(flags, date.name); err != nil {
+					return err
+				}
+			}
+			rpq.SortOrder, err = flags.GetString("sort")
+			if err != nil {
+				return err
+			}
+			rpq.Descending, err = flags.GetBool("descending")
+			if err != nil {
+				return err
+			}
+			rpq.IncludeTrashed, err = flags.GetBool("include-trashed")
+			if err != nil {
+				return err
+			}
+			rpq.IncludeVersions, err = flags.GetBool("include-versions")
+			if err != nil {
+				return err
+			}
+			rpq.IncludeLinked = opts.IncludeLinked
+			if err = rpq.Validate(); err != nil {
+				return err
+			}
 			opts.NotesQueryParams = &rpq
-			return interactor.FetchWriteNotes(ctx, &opts)
+			if err = getKeepGoingFlags(flags, &opts.KeepGoingParams); err != nil {
+				return err
+			}
+			return interactor.FetchWriteNotes(cmd.Context(), &opts)
 		}
 	}
 
@@ -107,22 +201,227 @@ default to using sandbox credentials.`,
 		Use:   "tags",
 		Short: "fetch Tags and write data to JSON file",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx, err := newEDAMCtx(cmd)
+			opts, err := buildEDAMFetchWriteParams(cmd)
 			if err != nil {
 				return err
 			}
 
+			return interactor.FetchWriteTags(cmd.Context(), &opts)
+		},
+	}
+	setupEDAMSubcmd(&tags)
+	setupEDAMLinkedFlag(&tags)
+
+	searches := cobra.Command{
+		Use:   "searches",
+		Short: "fetch SavedSearches and write data to JSON file",
+		RunE: func(cmd *cobra.Command, args []string) error {
 			opts, err := buildEDAMFetchWriteParams(cmd)
 			if err != nil {
 				return err
 			}
 
-			return interactor.FetchWriteTags(ctx, &opts)
+			return interactor.FetchWriteSavedSearches(cmd.Context(), &opts)
 		},
 	}
-	setupEDAMSubcmd(&tags)
+	setupEDAMSubcmd(&searches)
+
+	fakeServer := cobra.Command{
+		Use:   "fake-server",
+		Short: "serve fixture data with a stand-in for the Evernote API",
+		Long: `Serve fixture data over the same Thrift-over-HTTP protocol as the Evernote API.
+
+This is for trying out the other edam subcommands without an Evernote account.
+Point them at this server with the --base-url flag. Any non-empty token is
+accepted unless the --token flag is set. The fixture directory is expected to
+contain files named like the ones in internal/fixtures, missing files are
+treated as having no data.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			dir, err := flags.GetString("fixtures")
+			if err != nil {
+				return err
+			}
+			addr, err := flags.GetString("addr")
+			if err != nil {
+				return err
+			}
+			token, err := flags.GetString("token")
+			if err != nil {
+				return err
+			}
+			fixtures, err := fake.LoadFixtures(dir)
+			if err != nil {
+				return err
+			}
+			log.Info(cmd.Context(), map[string]any{"addr": addr, "fixtures": dir}, "serving fake Evernote API")
+			return http.ListenAndServe(addr, fake.NewServer(fixtures, token))
+		},
+	}
+	{
+		flags := fakeServer.Flags()
+		flags.StringP("fixtures", "", "", "path to directory of fixture data")
+		flags.StringP("addr", "", "localhost:8080", "address to listen on")
+		flags.StringP("token", "", "", "only accept this authentication token")
+	}
+
+	login := cobra.Command{
+		Use:   "login",
+		Short: "get an access token with OAuth instead of a developer token",
+		Long: `Get an access token for your Evernote account through the OAuth flow.
+
+This is an alternative to requesting a developer token. It requires the
+consumer key and secret of an Evernote API key, which are looked up like other
+credentials. Visit the printed URL in a browser to grant access. Evernote then
+redirects the browser to a listener on this machine, which completes the flow.
+
+The token is saved to the env file, which stays encrypted if it already is, or
+to the keyring with the -keyring flag. Tokens obtained this way expire, so log
+in again when that happens.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			conf, err := newEDAMCredentialsConfig(cmd)
+			if err != nil {
+				return err
+			}
+			timeout, err := flags.GetDuration("timeout")
+			if err != nil {
+				return err
+			}
+			callbackAddr, err := flags.GetString("callback-addr")
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			defer cancel()
+			return interactor.LoginEDAM(ctx, conf, edam.LoginParams{
+				CallbackAddr: callbackAddr,
+				Authorize: func(ctx context.Context, authorizeURL string) error {
+					_, err := fmt.Fprintf(cmd.ErrOrStderr(), "Open this URL in a browser to grant access:\n\n\t%s\n\n", authorizeURL)
+					return err
+				},
+			})
+		},
+	}
+	{
+		flags := login.Flags()
+		setupEDAMCredentialsFlags(flags)
+		flags.DurationP("timeout", "t", 5*time.Minute, "how long to wait for access to be granted")
+		flags.StringP("callback-addr", "", "127.0.0.1:0", "local address to listen on for the redirect back from Evernote")
+	}
+
+	push := cobra.Command{
+		Use:   "push",
+		Short: "create notes in Evernote from StandardNotes or ENEX data",
+		Long: fmt.Sprintf(`Create notes, and their tags, in an Evernote account from a file of notes.
 
-	cmd.AddCommand(&makeEnv, &notebooks, &notes, &tags)
+The input format is one of %q. A StandardNotes file is like the output of
+"convert". The text of its notes is converted to ENML from the format of the
+-note-text flag, which is guessed for each note by default. Archived notes,
+such as earlier revisions of notes, are left out. An ENEX file is an Evernote
+export, its attachments are uploaded too.
+
+Notes go into their notebook, if the input has one, such as a notebook tag of a
+StandardNotes file. Otherwise, they go into the notebook named by the -notebook
+flag, which may be a notebook shared with you, such as a business notebook.
+Either way, a notebook is created if it doesn't exist.
+
+The mapping file records what has been created, in each account and notebook.
+Pass the same one when running again with the same input, and notes which were
+already created there are skipped.`, interactor.PushInputFormats),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			client, err := newEDAMClient(cmd)
+			if err != nil {
+				return err
+			}
+			opts := interactor.PushParams{EDAMClient: client}
+			if opts.InputFilename, err = flags.GetString("input"); err != nil {
+				return err
+			}
+			if opts.InputFormat, err = flags.GetString("format"); err != nil {
+				return err
+			}
+			if opts.NoteText, err = flags.GetString("note-text"); err != nil {
+				return err
+			}
+			if opts.Notebook, err = flags.GetString("notebook"); err != nil {
+				return err
+			}
+			if opts.MappingFilename, err = flags.GetString("mapping"); err != nil {
+				return err
+			}
+			if opts.Timeout, err = flags.GetDuration("timeout"); err != nil {
+				return err
+			}
+			_, err = interactor.PushEDAM(cmd.Context(), &opts)
+			return err
+		},
+	}
+	{
+		flags := push.Flags()
+		setupEDAMCredentialsFlags(flags)
+		flags.StringP("input", "i", "", "path to input file")
+		flags.StringP("format", "f", "sn", fmt.Sprintf("format of input file, one of %q", interactor.PushInputFormats))
+		flags.StringP("note-text", "", interactor.PushNoteTextFormats[0], fmt.Sprintf("format of the text of StandardNotes notes, one of %q", interactor.PushNoteTextFormats))
+		flags.StringP("notebook", "", "", "name of notebook for notes without one, default notebook if empty")
+		flags.StringP("mapping", "m", "", "path to mapping file, to skip notes created in a previous run")
+		flags.DurationP("timeout", "t", 10*time.Minute, "how long to wait before timing out")
+	}
+
+	fetchAll := cobra.Command{
+		Use:   "fetch-all",
+		Short: "fetch all data and write it to a bundle directory",
+		Long: `Fetch notebooks, tags, notes with their attachments, and saved searches from
+your Evernote account, and write them to a bundle directory.
+
+The bundle directory has one JSON file for each kind of data, named like the
+output of the other edam subcommands, and a directory of attachments named by
+the MD5 hash of their data. A manifest describes the account, when it was
+fetched, how much of each kind of data there is, and the highest update
+sequence number at the time. Pass the directory to "convert edam-to-sn" or
+"backfill en-to-sn" with the -input-en-bundle flag.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			client, err := newEDAMClient(cmd)
+			if err != nil {
+				return err
+			}
+			opts := interactor.FetchAllParams{EDAMClient: client}
+			if opts.OutDir, err = flags.GetString("out-dir"); err != nil {
+				return err
+			}
+			if opts.Timeout, err = flags.GetDuration("timeout"); err != nil {
+				return err
+			}
+			if opts.PageSize, err = flags.GetInt32("page-size"); err != nil {
+				return err
+			}
+			if opts.IncludeLinked, err = flags.GetBool("include-linked"); err != nil {
+				return err
+			}
+			if opts.IncludeTrashed, err = flags.GetBool("include-trashed"); err != nil {
+				return err
+			}
+			if opts.IncludeVersions, err = flags.GetBool("include-versions"); err != nil {
+				return err
+			}
+			_, err = interactor.FetchAllEDAM(cmd.Context(), &opts)
+			return err
+		},
+	}
+	{
+		flags := fetchAll.Flags()
+		setupEDAMCredentialsFlags(flags)
+		flags.StringP("out-dir", "", "", "path to bundle directory")
+		flags.DurationP("timeout", "t", 30*time.Minute, "how long to wait before timing out")
+		flags.Int32P("page-size", "S", 100, "number of notes to fetch at once")
+		flags.BoolP("include-linked", "", false, "also fetch from notebooks owned by other accounts, such as shared and business notebooks")
+		flags.BoolP("include-trashed", "", false, "also fetch notes in the trash")
+		flags.BoolP("include-versions", "", false, "also fetch earlier revisions of each note, only kept for premium and business accounts")
+	}
+
+	cmd.AddCommand(&makeEnv, &login, &notebooks, &notes, &fetchAll, &push, &searches, &tags, &fakeServer)
 	return &cmd
 }
 
@@ -130,41 +429,145 @@ func setupEDAMSubcmd(cmd *cobra.Command) {
 	cmd.Long = fmt.Sprintf(`Fetch %s from your Evernote account and write them to JSON files.
 Use your sandbox account by default. To use your production account, pass
 the -production flag.
-Specify account credentials with the -envfile flag.`, cmd.Name())
+Specify account credentials with the -envfile flag.
+
+Credentials already set as environment variables take precedence. Otherwise
+they are looked up with the -token-command flag, then in the keyring with the
+-keyring flag, then in the env file. An encrypted env file, as created by
+"make-env -encrypt", prompts for its passphrase unless it's set in the
+environment variable `+passphraseEnvName+`.`, cmd.Name())
 
 	flags := cmd.Flags()
 	flags.StringP("output", "o", "", "path to write data as JSON")
 	flags.DurationP("timeout", "t", time.Duration(120)*time.Second, "how long to wait before timing out")
+	setupEDAMCredentialsFlags(flags)
+}
+
+// setupEDAMCredentialsFlags is for subcommands that need account credentials.
+func setupEDAMCredentialsFlags(flags *pflag.FlagSet) {
 	flags.BoolP("production", "p", false, "use production evernote account")
-	flags.StringP("envfile", "e", "", "path to to env var file")
+	flags.StringP("envfile", "e", "", "path to to env var file, which may be encrypted")
+	flags.StringP("token-command", "", "", "shell command that outputs the developer token, such as \"pass show evernote/prod\"")
+	flags.BoolP("keyring", "", false, "look up credentials in the desktop keyring with secret-tool")
+	flags.StringP("base-url", "", "", "use the Evernote service at this URL instead, such as one started by fake-server")
+}
+
+// setupEDAMLinkedFlag is for subcommands that fetch data which may be in
+// notebooks owned by other accounts.
+func setupEDAMLinkedFlag(cmd *cobra.Command) {
+	cmd.Long += fmt.Sprintf(`
+Use the -include-linked flag to also fetch %s from notebooks owned by other
+accounts, such as notebooks shared with you and business notebooks. These are
+marked with their origin so that they can be kept apart during conversion.`, cmd.Name())
+
+	cmd.Flags().BoolP("include-linked", "", false, "also fetch from notebooks owned by other accounts, such as shared and business notebooks")
+}
+
+func newEDAMClient(cmd *cobra.Command) (out *edam.Client, err error) {
+	conf, err := newEDAMCredentialsConfig(cmd)
+	if err != nil {
+		return
+	}
+	out = edam.NewClient(conf)
+	return
 }
 
-func newEDAMCtx(cmd *cobra.Command) (out context.Context, err error) {
+func newEDAMCredentialsConfig(cmd *cobra.Command) (out edam.CredentialsConfig, err error) {
 	flags := cmd.Flags()
 
 	envfile, err := flags.GetString("envfile")
 	if err != nil {
-		err = fmt.Errorf("failed to build EDAM context: %w", err)
+		err = fmt.Errorf("failed to build EDAM credentials config: %w", err)
 		return
 	}
 
 	var serviceEnvironment edam.EvernoteService
 	prod, err := flags.GetBool("production")
 	if err != nil {
-		err = fmt.Errorf("failed to build EDAM context: %w", err)
+		err = fmt.Errorf("failed to build EDAM credentials config: %w", err)
 		return
 	}
 	if prod {
 		serviceEnvironment = edam.EvernoteProductionService
 	}
 
-	val := edam.CredentialsConfig{EnvFilename: envfile, ServiceEnv: serviceEnvironment}
-	out = context.WithValue(cmd.Context(), edam.EvernoteServiceKey, val)
+	baseURL, err := flags.GetString("base-url")
+	if err != nil {
+		err = fmt.Errorf("failed to build EDAM credentials config: %w", err)
+		return
+	}
+
+	var sources []edam.CredentialSource
+	tokenCommand, err := flags.GetString("token-command")
+	if err != nil {
+		err = fmt.Errorf("failed to build EDAM credentials config: %w", err)
+		return
+	}
+	if tokenCommand != "" {
+		sources = append(sources, &edam.CommandSource{Command: tokenCommand})
+	}
+	keyring, err := flags.GetBool("keyring")
+	if err != nil {
+		err = fmt.Errorf("failed to build EDAM credentials config: %w", err)
+		return
+	}
+	if keyring {
+		sources = append(sources, &edam.KeyringSource{})
+	}
+	if envfile != "" {
+		sources = append(sources, &edam.EnvFileSource{
+			Filename: envfile,
+			Passphrase: func() (string, error) {
+				if val := os.Getenv(passphraseEnvName); val != "" {
+					return val, nil
+				}
+				return readSecret(cmd, "passphrase for "+envfile)
+			},
+		})
+	}
+
+	out = edam.CredentialsConfig{ServiceEnv: serviceEnvironment, BaseURL: baseURL, Sources: sources}
 	return
 }
 
+// passphraseEnvName is an environment variable for the passphrase of an
+// encrypted env file, for when there's no terminal to prompt on.
+const passphraseEnvName = "NOTEXFR_ENVFILE_PASSPHRASE"
+
+// readSecret prompts for a value without echoing it when stdin is a terminal.
+// Otherwise, it reads one line from stdin.
+func readSecret(cmd *cobra.Command, prompt string) (string, error) {
+	fmt.Fprintf(cmd.ErrOrStderr(), "%s: ", prompt)
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		val, err := term.ReadPassword(fd)
+		fmt.Fprintln(cmd.ErrOrStderr())
+		return string(val), err
+	}
+	// Read a byte at a time so that subsequent calls get the next line.
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := cmd.InOrStdin().Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				return strings.TrimSuffix(string(line), "\r"), nil
+			}
+			line = append(line, buf[0])
+		}
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return string(line), nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
 func buildEDAMFetchWriteParams(cmd *cobra.Command) (out interactor.FetchWriteParams, err error) {
 	flags := cmd.Flags()
+	out.EDAMClient, err = newEDAMClient(cmd)
+	if err != nil {
+		return
+	}
 	out.OutputFilename, err = flags.GetString("output")
 	if err != nil {
 		return
@@ -173,5 +576,27 @@ func buildEDAMFetchWriteParams(cmd *cobra.Command) (out interactor.FetchWritePar
 	if err != nil {
 		return
 	}
+	if flags.Lookup("include-linked") != nil {
+		out.IncludeLinked, err = flags.GetBool("include-linked")
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// getDateFlag parses a flag value as a date or a timestamp. An empty value is
+// a zero time.
+func getDateFlag(flags *pflag.FlagSet, name string) (out time.Time, err error) {
+	val, err := flags.GetString(name)
+	if err != nil || val == "" {
+		return
+	}
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if out, err = time.Parse(layout, val); err == nil {
+			return
+		}
+	}
+	err = fmt.Errorf("invalid value for flag %q: %q, should be formatted as YYYY-MM-DD or RFC3339", name, val)
 	return
 }
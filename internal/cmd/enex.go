@@ -25,12 +25,21 @@ For more info on exporting Evernote data, see: %s`, helpLink),
 		Use:   "to-json",
 		Short: "convert ENEX file to JSON",
 		Long: fmt.Sprintf(`Parse an Evernote export file and convert data to JSON entities.
-For more info on exporting Evernote data, see: %s`, helpLink),
+For more info on exporting Evernote data, see: %s
+
+Attachments are listed by filename and type, without their data.
+
+%s
+An export file has no notebooks, so notebook: and stack: don't match.
+
+%s`, helpLink, filterFlagHelp, keepGoingFlagsHelp),
 	}
 	{
 		toJSON.Flags().StringP("input", "i", "", "path to evernote export file")
 		toJSON.Flags().StringP("output", "o", "", "path to write data as JSON")
 		toJSON.Flags().DurationP("timeout", "t", 15*time.Second, "how long to wait before timing out")
+		toJSON.Flags().StringP("filter", "", "", filterFlagUsage)
+		setupKeepGoingFlags(toJSON.Flags())
 
 		toJSON.RunE = func(cmd *cobra.Command, args []string) (err error) {
 			f := cmd.Flags()
@@ -47,6 +56,13 @@ For more info on exporting Evernote data, see: %s`, helpLink),
 			if err != nil {
 				return
 			}
+			params.Filter, err = f.GetString("filter")
+			if err != nil {
+				return
+			}
+			if err = getKeepGoingFlags(f, &params.KeepGoingParams); err != nil {
+				return
+			}
 			return interactor.WriteENEXToJSON(cmd.Context(), &params)
 		}
 	}
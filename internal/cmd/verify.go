@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rafaelespinoza/notexfr/internal/interactor"
+)
+
+func makeVerify(cmdName string) *cobra.Command {
+	cmd := cobra.Command{
+		Use:     cmdName,
+		GroupID: dataGroupID,
+		Short:   "check converted StandardNotes data against its source",
+		Long: `Checks that each note of the source has exactly one counterpart in the output of
+a conversion to StandardNotes, and that it has the same text, tags, notebook and
+timestamps.
+
+The source is an ENEX file, with --from=enex, or the output directory of
+"edam fetch-all", with --from=edam. The converted data is --sn. Notes are
+paired by the UUID they were given in the conversion, then by title and creation
+time. Text is compared without markup and with whitespace collapsed, so
+differences in formatting aren't counted. Archived notes, which are earlier
+revisions, are left out.
+
+Pass the same --filter and --rules as for the conversion, so that the same
+notes, tags and notebooks are expected. Likewise for --passphrases and
+--ask-passphrase, if any encrypted text was decrypted, and for
+--deterministic-uuids, --uuid-namespace and --ledger, so that the UUID of each
+note is known. The ledger is only read.
+
+The report lists missing notes, extra notes and the discrepancies of each
+matched note, followed by whether it passed. It's written as text, or as JSON
+with --report-format=json, to standard output or to --report. The exit code is
+non-zero if it failed.`,
+	}
+	cmd.Flags().StringP("from", "", "", fmt.Sprintf("format of source, one of %q", interactor.VerifyFormats))
+	cmd.Flags().StringP("input", "i", "", "path to source file or directory")
+	cmd.Flags().StringP("sn", "", "", "path to converted StandardNotes data")
+	cmd.Flags().StringP("filter", "", "", filterFlagUsage)
+	cmd.Flags().StringP("rules", "", "", rulesFlagUsage)
+	setupDecryptFlags(cmd.Flags())
+	setupUUIDFlags(cmd.Flags())
+	cmd.Flags().StringP("ledger", "", "", "optional path to ledger file of the conversion")
+	cmd.Flags().StringP("report", "", "", "path to report file, instead of standard output")
+	cmd.Flags().StringP("report-format", "", interactor.DiffFormats[0], fmt.Sprintf("format of report, one of %q", interactor.DiffFormats))
+	cmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		flags := cmd.Flags()
+		var params interactor.VerifyParams
+		if params.From, err = flags.GetString("from"); err != nil {
+			return err
+		}
+		if params.InputPath, err = flags.GetString("input"); err != nil {
+			return err
+		}
+		if params.SNFilename, err = flags.GetString("sn"); err != nil {
+			return err
+		}
+		if params.Filter, err = flags.GetString("filter"); err != nil {
+			return err
+		}
+		if params.RulesFilename, err = flags.GetString("rules"); err != nil {
+			return err
+		}
+		if err = getDecryptFlags(cmd, &params.DecryptParams); err != nil {
+			return err
+		}
+		if params.UUIDNamespace, err = getUUIDNamespace(flags); err != nil {
+			return err
+		}
+		if params.LedgerFilename, err = flags.GetString("ledger"); err != nil {
+			return err
+		}
+		if params.ReportFilename, err = flags.GetString("report"); err != nil {
+			return err
+		}
+		if params.ReportFormat, err = flags.GetString("report-format"); err != nil {
+			return err
+		}
+
+		_, err = interactor.Verify(cmd.Context(), params)
+		return err
+	}
+	return &cmd
+}
@@ -0,0 +1,255 @@
+package enml
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml/rc2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// A Keyring has passphrases for text that's encrypted in the content of notes,
+// which is in en-crypt elements. The passphrase for the hint of an element,
+// if any, is tried first, then the others, followed by those of other hints.
+// For example:
+//
+//	{
+//	  "passphrases": ["correct horse battery staple"],
+//	  "hints": {"the usual": "hunter2"}
+//	}
+type Keyring struct {
+	Passphrases []string          `json:"passphrases"`
+	Hints       map[string]string `json:"hints"`
+	// Prompt, if set, asks for a passphrase when none of the others decrypt
+	// an element. It's asked once for each hint, and the answer is kept for
+	// that hint. An error, such as the end of input, counts as no answer.
+	Prompt func(hint string) (string, error) `json:"-"`
+	asked  map[string]bool
+}
+
+// ErrKeyring means that a Keyring could not be parsed.
+var ErrKeyring = errors.New("keyring error")
+
+// ErrPassphrase means that a passphrase doesn't decrypt the text.
+var ErrPassphrase = errors.New("wrong passphrase")
+
+// ParseKeyring reads a Keyring as JSON.
+func ParseKeyring(r io.Reader) (out *Keyring, err error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	out = &Keyring{}
+	if err = dec.Decode(out); err != nil {
+		err = fmt.Errorf("%w; %v", ErrKeyring, err)
+		out = nil
+		return
+	}
+	if len(out.Passphrases) < 1 && len(out.Hints) < 1 {
+		err = fmt.Errorf("%w; no passphrases", ErrKeyring)
+		out = nil
+	}
+	return
+}
+
+// passphrases lists the passphrases to try for a hint, without duplicates.
+func (k *Keyring) passphrases(hint string) (out []string) {
+	if passphrase, ok := k.Hints[hint]; ok {
+		out = append(out, passphrase)
+	}
+	for _, passphrase := range k.Passphrases {
+		if !slices.Contains(out, passphrase) {
+			out = append(out, passphrase)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(k.Hints)) {
+		if passphrase := k.Hints[key]; !slices.Contains(out, passphrase) {
+			out = append(out, passphrase)
+		}
+	}
+	return
+}
+
+// prompt asks for the passphrase of a hint, unless it's been asked already.
+func (k *Keyring) prompt(hint string) (out string, ok bool) {
+	if k.Prompt == nil || k.asked[hint] {
+		return
+	}
+	if k.asked == nil {
+		k.asked = make(map[string]bool)
+	}
+	k.asked[hint] = true
+	out, err := k.Prompt(hint)
+	if err != nil || out == "" {
+		return "", false
+	}
+	if k.Hints == nil {
+		k.Hints = make(map[string]string)
+	}
+	k.Hints[hint] = out
+	return out, true
+}
+
+var (
+	enCrypt     = regexp.MustCompile(`(?s)<en-crypt(\s[^>]*)?>(.*?)</en-crypt>`)
+	enCryptAttr = regexp.MustCompile(`([a-z]+)\s*=\s*"([^"]*)"`)
+)
+
+// Decrypt replaces each en-crypt element of the content with its plain text,
+// which is usually markup, when one of the passphrases decrypts it. The others
+// are left as they are, the output failed is the number of them. A nil
+// Keyring decrypts nothing.
+func (k *Keyring) Decrypt(content string) (out string, failed int) {
+	out = enCrypt.ReplaceAllStringFunc(content, func(element string) string {
+		match := enCrypt.FindStringSubmatch(element)
+		attrs := map[string]string{"cipher": "RC2", "length": "64"}
+		for _, attr := range enCryptAttr.FindAllStringSubmatch(match[1], -1) {
+			attrs[attr[1]] = html.UnescapeString(attr[2])
+		}
+		if k == nil {
+			failed++
+			return element
+		}
+		body := strings.TrimSpace(match[2])
+		for _, passphrase := range k.passphrases(attrs["hint"]) {
+			if text, err := DecryptText(attrs["cipher"], attrs["length"], body, passphrase); err == nil {
+				return text
+			}
+		}
+		if passphrase, ok := k.prompt(attrs["hint"]); ok {
+			if text, err := DecryptText(attrs["cipher"], attrs["length"], body, passphrase); err == nil {
+				return text
+			}
+		}
+		failed++
+		return element
+	})
+	return
+}
+
+// DecryptText decrypts the base64 body of an en-crypt element, with the values
+// of its cipher and length attributes. The error is ErrPassphrase if the
+// passphrase doesn't match. With "AES", the scheme is that of current Evernote
+// clients: the data starts with "ENC0", followed by a salt for the key, a salt
+// for the HMAC key and an IV, each 16 bytes. Both keys are derived with
+// PBKDF2-SHA256, 50000 iterations. The ciphertext is AES-CBC, and the last 32
+// bytes are an HMAC-SHA256 of everything before it. With "RC2", the legacy
+// scheme, the key is the MD5 hash of the passphrase.
+func DecryptText(cipherName, length, body, passphrase string) (out string, err error) {
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return
+	}
+	bitLength, err := strconv.Atoi(length)
+	if err != nil {
+		err = fmt.Errorf("invalid length %q", length)
+		return
+	}
+	switch strings.ToUpper(cipherName) {
+	case "AES":
+		if bitLength != 128 && bitLength != 192 && bitLength != 256 {
+			err = fmt.Errorf("invalid AES length %d, should be 128, 192 or 256", bitLength)
+			return
+		}
+		return decryptAES(data, bitLength, passphrase)
+	case "RC2":
+		if bitLength < 1 || bitLength > 1024 {
+			err = fmt.Errorf("invalid RC2 length %d, should be from 1 to 1024", bitLength)
+			return
+		}
+		return decryptRC2(data, bitLength, passphrase)
+	default:
+		err = fmt.Errorf("unsupported cipher %q", cipherName)
+	}
+	return
+}
+
+const (
+	aesHeader     = "ENC0"
+	aesSaltSize   = 16
+	aesIterations = 50000
+)
+
+func decryptAES(data []byte, bitLength int, passphrase string) (out string, err error) {
+	const headerSize = len(aesHeader) + 2*aesSaltSize + aes.BlockSize
+	if len(data) < headerSize+aes.BlockSize+sha256.Size || string(data[:len(aesHeader)]) != aesHeader {
+		err = errors.New("invalid AES data")
+		return
+	}
+	keySize := bitLength / 8
+	salt := data[len(aesHeader) : len(aesHeader)+aesSaltSize]
+	hmacSalt := data[len(aesHeader)+aesSaltSize : len(aesHeader)+2*aesSaltSize]
+	iv := data[len(aesHeader)+2*aesSaltSize : headerSize]
+	body, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+
+	mac := hmac.New(sha256.New, pbkdf2.Key([]byte(passphrase), hmacSalt, aesIterations, keySize, sha256.New))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), sum) {
+		err = ErrPassphrase
+		return
+	}
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, aesIterations, keySize, sha256.New))
+	if err != nil {
+		return
+	}
+	ciphertext := body[headerSize:]
+	if len(ciphertext)%aes.BlockSize != 0 {
+		err = errors.New("invalid AES data")
+		return
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding < 1 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		err = errors.New("invalid AES padding")
+		return
+	}
+	out = string(plaintext[:len(plaintext)-padding])
+	return
+}
+
+// decryptRC2 decrypts blocks one at a time, without chaining. The plaintext
+// starts with the checksum of the text, see rc2Checksum, which tells whether
+// the passphrase matches, and it's padded with zeros.
+func decryptRC2(data []byte, bitLength int, passphrase string) (out string, err error) {
+	if len(data) < rc2.BlockSize || len(data)%rc2.BlockSize != 0 {
+		err = errors.New("invalid RC2 data")
+		return
+	}
+	key := md5.Sum([]byte(passphrase))
+	block, err := rc2.NewCipher(key[:], bitLength)
+	if err != nil {
+		return
+	}
+	plaintext := make([]byte, len(data))
+	for i := 0; i < len(data); i += rc2.BlockSize {
+		block.Decrypt(plaintext[i:], data[i:])
+	}
+	text := bytes.TrimRight(plaintext[4:], "\x00")
+	if !strings.EqualFold(string(plaintext[:4]), rc2Checksum(text)) {
+		err = ErrPassphrase
+		return
+	}
+	out = string(text)
+	return
+}
+
+// rc2Checksum is how Evernote's legacy clients, which encrypted in JavaScript,
+// prefix the text: the first four hex digits of its CRC32 checksum.
+func rc2Checksum(text []byte) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(text))[:4]
+}
@@ -0,0 +1,172 @@
+package enml_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+	"github.com/rafaelespinoza/notexfr/internal/enml/rc2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// encryptAES is the scheme of current Evernote clients, see enml.DecryptText.
+func encryptAES(t *testing.T, text, passphrase string) string {
+	t.Helper()
+	salt, hmacSalt, iv := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 16), bytes.Repeat([]byte{3}, 16)
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, 50000, 16, sha256.New))
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(text)%aes.BlockSize
+	plaintext := append([]byte(text), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	data := append([]byte("ENC0"), salt...)
+	data = append(data, hmacSalt...)
+	data = append(data, iv...)
+	data = append(data, ciphertext...)
+	mac := hmac.New(sha256.New, pbkdf2.Key([]byte(passphrase), hmacSalt, 50000, 16, sha256.New))
+	mac.Write(data)
+	return base64.StdEncoding.EncodeToString(mac.Sum(data))
+}
+
+// encryptRC2 is the legacy scheme, see enml.DecryptText.
+func encryptRC2(t *testing.T, text, passphrase string) string {
+	t.Helper()
+	key := md5.Sum([]byte(passphrase))
+	block, err := rc2.NewCipher(key[:], 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := fmt.Appendf(nil, "%08x", crc32.ChecksumIEEE([]byte(text)))[:4]
+	plaintext = append(plaintext, text...)
+	if rem := len(plaintext) % rc2.BlockSize; rem != 0 {
+		plaintext = append(plaintext, make([]byte, rc2.BlockSize-rem)...)
+	}
+	data := make([]byte, len(plaintext))
+	for i := 0; i < len(data); i += rc2.BlockSize {
+		block.Encrypt(data[i:], plaintext[i:])
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// legacyCrypt is an en-crypt element in the legacy format, as it appears in
+// notes: without cipher and length attributes, so it's RC2 with 64 bits. The
+// text is "<div>legacy secret</div>" and the passphrase is "swordfish". It was
+// made with encryptRC2 and is kept as is, so that a change to the format of
+// either one is caught.
+const legacyCrypt = `<en-crypt hint="fish">uq99TTwrRBWrxcQGPcefv+HlpFO4yA+KfzrS+fmvRWQ=</en-crypt>`
+
+func TestDecryptText(t *testing.T) {
+	tests := []struct {
+		name, cipher, length, body string
+	}{
+		{"AES", "AES", "128", encryptAES(t, "<div>secret</div>", "hunter2")},
+		{"RC2", "RC2", "64", encryptRC2(t, "<div>secret</div>", "hunter2")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := enml.DecryptText(test.cipher, test.length, test.body, "hunter2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "<div>secret</div>" {
+				t.Errorf("wrong text; got %q, expected %q", got, "<div>secret</div>")
+			}
+			if _, err = enml.DecryptText(test.cipher, test.length, test.body, "wrong"); !errors.Is(err, enml.ErrPassphrase) {
+				t.Errorf("wrong error; got %v, expected %v", err, enml.ErrPassphrase)
+			}
+		})
+	}
+
+	t.Run("legacy", func(t *testing.T) {
+		keyring := enml.Keyring{Passphrases: []string{"swordfish"}}
+		got, failed := keyring.Decrypt("<en-note>" + legacyCrypt + "</en-note>")
+		if expected := "<en-note><div>legacy secret</div></en-note>"; got != expected || failed != 0 {
+			t.Errorf("wrong output; got %q, %d failures, expected %q", got, failed, expected)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := enml.DecryptText("DES", "64", "AAAA", "hunter2"); err == nil {
+			t.Error("expected an error for an unsupported cipher")
+		}
+		if _, err := enml.DecryptText("AES", "128", "not base64!", "hunter2"); err == nil {
+			t.Error("expected an error for invalid base64")
+		}
+		aesBody, rc2Body := encryptAES(t, "x", "hunter2"), encryptRC2(t, "x", "hunter2")
+		for _, length := range []string{"-8", "0", "64", "100", "512"} {
+			if _, err := enml.DecryptText("AES", length, aesBody, "hunter2"); err == nil {
+				t.Errorf("expected an error for AES length %s", length)
+			}
+		}
+		for _, length := range []string{"-8", "0", "1025"} {
+			if _, err := enml.DecryptText("RC2", length, rc2Body, "hunter2"); err == nil {
+				t.Errorf("expected an error for RC2 length %s", length)
+			}
+		}
+	})
+}
+
+func TestKeyring(t *testing.T) {
+	content := `<en-note><div>a</div>` +
+		`<en-crypt cipher="AES" length="128" hint="the usual">` + encryptAES(t, "<div>one</div>", "hunter2") + `</en-crypt>` +
+		`<en-crypt hint="legacy">` + encryptRC2(t, "two", "swordfish") + `</en-crypt>` +
+		`</en-note>`
+
+	t.Run("nil", func(t *testing.T) {
+		var keyring *enml.Keyring
+		got, failed := keyring.Decrypt(content)
+		if got != content || failed != 2 {
+			t.Errorf("expected content as is and 2 failures; got %q, %d", got, failed)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		keyring, err := enml.ParseKeyring(strings.NewReader(`{"passphrases": ["nope", "swordfish"], "hints": {"the usual": "hunter2"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, failed := keyring.Decrypt(content)
+		if expected := `<en-note><div>a</div><div>one</div>two</en-note>`; got != expected || failed != 0 {
+			t.Errorf("wrong output; got %q, %d failures, expected %q", got, failed, expected)
+		}
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		var asked []string
+		keyring := enml.Keyring{
+			Passphrases: []string{"hunter2"},
+			Prompt: func(hint string) (string, error) {
+				asked = append(asked, hint)
+				return "wrong", nil
+			},
+		}
+		got, failed := keyring.Decrypt(content + content)
+		if failed != 2 || !strings.Contains(got, "<div>one</div>") || strings.Count(got, "<en-crypt") != 2 {
+			t.Errorf("expected the RC2 elements to fail; got %q, %d failures", got, failed)
+		}
+		if len(asked) != 1 || asked[0] != "legacy" {
+			t.Errorf("expected to be asked once for %q; got %q", "legacy", asked)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, input := range []string{`{}`, `{"passphrase": "x"}`, `[`} {
+			if _, err := enml.ParseKeyring(strings.NewReader(input)); !errors.Is(err, enml.ErrKeyring) {
+				t.Errorf("input %s; wrong error; got %v, expected %v", input, err, enml.ErrKeyring)
+			}
+		}
+	})
+}
@@ -0,0 +1,131 @@
+// Package enml handles the Evernote Markup Language, which is the format of
+// the content of an Evernote note. It's a subset of XHTML with a root element
+// of en-note. Read more at https://dev.evernote.com/doc/articles/enml.php.
+package enml
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	xhtml "golang.org/x/net/html"
+)
+
+// Prolog is the required start of a note's content.
+const Prolog = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+`
+
+// FromText converts plain text to ENML. Each line becomes a div, so that line
+// breaks are kept, and any markup in the text is escaped.
+func FromText(text string) string {
+	var bld strings.Builder
+	bld.WriteString(Prolog)
+	bld.WriteString("<en-note>")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text != "" {
+		for _, line := range strings.Split(text, "\n") {
+			if line == "" {
+				bld.WriteString("<div><br/></div>")
+			} else {
+				bld.WriteString("<div>" + html.EscapeString(line) + "</div>")
+			}
+		}
+	}
+	bld.WriteString("</en-note>")
+	return bld.String()
+}
+
+var (
+	enNoteStart  = regexp.MustCompile(`<en-note[\s/>]`)
+	enMediaHash  = regexp.MustCompile(`<en-media[^>]*\shash="([0-9a-fA-F]{32})"`)
+	enNoteClosed = regexp.MustCompile(`<en-note([^>]*)/>`)
+)
+
+// IsENML says whether the content has an en-note element, as opposed to being
+// plain text.
+func IsENML(content string) bool { return enNoteStart.MatchString(content) }
+
+// Document makes the content of a note acceptable to Evernote. Content that
+// already has an en-note element, such as from an ENEX file, is kept as is,
+// except for the prolog, which is added when missing. Anything else is
+// treated as plain text. Attachments that aren't referenced in the content
+// are appended to it, otherwise they wouldn't be visible.
+func Document(content string, attachments []*entity.Attachment) string {
+	loc := enNoteStart.FindStringIndex(content)
+	if loc == nil {
+		content = FromText(content)
+	} else {
+		content = Prolog + content[loc[0]:]
+	}
+	if len(attachments) < 1 {
+		return content
+	}
+
+	referenced := make(map[string]bool)
+	for _, match := range enMediaHash.FindAllStringSubmatch(content, -1) {
+		referenced[strings.ToLower(match[1])] = true
+	}
+	var media strings.Builder
+	for _, att := range attachments {
+		hash := AttachmentHash(att)
+		if !referenced[hash] {
+			fmt.Fprintf(&media, `<div><en-media type="%s" hash="%s"/></div>`, html.EscapeString(att.MIME), hash)
+		}
+	}
+	if media.Len() == 0 {
+		return content
+	}
+	if loc := enNoteClosed.FindStringSubmatchIndex(content); loc != nil {
+		// expand <en-note/> so there's somewhere to put the media.
+		content = content[:loc[0]] + "<en-note" + content[loc[2]:loc[3]] + "></en-note>" + content[loc[1]:]
+	}
+	end := strings.LastIndex(content, "</en-note>")
+	if end < 0 {
+		return content
+	}
+	return content[:end] + media.String() + content[end:]
+}
+
+// AttachmentHash is how an attachment is referenced in ENML.
+func AttachmentHash(att *entity.Attachment) string { return att.MD5() }
+
+// HTML extracts the children of the en-note element as HTML.
+func HTML(content string) (string, error) {
+	note, err := enNote(content)
+	if err != nil {
+		return "", err
+	}
+	var bld strings.Builder
+	for curr := note.FirstChild; curr != nil; curr = curr.NextSibling {
+		if err = xhtml.Render(&bld, curr); err != nil {
+			return "", err
+		}
+	}
+	return bld.String(), nil
+}
+
+// enNote parses the content and finds its en-note element.
+func enNote(content string) (*xhtml.Node, error) {
+	root, err := xhtml.Parse(strings.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	// descend to <en-note>.
+	var curr *xhtml.Node
+	curr = root.LastChild
+	if curr == nil || curr.Data != "html" {
+		return nil, fmt.Errorf("could not find node: html")
+	}
+	curr = curr.LastChild
+	if curr == nil || curr.Data != "body" {
+		return nil, fmt.Errorf("could not find node: html.body")
+	}
+	curr = curr.FirstChild
+	if curr == nil || curr.Data != "en-note" {
+		return nil, fmt.Errorf("could not find node: html.body.en-note")
+	}
+	return curr, nil
+}
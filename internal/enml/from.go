@@ -0,0 +1,344 @@
+package enml
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedElements are the elements of ENML, other than en-note, en-media and
+// en-todo.
+var allowedElements = map[string]bool{
+	"a": true, "abbr": true, "acronym": true, "address": true, "area": true, "b": true, "bdo": true,
+	"big": true, "blockquote": true, "br": true, "caption": true, "center": true, "cite": true,
+	"code": true, "col": true, "colgroup": true, "dd": true, "del": true, "dfn": true, "div": true,
+	"dl": true, "dt": true, "em": true, "font": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "hr": true, "i": true, "img": true, "ins": true, "kbd": true, "li": true,
+	"map": true, "ol": true, "p": true, "pre": true, "q": true, "s": true, "samp": true, "small": true,
+	"span": true, "strike": true, "strong": true, "sub": true, "sup": true, "table": true,
+	"tbody": true, "td": true, "tfoot": true, "th": true, "thead": true, "tr": true, "tt": true,
+	"u": true, "ul": true, "var": true, "en-media": true, "en-todo": true,
+}
+
+// droppedElements aren't content, so they're left out along with what's in
+// them. Other elements that ENML doesn't allow are replaced by their children.
+var droppedElements = map[string]bool{
+	"applet": true, "base": true, "basefont": true, "button": true, "embed": true, "form": true,
+	"frame": true, "frameset": true, "head": true, "iframe": true, "input": true, "link": true,
+	"meta": true, "noscript": true, "object": true, "param": true, "script": true, "select": true,
+	"style": true, "svg": true, "textarea": true, "title": true,
+}
+
+// droppedAttributes aren't allowed on any element of ENML, nor are attributes
+// for events, such as onclick, or for data.
+var droppedAttributes = map[string]bool{
+	"accesskey": true, "class": true, "data": true, "dynsrc": true, "id": true, "tabindex": true,
+}
+
+var checkboxPattern = regexp.MustCompile(`(` + CheckboxOpen + `|` + CheckboxDone + `) ?`)
+
+// FromHTML converts HTML, such as the text of a StandardNotes note, to ENML.
+// Elements that ENML doesn't allow are replaced by their children, or left out
+// along with them if they aren't content, such as scripts and forms. So are
+// attributes that aren't allowed, such as id, class and event handlers. The
+// checkbox characters CheckboxOpen and CheckboxDone become en-todo elements,
+// which undoes Checkboxes.
+func FromHTML(content string) (string, error) {
+	body := &xhtml.Node{Type: xhtml.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := xhtml.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return "", err
+	}
+	note := &xhtml.Node{Type: xhtml.ElementNode, Data: "en-note"}
+	for _, node := range nodes {
+		appendENML(note, node)
+	}
+	var bld strings.Builder
+	bld.WriteString(Prolog)
+	if err = xhtml.Render(&bld, note); err != nil {
+		return "", err
+	}
+	return bld.String(), nil
+}
+
+// appendENML adds what's allowed of a node, and its descendants, to parent.
+func appendENML(parent, n *xhtml.Node) {
+	switch n.Type {
+	case xhtml.TextNode:
+		appendText(parent, n.Data)
+		return
+	case xhtml.ElementNode:
+	default:
+		return
+	}
+	if droppedElements[n.Data] {
+		return
+	} else if !allowedElements[n.Data] {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			appendENML(parent, child)
+		}
+		return
+	}
+	out := &xhtml.Node{Type: xhtml.ElementNode, Data: n.Data, DataAtom: n.DataAtom}
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || droppedAttributes[key] || strings.HasPrefix(key, "on") || strings.HasPrefix(key, "data-") {
+			continue
+		}
+		out.Attr = append(out.Attr, xhtml.Attribute{Key: key, Val: attr.Val})
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		appendENML(out, child)
+	}
+	parent.AppendChild(out)
+}
+
+// appendText adds text to parent, with an en-todo element for each checkbox.
+func appendText(parent *xhtml.Node, text string) {
+	var start int
+	for _, loc := range checkboxPattern.FindAllStringSubmatchIndex(text, -1) {
+		if loc[0] > start {
+			parent.AppendChild(&xhtml.Node{Type: xhtml.TextNode, Data: text[start:loc[0]]})
+		}
+		todo := &xhtml.Node{Type: xhtml.ElementNode, Data: "en-todo"}
+		if text[loc[2]:loc[3]] == CheckboxDone {
+			todo.Attr = []xhtml.Attribute{{Key: "checked", Val: "true"}}
+		}
+		parent.AppendChild(todo)
+		start = loc[1]
+	}
+	if start < len(text) {
+		parent.AppendChild(&xhtml.Node{Type: xhtml.TextNode, Data: text[start:]})
+	}
+}
+
+var (
+	mdFence   = regexp.MustCompile("^ {0,3}(```|~~~)")
+	mdHeading = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?[ \t]*$`)
+	mdRule    = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdQuote   = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+	mdItem    = regexp.MustCompile(`^( *)([-*+]|\d{1,9}[.)])(?:[ \t]+(.*))?$`)
+	mdTask    = regexp.MustCompile(`^\[([ xX])\](?:[ \t]+(.*))?$`)
+
+	mdEscape      = regexp.MustCompile("\\\\([!-/:-@\\[-`{-~])")
+	mdCode        = regexp.MustCompile("`([^`]+)`")
+	mdLink        = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+)\)`)
+	mdStrong      = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*`)
+	mdStrongUnder = regexp.MustCompile(`__(\S(?:.*?\S)?)__`)
+	mdEm          = regexp.MustCompile(`\*(\S(?:.*?\S)?)\*`)
+	mdEmUnder     = regexp.MustCompile(`(^|\W)_(\S(?:.*?\S)?)_(\W|$)`)
+	mdStrike      = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+	mdProtected   = regexp.MustCompile(protectStart + `(\d+)` + protectEnd)
+)
+
+// FromMarkdown converts Markdown to ENML. It understands what Markdown writes:
+// paragraphs, headings, lists, block quotes, code blocks and rules, with
+// emphasis, code and links. A task list, with items like "- [ ]" or "- [x]",
+// becomes a checklist of en-todo elements. Unlike most Markdown, a line break
+// within a paragraph is kept, since that's how notes tend to be written. HTML
+// in the text is escaped rather than kept.
+func FromMarkdown(text string) (string, error) {
+	var bld strings.Builder
+	markdownBlocks(&bld, strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), false)
+	return FromHTML(bld.String())
+}
+
+// markdownBlocks writes lines of Markdown as HTML. When tight, a paragraph
+// isn't wrapped in a p element, as for the items of a list.
+func markdownBlocks(bld *strings.Builder, lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case mdFence.MatchString(line):
+			fence := mdFence.FindStringSubmatch(line)[1]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			i++
+			bld.WriteString("<pre>" + html.EscapeString(strings.Join(code, "\n")) + "</pre>")
+		case mdHeading.MatchString(line):
+			match := mdHeading.FindStringSubmatch(line)
+			level := strconv.Itoa(len(match[1]))
+			bld.WriteString("<h" + level + ">" + markdownInline(match[2]) + "</h" + level + ">")
+			i++
+		case mdRule.MatchString(line):
+			bld.WriteString("<hr/>")
+			i++
+		case mdQuote.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && mdQuote.MatchString(lines[i]); i++ {
+				quoted = append(quoted, mdQuote.FindStringSubmatch(lines[i])[1])
+			}
+			bld.WriteString("<blockquote>")
+			markdownBlocks(bld, quoted, false)
+			bld.WriteString("</blockquote>")
+		case mdItem.MatchString(line):
+			i = markdownList(bld, lines, i)
+		default:
+			var texts []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsMarkdownBlock(lines[i]); i++ {
+				texts = append(texts, markdownInline(strings.TrimSpace(lines[i])))
+			}
+			if tight {
+				bld.WriteString(strings.Join(texts, "<br/>"))
+			} else {
+				bld.WriteString("<p>" + strings.Join(texts, "<br/>") + "</p>")
+			}
+		}
+	}
+}
+
+func startsMarkdownBlock(line string) bool {
+	for _, pattern := range []*regexp.Regexp{mdFence, mdHeading, mdRule, mdQuote, mdItem} {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// markdownItem is an item of a list. Its lines are without the marker and
+// the indentation of the item.
+type markdownItem struct {
+	lines []string
+	// task is " " or "x" for an item of a task list.
+	task  string
+	loose bool
+}
+
+// markdownList writes the list that starts at lines[i], and returns the index
+// of the line after it. An unordered list of tasks is written as a checklist,
+// like Evernote's, rather than as a list.
+func markdownList(bld *strings.Builder, lines []string, i int) int {
+	first := mdItem.FindStringSubmatch(lines[i])
+	indent, ordered := len(first[1]), first[2][0] >= '0' && first[2][0] <= '9'
+	var items []markdownItem
+	for i < len(lines) {
+		match := mdItem.FindStringSubmatch(lines[i])
+		if match == nil || len(match[1]) != indent || (match[2][0] >= '0' && match[2][0] <= '9') != ordered || mdRule.MatchString(lines[i]) {
+			break
+		}
+		width := len(match[1]) + len(match[2]) + 1
+		item := markdownItem{lines: []string{match[3]}}
+		if task := mdTask.FindStringSubmatch(match[3]); task != nil {
+			item.task, item.lines[0] = strings.ToLower(task[1]), task[2]
+		}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// It's part of the item if the item goes on after it.
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) >= width {
+					item.lines, item.loose = append(item.lines, ""), true
+					continue
+				}
+				break
+			} else if leadingSpaces(line) >= width {
+				item.lines = append(item.lines, line[width:])
+			} else if startsMarkdownBlock(line) {
+				break
+			} else {
+				item.lines = append(item.lines, strings.TrimSpace(line))
+			}
+		}
+		items = append(items, item)
+		// Items may be separated by blank lines.
+		next := i
+		for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+			next++
+		}
+		if next < len(lines) && next > i {
+			if match = mdItem.FindStringSubmatch(lines[next]); match != nil && len(match[1]) == indent {
+				i = next
+			}
+		}
+	}
+
+	checklist := !ordered
+	for _, item := range items {
+		checklist = checklist && item.task != ""
+	}
+	switch {
+	case checklist:
+	case ordered:
+		bld.WriteString("<ol>")
+	default:
+		bld.WriteString("<ul>")
+	}
+	for _, item := range items {
+		if checklist {
+			bld.WriteString("<div>")
+		} else {
+			bld.WriteString("<li>")
+		}
+		switch item.task {
+		case "x":
+			bld.WriteString(`<en-todo checked="true"></en-todo>`)
+		case " ":
+			bld.WriteString(`<en-todo checked="false"></en-todo>`)
+		}
+		markdownBlocks(bld, item.lines, !item.loose)
+		if checklist {
+			bld.WriteString("</div>")
+		} else {
+			bld.WriteString("</li>")
+		}
+	}
+	switch {
+	case checklist:
+	case ordered:
+		bld.WriteString("</ol>")
+	default:
+		bld.WriteString("</ul>")
+	}
+	return i
+}
+
+func leadingSpaces(line string) int { return len(line) - len(strings.TrimLeft(line, " ")) }
+
+// protectStart, protectEnd are around the index of text that's set aside by
+// markdownInline. They're in a private use area of Unicode, so they shouldn't
+// be in the text.
+const protectStart, protectEnd = "\ue000", "\ue001"
+
+// markdownInline converts the emphasis, code and links of some text to HTML.
+// Escaped characters, code and the targets of links are set aside while
+// everything else is converted, so that they're kept as they are.
+func markdownInline(text string) string {
+	var protected []string
+	protect := func(s string) string {
+		protected = append(protected, s)
+		return protectStart + strconv.Itoa(len(protected)-1) + protectEnd
+	}
+	text = mdEscape.ReplaceAllStringFunc(text, func(match string) string {
+		return protect(html.EscapeString(match[1:]))
+	})
+	text = html.EscapeString(text)
+	text = mdCode.ReplaceAllStringFunc(text, func(match string) string {
+		return protect("<code>" + match[1:len(match)-1] + "</code>")
+	})
+	text = mdLink.ReplaceAllStringFunc(text, func(match string) string {
+		parts := mdLink.FindStringSubmatch(match)
+		return `<a href="` + protect(parts[2]) + `">` + parts[1] + "</a>"
+	})
+	text = mdStrong.ReplaceAllString(text, "<b>$1</b>")
+	text = mdStrongUnder.ReplaceAllString(text, "<b>$1</b>")
+	text = mdEm.ReplaceAllString(text, "<i>$1</i>")
+	text = mdEmUnder.ReplaceAllString(text, "$1<i>$2</i>$3")
+	text = mdStrike.ReplaceAllString(text, "<s>$1</s>")
+
+	var restore func(string) string
+	restore = func(s string) string {
+		return mdProtected.ReplaceAllStringFunc(s, func(match string) string {
+			i, _ := strconv.Atoi(match[len(protectStart) : len(match)-len(protectEnd)])
+			return restore(protected[i])
+		})
+	}
+	return restore(text)
+}
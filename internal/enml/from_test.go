@@ -0,0 +1,52 @@
+package enml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+)
+
+func TestFromHTML(t *testing.T) {
+	got, err := enml.FromHTML(`<p class="x" onclick="y" style="color: red">☑ done ☐ <b>open</b></p>` +
+		`<script>alert(1)</script><article><a href="https://example.com" data-id="1">link</a><br></article>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := enml.Prolog + `<en-note><p style="color: red"><en-todo checked="true"></en-todo>done <en-todo></en-todo><b>open</b></p>` +
+		`<a href="https://example.com">link</a><br/></en-note>`
+	if got != expected {
+		t.Errorf("wrong output\ngot:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestFromMarkdown(t *testing.T) {
+	// What's written by Markdown is read back the same way.
+	markdown, err := enml.Markdown(checklist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := enml.FromMarkdown(markdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(content, enml.Prolog+"<en-note><h2>Groceries</h2><div><en-todo checked=\"true\"></en-todo>milk</div>") {
+		t.Errorf("expected a heading and a checklist; got %s", content)
+	}
+	if got, err := enml.Markdown(content); err != nil {
+		t.Fatal(err)
+	} else if got != markdown {
+		t.Errorf("wrong round trip\ngot:\n%s\nexpected:\n%s", got, markdown)
+	}
+
+	got, err := enml.FromMarkdown("one\ntwo <b>\n\n> `a_b` [c](https://example.com/c_d) ~~e~~\n\n```\n*f*\n```")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := enml.Prolog + `<en-note><p>one<br/>two &lt;b&gt;</p>` +
+		`<blockquote><p><code>a_b</code> <a href="https://example.com/c_d">c</a> <s>e</s></p></blockquote>` +
+		`<pre>*f*</pre></en-note>`
+	if got != expected {
+		t.Errorf("wrong output\ngot:\n%s\nexpected:\n%s", got, expected)
+	}
+}
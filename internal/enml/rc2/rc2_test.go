@@ -0,0 +1,48 @@
+package rc2_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml/rc2"
+)
+
+func TestCipher(t *testing.T) {
+	// These are the test vectors of section 5 of RFC 2268.
+	tests := []struct {
+		key, plain, cipher string
+		effectiveBits      int
+	}{
+		{"0000000000000000", "0000000000000000", "ebb773f993278eff", 63},
+		{"ffffffffffffffff", "ffffffffffffffff", "278b27e42e2f0d49", 64},
+		{"3000000000000000", "1000000000000001", "30649edf9be7d2c2", 64},
+		{"88", "0000000000000000", "61a8a244adacccf0", 64},
+		{"88bca90e90875a", "0000000000000000", "6ccf4308974c267f", 64},
+		{"88bca90e90875a7f0f79c384627bafb2", "0000000000000000", "1a807d272bbe5db1", 64},
+		{"88bca90e90875a7f0f79c384627bafb2", "0000000000000000", "2269552ab0f85ca6", 128},
+		{"88bca90e90875a7f0f79c384627bafb216f80a6f85920584c42fceb0be255daf1e", "0000000000000000", "5b78d3a43dfff1f1", 129},
+	}
+	for i, test := range tests {
+		key, _ := hex.DecodeString(test.key)
+		plain, _ := hex.DecodeString(test.plain)
+		expected, _ := hex.DecodeString(test.cipher)
+		block, err := rc2.NewCipher(key, test.effectiveBits)
+		if err != nil {
+			t.Fatalf("test %d; %v", i, err)
+		}
+		got := make([]byte, rc2.BlockSize)
+		block.Encrypt(got, plain)
+		if !bytes.Equal(got, expected) {
+			t.Errorf("test %d; wrong ciphertext; got %x, expected %x", i, got, expected)
+		}
+		block.Decrypt(got, got)
+		if !bytes.Equal(got, plain) {
+			t.Errorf("test %d; wrong plaintext; got %x, expected %x", i, got, plain)
+		}
+	}
+
+	if _, err := rc2.NewCipher(nil, 64); err == nil {
+		t.Error("expected an error for an empty key")
+	}
+}
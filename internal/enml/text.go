@@ -0,0 +1,328 @@
+package enml
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// These are checkboxes in plain text, for en-todo elements.
+const (
+	CheckboxOpen = "☐"
+	CheckboxDone = "☑"
+)
+
+var (
+	enTodo        = regexp.MustCompile(`<en-todo(\s[^>]*?)?/?>(</en-todo>)?`)
+	enTodoChecked = regexp.MustCompile(`(?i)\schecked\s*=\s*"true"`)
+	whitespace    = regexp.MustCompile(`\s+`)
+)
+
+// Checkboxes replaces each en-todo element of the content with a checkbox
+// character followed by a space, so that it's not lost when the content is
+// rendered as HTML.
+func Checkboxes(content string) string {
+	return enTodo.ReplaceAllStringFunc(content, func(element string) string {
+		if enTodoChecked.MatchString(element) {
+			return CheckboxDone + " "
+		}
+		return CheckboxOpen + " "
+	})
+}
+
+// CountTodos counts the en-todo elements of the content, by whether they're
+// checked.
+func CountTodos(content string) (open, done int) {
+	for _, element := range enTodo.FindAllString(content, -1) {
+		if enTodoChecked.MatchString(element) {
+			done++
+		} else {
+			open++
+		}
+	}
+	return
+}
+
+// Markdown converts the children of the en-note element to Markdown. Each
+// en-todo element becomes an item of a task list, "- [ ]" or "- [x]". Block
+// elements become paragraphs, lists, headings or code blocks, and emphasis,
+// code and links are kept. Other markup, media and tables are reduced to their
+// text.
+func Markdown(content string) (string, error) { return render(content, true) }
+
+// Text converts the children of the en-note element to plain text. Each
+// en-todo element becomes a checkbox character, CheckboxOpen or CheckboxDone.
+// Block elements and line breaks become lines, and items of lists are marked
+// with "-" or their number.
+func Text(content string) (string, error) { return render(content, false) }
+
+func render(content string, markdown bool) (string, error) {
+	note, err := enNote(content)
+	if err != nil {
+		return "", err
+	}
+	w := textWriter{markdown: markdown}
+	w.children(note)
+	w.flush()
+	return w.String(), nil
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`,
+)
+
+type textWriter struct {
+	markdown bool
+	lines    []textLine
+	curr     textLine
+	// lists are the lists that the current element is in, innermost last.
+	lists []textList
+	// indent is where the lines of the current list item start, after the
+	// first one.
+	indent string
+}
+
+// A textLine is a paragraph in Markdown.
+type textLine struct {
+	prefix, text string
+	// item is set for the items of a list, which aren't separated by blank
+	// lines in Markdown.
+	item bool
+}
+
+type textList struct {
+	ordered bool
+	n       int
+	width   int
+}
+
+func (w *textWriter) write(text string) { w.curr.text += text }
+
+// flush ends the current line, if there's anything on it.
+func (w *textWriter) flush() {
+	if strings.TrimSpace(w.curr.text) == "" {
+		return
+	}
+	w.lines = append(w.lines, textLine{
+		prefix: w.curr.prefix,
+		text:   strings.TrimRight(w.curr.text, " "),
+		item:   w.curr.item,
+	})
+	w.curr = textLine{prefix: w.indent, item: w.curr.item && w.indent != ""}
+}
+
+// lineBreak ends the current line. On its own, it's an empty line, which is
+// kept in plain text.
+func (w *textWriter) lineBreak() {
+	if strings.TrimSpace(w.curr.text) != "" {
+		w.flush()
+	} else if !w.markdown {
+		w.lines = append(w.lines, textLine{})
+	}
+}
+
+func (w *textWriter) text(data string) {
+	data = whitespace.ReplaceAllString(data, " ")
+	if w.curr.text == "" || strings.HasSuffix(w.curr.text, " ") {
+		data = strings.TrimLeft(data, " ")
+	}
+	if data == "" {
+		return
+	}
+	if w.markdown {
+		if w.curr.text == "" && w.curr.prefix == "" && strings.ContainsAny(data[:1], "#-+>") {
+			data = `\` + data
+		}
+		data = markdownEscaper.Replace(data)
+	}
+	w.write(data)
+}
+
+func (w *textWriter) todo(n *xhtml.Node) {
+	checked := strings.EqualFold(attrValue(n, "checked"), "true")
+	if !w.markdown {
+		if checked {
+			w.write(CheckboxDone + " ")
+		} else {
+			w.write(CheckboxOpen + " ")
+		}
+		return
+	}
+	if w.curr.text == "" && w.curr.prefix == "" {
+		w.curr.prefix, w.curr.item = "- ", true
+	}
+	if checked {
+		w.write("[x] ")
+	} else {
+		w.write("[ ] ")
+	}
+}
+
+func (w *textWriter) children(n *xhtml.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.node(child)
+	}
+}
+
+func (w *textWriter) node(n *xhtml.Node) {
+	if n.Type == xhtml.TextNode {
+		w.text(n.Data)
+		return
+	} else if n.Type != xhtml.ElementNode {
+		return
+	}
+
+	switch n.Data {
+	case "en-todo":
+		// The HTML parser doesn't know that it's empty, so what follows it
+		// may be its children.
+		w.todo(n)
+		w.children(n)
+	case "br":
+		w.lineBreak()
+	case "ul", "ol":
+		w.flush()
+		list := textList{ordered: n.Data == "ol", width: 2}
+		if list.ordered {
+			list.width = 3
+		}
+		w.lists = append(w.lists, list)
+		w.children(n)
+		w.flush()
+		w.lists = w.lists[:len(w.lists)-1]
+		w.indent = w.listIndent()
+		w.curr = textLine{prefix: w.indent, item: w.indent != ""}
+	case "li":
+		w.listItem(n)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		w.flush()
+		if w.markdown {
+			w.curr.prefix = strings.Repeat("#", int(n.Data[1]-'0')) + " "
+		}
+		w.children(n)
+		w.flush()
+	case "pre":
+		w.flush()
+		text := strings.TrimRight(textContent(n), "\n")
+		if w.markdown {
+			text = "```\n" + text + "\n```"
+		}
+		w.lines = append(w.lines, textLine{text: text})
+	case "hr":
+		w.flush()
+		w.lines = append(w.lines, textLine{text: "---"})
+	case "td", "th":
+		if strings.TrimSpace(w.curr.text) != "" {
+			w.write(" | ")
+		}
+		w.children(n)
+	case "b", "strong":
+		w.inline(n, "**", "**")
+	case "i", "em":
+		w.inline(n, "_", "_")
+	case "s", "strike", "del":
+		w.inline(n, "~~", "~~")
+	case "code", "tt":
+		w.inline(n, "`", "`")
+	case "a":
+		if href := attrValue(n, "href"); href != "" {
+			w.inline(n, "[", "]("+href+")")
+		} else {
+			w.children(n)
+		}
+	case "en-media", "img", "script", "style", "head", "title":
+	case "div", "p", "blockquote", "table", "thead", "tbody", "tfoot", "tr", "section", "article", "center":
+		w.flush()
+		w.children(n)
+		w.flush()
+	default:
+		w.children(n)
+	}
+}
+
+// inline wraps the text of an element with Markdown syntax.
+func (w *textWriter) inline(n *xhtml.Node, start, end string) {
+	if !w.markdown {
+		w.children(n)
+		return
+	}
+	w.write(start)
+	w.children(n)
+	w.write(end)
+}
+
+func (w *textWriter) listItem(n *xhtml.Node) {
+	w.flush()
+	if len(w.lists) < 1 {
+		w.lists = append(w.lists, textList{width: 2})
+		defer func() { w.lists = w.lists[:0] }()
+	}
+	list := &w.lists[len(w.lists)-1]
+	list.n++
+	marker := "- "
+	if list.ordered {
+		marker = strconv.Itoa(list.n) + ". "
+	}
+	outer := w.listIndent()[list.width:]
+	w.indent = outer + strings.Repeat(" ", len(marker))
+	w.curr = textLine{prefix: outer + marker, item: true}
+	w.children(n)
+	w.flush()
+	w.indent = w.listIndent()
+	w.curr = textLine{prefix: w.indent, item: w.indent != ""}
+}
+
+// listIndent is where the text of the items of the innermost list starts.
+func (w *textWriter) listIndent() string {
+	var width int
+	for _, list := range w.lists {
+		width += list.width
+	}
+	return strings.Repeat(" ", width)
+}
+
+func (w *textWriter) String() string {
+	var bld strings.Builder
+	var prev *textLine
+	for i := range w.lines {
+		line := &w.lines[i]
+		if w.markdown && line.text == "" {
+			continue
+		}
+		if prev != nil {
+			if w.markdown && !(prev.item && line.item) {
+				bld.WriteString("\n\n")
+			} else {
+				bld.WriteString("\n")
+			}
+		}
+		bld.WriteString(line.prefix + line.text)
+		prev = line
+	}
+	return bld.String()
+}
+
+// textContent is the text of a node and its descendants, as it is.
+func textContent(n *xhtml.Node) string {
+	if n.Type == xhtml.TextNode {
+		return n.Data
+	} else if n.Type == xhtml.ElementNode && n.Data == "br" {
+		return "\n"
+	}
+	var bld strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		bld.WriteString(textContent(child))
+	}
+	return bld.String()
+}
+
+func attrValue(n *xhtml.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
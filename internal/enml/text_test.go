@@ -0,0 +1,59 @@
+package enml_test
+
+import (
+	"testing"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+)
+
+const checklist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><h2>Groceries</h2><div><en-todo checked="true"/>milk</div><div><en-todo checked="false"/>eggs_large</div><div><en-todo></en-todo>bread</div>` +
+	`<div><br/></div><div>see <b>also</b> <a href="https://example.com">this</a></div>` +
+	`<ul><li>one</li><li>two<ol><li>a</li><li><en-todo checked="true"/>b</li></ol></li></ul><en-media type="image/png" hash="abc"/></en-note>`
+
+func TestMarkdown(t *testing.T) {
+	got, err := enml.Markdown(checklist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "## Groceries\n\n" +
+		"- [x] milk\n- [ ] eggs\\_large\n- [ ] bread\n\n" +
+		"see **also** [this](https://example.com)\n\n" +
+		"- one\n- two\n  1. a\n  2. [x] b"
+	if got != expected {
+		t.Errorf("wrong output\ngot:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	if _, err = enml.Markdown("<div>not a note</div>"); err == nil {
+		t.Error("expected an error for content without en-note")
+	}
+}
+
+func TestText(t *testing.T) {
+	got, err := enml.Text(checklist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Groceries\n" +
+		"☑ milk\n☐ eggs_large\n☐ bread\n\n" +
+		"see also this\n" +
+		"- one\n- two\n  1. a\n  2. ☑ b"
+	if got != expected {
+		t.Errorf("wrong output\ngot:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestCheckboxes(t *testing.T) {
+	got, err := enml.HTML(enml.Checkboxes(`<en-note><div><en-todo checked="true"/>milk</div><div><en-todo/>eggs</div></en-note>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<div>☑ milk</div><div>☐ eggs</div>"; got != expected {
+		t.Errorf("wrong output; got %q, expected %q", got, expected)
+	}
+
+	if open, done := enml.CountTodos(checklist); open != 2 || done != 2 {
+		t.Errorf("wrong counts; got %d open, %d done, expected 2, 2", open, done)
+	}
+}
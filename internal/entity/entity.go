@@ -16,6 +16,8 @@ type (
 		CreatedAt time.Time
 		// UpdatedAt is modeled after the edam.Notebook's ServiceUpdated field.
 		UpdatedAt time.Time
+		// Origin is set when the notebook belongs to another account.
+		Origin *Origin `json:",omitempty"`
 
 		// ID represents the GUID of the resource in Evernote.
 		ID string
@@ -39,6 +41,13 @@ type (
 		UpdatedAt time.Time
 		// Attributes is extra metadata about the note.
 		Attributes *Attributes
+		// Origin is set when the note belongs to another account.
+		Origin *Origin `json:",omitempty"`
+		// Attachments are files embedded in the note, which correspond to
+		// Evernote Resources.
+		Attachments []*Attachment `json:",omitempty"`
+		// Versions are earlier revisions of the note, if they were fetched.
+		Versions []*NoteVersion `json:",omitempty"`
 
 		// ID represents the GUID of the resource in Evernote.
 		ID string
@@ -49,12 +58,40 @@ type (
 		Name string
 		// ParentID is the GUID of the parent tag, if any.
 		ParentID string
+		// Origin is set when the tag belongs to another account.
+		Origin *Origin `json:",omitempty"`
+
+		// ID represents the GUID of the resource in Evernote.
+		ID string
+	}
+	// A SavedSearch is a named search query and corresponds to an Evernote
+	// SavedSearch.
+	SavedSearch struct {
+		// Name is a unique user-defined name.
+		Name string
+		// Query is the search expression, in the Evernote search grammar.
+		Query string
 
 		// ID represents the GUID of the resource in Evernote.
 		ID string
 	}
 )
 
+// A Collection is a set of data from one account of a service, in a form
+// that's independent of any particular service. It's the common model that
+// data formats are converted to and from. Notes refer to notebooks and tags by
+// ID, and their content is ENML.
+type Collection struct {
+	// Service names where the data is from, such as "evernote.com". It's empty
+	// when unknown. Other formats may keep service-specific metadata under
+	// this name.
+	Service       string
+	Notebooks     []*Notebook
+	Notes         []*Note
+	Tags          []*Tag
+	SavedSearches []*SavedSearch
+}
+
 // These are metadata entity types.
 type (
 	// Attributes is additional Note metadata and corresponds to Evernote
@@ -64,6 +101,49 @@ type (
 		SourceApplication string // TODO: maybe remove? curious to know what this is
 		Source            string
 		SourceURL         string
+		// ReminderOrder is set when the note has a reminder. ReminderTime is
+		// when it's due, if it has a date.
+		ReminderOrder int64      `json:",omitempty"`
+		ReminderTime  *time.Time `json:",omitempty"`
+	}
+	// An Attachment is a file in a Note and corresponds to an Evernote
+	// Resource. In ENML content, it's referenced by the MD5 hash of Data.
+	Attachment struct {
+		Filename string
+		MIME     string
+		Data     []byte `json:",omitempty"`
+		// Hash is the hex-encoded MD5 hash of the data. It's only set when
+		// Data is kept elsewhere, such as in a separate file.
+		Hash string `json:",omitempty"`
+	}
+	// A NoteVersion is an earlier revision of a Note and corresponds to an
+	// Evernote NoteVersionId, along with the content at that revision.
+	NoteVersion struct {
+		// UpdateSequenceNum identifies the revision among others of the note.
+		UpdateSequenceNum int32
+		// Title is the subject of the note at this revision.
+		Title string
+		// Content is the text content of the note at this revision.
+		Content string
+		// UpdatedAt is when the note was last edited before this revision.
+		UpdatedAt time.Time
+		// SavedAt is when the revision was saved by the service.
+		SavedAt time.Time
+	}
+	// Origin describes a resource that is readable from the user's account,
+	// but owned by another account. It corresponds to an Evernote
+	// LinkedNotebook, such as a notebook shared by another user or a business
+	// notebook. A nil Origin means the resource is owned by the user.
+	Origin struct {
+		// LinkedNotebookID is the GUID of the LinkedNotebook in the user's
+		// account.
+		LinkedNotebookID string
+		// ShareName is the name of the notebook as the user sees it.
+		ShareName string
+		// Username is the name of the owner of the notebook.
+		Username string
+		// BusinessID is set when the notebook belongs to a business account.
+		BusinessID int32 `json:",omitempty"`
 	}
 )
 
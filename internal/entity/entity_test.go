@@ -1,7 +1,10 @@
 package entity_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rafaelespinoza/notexfr/internal/entity"
 )
@@ -19,3 +22,377 @@ func TestInterfaceImplementations(t *testing.T) {
 		}
 	}
 }
+
+func TestGraph(t *testing.T) {
+	const (
+		noteA = "0a0a0a0a-0000-4000-8000-00000000000a"
+		noteB = "0b0b0b0b-0000-4000-8000-00000000000b"
+		noteC = "0c0c0c0c-0000-4000-8000-00000000000c"
+	)
+	attachment := &entity.Attachment{Filename: "a.txt", MIME: "text/plain", Data: []byte("hello")}
+	collection := &entity.Collection{
+		Notebooks: []*entity.Notebook{
+			{ID: "nb1", Name: "Inbox"},
+			{ID: "nb2", Name: "Travel", Stack: "Personal"},
+			{ID: "nb3", Name: "Recipes", Stack: "Personal"},
+		},
+		Tags: []*entity.Tag{
+			{ID: "t1", Name: "places"},
+			{ID: "t2", Name: "cities", ParentID: "t1"},
+			{ID: "t3", Name: "Chicago", ParentID: "t2"},
+		},
+		Notes: []*entity.Note{
+			{
+				ID: noteA, Title: "Trip", NotebookID: "nb2", TagIDs: []string{"t3"},
+				Content:     `<en-note><a href="evernote:///view/1/s1/` + noteB + `/` + noteB + `/">b</a></en-note>`,
+				Attachments: []*entity.Attachment{attachment},
+			},
+			{ID: noteB, Title: "Packing list", NotebookID: "nb2", TagIDs: []string{"t1", "t3"}},
+			{ID: noteC, Title: "Trip", NotebookID: "nb1"},
+		},
+	}
+	graph, err := entity.NewGraph(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("lookups", func(t *testing.T) {
+		if got := graph.Note(noteB); got == nil || got.Title != "Packing list" {
+			t.Errorf("wrong note by ID; got %+v", got)
+		}
+		if got := graph.NotesByTitle("Trip"); len(got) != 2 {
+			t.Errorf("wrong number of notes by title; got %d, expected %d", len(got), 2)
+		}
+		if got := graph.NotebookByName("travel"); got == nil || got.ID != "nb2" {
+			t.Errorf("wrong notebook by name; got %+v", got)
+		}
+		if got := graph.TagByName("CHICAGO"); got == nil || got.ID != "t3" {
+			t.Errorf("wrong tag by name; got %+v", got)
+		}
+		if got := graph.NotesInNotebook("nb2"); len(got) != 2 || got[0].ID != noteA || got[1].ID != noteB {
+			t.Errorf("wrong notes in notebook; got %v", got)
+		}
+		if got := graph.NotesWithTag("t3"); len(got) != 2 {
+			t.Errorf("wrong number of notes with tag; got %d, expected %d", len(got), 2)
+		}
+		if got := graph.Attachment(attachment.MD5()); got != attachment {
+			t.Errorf("wrong attachment by hash; got %+v", got)
+		}
+	})
+
+	t.Run("hierarchies", func(t *testing.T) {
+		path := graph.TagPath("t3")
+		if len(path) != 3 || path[0].ID != "t1" || path[1].ID != "t2" || path[2].ID != "t3" {
+			t.Errorf("wrong tag path; got %v", path)
+		}
+		if got := graph.TagChildren(""); len(got) != 1 || got[0].ID != "t1" {
+			t.Errorf("wrong top level tags; got %v", got)
+		}
+		if got := graph.Stacks(); len(got) != 1 || got[0] != "Personal" {
+			t.Errorf("wrong stacks; got %q", got)
+		}
+		if got := graph.NotebooksInStack("Personal"); len(got) != 2 {
+			t.Errorf("wrong number of notebooks in stack; got %d, expected %d", len(got), 2)
+		}
+	})
+
+	t.Run("links", func(t *testing.T) {
+		if got := graph.Links(noteA); len(got) != 1 || got[0] != noteB {
+			t.Errorf("wrong links; got %q", got)
+		}
+		if got := graph.Backlinks(noteB); len(got) != 1 || got[0] != noteA {
+			t.Errorf("wrong backlinks; got %q", got)
+		}
+		if err := graph.Check(); err != nil {
+			t.Errorf("unexpected integrity error; %v", err)
+		}
+	})
+
+	t.Run("integrity", func(t *testing.T) {
+		broken, err := entity.NewGraph(&entity.Collection{
+			Tags: []*entity.Tag{
+				{ID: "t1", Name: "a", ParentID: "t2"},
+				{ID: "t2", Name: "b", ParentID: "t1"},
+				{ID: "t3", Name: "c", ParentID: "nope"},
+			},
+			Notes: []*entity.Note{
+				{ID: noteA, NotebookID: "nope", TagIDs: []string{"t1", "nope"}, Content: "https://www.evernote.com/shard/s1/nl/1/" + noteC + "/"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = broken.Check()
+		if !errors.Is(err, entity.ErrIntegrity) {
+			t.Fatalf("expected %v; got %v", entity.ErrIntegrity, err)
+		}
+		for _, expected := range []string{
+			"missing notebook", "missing tag", "missing note", "missing parent", `tag "t1" is nested`, `tag "t2" is nested`,
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected error to mention %q; got %v", expected, err)
+			}
+		}
+
+		_, err = entity.NewGraph(&entity.Collection{Notes: []*entity.Note{{ID: noteA}, {ID: noteA}}})
+		if !errors.Is(err, entity.ErrIntegrity) {
+			t.Errorf("expected %v for duplicate IDs; got %v", entity.ErrIntegrity, err)
+		}
+	})
+
+	t.Run("added", func(t *testing.T) {
+		streamed, err := entity.NewGraph(&entity.Collection{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		note := &entity.Note{ID: noteA, TagIDs: []string{"t1"}}
+		if err = streamed.CheckNote(note); !errors.Is(err, entity.ErrIntegrity) {
+			t.Errorf("expected %v before the tag is added; got %v", entity.ErrIntegrity, err)
+		}
+		if err = streamed.AddTag(&entity.Tag{ID: "t1", Name: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		if err = streamed.CheckNote(note); err != nil {
+			t.Errorf("unexpected integrity error; %v", err)
+		}
+		if tag := streamed.TagByName("A"); tag == nil || tag.ID != "t1" {
+			t.Errorf("wrong tag by name; got %v", tag)
+		}
+		if err = streamed.AddTag(&entity.Tag{ID: "t1", Name: "b"}); !errors.Is(err, entity.ErrIntegrity) {
+			t.Errorf("expected %v for duplicate IDs; got %v", entity.ErrIntegrity, err)
+		}
+
+		if err = streamed.AddNote(note); err != nil {
+			t.Fatal(err)
+		}
+		if got := streamed.NotesWithTag("t1"); len(got) != 1 || got[0] != note {
+			t.Errorf("wrong notes with tag; got %v", got)
+		}
+		if err = streamed.AddNote(&entity.Note{ID: noteA}); !errors.Is(err, entity.ErrIntegrity) {
+			t.Errorf("expected %v for duplicate IDs; got %v", entity.ErrIntegrity, err)
+		}
+	})
+}
+
+func TestFilter(t *testing.T) {
+	collection := &entity.Collection{
+		Notebooks: []*entity.Notebook{
+			{ID: "nb1", Name: "Inbox"},
+			{ID: "nb2", Name: "Travel", Stack: "Personal"},
+		},
+		Tags: []*entity.Tag{
+			{ID: "t1", Name: "places"},
+			{ID: "t2", Name: "Chicago", ParentID: "t1"},
+			{ID: "t3", Name: "draft"},
+		},
+		Notes: []*entity.Note{
+			{
+				ID: "n1", Title: "Trip to Chicago", NotebookID: "nb2", TagIDs: []string{"t2"},
+				CreatedAt: time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local),
+				UpdatedAt: time.Date(2021, 1, 2, 0, 0, 0, 0, time.Local),
+			},
+			{
+				ID: "n2", Title: "Packing list", NotebookID: "nb2", TagIDs: []string{"t3"},
+				CreatedAt: time.Date(2019, 6, 1, 0, 0, 0, 0, time.Local),
+				UpdatedAt: time.Date(2019, 6, 1, 0, 0, 0, 0, time.Local),
+			},
+			{
+				ID: "n3", Title: "Article", NotebookID: "nb1",
+				Attributes: &entity.Attributes{Source: "web.clip"},
+				CreatedAt:  time.Date(2020, 3, 1, 0, 0, 0, 0, time.Local),
+				UpdatedAt:  time.Date(2020, 3, 1, 0, 0, 0, 0, time.Local),
+			},
+		},
+	}
+	graph, err := entity.NewGraph(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selectIDs := func(t *testing.T, expr string) (out []string) {
+		t.Helper()
+		filter, err := entity.ParseFilter(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, note := range graph.Notes {
+			if filter.Match(note, graph) {
+				out = append(out, note.ID)
+			}
+		}
+		return
+	}
+
+	t.Run("terms", func(t *testing.T) {
+		for _, test := range []struct {
+			expr     string
+			expected string
+		}{
+			{"", "n1 n2 n3"},
+			{"notebook:travel", "n1 n2"},
+			{"stack:Personal", "n1 n2"},
+			{"notebook:in*", "n3"},
+			{"tag:chicago", "n1"},
+			{"-tag:draft", "n1 n3"},
+			{"source:web.*", "n3"},
+			{"created:2020-01-01", "n1 n3"},
+			{"-created:20200101", "n2"},
+			{"updated:2021-01-01", "n1"},
+			{`title:"^(Trip|Art)"`, "n1 n3"},
+			{"notebook:travel -tag:draft", "n1"},
+			{"any: tag:draft source:web.clip", "n2 n3"},
+			{`notebook:"Travel" title:list`, "n2"},
+		} {
+			if got := strings.Join(selectIDs(t, test.expr), " "); got != test.expected {
+				t.Errorf("wrong notes for %q; got %q, expected %q", test.expr, got, test.expected)
+			}
+		}
+	})
+
+	t.Run("relative dates", func(t *testing.T) {
+		recent := &entity.Note{CreatedAt: time.Now()}
+		old := &entity.Note{CreatedAt: time.Now().AddDate(-2, 0, 0)}
+		for _, expr := range []string{"created:day", "created:week", "created:month-1", "created:year"} {
+			filter, err := entity.ParseFilter(expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !filter.Match(recent, nil) || filter.Match(old, nil) {
+				t.Errorf("wrong match for %q", expr)
+			}
+		}
+	})
+
+	t.Run("note tags", func(t *testing.T) {
+		filter, err := entity.ParseFilter("tag:recipes")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !filter.Match(&entity.Note{Tags: []string{"Recipes"}}, nil) {
+			t.Error("expected to match tag names of note without a graph")
+		}
+	})
+
+	t.Run("select", func(t *testing.T) {
+		filter, err := entity.ParseFilter("tag:chicago")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := filter.Select(graph)
+		if len(got.Notes) != 1 || got.Notes[0].ID != "n1" {
+			t.Errorf("wrong notes; got %v", got.Notes)
+		}
+		if len(got.Notebooks) != 1 || got.Notebooks[0].ID != "nb2" {
+			t.Errorf("wrong notebooks; got %v", got.Notebooks)
+		}
+		// The parent of a selected tag is kept too.
+		if len(got.Tags) != 2 || got.Tags[0].ID != "t1" || got.Tags[1].ID != "t2" {
+			t.Errorf("wrong tags; got %v", got.Tags)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, expr := range []string{
+			"notebook", "notebook:", "color:red", "any:", `tag:"oops`, "created:yesterday", "title:(", "created:week-x",
+		} {
+			if _, err := entity.ParseFilter(expr); !errors.Is(err, entity.ErrFilter) {
+				t.Errorf("expected %v for %q; got %v", entity.ErrFilter, expr, err)
+			}
+		}
+	})
+}
+
+func TestRules(t *testing.T) {
+	rules, err := entity.ParseRules(strings.NewReader(`{
+		"tags": [
+			{"pattern": "(?i)^to-?do$", "rename": "todo"},
+			{"name": "misc", "drop": true},
+			{"name": "Chicago", "parent": "cities"},
+			{"pattern": "^old-(.+)$", "rename": "$1"},
+			{"name": "cities", "parent": "Chicago"},
+			{"name": "child", "parent": "people"}
+		],
+		"notebooks": [
+			{"name": "Inbox", "rename": "Unsorted", "parent": "Misc"},
+			{"name": "Trash", "drop": true},
+			{"name": "Travel 2", "rename": "travel"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection := &entity.Collection{
+		Notebooks: []*entity.Notebook{
+			{ID: "nb1", Name: "Inbox"},
+			{ID: "nb2", Name: "Travel"},
+			{ID: "nb3", Name: "Travel 2"},
+			{ID: "nb4", Name: "Trash"},
+		},
+		Tags: []*entity.Tag{
+			{ID: "t1", Name: "TODO"},
+			{ID: "t2", Name: "To-Do"},
+			{ID: "t3", Name: "misc"},
+			{ID: "t4", Name: "Chicago", ParentID: "t3"},
+			{ID: "t5", Name: "old-places"},
+			{ID: "t6", Name: "child", ParentID: "t3"},
+			{ID: "t7", Name: "cities"},
+		},
+		Notes: []*entity.Note{
+			{ID: "n1", NotebookID: "nb3", TagIDs: []string{"t1", "t2", "t3", "t4"}},
+			{ID: "n2", NotebookID: "nb4", TagIDs: []string{"t2"}, Tags: []string{"To-Do"}},
+		},
+	}
+	out := rules.Apply(collection)
+
+	tagNames := make([]string, len(out.Tags))
+	tagParents := make(map[string]string)
+	for i, tag := range out.Tags {
+		tagNames[i] = tag.Name
+		tagParents[tag.Name] = tag.ParentID
+	}
+	if got := strings.Join(tagNames, ","); got != "todo,Chicago,places,child,cities,people" {
+		t.Errorf("wrong tags; got %q", got)
+	}
+	if tagParents["Chicago"] != "t7" || tagParents["places"] != "" {
+		t.Errorf("wrong parents; got %v", tagParents)
+	}
+	// The parent of child was dropped, then a rule gives it a new one.
+	if tagParents["child"] != "rules:people" || tagParents["people"] != "" {
+		t.Errorf("expected parent tag to be made; got %v", tagParents)
+	}
+	// Chicago is already a child of cities, so this would be a cycle.
+	if tagParents["cities"] != "" {
+		t.Errorf("expected cyclic parent to be left out; got %q", tagParents["cities"])
+	}
+
+	if len(out.Notebooks) != 2 || out.Notebooks[0].Name != "Unsorted" || out.Notebooks[0].Stack != "Misc" || out.Notebooks[1].ID != "nb2" {
+		t.Errorf("wrong notebooks; got %v", out.Notebooks)
+	}
+	if note := out.Notes[0]; note.NotebookID != "nb2" || strings.Join(note.TagIDs, ",") != "t1,t4" {
+		t.Errorf("wrong references for merged and dropped items; got %q, %q", note.NotebookID, note.TagIDs)
+	}
+	if note := out.Notes[1]; note.NotebookID != "" || strings.Join(note.Tags, ",") != "todo" {
+		t.Errorf("wrong references for dropped notebook, tag names; got %q, %q", note.NotebookID, note.Tags)
+	}
+	if graph, err := entity.NewGraph(out); err != nil {
+		t.Fatal(err)
+	} else if err = graph.Check(); err != nil {
+		t.Errorf("unexpected integrity error; %v", err)
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, input := range []string{
+			`{"tags": [{"rename": "a"}]}`,
+			`{"tags": [{"name": "a", "pattern": "a", "rename": "b"}]}`,
+			`{"tags": [{"name": "a"}]}`,
+			`{"notebooks": [{"name": "a", "drop": true, "rename": "b"}]}`,
+			`{"tags": [{"pattern": "(", "rename": "b"}]}`,
+			`{"labels": []}`,
+			`nope`,
+		} {
+			if _, err := entity.ParseRules(strings.NewReader(input)); !errors.Is(err, entity.ErrRules) {
+				t.Errorf("expected %v for %s; got %v", entity.ErrRules, input, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,313 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Filter selects notes with an expression modeled after the Evernote search
+// grammar. An expression is a list of terms separated by whitespace, a note
+// must match all of them. If the first term is any:, then it only needs to
+// match one of them. Values with whitespace go in double quotes. A term
+// starting with - excludes the notes that match it. The terms are:
+//
+//	notebook:<name>  the name of the notebook of the note
+//	stack:<name>     the stack of the notebook of the note
+//	tag:<name>       the name of any tag of the note
+//	source:<value>   the source attribute of the note, such as web.clip
+//	created:<date>   created on or after the date, or before it if excluded
+//	updated:<date>   updated on or after the date, or before it if excluded
+//	title:<pattern>  a regular expression to find in the title
+//
+// Names and sources are matched ignoring case, and by prefix when they end in
+// *. A date is absolute, such as 20200131 or 2020-01-31, or relative to now,
+// such as day, week-2, month-1 or year. See
+// https://dev.evernote.com/doc/articles/search_grammar.php.
+//
+// A nil Filter matches every note.
+type Filter struct {
+	expr  string
+	any   bool
+	terms []filterTerm
+}
+
+type filterTerm struct {
+	negated bool
+	match   func(note *Note, g *Graph) bool
+}
+
+// ErrFilter means that a filter expression could not be parsed.
+var ErrFilter = errors.New("filter error")
+
+// ParseFilter parses a filter expression. An empty expression is a nil Filter.
+func ParseFilter(expr string) (out *Filter, err error) {
+	if strings.TrimSpace(expr) == "" {
+		return
+	}
+	words, err := splitFilter(expr)
+	if err != nil {
+		return
+	}
+	out = &Filter{expr: expr}
+	if len(words) > 0 && strings.EqualFold(words[0], "any:") {
+		out.any = true
+		words = words[1:]
+	}
+	if len(words) < 1 {
+		err = fmt.Errorf("%w; no terms in %q", ErrFilter, expr)
+		return
+	}
+	out.terms = make([]filterTerm, len(words))
+	for i, word := range words {
+		if out.terms[i], err = parseFilterTerm(word); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// splitFilter splits an expression on whitespace, except for whitespace
+// within double quotes. The quotes themselves are removed.
+func splitFilter(expr string) (out []string, err error) {
+	var (
+		bld    strings.Builder
+		quoted bool
+	)
+	for _, r := range expr {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if bld.Len() > 0 {
+				out = append(out, bld.String())
+				bld.Reset()
+			}
+		default:
+			bld.WriteRune(r)
+		}
+	}
+	if quoted {
+		err = fmt.Errorf("%w; unterminated quote in %q", ErrFilter, expr)
+		return
+	}
+	if bld.Len() > 0 {
+		out = append(out, bld.String())
+	}
+	return
+}
+
+func parseFilterTerm(word string) (out filterTerm, err error) {
+	out.negated = strings.HasPrefix(word, "-")
+	name, val, ok := strings.Cut(strings.TrimPrefix(word, "-"), ":")
+	if !ok || val == "" {
+		err = fmt.Errorf("%w; invalid term %q", ErrFilter, word)
+		return
+	}
+
+	switch strings.ToLower(name) {
+	case "notebook":
+		out.match = func(note *Note, g *Graph) bool {
+			notebook := g.noteNotebook(note)
+			return notebook != nil && matchName(val, notebook.Name)
+		}
+	case "stack":
+		out.match = func(note *Note, g *Graph) bool {
+			notebook := g.noteNotebook(note)
+			return notebook != nil && matchName(val, notebook.Stack)
+		}
+	case "tag":
+		out.match = func(note *Note, g *Graph) bool {
+			for _, tagName := range g.noteTagNames(note) {
+				if matchName(val, tagName) {
+					return true
+				}
+			}
+			return false
+		}
+	case "source":
+		out.match = func(note *Note, _ *Graph) bool {
+			return note.Attributes != nil && matchName(val, note.Attributes.Source)
+		}
+	case "created", "updated":
+		var date time.Time
+		if date, err = parseFilterDate(val, time.Now()); err != nil {
+			return
+		}
+		created := strings.EqualFold(name, "created")
+		out.match = func(note *Note, _ *Graph) bool {
+			if created {
+				return !note.CreatedAt.Before(date)
+			}
+			return !note.UpdatedAt.Before(date)
+		}
+	case "title":
+		pattern, rerr := regexp.Compile(val)
+		if rerr != nil {
+			err = fmt.Errorf("%w; title pattern %q; %v", ErrFilter, val, rerr)
+			return
+		}
+		out.match = func(note *Note, _ *Graph) bool { return pattern.MatchString(note.Title) }
+	default:
+		err = fmt.Errorf("%w; unknown term %q", ErrFilter, word)
+	}
+	return
+}
+
+// matchName compares names ignoring case, by prefix if the pattern ends in *.
+func matchName(pattern, name string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(nameKey(name), nameKey(prefix))
+	}
+	return nameKey(pattern) == nameKey(name)
+}
+
+var relativeFilterDate = regexp.MustCompile(`^(?i)(day|week|month|year)(?:-(\d+))?$`)
+
+// parseFilterDate reads an absolute date or one relative to now. Relative
+// dates are the start of the day, week, month or year, in local time.
+func parseFilterDate(val string, now time.Time) (out time.Time, err error) {
+	for _, layout := range []string{"20060102", time.DateOnly} {
+		if date, perr := time.ParseInLocation(layout, val, time.Local); perr == nil {
+			out = date
+			return
+		}
+	}
+	match := relativeFilterDate.FindStringSubmatch(val)
+	if match == nil {
+		err = fmt.Errorf("%w; invalid date %q", ErrFilter, val)
+		return
+	}
+	var offset int
+	if match[2] != "" {
+		if offset, err = strconv.Atoi(match[2]); err != nil {
+			return
+		}
+	}
+	year, month, day := now.Date()
+	switch strings.ToLower(match[1]) {
+	case "day":
+		out = time.Date(year, month, day-offset, 0, 0, 0, 0, now.Location())
+	case "week":
+		out = time.Date(year, month, day-int(now.Weekday())-7*offset, 0, 0, 0, 0, now.Location())
+	case "month":
+		out = time.Date(year, month-time.Month(offset), 1, 0, 0, 0, 0, now.Location())
+	case "year":
+		out = time.Date(year-offset, time.January, 1, 0, 0, 0, 0, now.Location())
+	}
+	return
+}
+
+// String is the expression of the Filter.
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// Match tells whether a note is selected by the Filter. The Graph, which may
+// be nil, is for looking up the names of the notebook and tags of the note.
+// Tag names on the note itself, as from an export file, are used as well.
+func (f *Filter) Match(note *Note, g *Graph) bool {
+	if f == nil {
+		return true
+	}
+	for _, term := range f.terms {
+		ok := term.match(note, g) != term.negated
+		if f.any && ok {
+			return true
+		} else if !f.any && !ok {
+			return false
+		}
+	}
+	return !f.any
+}
+
+// Select makes a Collection of the notes in a Graph that match the Filter.
+// Only the notebooks and tags that those notes refer to are kept, along with
+// the parents of those tags. Saved searches are all kept.
+func (f *Filter) Select(g *Graph) *Collection {
+	if f == nil {
+		return g.Collection
+	}
+	out := &Collection{Service: g.Service, SavedSearches: g.SavedSearches}
+	var refs References
+	for _, note := range g.Notes {
+		if f.Match(note, g) {
+			out.Notes = append(out.Notes, note)
+			refs.Add(note)
+		}
+	}
+	out.Notebooks = refs.Notebooks(g.Notebooks)
+	out.Tags = refs.Tags(g.Tags)
+	return out
+}
+
+// References keeps track of the notebooks and tags that notes refer to, as the
+// notes are selected one at a time. The zero value is ready to use.
+type References struct {
+	notebookIDs, tagIDs map[string]bool
+}
+
+// Add keeps track of the notebook and tags of a note.
+func (r *References) Add(note *Note) {
+	if r.notebookIDs == nil {
+		r.notebookIDs, r.tagIDs = make(map[string]bool), make(map[string]bool)
+	}
+	r.notebookIDs[note.NotebookID] = true
+	for _, tagID := range note.TagIDs {
+		r.tagIDs[tagID] = true
+	}
+}
+
+// Notebooks lists the notebooks that were referred to, in order.
+func (r *References) Notebooks(notebooks []*Notebook) (out []*Notebook) {
+	for _, notebook := range notebooks {
+		if r.notebookIDs[notebook.ID] {
+			out = append(out, notebook)
+		}
+	}
+	return
+}
+
+// Tags lists the tags that were referred to, and their ancestors, in order.
+func (r *References) Tags(tags []*Tag) (out []*Tag) {
+	byID := make(map[string]*Tag, len(tags))
+	for _, tag := range tags {
+		byID[tag.ID] = tag
+	}
+	keep := make(map[string]bool)
+	for tagID := range r.tagIDs {
+		for tag := byID[tagID]; tag != nil && !keep[tag.ID]; tag = byID[tag.ParentID] {
+			keep[tag.ID] = true
+		}
+	}
+	for _, tag := range tags {
+		if keep[tag.ID] {
+			out = append(out, tag)
+		}
+	}
+	return
+}
+
+func (g *Graph) noteNotebook(note *Note) *Notebook {
+	if g == nil {
+		return nil
+	}
+	return g.notebooks[note.NotebookID]
+}
+
+func (g *Graph) noteTagNames(note *Note) []string {
+	out := note.Tags
+	if g == nil {
+		return out
+	}
+	for _, tag := range g.NoteTags(note) {
+		out = append(out[:len(out):len(out)], tag.Name)
+	}
+	return out
+}
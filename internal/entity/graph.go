@@ -0,0 +1,305 @@
+package entity
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Graph is a Collection along with the relationships between its parts:
+// notes in notebooks, notes with tags, nested tags, notebooks in stacks,
+// attachments of notes and links from one note to another. Readers of data
+// produce a Graph and writers consume it, so that relationships are resolved
+// in one place rather than by each format.
+//
+// The Graph is built by NewGraph. Tags and notes can be added later with
+// AddTag and AddNote, but other changes to the Collection aren't reflected in
+// the lookups, so build a new Graph instead.
+type Graph struct {
+	*Collection
+
+	notebooks   map[string]*Notebook
+	notes       map[string]*Note
+	tags        map[string]*Tag
+	attachments map[string]*Attachment
+
+	notebooksByName map[string]*Notebook
+	tagsByName      map[string]*Tag
+	notesByTitle    map[string][]*Note
+
+	notesByNotebookID map[string][]*Note
+	notesByTagID      map[string][]*Note
+	tagsByParentID    map[string][]*Tag
+	notebooksByStack  map[string][]*Notebook
+
+	links, backlinks map[string][]string
+}
+
+// ErrIntegrity means that parts of a Graph don't fit together, such as a note
+// that refers to a tag that's not there.
+var ErrIntegrity = errors.New("integrity error")
+
+// NewGraph indexes a Collection. It's an error for two notebooks, notes or
+// tags to have the same ID. Data without an ID, such as from an ENEX file, is
+// still part of the Graph, but it can't be looked up by ID.
+func NewGraph(in *Collection) (out *Graph, err error) {
+	if in == nil {
+		in = &Collection{}
+	}
+	out = &Graph{
+		Collection:        in,
+		notebooks:         make(map[string]*Notebook),
+		notes:             make(map[string]*Note),
+		tags:              make(map[string]*Tag),
+		attachments:       make(map[string]*Attachment),
+		notebooksByName:   make(map[string]*Notebook),
+		tagsByName:        make(map[string]*Tag),
+		notesByTitle:      make(map[string][]*Note),
+		notesByNotebookID: make(map[string][]*Note),
+		notesByTagID:      make(map[string][]*Note),
+		tagsByParentID:    make(map[string][]*Tag),
+		notebooksByStack:  make(map[string][]*Notebook),
+		links:             make(map[string][]string),
+		backlinks:         make(map[string][]string),
+	}
+	var errs []error
+	for _, notebook := range in.Notebooks {
+		errs = append(errs, out.indexNotebook(notebook))
+	}
+	for _, tag := range in.Tags {
+		errs = append(errs, out.indexTag(tag))
+	}
+	for _, note := range in.Notes {
+		errs = append(errs, out.indexNote(note))
+	}
+	err = errors.Join(errs...)
+	return
+}
+
+// AddTag adds a tag to the Collection, and to the lookups, such as for tags
+// that are only known as notes are read. It's an error if there's already a
+// tag with its ID.
+func (g *Graph) AddTag(tag *Tag) error {
+	g.Tags = append(g.Tags, tag)
+	return g.indexTag(tag)
+}
+
+// AddNote adds a note to the Collection, and to the lookups, such as for
+// notes that are indexed as they're read rather than all at once. It's an
+// error if there's already a note with its ID.
+func (g *Graph) AddNote(note *Note) error {
+	g.Notes = append(g.Notes, note)
+	return g.indexNote(note)
+}
+
+func (g *Graph) indexNotebook(notebook *Notebook) (err error) {
+	if notebook.ID != "" {
+		if _, ok := g.notebooks[notebook.ID]; ok {
+			err = fmt.Errorf("%w; duplicate notebook ID %q", ErrIntegrity, notebook.ID)
+		}
+		g.notebooks[notebook.ID] = notebook
+	}
+	if key := nameKey(notebook.Name); g.notebooksByName[key] == nil {
+		g.notebooksByName[key] = notebook
+	}
+	if notebook.Stack != "" {
+		g.notebooksByStack[notebook.Stack] = append(g.notebooksByStack[notebook.Stack], notebook)
+	}
+	return
+}
+
+func (g *Graph) indexTag(tag *Tag) (err error) {
+	if tag.ID != "" {
+		if _, ok := g.tags[tag.ID]; ok {
+			err = fmt.Errorf("%w; duplicate tag ID %q", ErrIntegrity, tag.ID)
+		}
+		g.tags[tag.ID] = tag
+	}
+	if key := nameKey(tag.Name); g.tagsByName[key] == nil {
+		g.tagsByName[key] = tag
+	}
+	g.tagsByParentID[tag.ParentID] = append(g.tagsByParentID[tag.ParentID], tag)
+	return
+}
+
+func (g *Graph) indexNote(note *Note) (err error) {
+	if note.ID != "" {
+		if _, ok := g.notes[note.ID]; ok {
+			err = fmt.Errorf("%w; duplicate note ID %q", ErrIntegrity, note.ID)
+		}
+		g.notes[note.ID] = note
+	}
+	g.notesByTitle[note.Title] = append(g.notesByTitle[note.Title], note)
+	if note.NotebookID != "" {
+		g.notesByNotebookID[note.NotebookID] = append(g.notesByNotebookID[note.NotebookID], note)
+	}
+	for _, tagID := range note.TagIDs {
+		g.notesByTagID[tagID] = append(g.notesByTagID[tagID], note)
+	}
+	for _, att := range note.Attachments {
+		if hash := att.MD5(); g.attachments[hash] == nil {
+			g.attachments[hash] = att
+		}
+	}
+	if note.ID == "" {
+		return
+	}
+	for _, linkedID := range NoteLinks(note.Content) {
+		g.links[note.ID] = append(g.links[note.ID], linkedID)
+		g.backlinks[linkedID] = append(g.backlinks[linkedID], note.ID)
+	}
+	return
+}
+
+// nameKey makes names case-insensitive, as they are in Evernote.
+func nameKey(name string) string { return strings.ToLower(name) }
+
+// Notebook looks up a notebook by ID.
+func (g *Graph) Notebook(id string) *Notebook { return g.notebooks[id] }
+
+// Note looks up a note by ID.
+func (g *Graph) Note(id string) *Note { return g.notes[id] }
+
+// Tag looks up a tag by ID.
+func (g *Graph) Tag(id string) *Tag { return g.tags[id] }
+
+// Attachment looks up an attachment by the hex-encoded MD5 hash of its data.
+// An attachment in multiple notes is the first one found.
+func (g *Graph) Attachment(hash string) *Attachment { return g.attachments[strings.ToLower(hash)] }
+
+// NotebookByName looks up a notebook by name, ignoring case.
+func (g *Graph) NotebookByName(name string) *Notebook { return g.notebooksByName[nameKey(name)] }
+
+// TagByName looks up a tag by name, ignoring case.
+func (g *Graph) TagByName(name string) *Tag { return g.tagsByName[nameKey(name)] }
+
+// NotesByTitle looks up notes by title. Titles aren't unique, so there could
+// be several.
+func (g *Graph) NotesByTitle(title string) []*Note { return g.notesByTitle[title] }
+
+// NotesInNotebook lists the notes in a notebook, in the order of the
+// Collection.
+func (g *Graph) NotesInNotebook(notebookID string) []*Note { return g.notesByNotebookID[notebookID] }
+
+// NotesWithTag lists the notes with a tag, in the order of the Collection.
+func (g *Graph) NotesWithTag(tagID string) []*Note { return g.notesByTagID[tagID] }
+
+// NoteTags lists the tags of a note that are in the Graph.
+func (g *Graph) NoteTags(note *Note) (out []*Tag) {
+	for _, tagID := range note.TagIDs {
+		if tag := g.tags[tagID]; tag != nil {
+			out = append(out, tag)
+		}
+	}
+	return
+}
+
+// TagChildren lists the tags nested directly under a tag. With an empty ID,
+// it's the tags at the top level.
+func (g *Graph) TagChildren(tagID string) []*Tag { return g.tagsByParentID[tagID] }
+
+// TagPath is the tag and its ancestors, starting with the top level. It stops
+// at a parent that's missing or that's already in the path.
+func (g *Graph) TagPath(tagID string) (out []*Tag) {
+	seen := make(map[string]bool)
+	for tag := g.tags[tagID]; tag != nil && !seen[tag.ID]; tag = g.tags[tag.ParentID] {
+		seen[tag.ID] = true
+		out = append([]*Tag{tag}, out...)
+	}
+	return
+}
+
+// Stacks lists the names of notebook stacks, sorted.
+func (g *Graph) Stacks() []string {
+	out := make([]string, 0, len(g.notebooksByStack))
+	for stack := range g.notebooksByStack {
+		out = append(out, stack)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NotebooksInStack lists the notebooks in a stack.
+func (g *Graph) NotebooksInStack(stack string) []*Notebook { return g.notebooksByStack[stack] }
+
+// Links lists the IDs of the notes that a note links to in its content.
+func (g *Graph) Links(noteID string) []string { return g.links[noteID] }
+
+// Backlinks lists the IDs of the notes that link to a note.
+func (g *Graph) Backlinks(noteID string) []string { return g.backlinks[noteID] }
+
+// Check looks for references to things that aren't in the Graph: notebooks or
+// tags of notes, parents of tags, and linked notes. It also looks for tags
+// that are nested within themselves. Data from a partial fetch, or from a
+// format that doesn't have all of these things, may not pass.
+func (g *Graph) Check() error {
+	var errs []error
+	for _, note := range g.Notes {
+		errs = append(errs, g.CheckNote(note))
+		for _, linkedID := range g.links[note.ID] {
+			if g.notes[linkedID] == nil {
+				errs = append(errs, fmt.Errorf("%w; note %q links to missing note %q", ErrIntegrity, note.ID, linkedID))
+			}
+		}
+	}
+	for _, tag := range g.Tags {
+		if tag.ParentID == "" {
+			continue
+		}
+		if g.tags[tag.ParentID] == nil {
+			errs = append(errs, fmt.Errorf("%w; tag %q refers to missing parent %q", ErrIntegrity, tag.ID, tag.ParentID))
+		} else if path := g.TagPath(tag.ID); path[0].ParentID != "" && g.tags[path[0].ParentID] != nil {
+			errs = append(errs, fmt.Errorf("%w; tag %q is nested within itself", ErrIntegrity, tag.ID))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckNote is like Check, for one note that may not be in the Graph, such as
+// when notes are checked as they're read. Links to other notes aren't
+// checked, since they may not have been read yet.
+func (g *Graph) CheckNote(note *Note) error {
+	var errs []error
+	if note.NotebookID != "" && g.notebooks[note.NotebookID] == nil {
+		errs = append(errs, fmt.Errorf("%w; note %q refers to missing notebook %q", ErrIntegrity, note.ID, note.NotebookID))
+	}
+	for _, tagID := range note.TagIDs {
+		if g.tags[tagID] == nil {
+			errs = append(errs, fmt.Errorf("%w; note %q refers to missing tag %q", ErrIntegrity, note.ID, tagID))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Evernote links to a note look like evernote:///view/<user>/<shard>/<id>/<id>/
+// within the app, or https://www.evernote.com/shard/<shard>/nl/<user>/<id>/ on
+// the web.
+var noteLinkPattern = regexp.MustCompile(`(?i)(?:evernote:///view/\d+/s\d+/|/shard/s\d+/(?:nl|sh)/\d+/)([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})`)
+
+// NoteLinks finds the IDs of the notes linked from the content of a note. An
+// ID linked more than once is only listed once.
+func NoteLinks(content string) (out []string) {
+	seen := make(map[string]bool)
+	for _, match := range noteLinkPattern.FindAllStringSubmatch(content, -1) {
+		id := strings.ToLower(match[1])
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return
+}
+
+// MD5 is the hex-encoded MD5 hash of the data, which is how the attachment is
+// referenced in ENML. When the data is kept elsewhere, it's the Hash field.
+func (a *Attachment) MD5() string {
+	if a.Data == nil && a.Hash != "" {
+		return strings.ToLower(a.Hash)
+	}
+	sum := md5.Sum(a.Data)
+	return hex.EncodeToString(sum[:])
+}
@@ -2,7 +2,10 @@ package entity
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
+	"iter"
 	"time"
 )
 
@@ -37,6 +40,72 @@ type RepoLocal interface {
 	ReadLocal(ctx context.Context, reader io.Reader) (out []LinkID, err error)
 }
 
+// A RepoRemoteStream is like a RepoRemote, but yields each resource as soon as
+// it's available, so that the whole result doesn't have to be in memory at
+// once. The sequence stops after yielding an error, unless it's a *NoteError,
+// in which case it continues with the next resource if the consumer does.
+type RepoRemoteStream interface {
+	StreamRemote(ctx context.Context) iter.Seq2[LinkID, error]
+}
+
+// A RepoLocalStream is like a RepoLocal, but yields each resource as soon as
+// it's parsed, in the order of the input. The sequence stops after yielding an
+// error, unless it's a *NoteError, as with a RepoRemoteStream.
+type RepoLocalStream interface {
+	StreamLocal(ctx context.Context, reader io.Reader) iter.Seq2[LinkID, error]
+}
+
+// Collect gathers the resources of a sequence, such as from a RepoLocalStream
+// or a RepoRemoteStream, into a slice. It stops at the first error.
+func Collect(seq iter.Seq2[LinkID, error]) (out []LinkID, err error) {
+	out = make([]LinkID, 0)
+	for item, ierr := range seq {
+		if ierr != nil {
+			err = ierr
+			return
+		}
+		out = append(out, item)
+	}
+	return
+}
+
+// Stages of handling a note, for a NoteError.
+const (
+	StageFetch   = "fetch"
+	StageRead    = "read"
+	StageConvert = "convert"
+)
+
+// A NoteError is a failure of one note, which doesn't prevent the others from
+// being handled. A consumer may skip the note and keep going.
+type NoteError struct {
+	// ID is the ID of the note in the source service, if it's known.
+	ID    string
+	Title string
+	// Stage is one of StageFetch, StageRead, StageConvert.
+	Stage string
+	Err   error
+}
+
+func (e *NoteError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("%v; %s note %q", e.Err, e.Stage, e.Title)
+	}
+	return fmt.Sprintf("%v; %s note %q, id %q", e.Err, e.Stage, e.Title, e.ID)
+}
+
+func (e *NoteError) Unwrap() error { return e.Err }
+
+// MarshalJSON writes the error as an entry of an error report.
+func (e *NoteError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"id":    e.ID,
+		"title": e.Title,
+		"stage": e.Stage,
+		"error": e.Err.Error(),
+	})
+}
+
 // The ChainLink interface is for re-associating entities between services.
 // Typically when the IDs have changed but some non-ID value has not, you can
 // attempt to uniquely identify the same data as it is in multiple services by
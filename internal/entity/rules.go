@@ -0,0 +1,326 @@
+package entity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+)
+
+// Rules clean up the names of tags and notebooks while converting. Each rule
+// matches by exact name, or by regular expression, and the first one to match
+// is applied. A rule may rename, drop, or move a tag to another parent tag or
+// a notebook to another stack. Tags or notebooks that end up with the same
+// name, ignoring case, are merged into the first one. For example:
+//
+//	{
+//	  "tags": [
+//	    {"pattern": "(?i)^to-?do$", "rename": "todo"},
+//	    {"name": "misc", "drop": true},
+//	    {"name": "Chicago", "parent": "cities"}
+//	  ],
+//	  "notebooks": [
+//	    {"name": "Inbox", "rename": "Unsorted", "parent": ""}
+//	  ]
+//	}
+type Rules struct {
+	Tags      []Rule `json:"tags"`
+	Notebooks []Rule `json:"notebooks"`
+}
+
+// A Rule matches a tag or notebook by Name or by Pattern, but not both.
+type Rule struct {
+	// Name is an exact name to match.
+	Name string `json:"name,omitempty"`
+	// Pattern is a regular expression to match names. Rename may refer to its
+	// submatches, such as $1.
+	Pattern string `json:"pattern,omitempty"`
+	// Rename is the new name.
+	Rename string `json:"rename,omitempty"`
+	// Drop removes the tag or notebook, and the references of notes to it.
+	// Children of a dropped tag move up to its parent.
+	Drop bool `json:"drop,omitempty"`
+	// Parent is the name of the parent tag, which is made if there isn't one,
+	// or the stack of a notebook. If it's empty, the tag or notebook is moved
+	// to the top level. If it's absent, the parent doesn't change.
+	Parent *string `json:"parent,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// ErrRules means that rules could not be parsed.
+var ErrRules = errors.New("rules error")
+
+// ParseRules reads Rules as JSON.
+func ParseRules(r io.Reader) (out *Rules, err error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	out = &Rules{}
+	if err = dec.Decode(out); err != nil {
+		err = fmt.Errorf("%w; %v", ErrRules, err)
+		out = nil
+		return
+	}
+	for _, kind := range []struct {
+		name  string
+		rules []Rule
+	}{{"tags", out.Tags}, {"notebooks", out.Notebooks}} {
+		for i := range kind.rules {
+			if err = kind.rules[i].init(); err != nil {
+				err = fmt.Errorf("%w; %s rule %d; %v", ErrRules, kind.name, i+1, err)
+				out = nil
+				return
+			}
+		}
+	}
+	return
+}
+
+func (r *Rule) init() (err error) {
+	switch {
+	case r.Name == "" && r.Pattern == "":
+		return errors.New("name or pattern is required")
+	case r.Name != "" && r.Pattern != "":
+		return errors.New("only one of name, pattern is allowed")
+	case r.Drop && (r.Rename != "" || r.Parent != nil):
+		return errors.New("drop can not be combined with rename or parent")
+	case !r.Drop && r.Rename == "" && r.Parent == nil:
+		return errors.New("one of rename, drop, parent is required")
+	}
+	if r.Pattern != "" {
+		r.pattern, err = regexp.Compile(r.Pattern)
+	}
+	return
+}
+
+// applyRules finds the first rule that matches a name. The output name is the
+// input name if the rule doesn't rename.
+func applyRules(rules []Rule, name string) (out string, rule *Rule) {
+	out = name
+	for i := range rules {
+		r := &rules[i]
+		if r.pattern == nil {
+			if r.Name != name {
+				continue
+			}
+			if r.Rename != "" {
+				out = r.Rename
+			}
+			return out, r
+		}
+		match := r.pattern.FindStringSubmatchIndex(name)
+		if match == nil {
+			continue
+		}
+		if r.Rename != "" {
+			out = string(r.pattern.ExpandString(nil, r.Rename, name, match))
+		}
+		return out, r
+	}
+	return
+}
+
+// Apply rewrites the tags, notebooks and notes of a Collection with the Rules.
+// Notes are changed in place. A nil Rules changes nothing.
+func (r *Rules) Apply(in *Collection) *Collection {
+	if r == nil {
+		return in
+	}
+	rw := r.NewRewriter()
+	rw.AddNotebooks(in.Notebooks)
+	rw.AddTags(in.Tags)
+	for _, note := range in.Notes {
+		rw.Note(note)
+	}
+	out := *in
+	out.Notebooks, out.Tags = rw.Notebooks(), rw.Tags()
+	return &out
+}
+
+// A Rewriter applies Rules to tags and notebooks as they're added, then to the
+// notes that refer to them, so that notes can be rewritten one at a time. Add
+// the tags and notebooks that a note refers to before rewriting the note.
+type Rewriter struct {
+	rules *Rules
+
+	notebooks       []*Notebook
+	notebookIDs     map[string]string
+	notebooksByName map[string]*Notebook
+
+	tags       []*Tag
+	tagIDs     map[string]string
+	tagsByID   map[string]*Tag
+	tagsByName map[string]*Tag
+	// tagParents are the input parent IDs of the input tags, and
+	// newParents are the names of parents set by rules, by output tag ID.
+	tagParents map[string]string
+	newParents map[string]string
+}
+
+// NewRewriter makes a Rewriter for the Rules, which may be nil.
+func (r *Rules) NewRewriter() *Rewriter {
+	if r == nil {
+		r = &Rules{}
+	}
+	return &Rewriter{
+		rules:           r,
+		notebookIDs:     make(map[string]string),
+		notebooksByName: make(map[string]*Notebook),
+		tagIDs:          make(map[string]string),
+		tagsByID:        make(map[string]*Tag),
+		tagsByName:      make(map[string]*Tag),
+		tagParents:      make(map[string]string),
+		newParents:      make(map[string]string),
+	}
+}
+
+// AddNotebooks applies the rules to notebooks.
+func (w *Rewriter) AddNotebooks(notebooks []*Notebook) {
+	for _, notebook := range notebooks {
+		if _, ok := w.notebookIDs[notebook.ID]; ok {
+			continue
+		}
+		name, rule := applyRules(w.rules.Notebooks, notebook.Name)
+		if rule != nil && rule.Drop {
+			w.notebookIDs[notebook.ID] = ""
+			continue
+		}
+		if prev, ok := w.notebooksByName[nameKey(name)]; ok {
+			w.notebookIDs[notebook.ID] = prev.ID
+			continue
+		}
+		out := *notebook
+		out.Name = name
+		if rule != nil && rule.Parent != nil {
+			out.Stack = *rule.Parent
+		}
+		w.notebooks = append(w.notebooks, &out)
+		w.notebookIDs[notebook.ID] = out.ID
+		w.notebooksByName[nameKey(name)] = &out
+	}
+}
+
+// AddTags applies the rules to tags.
+func (w *Rewriter) AddTags(tags []*Tag) {
+	for _, tag := range tags {
+		if _, ok := w.tagIDs[tag.ID]; ok {
+			continue
+		}
+		w.tagParents[tag.ID] = tag.ParentID
+		name, rule := applyRules(w.rules.Tags, tag.Name)
+		if rule != nil && rule.Drop {
+			w.tagIDs[tag.ID] = ""
+			continue
+		}
+		if prev, ok := w.tagsByName[nameKey(name)]; ok {
+			w.tagIDs[tag.ID] = prev.ID
+			continue
+		}
+		out := *tag
+		out.Name = name
+		w.addTag(&out)
+		w.tagIDs[tag.ID] = out.ID
+		if rule != nil && rule.Parent != nil {
+			w.newParents[out.ID] = *rule.Parent
+		}
+	}
+}
+
+func (w *Rewriter) addTag(tag *Tag) {
+	w.tags = append(w.tags, tag)
+	w.tagsByID[tag.ID] = tag
+	w.tagsByName[nameKey(tag.Name)] = tag
+}
+
+// Note rewrites the notebook and tags of a note, in place. Tags that were
+// merged are only referred to once. The output is the input.
+func (w *Rewriter) Note(note *Note) *Note {
+	if id, ok := w.notebookIDs[note.NotebookID]; ok {
+		note.NotebookID = id
+	}
+	if len(note.TagIDs) < 1 {
+		return note
+	}
+	tagIDs := make([]string, 0, len(note.TagIDs))
+	for _, tagID := range note.TagIDs {
+		if id, ok := w.tagIDs[tagID]; ok {
+			tagID = id
+		}
+		if tagID != "" && !slices.Contains(tagIDs, tagID) {
+			tagIDs = append(tagIDs, tagID)
+		}
+	}
+	note.TagIDs = tagIDs
+	if len(note.Tags) > 0 {
+		note.Tags = make([]string, 0, len(tagIDs))
+		for _, tagID := range tagIDs {
+			if tag, ok := w.tagsByID[tagID]; ok {
+				note.Tags = append(note.Tags, tag.Name)
+			}
+		}
+	}
+	return note
+}
+
+// Notebooks are the notebooks after applying the rules, in the order they
+// were added.
+func (w *Rewriter) Notebooks() []*Notebook { return w.notebooks }
+
+// Tags are the tags after applying the rules, in the order they were added,
+// followed by any parent tags made by the rules. Parents are resolved here,
+// since a tag may be added before its parent.
+func (w *Rewriter) Tags() []*Tag {
+	for _, tag := range w.tags {
+		tag.ParentID = w.outputParent(tag.ParentID)
+	}
+	for _, tag := range slices.Clone(w.tags) {
+		name, ok := w.newParents[tag.ID]
+		if !ok {
+			continue
+		}
+		delete(w.newParents, tag.ID)
+		if name == "" {
+			tag.ParentID = ""
+			continue
+		}
+		parent, ok := w.tagsByName[nameKey(name)]
+		if !ok {
+			parent = &Tag{ID: "rules:" + name, Name: name}
+			w.addTag(parent)
+			w.tagIDs[parent.ID] = parent.ID
+		}
+		if !w.isAncestor(tag, parent) {
+			tag.ParentID = parent.ID
+		}
+	}
+	return w.tags
+}
+
+// outputParent maps an input parent ID to an output tag ID. The parent of a
+// dropped tag is the nearest ancestor that wasn't dropped.
+func (w *Rewriter) outputParent(parentID string) string {
+	for n := 0; parentID != "" && n <= len(w.tagParents); n++ {
+		id, ok := w.tagIDs[parentID]
+		if !ok {
+			// It's not a known tag, leave it for an integrity check.
+			return parentID
+		} else if id != "" {
+			return id
+		}
+		parentID = w.tagParents[parentID]
+	}
+	return ""
+}
+
+// isAncestor tells whether tag is parent, or one of its ancestors, which would
+// make a cycle.
+func (w *Rewriter) isAncestor(tag, parent *Tag) bool {
+	for t, n := parent, 0; t != nil && n <= len(w.tags); t, n = w.tagsByID[t.ParentID], n+1 {
+		if t.ID == tag.ID {
+			return true
+		}
+	}
+	return false
+}
@@ -3,70 +3,181 @@ package interactor
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/rafaelespinoza/notexfr/internal/entity"
 	"github.com/rafaelespinoza/notexfr/internal/repo"
 	"github.com/rafaelespinoza/notexfr/internal/repo/edam"
+	"github.com/rafaelespinoza/notexfr/internal/repo/ledger"
 	"github.com/rafaelespinoza/notexfr/internal/repo/sn"
 )
 
 // BackfillParams is a set of named parameters for performing a backfill on
 // Evernote, StandardNotes data.
 type BackfillParams struct {
-	EvernoteFilenames     struct{ Notebooks, Notes, Tags string }
+	EvernoteFilenames struct{ Notebooks, Notes, Tags string }
+	// EvernoteBundleDir is the output of "edam fetch-all". When set, it
+	// replaces EvernoteFilenames.
+	EvernoteBundleDir     string
 	StandardNotesFilename string
 	OutputFilenames       struct{ Notebooks, Notes, Tags string }
+	// LedgerFilename, if set, is a file where the Evernote ID and
+	// StandardNotes UUID of each backfilled note is recorded. It's created if
+	// it doesn't exist. Notes that are unchanged since they were recorded are
+	// left out of the output.
+	LedgerFilename string
+	// Filter selects which Evernote notes to backfill from, see
+	// entity.Filter.
+	Filter string
+	// CollisionPolicy is what to do with an Evernote notebook with the same
+	// name as a StandardNotes tag, one of CollisionPolicies. With "merge",
+	// notes refer to the tag instead of the notebook. With "prefix" or
+	// "nest", the notebook tags written to OutputFilenames.Notebooks are
+	// changed. Each collision is logged.
+	CollisionPolicy string
+	// DryRunParams, when set, write a diff of the backfilled notes against
+	// the StandardNotes input, instead of the output files or a ledger.
+	DryRunParams
+	// KeepGoingParams are for skipping notes that can't be matched, such as
+	// those that aren't notes of the expected service.
+	KeepGoingParams
 }
 
+// BackfillSN updates existing StandardNotes notes with the metadata of the
+// Evernote notes they came from, such as notebooks. Both inputs and
+// the output are held in memory at once, so memory use grows with the size
+// of the accounts, unlike converting.
 func BackfillSN(ctx context.Context, opts *BackfillParams) (out []entity.LinkID, err error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var evernote, standardnotes *serviceItems
 
+	if opts.EvernoteBundleDir != "" {
+		files, berr := bundleFilenames(opts.EvernoteBundleDir)
+		if berr != nil {
+			err = berr
+			return
+		}
+		opts.EvernoteFilenames.Notebooks, opts.EvernoteFilenames.Notes, opts.EvernoteFilenames.Tags = files.Notebooks, files.Notes, files.Tags
+	}
+
+	if err = opts.validate(); err != nil {
+		return
+	}
+	report := errorReport{params: opts.KeepGoingParams}
+	defer func() { err = report.close(err) }()
+	filter, err := entity.ParseFilter(opts.Filter)
+	if err != nil {
+		return
+	}
 	if evernote, err = initEvernoteItems(ctx, opts); err != nil {
 		return
 	}
+	graph, err := evernote.graph()
+	if err != nil {
+		return
+	}
 	if standardnotes, err = initStandardNotesItems(ctx, opts); err != nil {
 		return
 	}
+	notebooks, tags := backfillNotebooks(graph, standardnotes)
+	collisions := sn.FindCollisions(tags, notebooks)
+	sn.LogCollisions(ctx, collisions, opts.CollisionPolicy)
+	mergedTagIDs := make(map[string]string)
+	if opts.CollisionPolicy == "merge" {
+		for _, collision := range collisions {
+			mergedTagIDs[collision.NotebookID] = collision.TagID
+		}
+	}
+	var diff *sn.Diff
+	if opts.DryRun {
+		// The diff is started before notes are changed by the backfill.
+		existing := make([]entity.LinkID, 0, len(standardnotes.notes.keys))
+		_ = standardnotes.notes.each(func(note entity.LinkID) error {
+			existing = append(existing, note)
+			return nil
+		})
+		diff = sn.NewDiff(existing)
+		// Notes refer to Evernote notebooks, which are named for readability.
+		_ = evernote.notebooks.each(func(notebook entity.LinkID) error {
+			diff.SetTitle(notebook.GetID(), notebook.(*edam.Notebook).Name)
+			return nil
+		})
+	}
+	var book *ledger.Ledger
+	if opts.LedgerFilename != "" {
+		if opts.DryRun {
+			book, err = ledger.Read(opts.LedgerFilename)
+		} else {
+			book, err = ledger.Open(opts.LedgerFilename)
+		}
+		if err != nil {
+			return
+		}
+		defer func() {
+			if cerr := book.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+	}
+	var settings []string
+	if opts.CollisionPolicy != "" && opts.CollisionPolicy != CollisionPolicies[0] {
+		settings = append(settings, "collisions="+opts.CollisionPolicy)
+	}
+	var numUnchanged int
 	const numNoteLinks = 3
-	enNoteDegrees := make([]map[string][]entity.LinkID, numNoteLinks)
+	enNoteDegrees := make([]map[string][]*edam.Note, numNoteLinks)
 	for i := 0; i < numNoteLinks; i++ {
-		enNoteDegrees[i] = make(map[string][]entity.LinkID)
-	}
-	err = evernote.notes.each(func(note entity.LinkID) (ierr error) {
-		links := note.LinkValues()
-		if len(links) != numNoteLinks {
-			ierr = fmt.Errorf(
-				"expected links length to be %d; got %d; evernote %q",
-				numNoteLinks, len(links), note.GetID(),
-			)
-			return
+		enNoteDegrees[i] = make(map[string][]*edam.Note)
+	}
+	err = evernote.notes.each(func(item entity.LinkID) (ierr error) {
+		note, ok := item.(*edam.Note)
+		if !ok {
+			ierr = &entity.NoteError{ID: item.GetID(), Stage: entity.StageRead, Err: fmt.Errorf("%w; expected an evernote note, got %T", errTypeAssertion, item)}
+		} else if links := note.LinkValues(); len(links) != numNoteLinks {
+			ierr = &entity.NoteError{ID: note.ID, Title: note.Title, Stage: entity.StageRead, Err: fmt.Errorf(
+				"expected links length of evernote note to be %d; got %d", numNoteLinks, len(links),
+			)}
 		}
-
-		for i, link := range links {
-			if list, ok := enNoteDegrees[i][link]; !ok {
-				enNoteDegrees[i][link] = []entity.LinkID{note}
-			} else {
-				enNoteDegrees[i][link] = append(list, note)
+		if ierr != nil {
+			if report.skip(ctx, ierr) {
+				ierr = nil
 			}
+			return
+		}
+		if !filter.Match(note.Note, graph) {
+			return
+		}
+		for i, link := range note.LinkValues() {
+			enNoteDegrees[i][link] = append(enNoteDegrees[i][link], note)
 		}
-
 		return
 	})
 	if err != nil {
 		return
 	}
 	var notes []entity.LinkID
-	err = standardnotes.notes.each(func(note entity.LinkID) (ierr error) {
-		links := note.LinkValues()
-		if len(links) != numNoteLinks {
-			ierr = fmt.Errorf(
-				"expected links length to be %d; got %d; standardnotes %q",
-				numNoteLinks, len(links), note.GetID(),
-			)
+	// members are the StandardNotes notes in each notebook, by UUID.
+	members, err := entity.NewGraph(&entity.Collection{})
+	if err != nil {
+		return
+	}
+	err = standardnotes.notes.each(func(item entity.LinkID) (ierr error) {
+		snNote, ok := item.(*sn.Note)
+		var links []string
+		if !ok {
+			ierr = &entity.NoteError{ID: item.GetID(), Stage: entity.StageRead, Err: fmt.Errorf("%w; expected a standardnotes note, got %T", errTypeAssertion, item)}
+		} else if links = snNote.LinkValues(); len(links) != numNoteLinks {
+			ierr = &entity.NoteError{ID: snNote.UUID, Title: snNote.Content.Title, Stage: entity.StageRead, Err: fmt.Errorf(
+				"expected links length of standardnotes note to be %d; got %d", numNoteLinks, len(links),
+			)}
+		}
+		if ierr != nil {
+			if report.skip(ctx, ierr) {
+				ierr = nil
+			}
 			return
 		}
 		for i, link := range links {
@@ -76,9 +187,25 @@ func BackfillSN(ctx context.Context, opts *BackfillParams) (out []entity.LinkID,
 				continue
 			}
 			if len(enNotes) == 1 && link == enNotes[0].LinkValues()[i] {
-				enNote := enNotes[0].(*edam.Note)
-				snNote := note.(*sn.Note)
-				snNote.AppendTags(enNote.NotebookID)
+				enNote := enNotes[0]
+				var unchanged bool
+				if unchanged, ierr = recordBackfill(book, enNote, snNote, settings); ierr != nil {
+					return
+				}
+				if tagID, ok := mergedTagIDs[enNote.NotebookID]; ok {
+					snNote.AppendTags(tagID)
+				} else {
+					snNote.AppendTags(enNote.NotebookID)
+					if ierr = members.AddNote(&entity.Note{ID: snNote.UUID, NotebookID: enNote.NotebookID}); ierr != nil {
+						return
+					}
+				}
+				// It's still a member of its notebook tag, which refers to
+				// every note in the notebook.
+				if unchanged {
+					numUnchanged++
+					break
+				}
 				notes = append(notes, &FromENToSN{
 					LinkID:     snNote,
 					EvernoteID: repo.NewServiceID(enNote.ID),
@@ -91,13 +218,89 @@ func BackfillSN(ctx context.Context, opts *BackfillParams) (out []entity.LinkID,
 	if err != nil {
 		return
 	}
+	var notebookTags []entity.LinkID
+	if opts.OutputFilenames.Notebooks != "" {
+		notebookTags = sn.NewNotebookTags(notebooks, tags, members, collisions, opts.CollisionPolicy)
+	}
+	if book != nil {
+		var orphans []ledger.Entry
+		if opts.Filter == "" {
+			for _, entry := range book.Orphans(edam.Service, sn.Service) {
+				if entry.ContentType == sn.ContentTypeNote.String() {
+					orphans = append(orphans, entry)
+				}
+			}
+		}
+		logLedger(ctx, opts.LedgerFilename, numUnchanged, orphans)
+	}
+	if opts.DryRun {
+		for _, item := range notes {
+			diff.Add(item.(*FromENToSN).LinkID)
+		}
+		for _, item := range notebookTags {
+			diff.Add(item)
+		}
+		out = notes
+		err = opts.writeDiff(ctx, diff)
+		return
+	}
 	if err = writeResources(notes, opts.OutputFilenames.Notes, "backfilled notes"); err != nil {
 		return
 	}
+	if notebookTags != nil {
+		if err = writeResources(notebookTags, opts.OutputFilenames.Notebooks, "notebook tags"); err != nil {
+			return
+		}
+	}
+	if book != nil {
+		err = book.Commit()
+	}
 	out = notes
 	return
 }
 
+func (p *BackfillParams) validate() error {
+	if p.CollisionPolicy != "" && !slices.Contains(CollisionPolicies, p.CollisionPolicy) {
+		return fmt.Errorf("invalid collision policy %q, should be one of %q", p.CollisionPolicy, CollisionPolicies)
+	}
+	return p.DryRunParams.validate()
+}
+
+// backfillNotebooks lists the Evernote notebooks, and the StandardNotes tags
+// that they could collide with. A tag with the ID of a notebook was made from
+// that notebook, so it's not a collision.
+func backfillNotebooks(graph *entity.Graph, standardnotes *serviceItems) (notebooks []*entity.Notebook, tags []*entity.Tag) {
+	notebooks = graph.Notebooks
+	_ = standardnotes.tags.each(func(item entity.LinkID) error {
+		tag := item.(*sn.Tag)
+		if graph.Notebook(tag.UUID) == nil {
+			tags = append(tags, &entity.Tag{ID: tag.UUID, Name: tag.Content.Title})
+		}
+		return nil
+	})
+	return
+}
+
+// recordBackfill writes down which Evernote note a backfilled note is, if
+// there's a ledger. It's unchanged if the ledger already had it, with the same
+// content.
+func recordBackfill(book *ledger.Ledger, enNote *edam.Note, snNote *sn.Note, settings []string) (unchanged bool, err error) {
+	if book == nil {
+		return
+	}
+	key := ledger.Key{
+		SourceService: edam.Service,
+		ContentType:   sn.ContentTypeNote.String(),
+		SourceID:      enNote.ID,
+		DestService:   sn.Service,
+	}
+	hash := ledger.NoteHash(enNote.Note, settings...)
+	prior, ok := book.Lookup(key)
+	unchanged = ok && prior.DestID == snNote.UUID && prior.ContentHash == hash
+	err = book.Record(ledger.Entry{Key: key, DestID: snNote.UUID, ContentHash: hash})
+	return
+}
+
 // serviceItems manages items from one service.
 type serviceItems struct {
 	notebooks, notes, tags keyedItems
@@ -112,7 +315,7 @@ func initEvernoteItems(ctx context.Context, opts *BackfillParams) (out *serviceI
 	}{
 		{
 			newRepo: func() (out entity.RepoLocal, err error) {
-				out, err = edam.NewNotebooksRepo()
+				out, err = edam.NewNotebooksRepo(nil, nil)
 				return
 			},
 			filename: opts.EvernoteFilenames.Notebooks,
@@ -120,7 +323,7 @@ func initEvernoteItems(ctx context.Context, opts *BackfillParams) (out *serviceI
 		},
 		{
 			newRepo: func() (out entity.RepoLocal, err error) {
-				out, err = edam.NewNotesRepo(nil)
+				out, err = edam.NewNotesRepo(nil, nil)
 				return
 			},
 			filename: opts.EvernoteFilenames.Notes,
@@ -128,7 +331,7 @@ func initEvernoteItems(ctx context.Context, opts *BackfillParams) (out *serviceI
 		},
 		{
 			newRepo: func() (out entity.RepoLocal, err error) {
-				out, err = edam.NewTagsRepo()
+				out, err = edam.NewTagsRepo(nil, nil)
 				return
 			},
 			filename: opts.EvernoteFilenames.Tags,
@@ -185,6 +388,21 @@ func initStandardNotesItems(_ context.Context, opts *BackfillParams) (out *servi
 	return
 }
 
+// graph relates the notebooks, tags of Evernote items, so that notes can be
+// filtered by their names.
+func (s *serviceItems) graph() (*entity.Graph, error) {
+	collection := &entity.Collection{Service: edam.Service}
+	_ = s.notebooks.each(func(item entity.LinkID) error {
+		collection.Notebooks = append(collection.Notebooks, item.(*edam.Notebook).Notebook)
+		return nil
+	})
+	_ = s.tags.each(func(item entity.LinkID) error {
+		collection.Tags = append(collection.Tags, item.(*edam.Tag).Tag)
+		return nil
+	})
+	return entity.NewGraph(collection)
+}
+
 // keyedItems is a collection resources that is indexed by some unique key,
 // such as an ID, where all items originate from the same service provider.
 // The keys field preserves the insertion order so you can iterate through items
@@ -0,0 +1,223 @@
+package interactor
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/log"
+	"github.com/rafaelespinoza/notexfr/internal/repo/edam"
+)
+
+// BundleVersion is the version of the layout of a bundle directory. It's
+// incremented upon incompatible changes.
+const BundleVersion = 1
+
+// These are names of files and directories within a bundle. The data files
+// are named like the fixture files of the fake Evernote server, so a bundle
+// can also be served by it, or read as the "edam" format.
+const (
+	BundleManifestFilename      = "manifest.json"
+	BundleNotebooksFilename     = edam.NotebooksFilename
+	BundleNotesFilename         = edam.NotesFilename
+	BundleSavedSearchesFilename = edam.SavedSearchesFilename
+	BundleTagsFilename          = edam.TagsFilename
+	BundleAttachmentsDir        = edam.AttachmentsDir
+)
+
+// BundleManifest describes the contents of a bundle directory, which holds all
+// of the data fetched from an Evernote account. Attachments are kept in
+// separate files, named by the MD5 hash of their data, rather than in the
+// notes file.
+type BundleManifest struct {
+	Version   int
+	FetchedAt time.Time
+	Account   *edam.Account
+	// HighestUSN is the update count of the account as of the fetch. Data
+	// changed after the fetch has a higher update sequence number.
+	HighestUSN int32
+	Counts     struct {
+		Notebooks, Notes, Tags, SavedSearches, Attachments int
+	}
+}
+
+// FetchAllParams is a set of named arguments for fetching an entire Evernote
+// account into a bundle.
+type FetchAllParams struct {
+	EDAMClient *edam.Client
+	// OutDir is the bundle directory. It's created if it doesn't exist, but
+	// it must not already contain a bundle.
+	OutDir  string
+	Timeout time.Duration
+	// IncludeLinked, IncludeTrashed, IncludeVersions are like the fields of
+	// the same names in edam.NotesRemoteQueryParams.
+	IncludeLinked, IncludeTrashed, IncludeVersions bool
+	PageSize                                       int32
+}
+
+// FetchAllEDAM gets notebooks, tags, notes with their attachments, and saved
+// searches from an Evernote account, and writes them to a bundle directory.
+// The manifest is written last, so a directory with a manifest is complete.
+func FetchAllEDAM(ctx context.Context, opts *FetchAllParams) (out *BundleManifest, err error) {
+	if opts.OutDir == "" {
+		err = fmt.Errorf("output directory is required")
+		return
+	}
+	manifestFilename := filepath.Join(opts.OutDir, BundleManifestFilename)
+	if _, err = os.Stat(manifestFilename); err == nil {
+		err = fmt.Errorf("bundle already present at %q", opts.OutDir)
+		return
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err = os.MkdirAll(filepath.Join(opts.OutDir, BundleAttachmentsDir), 0700); err != nil {
+		return
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	out = &BundleManifest{Version: BundleVersion, FetchedAt: time.Now().UTC()}
+	// Get the update count first. Anything changed during the fetch then has
+	// a higher number, so it's not mistaken as included.
+	if out.Account, err = opts.EDAMClient.Account(ctx); err != nil {
+		return
+	}
+	out.HighestUSN = out.Account.UpdateCount
+
+	notebooks, _ := edam.NewNotebooksRepo(opts.EDAMClient, &edam.NotebooksRemoteQueryParams{IncludeLinked: opts.IncludeLinked})
+	tags, _ := edam.NewTagsRepo(opts.EDAMClient, &edam.TagsRemoteQueryParams{IncludeLinked: opts.IncludeLinked})
+	searches, _ := edam.NewSavedSearchesRepo(opts.EDAMClient)
+	notes, _ := edam.NewNotesRepo(opts.EDAMClient, &edam.NotesRemoteQueryParams{
+		HiIndex:            -1,
+		PageSize:           opts.PageSize,
+		IncludeLinked:      opts.IncludeLinked,
+		IncludeTrashed:     opts.IncludeTrashed,
+		IncludeVersions:    opts.IncludeVersions,
+		IncludeAttachments: true,
+	})
+	parts := []struct {
+		name       string
+		repository entity.LocalRemoteRepo
+		filename   string
+		count      *int
+	}{
+		{name: "Notebooks", repository: notebooks, filename: BundleNotebooksFilename, count: &out.Counts.Notebooks},
+		{name: "Tags", repository: tags, filename: BundleTagsFilename, count: &out.Counts.Tags},
+		{name: "SavedSearches", repository: searches, filename: BundleSavedSearchesFilename, count: &out.Counts.SavedSearches},
+	}
+	for _, part := range parts {
+		var resources []entity.LinkID
+		if resources, err = fetchResources(ctx, part.repository, part.name); err != nil {
+			return
+		}
+		*part.count = len(resources)
+		if err = writeResources(resources, filepath.Join(opts.OutDir, part.filename), part.name); err != nil {
+			return
+		}
+	}
+	// There could be many notes, with large attachments, so each one is
+	// written as soon as it's fetched.
+	attachments := &bundleAttachments{dir: opts.OutDir, written: make(map[string]bool)}
+	out.Counts.Notes, err = writeStream(
+		ctx,
+		notes.(entity.RepoRemoteStream).StreamRemote(ctx),
+		filepath.Join(opts.OutDir, BundleNotesFilename),
+		"Notes",
+		attachments.write,
+	)
+	if err != nil {
+		return
+	}
+	out.Counts.Attachments = len(attachments.written)
+
+	data, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		return
+	}
+	if err = os.WriteFile(manifestFilename, data, os.FileMode(0644)); err != nil {
+		return
+	}
+	log.Info(ctx, map[string]any{
+		"dir":         opts.OutDir,
+		"notebooks":   out.Counts.Notebooks,
+		"notes":       out.Counts.Notes,
+		"tags":        out.Counts.Tags,
+		"searches":    out.Counts.SavedSearches,
+		"attachments": out.Counts.Attachments,
+		"highest_usn": out.HighestUSN,
+	}, "wrote bundle")
+	return
+}
+
+// bundleAttachments moves the data of each attachment of a note into a file,
+// and replaces it with the hash. An attachment that's in multiple notes is only
+// written once.
+type bundleAttachments struct {
+	dir string
+	// written is the hashes of the distinct files.
+	written map[string]bool
+}
+
+func (b *bundleAttachments) write(item entity.LinkID) (err error) {
+	note, ok := item.(*edam.Note)
+	if !ok {
+		err = fmt.Errorf("%w; expected %T", errTypeAssertion, &edam.Note{})
+		return
+	}
+	for _, att := range note.Attachments {
+		sum := md5.Sum(att.Data)
+		att.Hash = hex.EncodeToString(sum[:])
+		if !b.written[att.Hash] {
+			if err = os.WriteFile(filepath.Join(b.dir, BundleAttachmentsDir, att.Hash), att.Data, os.FileMode(0644)); err != nil {
+				return
+			}
+			b.written[att.Hash] = true
+		}
+		att.Data = nil
+	}
+	return
+}
+
+// ReadBundleManifest reads the manifest of a bundle directory, as written by
+// FetchAllEDAM.
+func ReadBundleManifest(dir string) (out *BundleManifest, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, BundleManifestFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		err = fmt.Errorf("no bundle manifest in %q", dir)
+		return
+	} else if err != nil {
+		return
+	}
+	out = &BundleManifest{}
+	if err = json.Unmarshal(data, out); err != nil {
+		err = fmt.Errorf("invalid bundle manifest in %q; %w", dir, err)
+		return
+	}
+	if out.Version != BundleVersion {
+		err = fmt.Errorf("unsupported bundle version %d in %q, expected %d", out.Version, dir, BundleVersion)
+		return
+	}
+	return
+}
+
+// bundleFilenames gets the paths to the data files of a bundle directory.
+func bundleFilenames(dir string) (out struct{ Notebooks, Notes, Tags, SavedSearches string }, err error) {
+	if _, err = ReadBundleManifest(dir); err != nil {
+		return
+	}
+	out.Notebooks = filepath.Join(dir, BundleNotebooksFilename)
+	out.Notes = filepath.Join(dir, BundleNotesFilename)
+	out.Tags = filepath.Join(dir, BundleTagsFilename)
+	out.SavedSearches = filepath.Join(dir, BundleSavedSearchesFilename)
+	return
+}
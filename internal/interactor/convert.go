@@ -2,399 +2,595 @@ package interactor
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"regexp"
-	"time"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 
-	"github.com/google/uuid"
+	"github.com/rafaelespinoza/notexfr/internal/enml"
 	"github.com/rafaelespinoza/notexfr/internal/entity"
-	"github.com/rafaelespinoza/notexfr/internal/repo/edam"
-	"github.com/rafaelespinoza/notexfr/internal/repo/enex"
+	"github.com/rafaelespinoza/notexfr/internal/log"
+	"github.com/rafaelespinoza/notexfr/internal/progress"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
+	"github.com/rafaelespinoza/notexfr/internal/repo/ledger"
 	"github.com/rafaelespinoza/notexfr/internal/repo/sn"
 )
 
 // ConvertParams are named inputs and outputs for converting data between
-// different formats.
+// formats, see repo.Formats.
 type ConvertParams struct {
-	InputFilenames                struct{ Notebooks, Notes, Tags string }
-	InputFilename, OutputFilename string
+	// From, To are the names of formats.
+	From, To string
+	// InputPath is a file or directory, depending on the input format.
+	InputPath string
+	// InputBundleDir is the output of "edam fetch-all". When set, its
+	// manifest is checked, then it's read as the edam format, instead of From
+	// and InputPath.
+	InputBundleDir string
+	// OutputFilename is where to write the output. If empty, then it's
+	// written to standard output.
+	OutputFilename string
+	// Options are passed to the reader and the writer. Each one must be
+	// understood by at least one of the formats. NoteVersions, NoteText,
+	// UUIDNamespace and CollisionPolicy are options of the sn format too, and
+	// they take precedence when set.
+	Options map[string]string
+	// NoteVersions is how earlier revisions of notes are converted, it's one
+	// of NoteVersionsOptions. The default is "appdata".
+	NoteVersions string
+	// NoteText is the format of the text of notes, one of NoteTextFormats.
+	// The default is "html".
+	NoteText string
+	// UUIDNamespace, if set, makes conversions repeatable by deriving UUIDs
+	// from it, rather than making random ones. See DefaultUUIDNamespace.
+	UUIDNamespace string
+	// LedgerFilename, if set, is a file that records which resource became
+	// which item, across runs. Items already in it keep their UUID, unchanged
+	// notes are skipped, and resources that weren't converted this time are
+	// logged as orphans, unless there's a Filter. It's created if it doesn't
+	// exist.
+	LedgerFilename string
+	// Filter selects which notes to convert, see entity.Filter. Only the
+	// notebooks and tags of those notes are converted.
+	Filter string
+	// RulesFilename, if set, is a JSON file of rules that rename, merge, drop
+	// or move tags and notebooks, see entity.Rules. Filter matches the names
+	// from before the rules are applied.
+	RulesFilename string
+	// CollisionPolicy is how a notebook with the same name as a tag is
+	// converted, one of CollisionPolicies. The default is "keep". Each
+	// collision is logged.
+	CollisionPolicy string
+	// CompareFilename is existing StandardNotes data, such as an export of an
+	// account, to compare against in a dry run. Without it, every item is
+	// new. It requires UUIDNamespace or LedgerFilename, so that items have
+	// the UUIDs they were given before.
+	CompareFilename string
+	// LossyReportFilename, if set, is where the notes with features that
+	// StandardNotes doesn't have are written, as a JSON array of objects with
+	// the id, title and features of each. See sn.FindLossyFeatures. Each
+	// feature is logged as a warning either way.
+	LossyReportFilename string
+	DecryptParams
+	DryRunParams
+	KeepGoingParams
+	// StreamOnly means that converted items are written without also being
+	// kept in the output, so memory use doesn't grow with the size of notes.
+	StreamOnly bool
 }
 
-// SN is the output of converting resources to the import, export format for
-// StandardNotes. The item schema is described at:
-// https://docs.standardnotes.org/specification/sync/#items
-type SN struct {
-	Items []entity.LinkID `json:"items"`
+// DiffFormats are the values of DryRunParams.DiffFormat.
+var DiffFormats = []string{"text", "json"}
+
+// DryRunParams are for previewing changes to StandardNotes data rather than
+// making them.
+type DryRunParams struct {
+	// DryRun means that a diff is written instead of the usual output.
+	// Nothing is recorded in a ledger either.
+	DryRun bool
+	// DiffFilename is where to write the diff. If empty, then it's written to
+	// standard output.
+	DiffFilename string
+	// DiffFormat is one of DiffFormats. The default is "text".
+	DiffFormat string
 }
 
-// ConvertEDAMToStandardNotes replicates the existing data conversion tools at
-// https://dashboard.standardnotes.org/tools.
-func ConvertEDAMToStandardNotes(ctx context.Context, opts ConvertParams) (out *SN, err error) {
-	evernote, err := initEvernoteItems(ctx, &BackfillParams{
-		EvernoteFilenames: struct{ Notebooks, Notes, Tags string }{
-			Notebooks: opts.InputFilenames.Notebooks,
-			Notes:     opts.InputFilenames.Notes,
-			Tags:      opts.InputFilenames.Tags,
-		},
-	})
-	if err != nil {
-		return
+func (p *DryRunParams) validate() error {
+	if p.DiffFormat == "" || slices.Contains(DiffFormats, p.DiffFormat) {
+		return nil
 	}
+	return fmt.Errorf("invalid diff format %q, should be one of %q", p.DiffFormat, DiffFormats)
+}
 
-	var combinedEnexResources []entity.LinkID
-	for _, source := range []keyedItems{evernote.notes, evernote.tags, evernote.notebooks} {
-		_ = source.each(func(item entity.LinkID) error {
-			combinedEnexResources = append(combinedEnexResources, item)
-			return nil
-		})
+func (p *DryRunParams) writeDiff(ctx context.Context, diff *sn.Diff) (err error) {
+	var w io.Writer = os.Stdout
+	if p.DiffFilename != "" {
+		file, ferr := os.Create(filepath.Clean(p.DiffFilename))
+		if ferr != nil {
+			return ferr
+		}
+		defer func() {
+			if cerr := file.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		w = file
+	}
+	if p.DiffFormat == "json" {
+		err = diff.WriteJSON(w)
+	} else {
+		err = diff.WriteText(w)
+	}
+	if err != nil || p.DiffFilename == "" {
+		return
 	}
+	log.Info(ctx, map[string]any{
+		"filename":  p.DiffFilename,
+		"created":   len(diff.Created),
+		"changed":   len(diff.Changed),
+		"unchanged": diff.Unchanged,
+	}, "wrote diff to file")
+	return
+}
+
+// DecryptParams are for decrypting the encrypted text in notes. Without
+// either one, encrypted text is left as it is.
+type DecryptParams struct {
+	// PassphrasesFilename, if set, is a JSON file of passphrases, see
+	// enml.Keyring.
+	PassphrasesFilename string
+	// Prompt, if set, asks for the passphrase of a hint when no other
+	// passphrase decrypts some text. It's only asked once for each hint.
+	Prompt func(hint string) (string, error)
+}
 
-	converter := &edamToSN{snConverter: snConverter{}}
-	items, err := converter.convertToSN(combinedEnexResources)
+// keyring is nil when there's nothing to decrypt with.
+func (p *DecryptParams) keyring() (out *enml.Keyring, err error) {
+	if p.PassphrasesFilename == "" && p.Prompt == nil {
+		return
+	}
+	out = &enml.Keyring{Prompt: p.Prompt}
+	if p.PassphrasesFilename == "" {
+		return
+	}
+	file, err := os.Open(filepath.Clean(p.PassphrasesFilename))
 	if err != nil {
 		return
 	}
-	out = &SN{items}
-	err = writeResources(
-		out,
-		opts.OutputFilename,
-		"standardnotes resources",
-	)
+	defer func() { _ = file.Close() }()
+	if out, err = enml.ParseKeyring(file); err != nil {
+		err = fmt.Errorf("%s; %w", p.PassphrasesFilename, err)
+		return
+	}
+	out.Prompt = p.Prompt
 	return
 }
 
-// ConvertENEXToStandardNotes replicates the existing data conversion tools at
-// https://dashboard.standardnotes.org/tools.
-func ConvertENEXToStandardNotes(ctx context.Context, opts ConvertParams) (out *SN, err error) {
-	var (
-		repository                   entity.RepoLocal
-		converter                    *enexToSN
-		origResources, convResources []entity.LinkID
-	)
+// These are aliases of types, values in the sn package, where the conversion
+// to StandardNotes happens.
+type (
+	// SN is the output of converting resources to the import, export format
+	// for StandardNotes.
+	SN = sn.Export
+	// SNItemAppData is extra metadata attached to a StandardNotes Item.
+	SNItemAppData = sn.AppData
+	// SNNoteVersion is an earlier revision of a note, kept in SNItemAppData.
+	SNNoteVersion = sn.NoteVersion
+	// UntranslatedSearch is an Evernote saved search that could not be
+	// expressed as a StandardNotes smart view.
+	UntranslatedSearch = sn.UntranslatedSearch
+)
+
+// NoteVersionsOptions are the values of ConvertParams.NoteVersions.
+var NoteVersionsOptions = sn.NoteVersionsOptions
 
-	if repository, err = enex.NewFileRepo(); err != nil {
+// NoteTextFormats are the values of ConvertParams.NoteText.
+var NoteTextFormats = sn.NoteTextFormats
+
+// DefaultUUIDNamespace is a value for ConvertParams.UUIDNamespace.
+var DefaultUUIDNamespace = sn.DefaultUUIDNamespace
+
+// CollisionPolicies are the values of ConvertParams.CollisionPolicy and
+// BackfillParams.CollisionPolicy. See sn.CollisionPolicies.
+var CollisionPolicies = sn.CollisionPolicies
+
+// Convert reads data in one format and writes it in another. Every conversion
+// goes the same way: notes are read, selected by the filter, rewritten by the
+// rules, and written, one at a time. See ConvertParams.StreamOnly. Only the sn
+// format can't be read one note at a time. The output is about the
+// StandardNotes items that were written, so it's empty for other formats.
+func Convert(ctx context.Context, opts ConvertParams) (out *SN, err error) {
+	if opts.InputBundleDir != "" {
+		if _, err = ReadBundleManifest(opts.InputBundleDir); err != nil {
+			return
+		}
+		opts.From, opts.InputPath = "edam", opts.InputBundleDir
+	}
+	from, to, err := opts.formats()
+	if err != nil {
+		return
+	}
+	if err = opts.validate(); err != nil {
 		return
 	}
-	origResources, err = readLocalFile(ctx, repository, opts.InputFilename)
+	filter, err := entity.ParseFilter(opts.Filter)
 	if err != nil {
 		return
 	}
-	converter = &enexToSN{
-		snConverter: snConverter{},
+	rules, err := readRules(opts.RulesFilename)
+	if err != nil {
+		return
 	}
-	if convResources, err = converter.convertToSN(origResources); err != nil {
+	src, err := from.Read(ctx, opts.InputPath, opts.Options)
+	if err != nil {
 		return
 	}
-	out = &SN{Items: convResources}
-	err = writeResources(
-		out,
-		opts.OutputFilename,
-		"standardnotes resources",
-	)
-	return
+	in, notes, err := selectNotes(ctx, src, filter, rules)
+	if err != nil {
+		return
+	}
+	return convertNotes(ctx, to, in, notes, opts)
 }
 
-type toStandardNotes interface {
-	convertToSN(in []entity.LinkID) (out []entity.LinkID, err error)
+// formats looks up the formats to convert from and to.
+func (p ConvertParams) formats() (from, to repo.Format, err error) {
+	if from, err = repo.LookupFormat(p.From); err != nil {
+		return
+	}
+	if to, err = repo.LookupFormat(p.To); err != nil {
+		return
+	}
+	if from.Read == nil {
+		err = fmt.Errorf("format %q can not be read", from.Name)
+		return
+	}
+	if to.NewEncoder == nil {
+		err = fmt.Errorf("format %q can not be written", to.Name)
+		return
+	}
+	if p.InputPath == "" && len(p.Options) < 1 {
+		err = fmt.Errorf("input path is required")
+		return
+	}
+	for key := range p.Options {
+		_, fromOK := from.Options[key]
+		_, toOK := to.Options[key]
+		if !fromOK && !toOK {
+			err = fmt.Errorf("unknown option %q for formats %q, %q; should be one of %q", key, from.Name, to.Name, optionNames(from, to))
+			return
+		}
+	}
+	// The diff and the ledger are of StandardNotes items.
+	if to.Name != "sn" && (p.DryRun || p.LedgerFilename != "") {
+		err = fmt.Errorf("a dry run or a ledger needs the sn format, not %q", to.Name)
+	}
+	return
 }
 
-type (
-	// snConverter is a base type for converting data from an outside service
-	// into StandardNotes format.
-	snConverter struct{}
-	// edamToSN converts Evernote data into StandardNotes data using the EDAM
-	// API.
-	edamToSN struct{ snConverter }
-	// enexToSN converts Evernote data into StandardNotes data using an ENEX
-	// file.
-	enexToSN struct{ snConverter }
-)
+func (p ConvertParams) validate() error {
+	if err := p.exportParams().Validate(); err != nil {
+		return err
+	}
+	// Otherwise, UUIDs are random, so every item would be created.
+	if p.DryRun && p.CompareFilename != "" && p.UUIDNamespace == "" && p.LedgerFilename == "" && p.Options["uuid-namespace"] == "" {
+		return fmt.Errorf("comparing needs the UUIDs of earlier conversions, from a UUID namespace or a ledger")
+	}
+	return p.DryRunParams.validate()
+}
 
-func (c *snConverter) generateUUID() (string, error) {
-	out, err := uuid.NewRandom()
-	if err != nil {
-		return "", err
+func (p ConvertParams) exportParams() *sn.ExportParams {
+	options := p.writeOptions()
+	return &sn.ExportParams{
+		NoteVersions:    options["note-versions"],
+		NoteText:        options["note-text"],
+		UUIDNamespace:   options["uuid-namespace"],
+		CollisionPolicy: options["collisions"],
 	}
-	return out.String(), nil
 }
 
-func (c *edamToSN) convertToSN(in []entity.LinkID) ([]entity.LinkID, error) {
-	notes := make([]entity.LinkID, 0)
-	noteIDsByTagID := make(map[string][]string)
-	noteIDsByNotebookID := make(map[string][]string)
+// writeOptions are the Options, along with the options of the sn format that
+// are set by fields.
+func (p ConvertParams) writeOptions() repo.Options {
+	out := maps.Clone(repo.Options(p.Options))
+	if out == nil {
+		out = make(repo.Options)
+	}
+	for name, val := range map[string]string{
+		"note-versions":  p.NoteVersions,
+		"note-text":      p.NoteText,
+		"uuid-namespace": p.UUIDNamespace,
+		"collisions":     p.CollisionPolicy,
+	} {
+		if val != "" {
+			out[name] = val
+		}
+	}
+	return out
+}
 
-	// process notes first so you can create references from notebooks, tags.
-	for _, link := range in {
-		item, ok := link.(*edam.Note)
-		if !ok {
-			continue
+// selectNotes is where the notes of a Source are selected by the filter, then
+// rewritten by the rules, as they're read. The output Collection has the
+// notebooks and tags to write along with the notes: they're rewritten by the
+// rules, and with a filter, they're only those of the selected notes. It's
+// complete once the notes are.
+func selectNotes(ctx context.Context, src *repo.Source, filter *entity.Filter, rules *entity.Rules) (out *entity.Collection, notes iter.Seq2[*entity.Note, error], err error) {
+	// The graph is for looking up names of notebooks and tags to filter by,
+	// and for checking references of notes. It has its own tags, since it
+	// gains them as the Source does.
+	graph, err := entity.NewGraph(&entity.Collection{
+		Notebooks: src.Collection.Notebooks,
+		Tags:      slices.Clone(src.Collection.Tags),
+	})
+	if err != nil {
+		return
+	}
+	if cerr := graph.Check(); cerr != nil {
+		log.Warn(ctx, map[string]any{"error": cerr.Error()}, "input has integrity problems")
+	}
+	out = &entity.Collection{Service: src.Collection.Service, SavedSearches: src.Collection.SavedSearches}
+	var rewriter *entity.Rewriter
+	if rules != nil {
+		rewriter = rules.NewRewriter()
+	}
+	// update catches up with the Source, which may have gained tags.
+	numTags := -1
+	update := func() (err error) {
+		if numTags == len(src.Collection.Tags) {
+			return
 		}
-		tagReferences := make([]sn.Reference, len(item.TagIDs))
-		for j, tagID := range item.TagIDs {
-			tagReferences[j] = sn.Reference{
-				UUID:        tagID,
-				ContentType: sn.ContentTypeTag,
+		for _, tag := range src.Collection.Tags[len(graph.Tags):] {
+			if err = graph.AddTag(tag); err != nil {
+				return
 			}
-			if _, ok = noteIDsByTagID[tagID]; !ok {
-				noteIDsByTagID[tagID] = make([]string, 0)
+		}
+		if rewriter == nil {
+			out.Notebooks, out.Tags = src.Collection.Notebooks, src.Collection.Tags
+		} else {
+			rewriter.AddNotebooks(src.Collection.Notebooks)
+			rewriter.AddTags(src.Collection.Tags)
+			out.Notebooks, out.Tags = rewriter.Notebooks(), rewriter.Tags()
+		}
+		numTags = len(src.Collection.Tags)
+		return
+	}
+	if err = update(); err != nil {
+		return
+	}
+	var refs entity.References
+	notes = func(yield func(*entity.Note, error) bool) {
+		for note, err := range src.Notes {
+			if err == nil {
+				err = update()
+			}
+			if err == nil {
+				if cerr := graph.CheckNote(note); cerr != nil {
+					log.Warn(ctx, map[string]any{"error": cerr.Error()}, "note has integrity problems")
+				}
+				if !filter.Match(note, graph) {
+					continue
+				}
+				if rewriter != nil {
+					rewriter.Note(note)
+				}
+				refs.Add(note)
+			}
+			if !yield(note, err) {
+				return
 			}
-			noteIDsByTagID[tagID] = append(noteIDsByTagID[tagID], item.ID)
 		}
-		if _, ok = noteIDsByNotebookID[item.NotebookID]; !ok {
-			noteIDsByNotebookID[item.NotebookID] = make([]string, 0)
+		if update() != nil {
+			return
 		}
-		noteIDsByNotebookID[item.NotebookID] = append(
-			noteIDsByNotebookID[item.NotebookID],
-			item.ID,
-		)
-		text, xerr := extractNoteContent(item)
-		if xerr != nil {
-			return nil, xerr
+		if filter != nil {
+			out.Notebooks = refs.Notebooks(out.Notebooks)
+			out.Tags = refs.Tags(out.Tags)
 		}
-		notes = append(notes, &sn.Note{
-			Item: sn.Item{
-				CreatedAt:   item.CreatedAt,
-				UpdatedAt:   item.UpdatedAt,
-				ContentType: sn.ContentTypeNote,
-				UUID:        item.ID,
-				Content: struct {
-					Title      string                 `json:"title"`
-					References []sn.Reference         `json:"references"`
-					Text       string                 `json:"text,omitempty"`
-					AppData    map[string]interface{} `json:"appData,omitempty"`
-				}{
-					Title: item.Title,
-					References: append(
-						tagReferences,
-						sn.Reference{
-							UUID:        item.NotebookID,
-							ContentType: sn.ContentTypeNotebook,
-						},
-					),
-					Text: text,
-					AppData: map[string]interface{}{
-						"org.standardnotes.sn": &SNItemAppData{
-							ClientUpdatedAt: &item.UpdatedAt,
-						},
-					},
-				},
-			},
-		})
 	}
+	return
+}
 
-	tags, notebooks := make([]entity.LinkID, 0), make([]entity.LinkID, 0)
-	// after collecting note IDs, process notebooks, tags.
-	for _, link := range in {
-		switch item := link.(type) {
-		case *edam.Note:
-			// already processed
-		case *edam.Tag:
-			var noteReferences []sn.Reference
-			if noteIDs, ok := noteIDsByTagID[item.ID]; !ok {
-				noteReferences = make([]sn.Reference, 0)
-			} else {
-				noteReferences = make([]sn.Reference, len(noteIDs))
-				for j, noteID := range noteIDs {
-					noteReferences[j] = sn.Reference{
-						UUID:        noteID,
-						ContentType: sn.ContentTypeNote,
-					}
+// convertNotes writes each note as it's yielded, followed by the rest of the
+// Collection.
+func convertNotes(ctx context.Context, to repo.Format, in *entity.Collection, notes iter.Seq2[*entity.Note, error], opts ConvertParams) (out *SN, err error) {
+	params := &repo.WriteParams{Options: opts.writeOptions()}
+	if params.Keyring, err = opts.keyring(); err != nil {
+		return
+	}
+	if opts.LedgerFilename != "" {
+		if opts.DryRun {
+			params.Ledger, err = ledger.Read(opts.LedgerFilename)
+		} else {
+			params.Ledger, err = ledger.Open(opts.LedgerFilename)
+		}
+		if err != nil {
+			return
+		}
+		// This runs after the output file is closed, so that the ledger only
+		// records notes that were written. Skipped notes weren't recorded.
+		defer func() {
+			if err == nil || errors.Is(err, ErrSkipped) {
+				if cerr := params.Ledger.Commit(); cerr != nil {
+					err = cerr
 				}
 			}
-			tags = append(tags, &sn.Tag{
-				Item: sn.Item{
-					CreatedAt:   time.Now().UTC(),
-					UpdatedAt:   time.Now().UTC(),
-					ContentType: sn.ContentTypeTag,
-					UUID:        item.ID,
-					Content: struct {
-						Title      string                 `json:"title"`
-						References []sn.Reference         `json:"references"`
-						Text       string                 `json:"text,omitempty"`
-						AppData    map[string]interface{} `json:"appData,omitempty"`
-					}{
-						Title:      item.Name,
-						References: noteReferences,
-						AppData: map[string]interface{}{
-							"evernote.com": &SNItemAppData{
-								ParentID: item.ParentID,
-							},
-						},
-					},
-				},
-			})
-		case *edam.Notebook:
-			var noteReferences []sn.Reference
-			if noteIDs, ok := noteIDsByNotebookID[item.ID]; !ok {
-				noteReferences = make([]sn.Reference, 0)
-			} else {
-				noteReferences = make([]sn.Reference, len(noteIDs))
-				for j, noteID := range noteIDs {
-					noteReferences[j] = sn.Reference{
-						UUID:        noteID,
-						ContentType: sn.ContentTypeNote,
-					}
-				}
+			if cerr := params.Ledger.Close(); cerr != nil && err == nil {
+				err = cerr
 			}
-			notebooks = append(notebooks, &sn.Tag{
-				Item: sn.Item{
-					CreatedAt:   item.CreatedAt,
-					UpdatedAt:   item.UpdatedAt,
-					ContentType: sn.ContentTypeNotebook,
-					UUID:        item.ID,
-					Content: struct {
-						Title      string                 `json:"title"`
-						References []sn.Reference         `json:"references"`
-						Text       string                 `json:"text,omitempty"`
-						AppData    map[string]interface{} `json:"appData,omitempty"`
-					}{
-						Title:      item.Name,
-						References: noteReferences,
-						AppData: map[string]interface{}{
-							"evernote.com": &SNItemAppData{
-								OriginalContentType: "Notebook",
-							},
-						},
-					},
-				},
-			})
-		default:
-			return nil, fmt.Errorf("%w; got %T", errTypeAssertion, item)
-		}
+		}()
 	}
 
-	out := make([]entity.LinkID, 0)
-	out = append(out, notes...)
-	out = append(out, tags...)
-	out = append(out, notebooks...)
-	return out, nil
-}
-
-func (c *enexToSN) convertToSN(in []entity.LinkID) (out []entity.LinkID, err error) {
-	out = make([]entity.LinkID, len(in))
-	tagsByName := make(map[string]*sn.Tag)
-	// The official sntools implementation adds a list of tags at the end of the
-	// output in the same order as read in the file. We can't rely on the
-	// iteration order in a map, so a separate list is needed.
-	listOfTags := make([]*sn.Tag, 0)
+	report := errorReport{params: opts.KeepGoingParams}
+	defer func() { err = report.close(err) }()
 
-	for i, link := range in {
-		enexNote, ok := link.(*enex.Note)
-		if !ok {
-			err = fmt.Errorf("%w; expected %T", errTypeAssertion, &enex.Note{})
+	out = &SN{}
+	var w io.Writer = os.Stdout
+	if opts.DryRun {
+		if out.Diff, err = newDiff(opts.CompareFilename); err != nil {
 			return
 		}
-
-		noteID, uerr := c.generateUUID()
-		if uerr != nil {
-			err = uerr
+		w = io.Discard
+	} else if opts.OutputFilename != "" {
+		file, ferr := os.Create(filepath.Clean(opts.OutputFilename))
+		if ferr != nil {
+			err = ferr
 			return
 		}
-		tagReferences := make([]sn.Reference, len(enexNote.Tags))
-		for j, tagName := range enexNote.Tags {
-			if _, ok = tagsByName[tagName]; !ok {
-				tagID, uerr := c.generateUUID()
-				if uerr != nil {
-					err = uerr
-					return
-				}
-				tagsByName[tagName] = &sn.Tag{
-					Item: sn.Item{
-						CreatedAt:   time.Now().UTC(),
-						UpdatedAt:   time.Now().UTC(),
-						ContentType: sn.ContentTypeTag,
-						UUID:        tagID,
-						Content: struct {
-							Title      string                 `json:"title"`
-							References []sn.Reference         `json:"references"`
-							Text       string                 `json:"text,omitempty"`
-							AppData    map[string]interface{} `json:"appData,omitempty"`
-						}{
-							Title:      tagName,
-							References: make([]sn.Reference, 0),
-						},
-					},
-				}
-				listOfTags = append(listOfTags, tagsByName[tagName])
+		defer func() {
+			if cerr := file.Close(); cerr != nil && err == nil {
+				err = cerr
 			}
-			tagReferences[j] = sn.Reference{
-				UUID:        tagsByName[tagName].UUID,
-				ContentType: sn.ContentTypeTag,
+		}()
+		w = file
+	}
+
+	if !opts.StreamOnly {
+		out.Items = make([]entity.LinkID, 0)
+	}
+	params.OnItem = func(item entity.LinkID) {
+		if !opts.StreamOnly {
+			out.Items = append(out.Items, item)
+		}
+		if out.Diff != nil {
+			out.Diff.Add(item)
+		} else {
+			progress.Add(progress.Write, progress.TypeName(item), 1)
+		}
+	}
+	// The report is written as the lossy notes are found, rather than kept
+	// until the end. OnLossy can't fail, so the first error is saved for later.
+	var lossy *repo.ArrayEncoder
+	var lossyErr error
+	if opts.LossyReportFilename != "" {
+		file, ferr := os.Create(filepath.Clean(opts.LossyReportFilename))
+		if ferr != nil {
+			err = ferr
+			return
+		}
+		defer func() {
+			if cerr := file.Close(); cerr != nil && err == nil {
+				err = cerr
 			}
-			tagsByName[tagName].Content.References = append(
-				tagsByName[tagName].Content.References,
-				sn.Reference{
-					UUID:        noteID,
-					ContentType: sn.ContentTypeNote,
-				},
-			)
+		}()
+		lossy = repo.NewArrayEncoder(file)
+	}
+	params.OnLossy = func(note sn.LossyNote) {
+		sn.LogLossy(ctx, note)
+		out.Lossy++
+		if lossy != nil && lossyErr == nil {
+			lossyErr = lossy.Encode(note)
+		}
+	}
+	encoder, err := to.NewEncoder(ctx, w, in, params)
+	if err != nil {
+		return
+	}
+	var count int
+	for note, nerr := range notes {
+		if nerr == nil {
+			nerr = encoder.WriteNote(note)
+		}
+		if nerr != nil && report.skip(ctx, nerr) {
+			continue
+		} else if nerr != nil {
+			err = nerr
+			return
+		}
+		count++
+	}
+	if err = encoder.Close(); err != nil {
+		return
+	}
+	if lossy != nil {
+		if err = lossyErr; err != nil {
+			return
 		}
-		text, xerr := extractNoteContent(enexNote)
-		if xerr != nil {
-			err = xerr
+		if err = lossy.Close(); err != nil {
 			return
 		}
-		out[i] = &sn.Note{
-			Item: sn.Item{
-				CreatedAt:   enexNote.CreatedAt,
-				UpdatedAt:   enexNote.UpdatedAt,
-				ContentType: sn.ContentTypeNote,
-				UUID:        noteID,
-				Content: struct {
-					Title      string                 `json:"title"`
-					References []sn.Reference         `json:"references"`
-					Text       string                 `json:"text,omitempty"`
-					AppData    map[string]interface{} `json:"appData,omitempty"`
-				}{
-					Title:      enexNote.Title,
-					References: tagReferences,
-					Text:       text,
-					AppData: map[string]interface{}{
-						"org.standardnotes.sn": &SNItemAppData{
-							ClientUpdatedAt: &enexNote.UpdatedAt,
-						},
-					},
-				},
-			},
+		log.Info(ctx, map[string]any{"filename": opts.LossyReportFilename, "resource_type": "lossy report", "count": lossy.Count()}, "wrote JSON data to file")
+	}
+	// These are only found by the sn format.
+	if enc, ok := encoder.(*sn.FormatEncoder); ok {
+		out.Collisions, out.UntranslatedSearches, out.Skipped = enc.Collisions, enc.Untranslated, enc.Skipped()
+	}
+	if params.Ledger != nil {
+		if opts.Filter == "" {
+			out.Orphans = params.Ledger.Orphans(in.Service, sn.Service)
 		}
+		logLedger(ctx, opts.LedgerFilename, out.Skipped, out.Orphans)
 	}
-	for i := range listOfTags {
-		out = append(out, listOfTags[i])
+	if opts.DryRun {
+		err = opts.writeDiff(ctx, out.Diff)
+		return
+	}
+	if opts.OutputFilename == "" {
+		return
 	}
+	log.Info(ctx, map[string]any{"filename": opts.OutputFilename, "format": to.Name, "notes": count}, "wrote converted data to file")
 	return
 }
 
-var (
-	enexLineBreakPattern = regexp.MustCompile(`/<br[^>]*>/g`)
-	enexListItemPattern  = regexp.MustCompile(`/<li[^>]*>/g`)
-)
-
-type noteWithHTMLContent interface {
-	HTMLContent() (out string, err error)
+// readRules reads the rules file, if there is one.
+func readRules(filename string) (out *entity.Rules, err error) {
+	if filename == "" {
+		return
+	}
+	file, err := os.Open(filepath.Clean(filename))
+	if err != nil {
+		return
+	}
+	defer func() { _ = file.Close() }()
+	if out, err = entity.ParseRules(file); err != nil {
+		err = fmt.Errorf("%s; %w", filename, err)
+	}
+	return
 }
 
-func extractNoteContent(note noteWithHTMLContent) (string, error) {
-	content, err := note.HTMLContent()
+// newDiff starts a diff against the items in a StandardNotes file, if any.
+func newDiff(filename string) (out *sn.Diff, err error) {
+	if filename == "" {
+		out = sn.NewDiff(nil)
+		return
+	}
+	notes, tags, err := sn.ReadConversionFile(filename)
 	if err != nil {
-		return "", err
+		return
 	}
-	out := enexLineBreakPattern.ReplaceAllString(content, "\n\n")
-	out = enexListItemPattern.ReplaceAllString(out, "\n")
-	return out, nil
+	out = sn.NewDiff(append(notes, tags...))
+	return
 }
 
-// SNItemAppData is extra metadata attached to a StandardNotes Item that should
-// be preserved between platforms or services.
-type SNItemAppData struct {
-	// ClientUpdatedAt is a pointer rather than value because of the omitempty
-	// field tag. If it was a value, then omitempty would have no effect.
-	ClientUpdatedAt *time.Time `json:"client_updated_at,omitempty"`
-	// OriginalContentType is the kind of data in the origin service. For
-	// example, StandardNotes does not have a Notebook type, the closest thing
-	// is a Tag. This field is available to preserve this kind of metadata.
-	OriginalContentType string `json:"original_content_type,omitempty"`
-	// ParentID could be the ID of a parent resource in the original service.
-	ParentID string `json:"parent_id,omitempty"`
+// logLedger reports the resources that were skipped, since they were
+// unchanged, and the orphans of the ledger.
+func logLedger(ctx context.Context, filename string, skipped int, orphans []ledger.Entry) {
+	for _, entry := range orphans {
+		log.Warn(ctx, map[string]any{
+			"content_type":   entry.ContentType,
+			"source_id":      entry.SourceID,
+			"destination_id": entry.DestID,
+		}, "resource in ledger was not converted, it may have been deleted")
+	}
+	log.Info(ctx, map[string]any{
+		"filename": filename,
+		"skipped":  skipped,
+		"orphans":  len(orphans),
+	}, "converted with ledger")
+}
+
+func optionNames(formats ...repo.Format) []string {
+	out := make([]string, 0)
+	for _, format := range formats {
+		for name := range format.Options {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
 }
 
 var (
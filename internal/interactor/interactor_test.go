@@ -3,26 +3,37 @@ package interactor_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/rafaelespinoza/notexfr/internal/enml"
 	"github.com/rafaelespinoza/notexfr/internal/entity"
 	"github.com/rafaelespinoza/notexfr/internal/interactor"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
+	"github.com/rafaelespinoza/notexfr/internal/repo/edam"
+	"github.com/rafaelespinoza/notexfr/internal/repo/edam/fake"
+	"github.com/rafaelespinoza/notexfr/internal/repo/ledger"
 	"github.com/rafaelespinoza/notexfr/internal/repo/sn"
 )
 
 const (
-	_BaseTestOutputDir = "/tmp/notexfr_test/internal/interactor"
-	_FixturesDir       = "../../internal/fixtures"
-	_StubNotebooksFile = "edam_notebooks.json"
-	_StubNotesFile     = "edam_notes.json"
-	_StubTagsFile      = "edam_tags.json"
-	_StubENEXFile      = "test_export.enex"
-	_StubENtoSNFile    = "evernote-to-sn.txt"
+	_BaseTestOutputDir       = "/tmp/notexfr_test/internal/interactor"
+	_FixturesDir             = "../../internal/fixtures"
+	_StubNotebooksFile       = "edam_notebooks.json"
+	_StubNotesFile           = "edam_notes.json"
+	_StubSearchesFile        = "edam_searches.json"
+	_StubTagsFile            = "edam_tags.json"
+	_StubENEXFile            = "test_export.enex"
+	_StubENEXAttachmentsFile = "test_export_attachments.enex"
+	_StubENtoSNFile          = "evernote-to-sn.txt"
 )
 
 func TestMain(m *testing.M) {
@@ -188,13 +199,15 @@ func TestConvert(t *testing.T) {
 	}
 
 	t.Run("EDAMToStandardNotes", func(t *testing.T) {
-		out, err := interactor.ConvertEDAMToStandardNotes(
+		out, err := interactor.Convert(
 			context.TODO(),
 			interactor.ConvertParams{
-				InputFilenames: struct{ Notebooks, Notes, Tags string }{
-					Notebooks: _FixturesDir + "/" + _StubNotebooksFile,
-					Notes:     _FixturesDir + "/" + _StubNotesFile,
-					Tags:      _FixturesDir + "/" + _StubTagsFile,
+				From: "edam",
+				To:   "sn",
+				Options: map[string]string{
+					"notebooks": _FixturesDir + "/" + _StubNotebooksFile,
+					"notes":     _FixturesDir + "/" + _StubNotesFile,
+					"tags":      _FixturesDir + "/" + _StubTagsFile,
 				},
 				OutputFilename: pathToTestDir + "/edam_to_standardnotes.json",
 			},
@@ -358,88 +371,1160 @@ func TestConvert(t *testing.T) {
 		}
 	})
 
+	t.Run("EDAMSearchesToSmartViews", func(t *testing.T) {
+		out, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From: "edam",
+				To:   "sn",
+				Options: map[string]string{
+					"notebooks": _FixturesDir + "/" + _StubNotebooksFile,
+					"notes":     _FixturesDir + "/" + _StubNotesFile,
+					"tags":      _FixturesDir + "/" + _StubTagsFile,
+					"searches":  _FixturesDir + "/" + _StubSearchesFile,
+				},
+				OutputFilename: pathToTestDir + "/edam_searches_to_standardnotes.json",
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedViews := map[string]string{
+			"Foo movies":     `{"operator":"and","value":[{"keypath":"tags","operator":"includes","value":{"keypath":"title","operator":"=","value":"foo"}},{"keypath":"tags","operator":"includes","value":{"keypath":"title","operator":"=","value":"Movies"}}]}`,
+			"Recent":         `{"keypath":"created_at","operator":">=","value":"7.days.ago"}`,
+			"Hello, not bar": `{"operator":"and","value":[{"keypath":"title","operator":"includes","value":"Hello World"},{"operator":"not","value":{"keypath":"tags","operator":"includes","value":{"keypath":"title","operator":"=","value":"bar"}}}]}`,
+			"Foo or bar":     `{"operator":"or","value":[{"keypath":"tags","operator":"includes","value":{"keypath":"title","operator":"=","value":"foo"}},{"keypath":"tags","operator":"includes","value":{"keypath":"title","operator":"startsWith","value":"ba"}}]}`,
+			"Old cities":     `{"operator":"and","value":[{"keypath":"tags","operator":"includes","value":{"keypath":"title","operator":"=","value":"Cities"}},{"keypath":"updated_at","operator":"<","value":"2020-04-01"}]}`,
+			"Since noon":     `{"keypath":"updated_at","operator":">=","value":"2020-03-01"}`,
+		}
+		var numViews int
+		for _, item := range out.Items {
+			view, ok := item.(*sn.SmartView)
+			if !ok {
+				continue
+			}
+			numViews++
+			if view.ContentType.String() != "SN|SmartView" {
+				t.Errorf("%q; wrong content type %q", view.Content.Title, view.ContentType)
+			}
+			if !uuidMatcher.MatchString(view.UUID) {
+				t.Errorf("%q; invalid UUID: %q", view.Content.Title, view.UUID)
+			}
+			expected, ok := expectedViews[view.Content.Title]
+			if !ok {
+				t.Errorf("unexpected smart view %q", view.Content.Title)
+				continue
+			}
+			var actual strings.Builder
+			enc := json.NewEncoder(&actual)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(view.Content.Predicate); err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(actual.String()) != expected {
+				t.Errorf("%q; wrong predicate\ngot      %s\nexpected %s", view.Content.Title, actual.String(), expected)
+			}
+		}
+		if numViews != len(expectedViews) {
+			t.Errorf("wrong number of smart views; got %d, expected %d", numViews, len(expectedViews))
+		}
+
+		expectedUntranslated := []string{"Batman", "Open todos", "This month", "Last weeks", "Bad date"}
+		if len(out.UntranslatedSearches) != len(expectedUntranslated) {
+			t.Fatalf(
+				"wrong number of untranslated searches; got %d, expected %d",
+				len(out.UntranslatedSearches), len(expectedUntranslated),
+			)
+		}
+		for i, search := range out.UntranslatedSearches {
+			if search.Name != expectedUntranslated[i] {
+				t.Errorf("test %d; wrong name; got %q, expected %q", i, search.Name, expectedUntranslated[i])
+			}
+			if search.Reason == "" {
+				t.Errorf("test %d; expected a reason", i)
+			}
+		}
+	})
+
+	t.Run("EDAMNoteVersions", func(t *testing.T) {
+		repository, _ := edam.NewNotesRepo(nil, nil)
+		file, err := os.Open(_FixturesDir + "/" + _StubNotesFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		notes, err := repository.ReadLocal(context.TODO(), file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		edited := notes[0].(*edam.Note)
+		edited.Versions = []*entity.NoteVersion{
+			{UpdateSequenceNum: 3, Title: "First draft", Content: "<en-note><div>draft</div></en-note>", UpdatedAt: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+		}
+		data, err := json.Marshal(notes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		notesFilename := filepath.Join(pathToTestDir, "edam_notes_versions.json")
+		if err = os.WriteFile(notesFilename, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		convert := func(t *testing.T, noteVersions string) *interactor.SN {
+			t.Helper()
+			out, err := interactor.Convert(context.TODO(), interactor.ConvertParams{
+				From: "edam",
+				To:   "sn",
+				Options: map[string]string{
+					"notebooks": _FixturesDir + "/" + _StubNotebooksFile,
+					"notes":     notesFilename,
+					"tags":      _FixturesDir + "/" + _StubTagsFile,
+				},
+				OutputFilename: filepath.Join(pathToTestDir, "edam_note_versions_"+noteVersions+".json"),
+				NoteVersions:   noteVersions,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return out
+		}
+
+		t.Run("appdata", func(t *testing.T) {
+			out := convert(t, "appdata")
+			var found bool
+			for _, item := range out.Items {
+				note, ok := item.(*sn.Note)
+				if !ok || note.UUID != edited.ID {
+					continue
+				}
+				found = true
+				appData, ok := note.Content.AppData["evernote.com"].(*interactor.SNItemAppData)
+				if !ok || len(appData.Versions) != 1 {
+					t.Fatalf("expected 1 version in appData; got %#v", note.Content.AppData["evernote.com"])
+				}
+				version := appData.Versions[0]
+				if version.UpdateSequenceNum != 3 || version.Title != "First draft" || !strings.Contains(version.Text, "draft") {
+					t.Errorf("wrong version; got %+v", version)
+				}
+			}
+			if !found {
+				t.Fatalf("did not find note %q", edited.ID)
+			}
+		})
+
+		t.Run("archive", func(t *testing.T) {
+			out := convert(t, "archive")
+			var archived []*sn.Note
+			for _, item := range out.Items {
+				note, ok := item.(*sn.Note)
+				if !ok {
+					continue
+				}
+				if appData, ok := note.Content.AppData["evernote.com"].(*interactor.SNItemAppData); ok && appData.VersionOf != "" {
+					archived = append(archived, note)
+				}
+			}
+			if len(archived) != 1 {
+				t.Fatalf("wrong number of archived notes; got %d, expected %d", len(archived), 1)
+			}
+			note := archived[0]
+			if note.Content.Title != "First draft" || note.UUID == edited.ID || !uuidMatcher.MatchString(note.UUID) {
+				t.Errorf("wrong archived note; title %q, uuid %q", note.Content.Title, note.UUID)
+			}
+			if appData := note.Content.AppData["org.standardnotes.sn"].(*interactor.SNItemAppData); !appData.Archived {
+				t.Errorf("expected note to be archived")
+			}
+			if appData := note.Content.AppData["evernote.com"].(*interactor.SNItemAppData); appData.VersionOf != edited.ID || appData.UpdateSequenceNum != 3 {
+				t.Errorf("wrong version metadata; got %+v", appData)
+			}
+		})
+
+		t.Run("invalid", func(t *testing.T) {
+			_, err := interactor.Convert(context.TODO(), interactor.ConvertParams{From: "edam", To: "sn", InputPath: _FixturesDir, NoteVersions: "nope"})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	})
+
 	t.Run("ENEXToStandardNotes", func(t *testing.T) {
-		out, err := interactor.ConvertENEXToStandardNotes(
+		out, err := interactor.Convert(
 			context.TODO(),
 			interactor.ConvertParams{
-				InputFilename:  _FixturesDir + "/" + _StubENEXFile,
+				From:           "enex",
+				To:             "sn",
+				InputPath:      _FixturesDir + "/" + _StubENEXFile,
 				OutputFilename: pathToTestDir + "/enex_to_standardnotes.json",
 			},
 		)
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		// collect generated tag info to use in tests later.
-		knownIDs := ReferenceIDs{
-			Notes: make([]string, 0),
-			Tags:  make([]string, 0),
+
+		// collect generated tag info to use in tests later.
+		knownIDs := ReferenceIDs{
+			Notes: make([]string, 0),
+			Tags:  make([]string, 0),
+		}
+		actualItems := out.Items
+		for ind, member := range actualItems {
+			switch actual := member.(type) {
+			case *sn.Note:
+				knownIDs.Notes = append(knownIDs.Notes, actual.UUID)
+			case *sn.Tag:
+				knownIDs.Tags = append(knownIDs.Tags, actual.UUID)
+			default:
+				t.Fatalf("%d, unexpected type %T", ind, actual)
+			}
+		}
+
+		baseExpectedNotes := []ExpectedTestValues{
+			{ContentType: "Note", Title: "Batman", ExpectNoteText: true, References: []TestReference{{"Tag", 0}, {"Tag", 1}}},
+			{ContentType: "Note", Title: "Atlanta", ExpectNoteText: true, References: []TestReference{{"Tag", 0}}},
+			{ContentType: "Note", Title: "Hello World", ExpectNoteText: true, References: []TestReference{{"Tag", 0}, {"Tag", 1}}},
+			{ContentType: "Note", Title: "Chicago", References: []TestReference{{"Tag", 1}, {"Tag", 2}}},
+			{ContentType: "Note", Title: "Edmonton", References: []TestReference{{"Tag", 0}}},
+			{ContentType: "Note", Title: "Baltimore", References: []TestReference{{"Tag", 3}}},
+			{ContentType: "Note", Title: "Fargo", References: []TestReference{{"Tag", 3}}},
+			{ContentType: "Note", Title: "Despicable Me", References: []TestReference{{"Tag", 0}}},
+			{ContentType: "Note", Title: "Fargo", References: []TestReference{{"Tag", 0}}},
+			{ContentType: "Note", Title: "Enter The Dragon", References: []TestReference{{"Tag", 3}}},
+			{ContentType: "Note", Title: "Detroit", References: []TestReference{{"Tag", 3}}},
+			{ContentType: "Note", Title: "Casino", References: []TestReference{{"Tag", 3}}},
+			{ContentType: "Note", Title: "Aladdin", References: []TestReference{{"Tag", 3}}},
+		}
+
+		baseExpectedTags := []ExpectedTestValues{
+			{ContentType: "Tag", Title: "foo", References: []TestReference{{"Note", 0}, {"Note", 1}, {"Note", 2}, {"Note", 4}, {"Note", 7}, {"Note", 8}}},
+			{ContentType: "Tag", Title: "baker", References: []TestReference{{"Note", 0}, {"Note", 2}, {"Note", 3}}},
+			{ContentType: "Tag", Title: "free", References: []TestReference{{"Note", 3}}},
+			{ContentType: "Tag", Title: "bar", References: []TestReference{{"Note", 5}, {"Note", 6}, {"Note", 9}, {"Note", 10}, {"Note", 11}, {"Note", 12}}},
+		}
+		expectedOutput := make([]ExpectedTestValues, 0)
+		expectedOutput = append(expectedOutput, baseExpectedNotes...)
+		expectedOutput = append(expectedOutput, baseExpectedTags...)
+
+		if len(actualItems) != len(expectedOutput) {
+			t.Fatalf(
+				"wrong output length; got %d, expected  %d",
+				len(actualItems), len(expectedOutput),
+			)
+		}
+
+		for ind, member := range actualItems {
+			switch actual := member.(type) {
+			case *sn.Note:
+				testSNItem(t, ind, &actual.Item, expectedOutput)
+				testSNNote(t, ind, actual, expectedOutput)
+				testSNReferences(t, ind, actual.Content.References, expectedOutput[ind].References, &knownIDs)
+			case *sn.Tag:
+				testSNItem(t, ind, &actual.Item, expectedOutput)
+				testSNReferences(t, ind, actual.Content.References, expectedOutput[ind].References, &knownIDs)
+			default:
+				t.Errorf("test %d; unexpected type %T", ind, actual)
+			}
+		}
+	})
+
+	t.Run("StreamOnly", func(t *testing.T) {
+		outputFilename := pathToTestDir + "/enex_to_standardnotes_stream.json"
+		out, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:           "enex",
+				To:             "sn",
+				InputPath:      _FixturesDir + "/" + _StubENEXFile,
+				OutputFilename: outputFilename,
+				StreamOnly:     true,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Items) != 0 {
+			t.Errorf("expected items to not be kept; got %d", len(out.Items))
+		}
+
+		notes, tags, err := sn.ReadConversionFile(outputFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notes) != 13 {
+			t.Errorf("wrong number of notes; got %d, expected %d", len(notes), 13)
+		}
+		if len(tags) != 4 {
+			t.Errorf("wrong number of tags; got %d, expected %d", len(tags), 4)
+		}
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		countItems := func(items []entity.LinkID) (notes, tags int) {
+			for _, item := range items {
+				switch item.(type) {
+				case *sn.Note:
+					notes++
+				case *sn.Tag:
+					tags++
+				}
+			}
+			return
+		}
+
+		out, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:           "enex",
+				To:             "sn",
+				InputPath:      _FixturesDir + "/" + _StubENEXFile,
+				OutputFilename: pathToTestDir + "/enex_to_standardnotes_filter.json",
+				Filter:         "tag:bar",
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if notes, tags := countItems(out.Items); notes != 6 || tags != 1 {
+			t.Errorf("wrong number of items; got %d notes, %d tags, expected %d notes, %d tags", notes, tags, 6, 1)
+		}
+
+		out, err = interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From: "edam",
+				To:   "sn",
+				Options: map[string]string{
+					"notebooks": _FixturesDir + "/" + _StubNotebooksFile,
+					"notes":     _FixturesDir + "/" + _StubNotesFile,
+					"tags":      _FixturesDir + "/" + _StubTagsFile,
+				},
+				OutputFilename: pathToTestDir + "/edam_to_standardnotes_filter.json",
+				Filter:         "notebook:movies -title:^[A-C]",
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range out.Items {
+			switch val := item.(type) {
+			case *sn.Note:
+				if title := val.Content.Title; title != "Despicable Me" && title != "Enter The Dragon" && title != "Fargo" {
+					t.Errorf("unexpected note %q", title)
+				}
+			case *sn.Tag:
+				if title := val.Content.Title; title == "Cities" {
+					t.Errorf("expected notebook %q to be left out", title)
+				}
+			}
+		}
+		if notes, _ := countItems(out.Items); notes != 3 {
+			t.Errorf("wrong number of notes; got %d, expected %d", notes, 3)
+		}
+
+		_, err = interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:           "enex",
+				To:             "sn",
+				InputPath:      _FixturesDir + "/" + _StubENEXFile,
+				OutputFilename: pathToTestDir + "/enex_to_standardnotes_filter.json",
+				Filter:         "color:red",
+			},
+		)
+		if !errors.Is(err, entity.ErrFilter) {
+			t.Errorf("expected %v; got %v", entity.ErrFilter, err)
+		}
+	})
+
+	t.Run("Rules", func(t *testing.T) {
+		rulesFilename := filepath.Join(t.TempDir(), "rules.json")
+		rules := `{"tags": [{"name": "baker", "rename": "FOO"}, {"name": "free", "drop": true}]}`
+		if err := os.WriteFile(rulesFilename, []byte(rules), 0600); err != nil {
+			t.Fatal(err)
+		}
+		out, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:           "enex",
+				To:             "sn",
+				InputPath:      _FixturesDir + "/" + _StubENEXFile,
+				OutputFilename: pathToTestDir + "/enex_to_standardnotes_rules.json",
+				RulesFilename:  rulesFilename,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tagTitles []string
+		for _, item := range out.Items {
+			switch val := item.(type) {
+			case *sn.Note:
+				seen := make(map[string]bool)
+				for _, ref := range val.Content.References {
+					if seen[ref.UUID] {
+						t.Errorf("note %q refers to %s more than once", val.Content.Title, ref.UUID)
+					}
+					seen[ref.UUID] = true
+				}
+			case *sn.Tag:
+				tagTitles = append(tagTitles, val.Content.Title)
+				// foo is on 6 notes, baker on 3, and 2 notes have both.
+				if val.Content.Title == "foo" && len(val.Content.References) != 7 {
+					t.Errorf("wrong number of references for merged tag; got %d, expected %d", len(val.Content.References), 7)
+				}
+			}
+		}
+		if got := strings.Join(tagTitles, ","); got != "foo,bar" {
+			t.Errorf("wrong tags; got %q, expected %q", got, "foo,bar")
+		}
+
+		if err := os.WriteFile(rulesFilename, []byte(`{"tags": [{"name": "foo"}]}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err = interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:           "enex",
+				To:             "sn",
+				InputPath:      _FixturesDir + "/" + _StubENEXFile,
+				OutputFilename: pathToTestDir + "/enex_to_standardnotes_rules.json",
+				RulesFilename:  rulesFilename,
+			},
+		)
+		if !errors.Is(err, entity.ErrRules) {
+			t.Errorf("expected %v; got %v", entity.ErrRules, err)
+		}
+	})
+
+	t.Run("Collisions", func(t *testing.T) {
+		// Rename a tag so that it has the same name as a notebook.
+		rulesFilename := filepath.Join(t.TempDir(), "rules.json")
+		if err := os.WriteFile(rulesFilename, []byte(`{"tags": [{"name": "foo", "rename": "Movies"}]}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		out, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From: "edam",
+				To:   "sn",
+				Options: map[string]string{
+					"notebooks": _FixturesDir + "/" + _StubNotebooksFile,
+					"notes":     _FixturesDir + "/" + _StubNotesFile,
+					"tags":      _FixturesDir + "/" + _StubTagsFile,
+				},
+				OutputFilename:  pathToTestDir + "/edam_to_standardnotes_collisions.json",
+				RulesFilename:   rulesFilename,
+				CollisionPolicy: "merge",
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Collisions) != 1 || out.Collisions[0].Name != "Movies" {
+			t.Errorf("wrong collisions; got %+v", out.Collisions)
+		}
+		var movies int
+		for _, item := range out.Items {
+			if tag, ok := item.(*sn.Tag); ok && tag.Content.Title == "Movies" {
+				movies++
+			}
+		}
+		if movies != 1 {
+			t.Errorf("expected notebook and tag to be merged; got %d tags", movies)
+		}
+	})
+
+	t.Run("KeepGoing", func(t *testing.T) {
+		note := func(title, created, content string) string {
+			return `<note><title>` + title + `</title><content><![CDATA[` + content + `]]></content><created>` + created + `</created><updated>20200307T202554Z</updated></note>`
+		}
+		inputFilename := filepath.Join(t.TempDir(), "export.enex")
+		data := `<?xml version="1.0" encoding="UTF-8"?>
+<en-export>
+` + note("Bad Date", "yesterday", "<en-note>a</en-note>") + `
+` + note("No Body", "20200307T202156Z", "<div>b</div>") + `
+` + note("Good", "20200307T202156Z", "<en-note>c</en-note>") + `
+</en-export>`
+		if err := os.WriteFile(inputFilename, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:           "enex",
+				To:             "sn",
+				InputPath:      inputFilename,
+				OutputFilename: pathToTestDir + "/enex_to_standardnotes_keep_going.json",
+			},
+		)
+		var nerr *entity.NoteError
+		if !errors.As(err, &nerr) || nerr.Title != "Bad Date" {
+			t.Fatalf("expected error for first note; got %v", err)
+		}
+
+		reportFilename := filepath.Join(t.TempDir(), "report.json")
+		out, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:            "enex",
+				To:              "sn",
+				InputPath:       inputFilename,
+				OutputFilename:  pathToTestDir + "/enex_to_standardnotes_keep_going.json",
+				KeepGoingParams: interactor.KeepGoingParams{KeepGoing: true, ErrorReportFilename: reportFilename},
+			},
+		)
+		if !errors.Is(err, interactor.ErrSkipped) {
+			t.Fatalf("expected error %v; got %v", interactor.ErrSkipped, err)
+		}
+		var titles []string
+		for _, item := range out.Items {
+			if val, ok := item.(*sn.Note); ok {
+				titles = append(titles, val.Content.Title)
+			}
+		}
+		if len(titles) != 1 || titles[0] != "Good" {
+			t.Errorf("wrong notes; got %q", titles)
+		}
+		raw, err := os.ReadFile(reportFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report []struct{ ID, Title, Stage, Error string }
+		if err = json.Unmarshal(raw, &report); err != nil {
+			t.Fatal(err)
+		}
+		if len(report) != 2 {
+			t.Fatalf("wrong number of skipped notes; got %d, expected %d", len(report), 2)
+		}
+		if report[0].Title != "Bad Date" || report[0].Stage != entity.StageRead {
+			t.Errorf("wrong report entry; got %+v", report[0])
+		}
+		if report[1].Title != "No Body" || report[1].Stage != entity.StageConvert || report[1].Error == "" {
+			t.Errorf("wrong report entry; got %+v", report[1])
+		}
+	})
+
+	t.Run("LossyReport", func(t *testing.T) {
+		inputFilename := filepath.Join(t.TempDir(), "export.enex")
+		data := `<?xml version="1.0" encoding="UTF-8"?>
+<en-export>
+<note><title>Lossy</title><content><![CDATA[<en-note><en-crypt cipher="AES" length="128">abc=</en-crypt><div><en-todo checked="true"/>done</div><table><tr><td><table><tr><td>x</td></tr></table></td></tr></table></en-note>]]></content><created>20200307T202156Z</created><updated>20200307T202554Z</updated><note-attributes><reminder-order>1583612754000</reminder-order><reminder-time>20200401T090000Z</reminder-time></note-attributes></note>
+<note><title>Plain</title><content><![CDATA[<en-note><div>plain</div></en-note>]]></content><created>20200307T202156Z</created><updated>20200307T202554Z</updated></note>
+</en-export>`
+		if err := os.WriteFile(inputFilename, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		reportFilename := filepath.Join(t.TempDir(), "lossy.json")
+		out, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:                "enex",
+				To:                  "sn",
+				InputPath:           inputFilename,
+				OutputFilename:      pathToTestDir + "/enex_to_standardnotes_lossy.json",
+				LossyReportFilename: reportFilename,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.Lossy != 1 {
+			t.Fatalf("wrong number of lossy notes; got %d, expected %d", out.Lossy, 1)
+		}
+		raw, err := os.ReadFile(reportFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report []struct {
+			Title    string
+			Features []struct {
+				Name   string
+				Count  int
+				Detail string
+			}
+		}
+		if err = json.Unmarshal(raw, &report); err != nil {
+			t.Fatal(err)
+		}
+		if len(report) != 1 || report[0].Title != "Lossy" {
+			t.Fatalf("wrong report; got %s", raw)
+		}
+		var names []string
+		for _, feature := range report[0].Features {
+			names = append(names, feature.Name)
+		}
+		if expected := "encryption todo reminder table"; strings.Join(names, " ") != expected {
+			t.Errorf("wrong features; got %q, expected %q", names, expected)
+		}
+		if detail := report[0].Features[2].Detail; !strings.Contains(detail, "2020-04-01") {
+			t.Errorf("expected reminder detail to have its time; got %q", detail)
+		}
+	})
+
+	t.Run("NoteText", func(t *testing.T) {
+		dir := t.TempDir()
+		inputFilename := filepath.Join(dir, "export.enex")
+		data := `<?xml version="1.0" encoding="UTF-8"?>
+<en-export>
+<note><title>Groceries</title><content><![CDATA[<en-note><div><en-todo checked="true"/>milk</div><div><en-todo/>eggs</div><div><en-todo/>bread</div></en-note>]]></content><created>20200307T202156Z</created><updated>20200307T202554Z</updated></note>
+</en-export>`
+		if err := os.WriteFile(inputFilename, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range []struct {
+			format, text string
+			lossy        int
+		}{
+			{"html", "<div>☑ milk</div><div>☐ eggs</div><div>☐ bread</div>", 1},
+			{"markdown", "- [x] milk\n- [ ] eggs\n- [ ] bread", 0},
+			{"text", "☑ milk\n☐ eggs\n☐ bread", 1},
+		} {
+			t.Run(test.format, func(t *testing.T) {
+				outputFilename := filepath.Join(dir, "sn_"+test.format+".json")
+				out, err := interactor.Convert(
+					context.TODO(),
+					interactor.ConvertParams{
+						From:           "enex",
+						To:             "sn",
+						InputPath:      inputFilename,
+						OutputFilename: outputFilename,
+						NoteText:       test.format,
+					},
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var note *sn.Note
+				for _, item := range out.Items {
+					if n, ok := item.(*sn.Note); ok {
+						note = n
+					}
+				}
+				if note == nil {
+					t.Fatal("expected a note")
+				}
+				if note.Content.Text != test.text {
+					t.Errorf("wrong text; got %q, expected %q", note.Content.Text, test.text)
+				}
+				appData, ok := note.Content.AppData["evernote.com"].(*sn.AppData)
+				if !ok || appData.Todos == nil {
+					t.Fatalf("expected appData to have todos; got %#v", note.Content.AppData)
+				}
+				if appData.Todos.Open != 2 || appData.Todos.Done != 1 {
+					t.Errorf("wrong todos; got %+v, expected 2 open, 1 done", *appData.Todos)
+				}
+				if out.Lossy != test.lossy {
+					t.Errorf("wrong number of lossy notes; got %d, expected %d", out.Lossy, test.lossy)
+				}
+
+				verification, err := interactor.Verify(context.TODO(), interactor.VerifyParams{
+					From:           "enex",
+					InputPath:      inputFilename,
+					SNFilename:     outputFilename,
+					ReportFilename: filepath.Join(dir, "report_"+test.format+".txt"),
+				})
+				if err != nil {
+					t.Errorf("expected verification to pass; got %v, %+v", err, verification.Discrepancies)
+				}
+			})
+		}
+
+		_, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:           "enex",
+				To:             "sn",
+				InputPath:      inputFilename,
+				OutputFilename: filepath.Join(dir, "invalid.json"),
+				NoteText:       "rtf",
+			},
+		)
+		if err == nil {
+			t.Error("expected an error for an invalid note text format")
+		}
+	})
+
+	t.Run("Decrypt", func(t *testing.T) {
+		dir := t.TempDir()
+		// The text is "<div>the secret</div>", with the passphrase "hunter2".
+		const body = "RU5DMAEBAQEBAQEBAQEBAQEBAQECAgICAgICAgICAgICAgICAwMDAwMDAwMDAwMDAwMDAzQcKqLBFiBzmuBNg0y1zgZ0lrGXlCW3ouaHfhWVwFhPNh3kuU9p7Lf74Xg6165tNHmmtqEq53UknV64+yGSPoI="
+		inputFilename := filepath.Join(dir, "export.enex")
+		data := `<?xml version="1.0" encoding="UTF-8"?>
+<en-export>
+<note><title>Secret</title><content><![CDATA[<en-note><div>before</div><en-crypt cipher="AES" length="128" hint="the usual">` + body + `</en-crypt></en-note>]]></content><created>20200307T202156Z</created><updated>20200307T202554Z</updated></note>
+</en-export>`
+		if err := os.WriteFile(inputFilename, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		passphrasesFilename := filepath.Join(dir, "passphrases.json")
+		if err := os.WriteFile(passphrasesFilename, []byte(`{"hints": {"the usual": "hunter2"}}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range []struct {
+			name   string
+			params interactor.DecryptParams
+			text   string
+			lossy  int
+		}{
+			{"passphrases", interactor.DecryptParams{PassphrasesFilename: passphrasesFilename}, "the secret", 0},
+			{"prompt", interactor.DecryptParams{Prompt: func(hint string) (string, error) { return "hunter2", nil }}, "the secret", 0},
+			{"wrong", interactor.DecryptParams{Prompt: func(hint string) (string, error) { return "wrong", nil }}, "en-crypt", 1},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				out, err := interactor.Convert(
+					context.TODO(),
+					interactor.ConvertParams{
+						From:           "enex",
+						To:             "sn",
+						InputPath:      inputFilename,
+						OutputFilename: filepath.Join(dir, test.name+".json"),
+						DecryptParams:  test.params,
+					},
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var text string
+				for _, item := range out.Items {
+					if note, ok := item.(*sn.Note); ok {
+						text = note.Content.Text
+					}
+				}
+				if !strings.Contains(text, test.text) {
+					t.Errorf("expected note text to contain %q; got %q", test.text, text)
+				}
+				if out.Lossy != test.lossy {
+					t.Errorf("wrong number of lossy notes; got %d, expected %d", out.Lossy, test.lossy)
+				}
+			})
+		}
+
+		_, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:           "enex",
+				To:             "sn",
+				InputPath:      inputFilename,
+				OutputFilename: filepath.Join(dir, "invalid.json"),
+				DecryptParams:  interactor.DecryptParams{PassphrasesFilename: inputFilename},
+			},
+		)
+		if !errors.Is(err, enml.ErrKeyring) {
+			t.Errorf("wrong error; got %v, expected %v", err, enml.ErrKeyring)
+		}
+	})
+
+	t.Run("Ledger", func(t *testing.T) {
+		ledgerFilename := filepath.Join(t.TempDir(), "ledger.jsonl")
+		convert := func(t *testing.T) *interactor.SN {
+			t.Helper()
+			out, err := interactor.Convert(
+				context.TODO(),
+				interactor.ConvertParams{
+					From:           "enex",
+					To:             "sn",
+					InputPath:      _FixturesDir + "/" + _StubENEXFile,
+					OutputFilename: pathToTestDir + "/enex_to_standardnotes_ledger.json",
+					LedgerFilename: ledgerFilename,
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return out
+		}
+		tagUUIDs := func(out *interactor.SN) (uuids []string) {
+			for _, item := range out.Items {
+				if tag, ok := item.(*sn.Tag); ok {
+					uuids = append(uuids, tag.UUID)
+				}
+			}
+			return
+		}
+
+		// A run that fails after the notes are written records nothing.
+		_, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:                "enex",
+				To:                  "sn",
+				InputPath:           _FixturesDir + "/" + _StubENEXFile,
+				OutputFilename:      pathToTestDir + "/enex_to_standardnotes_ledger.json",
+				LedgerFilename:      ledgerFilename,
+				LossyReportFilename: filepath.Join(t.TempDir(), "nope", "lossy.json"),
+			},
+		)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if data, rerr := os.ReadFile(ledgerFilename); rerr != nil || len(data) != 0 {
+			t.Fatalf("expected an empty ledger; got %q, %v", data, rerr)
+		}
+
+		first := convert(t)
+		if len(first.Items) != 17 || first.Skipped != 0 {
+			t.Fatalf("wrong output; got %d items, %d skipped", len(first.Items), first.Skipped)
+		}
+
+		// Pretend that a note was converted before, but isn't there anymore.
+		book, err := ledger.Open(ledgerFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deleted := ledger.Key{SourceService: edam.Service, ContentType: "Note", SourceID: "deleted", DestService: sn.Service}
+		if err = book.Record(ledger.Entry{Key: deleted, DestID: "d"}); err != nil {
+			t.Fatal(err)
+		}
+		if err = book.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err = book.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		second := convert(t)
+		if second.Skipped != 13 {
+			t.Errorf("expected unchanged notes to be skipped; got %d", second.Skipped)
+		}
+		if len(second.Items) != 4 {
+			t.Errorf("expected only tags to be written; got %d items", len(second.Items))
+		}
+		expectedTags, actualTags := tagUUIDs(first), tagUUIDs(second)
+		if fmt.Sprint(actualTags) != fmt.Sprint(expectedTags) {
+			t.Errorf("expected tag UUIDs to be kept\ngot      %q\nexpected %q", actualTags, expectedTags)
+		}
+		if len(second.Orphans) != 1 || second.Orphans[0].Key != deleted {
+			t.Errorf("wrong orphans; got %+v", second.Orphans)
+		}
+
+		// Other settings change the output, so nothing is skipped.
+		third, err := interactor.Convert(
+			context.TODO(),
+			interactor.ConvertParams{
+				From:           "enex",
+				To:             "sn",
+				InputPath:      _FixturesDir + "/" + _StubENEXFile,
+				OutputFilename: pathToTestDir + "/enex_to_standardnotes_ledger.json",
+				LedgerFilename: ledgerFilename,
+				NoteText:       "markdown",
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if third.Skipped != 0 || len(third.Items) != 17 {
+			t.Errorf("expected notes to be converted again; got %d items, %d skipped", len(third.Items), third.Skipped)
+		}
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		dir := t.TempDir()
+		params := interactor.ConvertParams{
+			From:           "enex",
+			To:             "sn",
+			InputPath:      _FixturesDir + "/" + _StubENEXFile,
+			OutputFilename: filepath.Join(dir, "output.json"),
+			UUIDNamespace:  interactor.DefaultUUIDNamespace,
+			LedgerFilename: filepath.Join(dir, "ledger.jsonl"),
+		}
+		params.DryRun = true
+		params.DiffFilename = filepath.Join(dir, "diff.json")
+		params.DiffFormat = "json"
+		out, err := interactor.Convert(context.TODO(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Diff.Created) != 17 || len(out.Diff.Changed) != 0 {
+			t.Errorf("expected all items to be created; got %d created, %d changed", len(out.Diff.Created), len(out.Diff.Changed))
+		}
+		for _, filename := range []string{params.OutputFilename, params.LedgerFilename} {
+			if _, err = os.Stat(filename); !os.IsNotExist(err) {
+				t.Errorf("expected %q to not be written; got %v", filename, err)
+			}
+		}
+		var diff struct{ Created []any }
+		if data, rerr := os.ReadFile(params.DiffFilename); rerr != nil {
+			t.Fatal(rerr)
+		} else if err = json.Unmarshal(data, &diff); err != nil || len(diff.Created) != 17 {
+			t.Errorf("wrong diff file; %v\n%s", err, data)
+		}
+
+		// Convert for real, then compare a dry run against that.
+		params.DryRun = false
+		if _, err = interactor.Convert(context.TODO(), params); err != nil {
+			t.Fatal(err)
+		}
+		params.DryRun = true
+		params.LedgerFilename = ""
+		params.CompareFilename = params.OutputFilename
+		params.DiffFormat = "text"
+		if out, err = interactor.Convert(context.TODO(), params); err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Diff.Created) != 0 || len(out.Diff.Changed) != 0 || out.Diff.Unchanged != 17 {
+			t.Errorf("expected all items to be unchanged; got %+v", out.Diff)
+		}
+
+		params.UUIDNamespace = ""
+		if _, err = interactor.Convert(context.TODO(), params); err == nil {
+			t.Error("expected an error for comparing without a UUID namespace or a ledger")
+		}
+
+		params.UUIDNamespace = interactor.DefaultUUIDNamespace
+		params.DiffFormat = "nope"
+		if _, err = interactor.Convert(context.TODO(), params); err == nil {
+			t.Error("expected an error for an invalid diff format")
+		}
+	})
+}
+
+// uuidMatcher helps us make sure we're at least trying to make a UUID. Pattern
+// lifted from: https://stackoverflow.com/a/13653180.
+var uuidMatcher = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-5][0-9a-f]{3}-[089ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestConvertFormats(t *testing.T) {
+	pathToTestDir := _BaseTestOutputDir + "/" + t.Name()
+	if err := os.MkdirAll(pathToTestDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	readGraph := func(t *testing.T, name, path string) *entity.Graph {
+		t.Helper()
+		format, err := repo.LookupFormat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := repo.ReadGraph(context.TODO(), format, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	t.Run("edam-to-enex-to-sn", func(t *testing.T) {
+		enexFilename := pathToTestDir + "/edam.enex"
+		_, err := interactor.Convert(context.TODO(), interactor.ConvertParams{
+			From:           "edam",
+			To:             "enex",
+			InputPath:      _FixturesDir,
+			OutputFilename: enexFilename,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		edamData := readGraph(t, "edam", _FixturesDir)
+		if len(edamData.Notes) < 1 || len(edamData.Notebooks) < 1 || len(edamData.Tags) < 1 {
+			t.Fatalf("expected notes, notebooks, tags; got %d, %d, %d", len(edamData.Notes), len(edamData.Notebooks), len(edamData.Tags))
+		}
+
+		_, err = interactor.Convert(context.TODO(), interactor.ConvertParams{
+			From:           "enex",
+			To:             "sn",
+			InputPath:      enexFilename,
+			OutputFilename: pathToTestDir + "/edam_enex.json",
+			Options:        map[string]string{"note-versions": "archive"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		enexData := readGraph(t, "enex", enexFilename)
+		if len(enexData.Notes) != len(edamData.Notes) {
+			t.Fatalf("wrong number of notes; got %d, expected %d", len(enexData.Notes), len(edamData.Notes))
+		}
+		tagNames := make(map[string]string)
+		for _, tag := range edamData.Tags {
+			tagNames[tag.ID] = tag.Name
+		}
+		for i, note := range enexData.Notes {
+			expected := edamData.Notes[i]
+			if note.Title != expected.Title {
+				t.Errorf("test %d; wrong title; got %q, expected %q", i, note.Title, expected.Title)
+			}
+			if !note.UpdatedAt.Equal(expected.UpdatedAt.Truncate(time.Second)) {
+				t.Errorf("test %d; wrong UpdatedAt; got %v, expected %v", i, note.UpdatedAt, expected.UpdatedAt)
+			}
+			expectedTags := make([]string, 0)
+			for _, id := range expected.TagIDs {
+				expectedTags = append(expectedTags, tagNames[id])
+			}
+			if fmt.Sprint(note.Tags) != fmt.Sprint(expectedTags) {
+				t.Errorf("test %d; wrong tags; got %q, expected %q", i, note.Tags, expectedTags)
+			}
+		}
+
+		_, err = interactor.Convert(context.TODO(), interactor.ConvertParams{
+			From:           "sn",
+			To:             "enex",
+			InputPath:      pathToTestDir + "/edam_enex.json",
+			OutputFilename: pathToTestDir + "/edam_enex_sn.enex",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		snData := readGraph(t, "enex", pathToTestDir+"/edam_enex_sn.enex")
+		if len(snData.Notes) != len(edamData.Notes) || len(snData.Tags) != len(enexData.Tags) {
+			t.Errorf(
+				"wrong number of notes, tags; got %d, %d; expected %d, %d",
+				len(snData.Notes), len(snData.Tags), len(edamData.Notes), len(enexData.Tags),
+			)
+		}
+	})
+
+	t.Run("enex-attachments", func(t *testing.T) {
+		outputFilename := pathToTestDir + "/attachments.enex"
+		_, err := interactor.Convert(context.TODO(), interactor.ConvertParams{
+			From:           "enex",
+			To:             "enex",
+			InputPath:      _FixturesDir + "/" + _StubENEXAttachmentsFile,
+			OutputFilename: outputFilename,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := readGraph(t, "enex", _FixturesDir+"/"+_StubENEXAttachmentsFile)
+		roundtrip := readGraph(t, "enex", outputFilename)
+		if len(roundtrip.Notes) != len(original.Notes) {
+			t.Fatalf("wrong number of notes; got %d, expected %d", len(roundtrip.Notes), len(original.Notes))
+		}
+		for i, note := range roundtrip.Notes {
+			expected := original.Notes[i].Attachments
+			if len(note.Attachments) != len(expected) {
+				t.Errorf("test %d; wrong number of attachments; got %d, expected %d", i, len(note.Attachments), len(expected))
+				continue
+			}
+			for j, att := range note.Attachments {
+				if att.Filename != expected[j].Filename || att.MIME != expected[j].MIME || string(att.Data) != string(expected[j].Data) {
+					t.Errorf("test [%d][%d]; attachment changed; got %q, %q", i, j, att.Filename, att.MIME)
+				}
+			}
+		}
+	})
+
+	t.Run("sn-to-sn", func(t *testing.T) {
+		// Any input gets the features of converting to StandardNotes.
+		dir := t.TempDir()
+		params := interactor.ConvertParams{
+			From:           "sn",
+			To:             "sn",
+			InputPath:      _FixturesDir + "/" + _StubENtoSNFile,
+			OutputFilename: filepath.Join(dir, "output.json"),
+			LedgerFilename: filepath.Join(dir, "ledger.jsonl"),
+			Options:        map[string]string{"uuid-namespace": interactor.DefaultUUIDNamespace},
+		}
+		first, err := interactor.Convert(context.TODO(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(first.Items) < 1 || first.Skipped != 0 {
+			t.Fatalf("expected items and none skipped; got %d, %d", len(first.Items), first.Skipped)
+		}
+		second, err := interactor.Convert(context.TODO(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second.Skipped < 1 {
+			t.Error("expected unchanged notes to be skipped with a ledger")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			params interactor.ConvertParams
+		}{
+			{name: "unknown from", params: interactor.ConvertParams{From: "nope", To: "sn", InputPath: _FixturesDir}},
+			{name: "unknown to", params: interactor.ConvertParams{From: "edam", To: "nope", InputPath: _FixturesDir}},
+			{name: "not writable", params: interactor.ConvertParams{From: "enex", To: "edam", InputPath: _FixturesDir + "/" + _StubENEXFile}},
+			{name: "no input", params: interactor.ConvertParams{From: "edam", To: "sn"}},
+			{name: "unknown option", params: interactor.ConvertParams{From: "edam", To: "enex", InputPath: _FixturesDir, Options: map[string]string{"note-versions": "archive"}}},
+			{name: "ledger for enex", params: interactor.ConvertParams{From: "edam", To: "enex", InputPath: _FixturesDir, LedgerFilename: filepath.Join(t.TempDir(), "ledger.jsonl")}},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				if _, err := interactor.Convert(context.TODO(), test.params); err == nil {
+					t.Fatal("expected an error")
+				}
+			})
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	pathToTestDir := _BaseTestOutputDir + "/" + t.Name()
+	if err := os.MkdirAll(pathToTestDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	outputFilename := pathToTestDir + "/enex_to_standardnotes.json"
+	_, err := interactor.Convert(
+		context.TODO(),
+		interactor.ConvertParams{
+			From:           "enex",
+			To:             "sn",
+			InputPath:      _FixturesDir + "/" + _StubENEXFile,
+			OutputFilename: outputFilename,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("pass", func(t *testing.T) {
+		reportFilename := pathToTestDir + "/pass.json"
+		out, err := interactor.Verify(context.TODO(), interactor.VerifyParams{
+			From:           "enex",
+			InputPath:      _FixturesDir + "/" + _StubENEXFile,
+			SNFilename:     outputFilename,
+			ReportFilename: reportFilename,
+			ReportFormat:   "json",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !out.Passed() || out.Matched != out.SourceNotes || out.Matched < 1 {
+			t.Errorf("expected verification to pass; got %+v", out)
 		}
-		actualItems := out.Items
-		for ind, member := range actualItems {
-			switch actual := member.(type) {
-			case *sn.Note:
-				knownIDs.Notes = append(knownIDs.Notes, actual.UUID)
-			case *sn.Tag:
-				knownIDs.Tags = append(knownIDs.Tags, actual.UUID)
-			default:
-				t.Fatalf("%d, unexpected type %T", ind, actual)
-			}
+		data, err := os.ReadFile(reportFilename)
+		if err != nil {
+			t.Fatal(err)
 		}
-
-		baseExpectedNotes := []ExpectedTestValues{
-			{ContentType: "Note", Title: "Batman", ExpectNoteText: true, References: []TestReference{{"Tag", 0}, {"Tag", 1}}},
-			{ContentType: "Note", Title: "Atlanta", ExpectNoteText: true, References: []TestReference{{"Tag", 0}}},
-			{ContentType: "Note", Title: "Hello World", ExpectNoteText: true, References: []TestReference{{"Tag", 0}, {"Tag", 1}}},
-			{ContentType: "Note", Title: "Chicago", References: []TestReference{{"Tag", 1}, {"Tag", 2}}},
-			{ContentType: "Note", Title: "Edmonton", References: []TestReference{{"Tag", 0}}},
-			{ContentType: "Note", Title: "Baltimore", References: []TestReference{{"Tag", 3}}},
-			{ContentType: "Note", Title: "Fargo", References: []TestReference{{"Tag", 3}}},
-			{ContentType: "Note", Title: "Despicable Me", References: []TestReference{{"Tag", 0}}},
-			{ContentType: "Note", Title: "Fargo", References: []TestReference{{"Tag", 0}}},
-			{ContentType: "Note", Title: "Enter The Dragon", References: []TestReference{{"Tag", 3}}},
-			{ContentType: "Note", Title: "Detroit", References: []TestReference{{"Tag", 3}}},
-			{ContentType: "Note", Title: "Casino", References: []TestReference{{"Tag", 3}}},
-			{ContentType: "Note", Title: "Aladdin", References: []TestReference{{"Tag", 3}}},
+		var report sn.Verification
+		if err = json.Unmarshal(data, &report); err != nil || report.Matched != out.Matched {
+			t.Errorf("wrong report; %v\n%s", err, data)
 		}
+	})
 
-		baseExpectedTags := []ExpectedTestValues{
-			{ContentType: "Tag", Title: "foo", References: []TestReference{{"Note", 0}, {"Note", 1}, {"Note", 2}, {"Note", 4}, {"Note", 7}, {"Note", 8}}},
-			{ContentType: "Tag", Title: "baker", References: []TestReference{{"Note", 0}, {"Note", 2}, {"Note", 3}}},
-			{ContentType: "Tag", Title: "free", References: []TestReference{{"Note", 3}}},
-			{ContentType: "Tag", Title: "bar", References: []TestReference{{"Note", 5}, {"Note", 6}, {"Note", 9}, {"Note", 10}, {"Note", 11}, {"Note", 12}}},
+	t.Run("fail", func(t *testing.T) {
+		// Only some of the source notes are expected, so the others are extra.
+		out, err := interactor.Verify(context.TODO(), interactor.VerifyParams{
+			From:           "enex",
+			InputPath:      _FixturesDir + "/" + _StubENEXFile,
+			SNFilename:     outputFilename,
+			Filter:         "tag:bar",
+			ReportFilename: pathToTestDir + "/fail.txt",
+		})
+		if !errors.Is(err, interactor.ErrVerify) {
+			t.Fatalf("expected %v; got %v", interactor.ErrVerify, err)
 		}
-		expectedOutput := make([]ExpectedTestValues, 0)
-		expectedOutput = append(expectedOutput, baseExpectedNotes...)
-		expectedOutput = append(expectedOutput, baseExpectedTags...)
-
-		if len(actualItems) != len(expectedOutput) {
-			t.Fatalf(
-				"wrong output length; got %d, expected  %d",
-				len(actualItems), len(expectedOutput),
-			)
+		if out.Passed() || len(out.Extra) != out.ConvertedNotes-out.SourceNotes {
+			t.Errorf("wrong verification; got %+v", out)
 		}
+	})
 
-		for ind, member := range actualItems {
-			switch actual := member.(type) {
-			case *sn.Note:
-				testSNItem(t, ind, &actual.Item, expectedOutput)
-				testSNNote(t, ind, actual, expectedOutput)
-				testSNReferences(t, ind, actual.Content.References, expectedOutput[ind].References, &knownIDs)
-			case *sn.Tag:
-				testSNItem(t, ind, &actual.Item, expectedOutput)
-				testSNReferences(t, ind, actual.Content.References, expectedOutput[ind].References, &knownIDs)
-			default:
-				t.Errorf("test %d; unexpected type %T", ind, actual)
-			}
+	t.Run("invalid format", func(t *testing.T) {
+		_, err := interactor.Verify(context.TODO(), interactor.VerifyParams{
+			From:       "sn",
+			InputPath:  outputFilename,
+			SNFilename: outputFilename,
+		})
+		if err == nil {
+			t.Error("expected an error")
 		}
 	})
 }
 
-// uuidMatcher helps us make sure we're at least trying to make a UUID. Pattern
-// lifted from: https://stackoverflow.com/a/13653180.
-var uuidMatcher = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-5][0-9a-f]{3}-[089ab][0-9a-f]{3}-[0-9a-f]{12}$`)
-
 func TestBackfill(t *testing.T) {
 	pathToTestDir := _BaseTestOutputDir + "/" + t.Name()
 	if err := os.MkdirAll(pathToTestDir, 0700); err != nil {
@@ -455,7 +1540,163 @@ func TestBackfill(t *testing.T) {
 		TagIDs    []string
 	}
 
+	t.Run("DryRun", func(t *testing.T) {
+		dir := t.TempDir()
+		params := interactor.BackfillParams{
+			EvernoteFilenames: struct{ Notebooks, Notes, Tags string }{
+				Notebooks: _FixturesDir + "/" + _StubNotebooksFile,
+				Notes:     _FixturesDir + "/" + _StubNotesFile,
+				Tags:      _FixturesDir + "/" + _StubTagsFile,
+			},
+			StandardNotesFilename: _FixturesDir + "/" + _StubENtoSNFile,
+			OutputFilenames: struct{ Notebooks, Notes, Tags string }{
+				Notes: filepath.Join(dir, "notes.json"),
+			},
+			LedgerFilename: filepath.Join(dir, "ledger.jsonl"),
+		}
+		params.DryRun = true
+		params.DiffFilename = filepath.Join(dir, "diff.txt")
+		notes, err := interactor.BackfillSN(context.TODO(), &params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, filename := range []string{params.OutputFilenames.Notes, params.LedgerFilename} {
+			if _, err = os.Stat(filename); !os.IsNotExist(err) {
+				t.Errorf("expected %q to not be written; got %v", filename, err)
+			}
+		}
+		data, err := os.ReadFile(params.DiffFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each backfilled note gains a reference to its notebook.
+		expected := fmt.Sprintf("0 to create, %d to change, 0 unchanged\n", len(notes))
+		if !strings.HasSuffix(string(data), expected) {
+			t.Errorf("wrong diff; expected it to end with %q\n%s", expected, data)
+		}
+	})
+
+	t.Run("Ledger", func(t *testing.T) {
+		dir := t.TempDir()
+		params := interactor.BackfillParams{
+			EvernoteFilenames: struct{ Notebooks, Notes, Tags string }{
+				Notebooks: _FixturesDir + "/" + _StubNotebooksFile,
+				Notes:     _FixturesDir + "/" + _StubNotesFile,
+				Tags:      _FixturesDir + "/" + _StubTagsFile,
+			},
+			StandardNotesFilename: _FixturesDir + "/" + _StubENtoSNFile,
+			OutputFilenames: struct{ Notebooks, Notes, Tags string }{
+				Notes: filepath.Join(dir, "notes.json"),
+			},
+			LedgerFilename: filepath.Join(dir, "ledger.jsonl"),
+		}
+		notes, err := interactor.BackfillSN(context.TODO(), &params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notes) < 1 {
+			t.Fatal("expected some notes to be backfilled")
+		}
+		numBackfilled := len(notes)
+
+		// Again, the notes are unchanged.
+		if notes, err = interactor.BackfillSN(context.TODO(), &params); err != nil {
+			t.Fatal(err)
+		}
+		if len(notes) != 0 {
+			t.Errorf("expected unchanged notes to be left out; got %d", len(notes))
+		}
+
+		// Another collision policy changes how notes are backfilled.
+		params.CollisionPolicy = "merge"
+		if notes, err = interactor.BackfillSN(context.TODO(), &params); err != nil {
+			t.Fatal(err)
+		}
+		if len(notes) != numBackfilled {
+			t.Errorf("wrong number of notes with another policy; got %d, expected %d", len(notes), numBackfilled)
+		}
+	})
+
+	t.Run("Collisions", func(t *testing.T) {
+		const (
+			citiesNotebookID = "cdb30948-fd4b-4f0f-88e8-68f0ed9e5a09"
+			barTagID         = "30cf9510-845d-4ea8-b673-51104a3e0bc2"
+		)
+		dir := t.TempDir()
+		// Rename a tag so that it has the same name as a notebook.
+		data, err := os.ReadFile(_FixturesDir + "/" + _StubENtoSNFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snFilename := filepath.Join(dir, "sn.json")
+		data = []byte(strings.Replace(string(data), `"title": "bar"`, `"title": "Cities"`, 1))
+		if err = os.WriteFile(snFilename, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		newParams := func(policy string) *interactor.BackfillParams {
+			params := interactor.BackfillParams{
+				EvernoteFilenames: struct{ Notebooks, Notes, Tags string }{
+					Notebooks: _FixturesDir + "/" + _StubNotebooksFile,
+					Notes:     _FixturesDir + "/" + _StubNotesFile,
+					Tags:      _FixturesDir + "/" + _StubTagsFile,
+				},
+				StandardNotesFilename: snFilename,
+				CollisionPolicy:       policy,
+			}
+			params.OutputFilenames.Notes = filepath.Join(dir, policy+"_notes.json")
+			params.OutputFilenames.Notebooks = filepath.Join(dir, policy+"_notebooks.json")
+			return &params
+		}
+
+		params := newParams("merge")
+		notes, err := interactor.BackfillSN(context.TODO(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var merged int
+		for _, item := range notes {
+			note := item.(*interactor.FromENToSN).LinkID.(*sn.Note)
+			for _, ref := range note.Content.References {
+				if ref.UUID == citiesNotebookID {
+					t.Errorf("expected note %q to refer to the tag instead of the notebook", note.Content.Title)
+				} else if ref.UUID == barTagID {
+					merged++
+				}
+			}
+		}
+		if merged < 1 {
+			t.Error("expected notes of the notebook to refer to the tag")
+		}
+
+		params = newParams("nest")
+		if _, err = interactor.BackfillSN(context.TODO(), params); err != nil {
+			t.Fatal(err)
+		}
+		if data, err = os.ReadFile(params.OutputFilenames.Notebooks); err != nil {
+			t.Fatal(err)
+		}
+		var tags []sn.Tag
+		if err = json.Unmarshal(data, &tags); err != nil {
+			t.Fatal(err)
+		}
+		if len(tags) != 5 || tags[0].Content.Title != sn.NotebooksTagTitle {
+			t.Fatalf("expected parent tag and a tag for each notebook; got %d tags", len(tags))
+		}
+		for _, tag := range tags[1:] {
+			refs := tag.Content.References
+			nested := len(refs) > 0 && refs[len(refs)-1].ReferenceType == sn.ReferenceTypeParentTag
+			if expected := tag.UUID == citiesNotebookID; nested != expected {
+				t.Errorf("tag %q; wrong nesting; got %t, expected %t", tag.Content.Title, nested, expected)
+			}
+		}
+
+		if _, err = interactor.BackfillSN(context.TODO(), newParams("nope")); err == nil {
+			t.Error("expected an error for an invalid collision policy")
+		}
+	})
+
 	t.Run("BackfillSN", func(t *testing.T) {
+		ledgerFilename := filepath.Join(t.TempDir(), "ledger.jsonl")
 		var (
 			actualOutput []entity.LinkID
 			err          error
@@ -475,11 +1716,24 @@ func TestBackfill(t *testing.T) {
 				OutputFilenames: struct{ Notebooks, Notes, Tags string }{
 					Notes: pathToTestDir + "/all_the_things.json",
 				},
+				LedgerFilename: ledgerFilename,
 			},
 		)
 		if err != nil {
 			t.Fatal(err)
 		}
+		book, err := ledger.Open(ledgerFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer book.Close()
+		for i, item := range actualOutput {
+			conv := item.(*interactor.FromENToSN)
+			entry, ok := book.Lookup(ledger.Key{SourceService: edam.Service, ContentType: "Note", SourceID: conv.EvernoteID.GetID(), DestService: sn.Service})
+			if !ok || entry.DestID != mustSNNote(item).UUID {
+				t.Errorf("item[%d]; wrong ledger entry; got %+v, %t", i, entry, ok)
+			}
+		}
 
 		sort.Slice(actualOutput, func(i, j int) bool {
 			left, right := mustSNNote(actualOutput[i]), mustSNNote(actualOutput[j])
@@ -702,3 +1956,324 @@ func mustPrettyPrintJSON(in interface{}) {
 	}
 	fmt.Printf("%+v\n", string(out))
 }
+
+func TestPushEDAM(t *testing.T) {
+	ctx := context.Background()
+
+	// setup starts a fake Evernote service with one notebook of the user's
+	// own and one business notebook.
+	setup := func(t *testing.T) (*fake.Fixtures, *edam.Client) {
+		t.Helper()
+		const token = "push-token"
+		t.Setenv("EVERNOTE_SANDBOX_TOKEN", token)
+		fixtures := &fake.Fixtures{
+			Notebooks: []*entity.Notebook{{ID: "nb-default", Name: "Default"}},
+			Tags:      []*entity.Tag{{ID: "tag-foo", Name: "foo"}},
+			Linked: []*fake.LinkedFixtures{{
+				ShareName: "Team Notes",
+				Username:  "acme",
+				Notebook:  &entity.Notebook{ID: "nb-team", Name: "Team"},
+			}},
+		}
+		srv := httptest.NewServer(fake.NewServer(fixtures, token))
+		t.Cleanup(srv.Close)
+		return fixtures, edam.NewClient(edam.CredentialsConfig{BaseURL: srv.URL})
+	}
+
+	t.Run("sn", func(t *testing.T) {
+		fixtures, client := setup(t)
+		opts := interactor.PushParams{
+			EDAMClient:      client,
+			InputFilename:   _FixturesDir + "/" + _StubENtoSNFile,
+			InputFormat:     "sn",
+			Notebook:        "Imported",
+			MappingFilename: filepath.Join(t.TempDir(), "mapping.json"),
+		}
+		result, err := interactor.PushEDAM(ctx, &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		const numNotes, numTags = 13, 5
+		// one of the tags already exists.
+		expected := interactor.PushResult{NotesCreated: numNotes, TagsCreated: numTags - 1}
+		if *result != expected {
+			t.Errorf("wrong result; got %+v, expected %+v", *result, expected)
+		}
+		if len(fixtures.Notebooks) != 2 || fixtures.Notebooks[1].Name != "Imported" {
+			t.Fatalf("expected notebook to be created; got %v", fixtures.Notebooks)
+		}
+		if len(fixtures.Tags) != numTags {
+			t.Errorf("expected existing tag to be reused; got %d tags", len(fixtures.Tags))
+		}
+		for _, note := range fixtures.Notes {
+			if note.NotebookID != fixtures.Notebooks[1].ID {
+				t.Errorf("note %q in wrong notebook %q", note.Title, note.NotebookID)
+			}
+		}
+
+		// Again, nothing is created.
+		result, err = interactor.PushEDAM(ctx, &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = interactor.PushResult{NotesSkipped: numNotes}
+		if *result != expected {
+			t.Errorf("wrong result on rerun; got %+v, expected %+v", *result, expected)
+		}
+		if len(fixtures.Notes) != numNotes {
+			t.Errorf("expected no duplicate notes; got %d", len(fixtures.Notes))
+		}
+
+		// The mapping is of the first account, so everything is created in
+		// another one.
+		other, otherClient := setup(t)
+		other.UserID = 2
+		opts.EDAMClient = otherClient
+		if result, err = interactor.PushEDAM(ctx, &opts); err != nil {
+			t.Fatal(err)
+		}
+		expected = interactor.PushResult{NotesCreated: numNotes, TagsCreated: numTags - 1}
+		if *result != expected {
+			t.Errorf("wrong result for other account; got %+v, expected %+v", *result, expected)
+		}
+		if len(other.Notes) != numNotes || len(fixtures.Notes) != numNotes {
+			t.Errorf("expected notes in each account; got %d, %d", len(fixtures.Notes), len(other.Notes))
+		}
+	})
+
+	t.Run("sn notebooks", func(t *testing.T) {
+		fixtures, client := setup(t)
+		input := filepath.Join(t.TempDir(), "sn.json")
+		data := `{"items": [
+			{"uuid": "note-a", "content_type": "Note", "content": {"title": "A", "text": "- [x] milk\n- [ ] eggs\n\nsee **this**"}},
+			{"uuid": "note-b", "content_type": "Note", "content": {"title": "B", "text": "<div>old</div>",
+				"appData": {"org.standardnotes.sn": {"archived": true}}}},
+			{"uuid": "note-c", "content_type": "Note", "content": {"title": "C", "text": "<p class=\"x\">hi<script>x</script></p>"}},
+			{"uuid": "tag-work", "content_type": "Notebook", "content": {"title": "Notebook: Default",
+				"references": [{"uuid": "note-a", "content_type": "Note"}, {"uuid": "note-b", "content_type": "Note"}],
+				"appData": {"evernote.com": {"original_content_type": "Notebook"}}}},
+			{"uuid": "tag-foo", "content_type": "Tag", "content": {"title": "foo",
+				"references": [{"uuid": "note-a", "content_type": "Note"}, {"uuid": "note-c", "content_type": "Note"}]}}
+		]}`
+		if err := os.WriteFile(input, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		opts := interactor.PushParams{
+			EDAMClient:      client,
+			InputFilename:   input,
+			InputFormat:     "sn",
+			Notebook:        "Imported",
+			MappingFilename: filepath.Join(t.TempDir(), "mapping.json"),
+		}
+		result, err := interactor.PushEDAM(ctx, &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the archived note is left out, the notebook tag isn't a tag.
+		expected := interactor.PushResult{NotesCreated: 2}
+		if *result != expected {
+			t.Errorf("wrong result; got %+v, expected %+v", *result, expected)
+		}
+		if len(fixtures.Notebooks) != 2 || fixtures.Notebooks[1].Name != "Imported" {
+			t.Fatalf("expected only the Imported notebook to be created; got %v", fixtures.Notebooks)
+		}
+		notebookIDs := map[string]string{"A": "nb-default", "C": fixtures.Notebooks[1].ID}
+		for _, note := range fixtures.Notes {
+			if note.NotebookID != notebookIDs[note.Title] {
+				t.Errorf("note %q in wrong notebook %q", note.Title, note.NotebookID)
+			}
+			if len(note.TagIDs) != 1 || note.TagIDs[0] != "tag-foo" {
+				t.Errorf("note %q; wrong tags %q", note.Title, note.TagIDs)
+			}
+			switch note.Title {
+			case "A":
+				if !strings.Contains(note.Content, `<en-todo checked="true"></en-todo>milk`) || !strings.Contains(note.Content, "<b>this</b>") {
+					t.Errorf("expected Markdown to be converted; got %s", note.Content)
+				}
+			case "C":
+				if !strings.Contains(note.Content, "<en-note><p>hi</p></en-note>") {
+					t.Errorf("expected HTML to be converted; got %s", note.Content)
+				}
+			}
+		}
+
+		opts.NoteText = "nope"
+		if _, err = interactor.PushEDAM(ctx, &opts); err == nil {
+			t.Error("expected an error for an invalid note text format")
+		}
+	})
+
+	t.Run("enex", func(t *testing.T) {
+		fixtures, client := setup(t)
+		opts := interactor.PushParams{
+			EDAMClient:      client,
+			InputFilename:   _FixturesDir + "/" + _StubENEXAttachmentsFile,
+			InputFormat:     "enex",
+			Notebook:        "team notes",
+			MappingFilename: filepath.Join(t.TempDir(), "mapping.json"),
+		}
+		if _, err := interactor.PushEDAM(ctx, &opts); err != nil {
+			t.Fatal(err)
+		}
+		notes := fixtures.Linked[0].Notes
+		if len(notes) != 2 {
+			t.Fatalf("expected notes in the linked notebook; got %d", len(notes))
+		}
+		if len(fixtures.Notes) != 0 {
+			t.Errorf("expected no notes in own account; got %d", len(fixtures.Notes))
+		}
+		for _, note := range notes {
+			if len(note.Attachments) != 1 {
+				t.Errorf("note %q; expected 1 attachment, got %d", note.Title, len(note.Attachments))
+			}
+			if count := strings.Count(note.Content, "<en-media"); count != 1 {
+				t.Errorf("note %q; expected 1 en-media element, got %d; %s", note.Title, count, note.Content)
+			}
+		}
+	})
+}
+
+func TestFetchAllEDAM(t *testing.T) {
+	const token = "bundle-token"
+	ctx := context.Background()
+
+	fixtures, err := fake.LoadFixtures(_FixturesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachment := &entity.Attachment{Filename: "hello.txt", MIME: "text/plain", Data: []byte("hello")}
+	// The same file in two notes is only written once.
+	fixtures.Notes[0].Attachments = []*entity.Attachment{attachment}
+	fixtures.Notes[1].Attachments = []*entity.Attachment{attachment}
+	t.Setenv("EVERNOTE_SANDBOX_TOKEN", token)
+	srv := httptest.NewServer(fake.NewServer(fixtures, token))
+	defer srv.Close()
+
+	dir := filepath.Join(t.TempDir(), "bundle")
+	opts := interactor.FetchAllParams{
+		EDAMClient: edam.NewClient(edam.CredentialsConfig{BaseURL: srv.URL}),
+		OutDir:     dir,
+		PageSize:   5,
+	}
+	manifest, err := interactor.FetchAllEDAM(ctx, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("manifest", func(t *testing.T) {
+		read, err := interactor.ReadBundleManifest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read.Version != interactor.BundleVersion {
+			t.Errorf("wrong version; got %d, expected %d", read.Version, interactor.BundleVersion)
+		}
+		if read.Account == nil || read.Account.Username != fake.Username {
+			t.Errorf("wrong account; got %+v", read.Account)
+		}
+		if read.HighestUSN != manifest.HighestUSN || read.HighestUSN < 1 {
+			t.Errorf("wrong highest USN; got %d, returned %d", read.HighestUSN, manifest.HighestUSN)
+		}
+		if read.FetchedAt.IsZero() {
+			t.Error("expected a fetch time")
+		}
+		counts := read.Counts
+		expected := counts
+		expected.Notebooks, expected.Notes, expected.Tags = len(fixtures.Notebooks), len(fixtures.Notes), len(fixtures.Tags)
+		expected.SavedSearches, expected.Attachments = len(fixtures.SavedSearches), 1
+		if counts != expected {
+			t.Errorf("wrong counts; got %+v, expected %+v", counts, expected)
+		}
+	})
+
+	t.Run("attachments", func(t *testing.T) {
+		const hash = "5d41402abc4b2a76b9719d911017c592" // md5 of "hello"
+		data, err := os.ReadFile(filepath.Join(dir, interactor.BundleAttachmentsDir, hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "hello" {
+			t.Errorf("wrong attachment data; got %q", data)
+		}
+		repository, _ := edam.NewNotesRepo(nil, nil)
+		file, err := os.Open(filepath.Join(dir, interactor.BundleNotesFilename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		notes, err := repository.ReadLocal(ctx, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var numAttachments int
+		for _, item := range notes {
+			for _, att := range item.(*edam.Note).Attachments {
+				numAttachments++
+				if att.Hash != hash || att.Data != nil || att.Filename != "hello.txt" {
+					t.Errorf("wrong attachment in notes file; got %+v", att)
+				}
+			}
+		}
+		if numAttachments != 2 {
+			t.Errorf("wrong number of attachments in notes file; got %d, expected %d", numAttachments, 2)
+		}
+	})
+
+	t.Run("convert", func(t *testing.T) {
+		out, err := interactor.Convert(ctx, interactor.ConvertParams{
+			To:             "sn",
+			InputBundleDir: dir,
+			OutputFilename: filepath.Join(t.TempDir(), "sn.json"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var numNotes, numViews int
+		for _, item := range out.Items {
+			switch item.(type) {
+			case *sn.Note:
+				numNotes++
+			case *sn.SmartView:
+				numViews++
+			}
+		}
+		if numNotes != len(fixtures.Notes) {
+			t.Errorf("wrong number of notes; got %d, expected %d", numNotes, len(fixtures.Notes))
+		}
+		if numViews+len(out.UntranslatedSearches) != len(fixtures.SavedSearches) {
+			t.Errorf("expected saved searches to be read from bundle")
+		}
+	})
+
+	t.Run("backfill", func(t *testing.T) {
+		outDir := t.TempDir()
+		out, err := interactor.BackfillSN(ctx, &interactor.BackfillParams{
+			EvernoteBundleDir:     dir,
+			StandardNotesFilename: _FixturesDir + "/" + _StubENtoSNFile,
+			OutputFilenames: struct{ Notebooks, Notes, Tags string }{
+				Notebooks: filepath.Join(outDir, "notebooks.json"),
+				Notes:     filepath.Join(outDir, "notes.json"),
+				Tags:      filepath.Join(outDir, "tags.json"),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) < 1 {
+			t.Error("expected some output")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := interactor.FetchAllEDAM(ctx, &opts); err == nil {
+			t.Error("expected an error for an existing bundle")
+		}
+		if _, err := interactor.ReadBundleManifest(t.TempDir()); err == nil {
+			t.Error("expected an error for a directory without a manifest")
+		}
+		_, err := interactor.Convert(ctx, interactor.ConvertParams{To: "sn", InputBundleDir: t.TempDir()})
+		if err == nil {
+			t.Error("expected an error for a directory without a manifest")
+		}
+	})
+}
@@ -0,0 +1,411 @@
+package interactor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/log"
+	"github.com/rafaelespinoza/notexfr/internal/repo/edam"
+	"github.com/rafaelespinoza/notexfr/internal/repo/enex"
+	"github.com/rafaelespinoza/notexfr/internal/repo/sn"
+)
+
+// PushParams is a set of named arguments for creating notes in Evernote.
+type PushParams struct {
+	// EDAMClient is the Evernote account to create notes in.
+	EDAMClient *edam.Client
+	// InputFilename is a StandardNotes file, such as the output of a
+	// conversion, or an Evernote export file.
+	InputFilename string
+	// InputFormat is one of PushInputFormats.
+	InputFormat string
+	// NoteText is the format of the text of StandardNotes notes, one of
+	// PushNoteTextFormats. With "auto", the default, it's guessed for each
+	// note.
+	NoteText string
+	// Notebook is the name of the notebook to put notes into, unless they're
+	// in a notebook of the input, such as a notebook tag of a StandardNotes
+	// file. Either way, a notebook is created if it doesn't exist. When empty,
+	// the default notebook is used.
+	Notebook string
+	// MappingFilename is where to keep track of what's been created, so that
+	// rerunning with the same input doesn't create duplicates. It's created
+	// if it doesn't exist.
+	MappingFilename string
+	Timeout         time.Duration
+}
+
+// PushInputFormats are the accepted values of PushParams.InputFormat.
+var PushInputFormats = []string{"sn", "enex"}
+
+// PushNoteTextFormats are the accepted values of PushParams.NoteText, which
+// are "auto" and the formats that a conversion to StandardNotes may write.
+var PushNoteTextFormats = append([]string{"auto"}, sn.NoteTextFormats...)
+
+// PushResult summarizes a push.
+type PushResult struct {
+	NotesCreated, NotesSkipped, TagsCreated int
+}
+
+// pushMapping relates items from the input to the GUIDs of what was created
+// for them in one notebook of an Evernote account. Tags are keyed by name.
+// Notes are keyed by UUID when the input has one, otherwise by a combination
+// of creation time and title. The file is JSON lines, one per pushMappingEntry,
+// which is appended to as items are created. Entries of other accounts or
+// notebooks are left as they are, so one file may be used for several.
+type pushMapping struct {
+	file              *os.File
+	account, notebook string
+	Tags, Notes       map[string]string
+}
+
+// pushMappingEntry is a line of a mapping file.
+type pushMappingEntry struct {
+	// Account identifies the Evernote account, by service environment and
+	// user ID, such as "sandbox:1".
+	Account  string `json:"account"`
+	Notebook string `json:"notebook_guid"`
+	// Type is "note" or "tag".
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	GUID string `json:"guid"`
+}
+
+// pushNote is a note from the input, ready to be created.
+type pushNote struct {
+	key  string
+	note *entity.Note
+	tags []string
+	// notebook is the name of its notebook in the input, if it has one.
+	notebook string
+}
+
+// PushEDAM creates notes and their tags in an Evernote account from a file of
+// notes from elsewhere. It's idempotent through a mapping file. Each note and
+// tag is added to the mapping as soon as it's created, so an interrupted push
+// can be rerun.
+func PushEDAM(ctx context.Context, opts *PushParams) (out *PushResult, err error) {
+	if opts.MappingFilename == "" {
+		err = fmt.Errorf("mapping filename is required")
+		return
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var notes []*pushNote
+	switch opts.InputFormat {
+	case "sn":
+		notes, err = readSNForPush(ctx, opts.InputFilename, opts.NoteText)
+	case "enex":
+		notes, err = readENEXForPush(ctx, opts.InputFilename)
+	default:
+		err = fmt.Errorf("invalid input format %q, should be one of %q", opts.InputFormat, PushInputFormats)
+	}
+	if err != nil {
+		return
+	}
+
+	out = &PushResult{}
+	writer := edam.NewWriter(opts.EDAMClient)
+	account, err := opts.EDAMClient.Account(ctx)
+	if err != nil {
+		return
+	}
+	// Notes are pushed a notebook at a time, in the order that each notebook
+	// first comes up in the input.
+	var notebooks []string
+	notesByNotebook := make(map[string][]*pushNote)
+	for _, item := range notes {
+		name := item.notebook
+		if name == "" {
+			name = opts.Notebook
+		}
+		if _, ok := notesByNotebook[name]; !ok {
+			notebooks = append(notebooks, name)
+		}
+		notesByNotebook[name] = append(notesByNotebook[name], item)
+	}
+	for _, name := range notebooks {
+		var notebookGUID string
+		if notebookGUID, err = writer.UseNotebook(ctx, name); err != nil {
+			return
+		}
+		var mapping *pushMapping
+		mapping, err = openPushMapping(
+			opts.MappingFilename,
+			fmt.Sprintf("%s:%d", account.ServiceEnv, account.UserID),
+			notebookGUID,
+		)
+		if err != nil {
+			return
+		}
+		log.Info(ctx, map[string]any{"notebook": name, "notebook_guid": notebookGUID, "num_notes": len(notesByNotebook[name])}, "pushing notes")
+		err = pushNotes(ctx, writer, mapping, notesByNotebook[name], out)
+		if cerr := mapping.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return
+		}
+	}
+	log.Info(ctx, map[string]any{
+		"notes_created": out.NotesCreated,
+		"notes_skipped": out.NotesSkipped,
+		"tags_created":  out.TagsCreated,
+	}, "pushed notes")
+	return
+}
+
+// pushNotes creates the notes, and their tags, in the notebook selected by the
+// writer, unless they're already in the mapping.
+func pushNotes(ctx context.Context, writer *edam.Writer, mapping *pushMapping, notes []*pushNote, out *PushResult) (err error) {
+	for _, item := range notes {
+		if _, ok := mapping.Notes[item.key]; ok {
+			out.NotesSkipped++
+			continue
+		}
+		tagGUIDs := make([]string, len(item.tags))
+		for i, name := range item.tags {
+			guid, ok := mapping.Tags[name]
+			if !ok {
+				var created bool
+				if guid, created, err = writer.EnsureTag(ctx, name); err != nil {
+					return
+				}
+				if err = mapping.add("tag", name, guid); err != nil {
+					return
+				}
+				if created {
+					out.TagsCreated++
+				}
+			}
+			tagGUIDs[i] = guid
+		}
+		var guid string
+		if guid, err = writer.CreateNote(ctx, item.note, tagGUIDs); err != nil {
+			return
+		}
+		out.NotesCreated++
+		if err = mapping.add("note", item.key, guid); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readSNForPush reads StandardNotes notes and tags. A note is tagged if it
+// references the tag, or if the tag references the note. A tag that was
+// converted from a notebook is the note's notebook instead. The text of each
+// note is converted to ENML from the textFormat, one of PushNoteTextFormats.
+// Archived notes, such as earlier revisions of notes, are left out.
+func readSNForPush(ctx context.Context, filename, textFormat string) (out []*pushNote, err error) {
+	if textFormat == "" {
+		textFormat = PushNoteTextFormats[0]
+	} else if !slices.Contains(PushNoteTextFormats, textFormat) {
+		err = fmt.Errorf("invalid note text format %q, should be one of %q", textFormat, PushNoteTextFormats)
+		return
+	}
+	notes, tags, err := sn.ReadConversionFile(filename)
+	if err != nil {
+		return
+	}
+	tagsByID := make(map[string]*sn.Tag, len(tags))
+	tagsByNote := make(map[string][]*sn.Tag)
+	for _, item := range tags {
+		tag := item.(*sn.Tag)
+		tagsByID[tag.UUID] = tag
+		for _, ref := range tag.Content.References {
+			if ref.ContentType == sn.ContentTypeNote {
+				tagsByNote[ref.UUID] = append(tagsByNote[ref.UUID], tag)
+			}
+		}
+	}
+
+	var archived int
+	out = make([]*pushNote, 0, len(notes))
+	for _, item := range notes {
+		note := item.(*sn.Note)
+		if sn.IsArchived(note) {
+			archived++
+			continue
+		}
+		noteTags := tagsByNote[note.UUID]
+		for _, ref := range note.Content.References {
+			if tag, ok := tagsByID[ref.UUID]; ok && ref.ContentType == sn.ContentTypeTag {
+				noteTags = append(noteTags, tag)
+			}
+		}
+		var content string
+		if content, err = pushContent(note.Content.Text, textFormat); err != nil {
+			err = fmt.Errorf("%w; note %q", err, note.UUID)
+			return
+		}
+		push := &pushNote{
+			key: note.UUID,
+			note: &entity.Note{
+				Title:     note.Content.Title,
+				Content:   content,
+				CreatedAt: note.CreatedAt,
+				UpdatedAt: note.UpdatedAt,
+			},
+		}
+		var names []string
+		for _, tag := range noteTags {
+			if !sn.IsNotebook(tag) {
+				names = append(names, tag.Content.Title)
+			} else if push.notebook == "" {
+				push.notebook = sn.NotebookName(tag)
+			}
+		}
+		push.tags = dedupe(names)
+		out = append(out, push)
+	}
+	if archived > 0 {
+		log.Info(ctx, map[string]any{"num_notes": archived}, "left out archived notes")
+	}
+	return
+}
+
+var (
+	htmlPattern     = regexp.MustCompile(`(?i)<(p|div|br|span|a|b|i|u|em|strong|ul|ol|li|h[1-6]|pre|code|blockquote|table|hr|img)[\s/>]`)
+	markdownPattern = regexp.MustCompile(`(?m)^ {0,3}(#{1,6} |[-*+] |\d{1,9}\. |> |` + "```" + `)|\*\*\S|\[[^\]]+\]\([^)\s]+\)`)
+)
+
+// pushContent converts the text of a note to ENML. With the "auto" format,
+// text that's already ENML is kept, and otherwise it's treated as HTML if it
+// has HTML elements, or as Markdown if it has Markdown syntax that's unlikely
+// to be in plain text.
+func pushContent(text, format string) (string, error) {
+	if format == "auto" {
+		switch {
+		case enml.IsENML(text):
+			return text, nil
+		case htmlPattern.MatchString(text):
+			format = "html"
+		case markdownPattern.MatchString(text):
+			format = "markdown"
+		default:
+			format = "text"
+		}
+	}
+	switch format {
+	case "html":
+		return enml.FromHTML(text)
+	case "markdown":
+		return enml.FromMarkdown(text)
+	}
+	return enml.FromText(text), nil
+}
+
+// readENEXForPush reads notes from an Evernote export file. The content is
+// already ENML and attachments are kept.
+func readENEXForPush(ctx context.Context, filename string) (out []*pushNote, err error) {
+	resources, err := readLocalFile(ctx, &enex.File{Attachments: true}, filename)
+	if err != nil {
+		return
+	}
+	out = make([]*pushNote, len(resources))
+	for i, item := range resources {
+		note := item.(*enex.Note)
+		out[i] = &pushNote{
+			key:  "enex|" + strings.Join(note.LinkValues(), "|"),
+			note: note.Note,
+			tags: dedupe(note.Tags),
+		}
+	}
+	return
+}
+
+func dedupe(names []string) (out []string) {
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return
+}
+
+// openPushMapping reads the entries of an account and notebook from a mapping
+// file, creating it if it doesn't exist. Call Close when done.
+func openPushMapping(filename, account, notebook string) (out *pushMapping, err error) {
+	file, err := os.OpenFile(filepath.Clean(filename), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	out = &pushMapping{
+		file:     file,
+		account:  account,
+		notebook: notebook,
+		Tags:     make(map[string]string),
+		Notes:    make(map[string]string),
+	}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry pushMappingEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			err = fmt.Errorf("invalid mapping file %q, line %d; %w", filename, line, err)
+			break
+		}
+		if entry.Account != account || entry.Notebook != notebook {
+			continue
+		}
+		switch entry.Type {
+		case "tag":
+			out.Tags[entry.Key] = entry.GUID
+		case "note":
+			out.Notes[entry.Key] = entry.GUID
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	if err != nil {
+		_ = file.Close()
+		out = nil
+	}
+	return
+}
+
+// add keeps track of a created item, and appends it to the file.
+func (m *pushMapping) add(itemType, key, guid string) (err error) {
+	data, err := json.Marshal(pushMappingEntry{
+		Account:  m.account,
+		Notebook: m.notebook,
+		Type:     itemType,
+		Key:      key,
+		GUID:     guid,
+	})
+	if err != nil {
+		return
+	}
+	if _, err = m.file.Write(append(data, '\n')); err != nil {
+		return
+	}
+	if itemType == "tag" {
+		m.Tags[key] = guid
+	} else {
+		m.Notes[key] = guid
+	}
+	return
+}
+
+// Close closes the file.
+func (m *pushMapping) Close() error { return m.file.Close() }
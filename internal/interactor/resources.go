@@ -2,13 +2,17 @@ package interactor
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/rafaelespinoza/notexfr/internal/entity"
 	"github.com/rafaelespinoza/notexfr/internal/log"
+	"github.com/rafaelespinoza/notexfr/internal/progress"
 	"github.com/rafaelespinoza/notexfr/internal/repo"
 	"github.com/rafaelespinoza/notexfr/internal/repo/edam"
 	"github.com/rafaelespinoza/notexfr/internal/repo/enex"
@@ -17,10 +21,90 @@ import (
 // FetchWriteParams is a set of named arguments for fetching remote resources
 // and/or writing results to a local file.
 type FetchWriteParams struct {
+	// EDAMClient is the Evernote account to fetch from.
+	EDAMClient       *edam.Client
 	InputFilename    string
 	OutputFilename   string
 	Timeout          time.Duration
 	NotesQueryParams *edam.NotesRemoteQueryParams
+	// IncludeLinked says whether to also fetch Notebooks, Tags from notebooks
+	// owned by other accounts. For Notes, see NotesQueryParams.
+	IncludeLinked bool
+	// Filter selects notes from an export file, see entity.Filter. An export
+	// file has no notebooks, so only the other terms apply.
+	Filter string
+	KeepGoingParams
+}
+
+// ErrSkipped means that notes were skipped, see KeepGoingParams.
+var ErrSkipped = errors.New("notes were skipped")
+
+// KeepGoingParams are for skipping notes that fail, rather than stopping at
+// the first one.
+type KeepGoingParams struct {
+	// KeepGoing means that a note that can't be fetched, read or converted is
+	// skipped and logged, see entity.NoteError. Everything else is done as
+	// usual, then the error is ErrSkipped if any notes were skipped.
+	KeepGoing bool
+	// ErrorReportFilename, if set, is where the skipped notes are written, as
+	// a JSON array of objects with the id, title, stage and error of each.
+	ErrorReportFilename string
+}
+
+// An errorReport collects the notes skipped with KeepGoingParams.
+type errorReport struct {
+	params KeepGoingParams
+	notes  []*entity.NoteError
+}
+
+// skip tells whether to go on after err, in which case it's added to the
+// report.
+func (r *errorReport) skip(ctx context.Context, err error) bool {
+	var nerr *entity.NoteError
+	if !r.params.KeepGoing || !errors.As(err, &nerr) {
+		return false
+	}
+	log.Warn(ctx, map[string]any{
+		"id":    nerr.ID,
+		"title": nerr.Title,
+		"stage": nerr.Stage,
+		"error": nerr.Err.Error(),
+	}, "skipped note")
+	progress.Skip(nerr.Stage, "Note", 1)
+	r.notes = append(r.notes, nerr)
+	return true
+}
+
+// skipErrors leaves out the errors of a sequence that are skipped.
+func (r *errorReport) skipErrors(ctx context.Context, seq iter.Seq2[entity.LinkID, error]) iter.Seq2[entity.LinkID, error] {
+	return func(yield func(entity.LinkID, error) bool) {
+		for item, err := range seq {
+			if err != nil && r.skip(ctx, err) {
+				continue
+			}
+			if !yield(item, err) {
+				return
+			}
+		}
+	}
+}
+
+// close writes the report, if there's a filename. The output is err, unless
+// it's nil and notes were skipped, in which case it's ErrSkipped.
+func (r *errorReport) close(err error) error {
+	if r.params.ErrorReportFilename != "" {
+		notes := r.notes
+		if notes == nil {
+			notes = make([]*entity.NoteError, 0)
+		}
+		if werr := writeResources(notes, r.params.ErrorReportFilename, "error report"); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	if err == nil && len(r.notes) > 0 {
+		err = fmt.Errorf("%w; %d of them", ErrSkipped, len(r.notes))
+	}
+	return err
 }
 
 // FetchWriteNotebooks gets Notebooks from your Evernote account and writes the
@@ -29,7 +113,7 @@ func FetchWriteNotebooks(ctx context.Context, opts *FetchWriteParams) (err error
 	var repository entity.LocalRemoteRepo
 	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
-	if repository, err = edam.NewNotebooksRepo(); err != nil {
+	if repository, err = edam.NewNotebooksRepo(opts.EDAMClient, &edam.NotebooksRemoteQueryParams{IncludeLinked: opts.IncludeLinked}); err != nil {
 		return
 	}
 	err = fetchWriteResource(ctx, repository, opts, "Notebooks")
@@ -42,7 +126,7 @@ func FetchWriteTags(ctx context.Context, opts *FetchWriteParams) (err error) {
 	var repository entity.LocalRemoteRepo
 	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
-	if repository, err = edam.NewTagsRepo(); err != nil {
+	if repository, err = edam.NewTagsRepo(opts.EDAMClient, &edam.TagsRemoteQueryParams{IncludeLinked: opts.IncludeLinked}); err != nil {
 		return
 	}
 	err = fetchWriteResource(ctx, repository, opts, "Tags")
@@ -55,30 +139,74 @@ func FetchWriteNotes(ctx context.Context, opts *FetchWriteParams) (err error) {
 	var repository entity.LocalRemoteRepo
 	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
-	if repository, err = edam.NewNotesRepo(opts.NotesQueryParams); err != nil {
+	if repository, err = edam.NewNotesRepo(opts.EDAMClient, opts.NotesQueryParams); err != nil {
 		return
 	}
 	err = fetchWriteResource(ctx, repository, opts, "Notes")
 	return
 }
 
-// WriteENEXToJSON converts an Evernote export file to JSON.
-func WriteENEXToJSON(ctx context.Context, opts *FetchWriteParams) (err error) {
-	var repository entity.RepoLocal
-	var resources []entity.LinkID
+// FetchWriteSavedSearches gets SavedSearches from your Evernote account and
+// writes the results to a local JSON file.
+func FetchWriteSavedSearches(ctx context.Context, opts *FetchWriteParams) (err error) {
+	var repository entity.LocalRemoteRepo
 	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
-	if repository, err = enex.NewFileRepo(); err != nil {
+	if repository, err = edam.NewSavedSearchesRepo(opts.EDAMClient); err != nil {
 		return
 	}
-	if resources, err = readLocalFile(ctx, repository, opts.InputFilename); err != nil {
+	err = fetchWriteResource(ctx, repository, opts, "SavedSearches")
+	return
+}
+
+// WriteENEXToJSON converts an Evernote export file to JSON. Notes are read and
+// written one at a time.
+func WriteENEXToJSON(ctx context.Context, opts *FetchWriteParams) (err error) {
+	filter, err := entity.ParseFilter(opts.Filter)
+	if err != nil {
 		return
 	}
-	err = writeResources(resources, opts.OutputFilename, "ENEX export items")
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	report := errorReport{params: opts.KeepGoingParams}
+	defer func() { err = report.close(err) }()
+	resources := report.skipErrors(ctx, repo.StreamLocalFile(ctx, &enex.File{}, opts.InputFilename))
+	if filter != nil {
+		resources = filterResources(resources, func(item entity.LinkID) bool {
+			return filter.Match(item.(*enex.Note).Note, nil)
+		})
+	}
+	_, err = writeStream(ctx, resources, opts.OutputFilename, "ENEX export items", nil)
 	return
 }
 
+// filterResources leaves out the resources for which keep is false.
+func filterResources(seq iter.Seq2[entity.LinkID, error], keep func(entity.LinkID) bool) iter.Seq2[entity.LinkID, error] {
+	return func(yield func(entity.LinkID, error) bool) {
+		for item, err := range seq {
+			if err == nil && !keep(item) {
+				continue
+			}
+			if !yield(item, err) {
+				return
+			}
+		}
+	}
+}
+
+// fetchWriteResource writes resources as they're fetched, if the repository
+// can stream them. Otherwise, they're all fetched before writing.
 func fetchWriteResource(ctx context.Context, repository entity.LocalRemoteRepo, opts *FetchWriteParams, name string) (err error) {
+	if stream, ok := repository.(entity.RepoRemoteStream); ok {
+		report := errorReport{params: opts.KeepGoingParams}
+		defer func() { err = report.close(err) }()
+		var count int
+		if count, err = writeStream(ctx, report.skipErrors(ctx, stream.StreamRemote(ctx)), opts.OutputFilename, name, nil); err != nil {
+			return
+		}
+		log.Info(ctx, map[string]any{"count": count}, "fetched "+name)
+		return
+	}
 	var resources []entity.LinkID
 	if resources, err = fetchResources(ctx, repository, name); err != nil {
 		return
@@ -91,26 +219,71 @@ func fetchResources(ctx context.Context, repository entity.LocalRemoteRepo, name
 	if resources, err = repo.FetchResources(ctx, repository); err != nil {
 		return
 	}
+	for _, item := range resources {
+		progress.Add(progress.Fetch, progress.TypeName(item), 1)
+	}
 	log.Info(ctx, map[string]any{"count": len(resources)}, "fetched "+name)
 	return
 }
 
 // writeResources marshalizes resources to JSON and writes to a local file. If
-// filename is empty, then it prints to standard output.
-func writeResources(resources interface{}, filename string, name string) (err error) {
-	data, err := json.Marshal(resources)
-	if err != nil {
-		return
+// filename is empty, then it prints to standard output. Resources are encoded
+// one at a time, so the JSON of all of them isn't in memory at once.
+func writeResources[T any](resources []T, filename string, name string) (err error) {
+	_, err = writeStream(context.TODO(), func(yield func(T, error) bool) {
+		for _, item := range resources {
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}, filename, name, nil)
+	return
+}
+
+// writeStream is like writeResources, but writes each resource of a sequence
+// as it's yielded, so the output is the same, but without all of the resources
+// in memory at once. If each is set, it's called on each resource before it's
+// written. The output is the number of resources written.
+func writeStream[T any](ctx context.Context, resources iter.Seq2[T, error], filename, name string, each func(T) error) (count int, err error) {
+	var w io.Writer = os.Stdout
+	if filename != "" {
+		file, ferr := os.Create(filepath.Clean(filename))
+		if ferr != nil {
+			err = ferr
+			return
+		}
+		defer func() {
+			if cerr := file.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		w = file
 	}
-	if filename == "" {
-		fmt.Println(string(data))
+	encoder := repo.NewArrayEncoder(w)
+	for item, ierr := range resources {
+		if ierr != nil {
+			err = ierr
+			return
+		}
+		if each != nil {
+			if err = each(item); err != nil {
+				return
+			}
+		}
+		if err = encoder.Encode(item); err != nil {
+			return
+		}
+		progress.Add(progress.Write, progress.TypeName(item), 1)
+	}
+	if err = encoder.Close(); err != nil {
 		return
 	}
-	err = os.WriteFile(filename, data, os.FileMode(0644))
-	if err != nil {
+	count = encoder.Count()
+	if filename == "" {
+		_, err = fmt.Fprintln(w)
 		return
 	}
-	log.Info(context.TODO(), map[string]any{"filename": filename, "resource_type": name}, "wrote JSON data to file")
+	log.Info(ctx, map[string]any{"filename": filename, "resource_type": name, "count": count}, "wrote JSON data to file")
 	return
 }
 
@@ -132,3 +305,30 @@ func MakeEDAMEnvFile(envfile string) (err error) {
 	log.Info(context.TODO(), map[string]any{"filename": envfile}, "wrote envfile")
 	return
 }
+
+// MakeEncryptedEDAMEnvFile creates a file at filename with the values of
+// environment variables for the Evernote API, encrypted with passphrase,
+// unless the file already exists.
+func MakeEncryptedEDAMEnvFile(envfile, passphrase string, values map[string]string) (err error) {
+	if envfile == "" {
+		err = fmt.Errorf("envfile is required")
+		return
+	}
+	if err = edam.MakeEncryptedEnvFile(envfile, passphrase, values); err != nil {
+		return
+	}
+
+	log.Info(context.TODO(), map[string]any{"filename": envfile}, "wrote encrypted envfile")
+	return
+}
+
+// LoginEDAM gets an access token for an Evernote account through the OAuth
+// flow and saves it with the configured credentials.
+func LoginEDAM(ctx context.Context, conf edam.CredentialsConfig, params edam.LoginParams) (err error) {
+	result, err := edam.Login(ctx, conf, params)
+	if err != nil {
+		return
+	}
+	log.Info(ctx, map[string]any{"name": result.TokenName, "expires": result.Expires}, "saved access token")
+	return
+}
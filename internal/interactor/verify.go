@@ -0,0 +1,158 @@
+package interactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/log"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
+	"github.com/rafaelespinoza/notexfr/internal/repo/ledger"
+	"github.com/rafaelespinoza/notexfr/internal/repo/sn"
+)
+
+// VerifyFormats are the values of VerifyParams.From.
+var VerifyFormats = []string{"enex", "edam"}
+
+// VerifyParams are named inputs and outputs for checking a conversion to
+// StandardNotes against its source.
+type VerifyParams struct {
+	// From is the format of the source, one of VerifyFormats.
+	From string
+	// InputPath is the source, an ENEX file or the output directory of "edam
+	// fetch-all".
+	InputPath string
+	// SNFilename is the output of the conversion.
+	SNFilename string
+	// Filter, RulesFilename should be the same as for the conversion, so
+	// that the same notes, tags and notebooks are expected.
+	Filter        string
+	RulesFilename string
+	// UUIDNamespace, LedgerFilename should be the same as for the
+	// conversion, if any, so that notes are paired by the UUIDs they were
+	// given. The ledger is only read.
+	UUIDNamespace  string
+	LedgerFilename string
+	// ReportFilename is where to write the report. If empty, then it's
+	// written to standard output.
+	ReportFilename string
+	// ReportFormat is one of DiffFormats. The default is "text".
+	ReportFormat string
+	// DecryptParams should be the same as for the conversion, if it
+	// decrypted any text.
+	DecryptParams
+}
+
+// ErrVerify means that a conversion doesn't match its source.
+var ErrVerify = errors.New("verification failed")
+
+// Verify checks that each note of the source has exactly one counterpart in
+// the StandardNotes data, with the same text, tags, notebook and timestamps,
+// see sn.Verify. The report is written either way. The error is ErrVerify if
+// there are any missing or extra notes, or any discrepancies.
+func Verify(ctx context.Context, params VerifyParams) (out *sn.Verification, err error) {
+	if !slices.Contains(VerifyFormats, params.From) {
+		err = fmt.Errorf("invalid source format %q, should be one of %q", params.From, VerifyFormats)
+		return
+	}
+	if params.ReportFormat != "" && !slices.Contains(DiffFormats, params.ReportFormat) {
+		err = fmt.Errorf("invalid report format %q, should be one of %q", params.ReportFormat, DiffFormats)
+		return
+	}
+	if params.InputPath == "" || params.SNFilename == "" {
+		err = fmt.Errorf("input path and StandardNotes filename are required")
+		return
+	}
+	filter, err := entity.ParseFilter(params.Filter)
+	if err != nil {
+		return
+	}
+	rules, err := readRules(params.RulesFilename)
+	if err != nil {
+		return
+	}
+	from, err := repo.LookupFormat(params.From)
+	if err != nil {
+		return
+	}
+	exportParams := sn.ExportParams{UUIDNamespace: params.UUIDNamespace}
+	if exportParams.Keyring, err = params.keyring(); err != nil {
+		return
+	}
+	if params.LedgerFilename != "" {
+		if exportParams.Ledger, err = ledger.Read(params.LedgerFilename); err != nil {
+			return
+		}
+	}
+
+	src, err := from.Read(ctx, params.InputPath, nil)
+	if err != nil {
+		return
+	}
+	// The source is selected and rewritten as it is when converting.
+	in, sourceNotes, err := selectNotes(ctx, src, filter, rules)
+	if err != nil {
+		return
+	}
+	for note, nerr := range sourceNotes {
+		if nerr != nil {
+			err = nerr
+			return
+		}
+		in.Notes = append(in.Notes, note)
+	}
+	source, err := entity.NewGraph(in)
+	if err != nil {
+		return
+	}
+	notes, tags, err := sn.ReadConversionFile(params.SNFilename)
+	if err != nil {
+		return
+	}
+
+	if out, err = sn.Verify(source, notes, tags, &exportParams); err != nil {
+		return
+	}
+	if err = writeVerification(ctx, params, out); err != nil {
+		return
+	}
+	if !out.Passed() {
+		err = fmt.Errorf("%w; %d missing, %d extra, %d discrepancies", ErrVerify, len(out.Missing), len(out.Extra), len(out.Discrepancies))
+	}
+	return
+}
+
+func writeVerification(ctx context.Context, params VerifyParams, verification *sn.Verification) (err error) {
+	var w io.Writer = os.Stdout
+	if params.ReportFilename != "" {
+		file, ferr := os.Create(filepath.Clean(params.ReportFilename))
+		if ferr != nil {
+			return ferr
+		}
+		defer func() {
+			if cerr := file.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		w = file
+	}
+	if params.ReportFormat == "json" {
+		err = verification.WriteJSON(w)
+	} else {
+		err = verification.WriteText(w)
+	}
+	if err != nil || params.ReportFilename == "" {
+		return
+	}
+	log.Info(ctx, map[string]any{
+		"filename": params.ReportFilename,
+		"matched":  verification.Matched,
+		"passed":   verification.Passed(),
+	}, "wrote verification report to file")
+	return
+}
@@ -13,11 +13,14 @@ import (
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 var (
 	theLogger  *slog.Logger
 	initLogger sync.Once
+	// numWarnings counts calls to Warn, whether or not they're logged.
+	numWarnings atomic.Int64
 )
 
 // Init sets up a singleton logger just once. Subsequent invocations after the
@@ -45,9 +48,14 @@ func Info(ctx context.Context, fields map[string]any, msg string) {
 }
 
 func Warn(ctx context.Context, fields map[string]any, msg string) {
+	numWarnings.Add(1)
 	log(ctx, slog.LevelWarn, fields, nil, msg)
 }
 
+// Warnings is the number of calls to Warn so far, including those below the
+// logging level.
+func Warnings() int { return int(numWarnings.Load()) }
+
 func Error(ctx context.Context, fields map[string]any, err error, msg string) {
 	log(ctx, slog.LevelError, fields, err, msg)
 }
@@ -0,0 +1,287 @@
+// Package progress keeps track of how many items go through each stage of a
+// run, such as fetching or converting notes, using a singleton tracker. Events
+// are emitted via the Expect, Add and Skip functions. They're always counted,
+// for the Summary at the end of a run, but they're only displayed, as a line
+// of counts, rates and estimated times left, after calling Init with a
+// writer.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stages of a run. The first three are the same as those of entity.NoteError.
+const (
+	Fetch   = "fetch"
+	Read    = "read"
+	Convert = "convert"
+	Write   = "write"
+)
+
+// stageOrder is the order of stages in the display and the summary. Any other
+// stages follow, in the order they started.
+var stageOrder = []string{Fetch, Read, Convert, Write}
+
+// Interval is how often the display is redrawn.
+const Interval = 250 * time.Millisecond
+
+type stage struct {
+	name    string
+	types   []string
+	counts  map[string]int
+	skipped map[string]int
+	// done and total are items, or bytes if inBytes, for estimating the time
+	// left.
+	done, total int64
+	inBytes     bool
+	start, last time.Time
+}
+
+type tracker struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	start  time.Time
+	stages []*stage
+	w      io.Writer
+	shown  bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+var theTracker = &tracker{now: time.Now}
+
+// Init starts a run, leaving out the events of any earlier one, and displays
+// its progress on w, which is typically a terminal, until Stop is called. If w
+// is nil, then events are only counted.
+func Init(w io.Writer) {
+	t := theTracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.start, t.stages = t.now(), nil
+	if w == nil || t.w != nil {
+		return
+	}
+	t.w, t.stop, t.done = w, make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.mu.Lock()
+				t.draw()
+				t.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop stops displaying progress and clears the display. Events are still
+// counted.
+func Stop() {
+	t := theTracker
+	t.mu.Lock()
+	if t.w == nil {
+		t.mu.Unlock()
+		return
+	}
+	close(t.stop)
+	t.mu.Unlock()
+	<-t.done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clear()
+	t.w = nil
+}
+
+// Expect adds n to the number of items expected in a stage, for estimating the
+// time left. Call it again for each batch of items that becomes known.
+func Expect(stageName string, n int) {
+	t := theTracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stage(stageName).total += int64(n)
+}
+
+// Add counts n items of a type that went through a stage. The type is a name
+// like one from TypeName.
+func Add(stageName, typ string, n int) {
+	t := theTracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stage(stageName)
+	s.count(s.counts, typ, n)
+	if !s.inBytes {
+		s.done += int64(n)
+	}
+}
+
+// Skip counts n items of a type that were left out of a stage.
+func Skip(stageName, typ string, n int) {
+	if n < 1 {
+		return
+	}
+	t := theTracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stage(stageName)
+	s.count(s.skipped, typ, n)
+}
+
+// Reader tracks the progress of a stage by the bytes read from r, rather than
+// by the number of items. The size is the total number of bytes, such as the
+// size of a file.
+func Reader(stageName string, r io.Reader, size int64) io.Reader {
+	t := theTracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stage(stageName)
+	if !s.inBytes {
+		s.inBytes, s.done, s.total = true, 0, 0
+	}
+	s.total += size
+	return &reader{r: r, stage: s}
+}
+
+type reader struct {
+	r     io.Reader
+	stage *stage
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	t := theTracker
+	t.mu.Lock()
+	r.stage.done += int64(n)
+	t.mu.Unlock()
+	return
+}
+
+// TypeName is the name of the type of an item, without the package name or a
+// pointer, such as "Note".
+func TypeName(item any) string {
+	typ := reflect.TypeOf(item)
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == nil {
+		return "nil"
+	}
+	return typ.Name()
+}
+
+// Writer wraps w, such as the output of logs, so that the progress display is
+// cleared before each write. It's redrawn at the next interval.
+func Writer(w io.Writer) io.Writer { return &writer{w: w} }
+
+type writer struct{ w io.Writer }
+
+func (w *writer) Write(p []byte) (int, error) {
+	t := theTracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clear()
+	return w.w.Write(p)
+}
+
+// stage finds a stage by name, or starts it. The caller must hold the lock.
+func (t *tracker) stage(name string) *stage {
+	now := t.now()
+	if t.start.IsZero() {
+		t.start = now
+	}
+	for _, s := range t.stages {
+		if s.name == name {
+			s.last = now
+			return s
+		}
+	}
+	s := &stage{
+		name:    name,
+		counts:  make(map[string]int),
+		skipped: make(map[string]int),
+		start:   now,
+		last:    now,
+	}
+	t.stages = append(t.stages, s)
+	slices.SortStableFunc(t.stages, func(a, b *stage) int { return rank(a.name) - rank(b.name) })
+	return s
+}
+
+func rank(name string) int {
+	if i := slices.Index(stageOrder, name); i >= 0 {
+		return i
+	}
+	return len(stageOrder)
+}
+
+func (s *stage) count(counts map[string]int, typ string, n int) {
+	if _, ok := s.counts[typ]; !ok {
+		if _, ok = s.skipped[typ]; !ok {
+			s.types = append(s.types, typ)
+		}
+	}
+	counts[typ] += n
+}
+
+func (s *stage) numItems() (out int) {
+	for _, n := range s.counts {
+		out += n
+	}
+	return
+}
+
+// draw writes the progress of each stage on one line. The caller must hold
+// the lock.
+func (t *tracker) draw() {
+	if t.w == nil || len(t.stages) < 1 {
+		return
+	}
+	now := t.now()
+	parts := make([]string, len(t.stages))
+	for i, s := range t.stages {
+		parts[i] = s.describe(now)
+	}
+	_, _ = fmt.Fprintf(t.w, "\r\x1b[K%s", strings.Join(parts, " | "))
+	t.shown = true
+}
+
+// clear erases the display, if it's shown. The caller must hold the lock.
+func (t *tracker) clear() {
+	if t.w == nil || !t.shown {
+		return
+	}
+	_, _ = io.WriteString(t.w, "\r\x1b[K")
+	t.shown = false
+}
+
+// describe is the progress of a stage, like "fetch 120/3400 12.0/s ETA 4m40s".
+func (s *stage) describe(now time.Time) string {
+	items := s.numItems()
+	out := fmt.Sprintf("%s %d", s.name, items)
+	if s.total > 0 && !s.inBytes {
+		out += fmt.Sprintf("/%d", s.total)
+	} else if s.total > 0 {
+		out += fmt.Sprintf(" (%d%%)", 100*s.done/s.total)
+	}
+	elapsed := now.Sub(s.start)
+	if elapsed < time.Second {
+		return out
+	}
+	out += fmt.Sprintf(" %.1f/s", float64(items)/elapsed.Seconds())
+	if s.done > 0 && s.total > s.done {
+		left := time.Duration(float64(elapsed) * float64(s.total-s.done) / float64(s.done))
+		out += " ETA " + left.Round(time.Second).String()
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package progress_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/progress"
+)
+
+func TestSummarize(t *testing.T) {
+	progress.Init(nil)
+	progress.Expect(progress.Fetch, 3)
+	progress.Add(progress.Fetch, "Note", 2)
+	progress.Skip(progress.Fetch, "Note", 1)
+	progress.Add(progress.Write, "Note", 2)
+	progress.Add(progress.Write, "Tag", 1)
+	progress.Skip(progress.Write, "Tag", 0)
+
+	summary := progress.Summarize()
+	if len(summary.Stages) != 2 {
+		t.Fatalf("wrong number of stages; got %d, expected %d", len(summary.Stages), 2)
+	}
+	fetch, write := summary.Stages[0], summary.Stages[1]
+	if fetch.Name != progress.Fetch || fetch.Items["Note"] != 2 || fetch.Skipped["Note"] != 1 {
+		t.Errorf("wrong fetch stage; got %+v", fetch)
+	}
+	if write.Name != progress.Write || write.Items["Note"] != 2 || write.Items["Tag"] != 1 || len(write.Skipped) != 0 {
+		t.Errorf("wrong write stage; got %+v", write)
+	}
+
+	var buf bytes.Buffer
+	if err := summary.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"fetch", "2 Note", "1 Note", "2 Note, 1 Tag", "warnings: 0"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected summary to contain %q; got\n%s", want, buf.String())
+		}
+	}
+
+	// Starting another run leaves out the events of this one.
+	progress.Init(nil)
+	if summary = progress.Summarize(); len(summary.Stages) != 0 {
+		t.Errorf("expected no stages; got %+v", summary.Stages)
+	}
+}
+
+func TestReader(t *testing.T) {
+	progress.Init(nil)
+	r := progress.Reader(progress.Read, strings.NewReader("abcdef"), 6)
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	progress.Add(progress.Read, progress.TypeName(&entity.Note{}), 1)
+	summary := progress.Summarize()
+	if len(summary.Stages) != 1 || summary.Stages[0].Items["Note"] != 1 {
+		t.Errorf("wrong summary; got %+v", summary)
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	for _, test := range []struct {
+		in       any
+		expected string
+	}{
+		{in: &entity.Note{}, expected: "Note"},
+		{in: entity.Tag{}, expected: "Tag"},
+		{in: nil, expected: "nil"},
+	} {
+		if got := progress.TypeName(test.in); got != test.expected {
+			t.Errorf("wrong name for %T; got %q, expected %q", test.in, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A Summary is what went through each stage of a run.
+type Summary struct {
+	Stages []StageSummary `json:"stages"`
+	// Warnings is the number of warnings logged, it's up to the caller.
+	Warnings int           `json:"warnings"`
+	Elapsed  time.Duration `json:"elapsed"`
+}
+
+// A StageSummary counts the items of a stage, by type.
+type StageSummary struct {
+	Name    string         `json:"name"`
+	Items   map[string]int `json:"items"`
+	Skipped map[string]int `json:"skipped,omitempty"`
+	// Types are the keys of Items and Skipped, in the order they were first
+	// counted.
+	Types []string `json:"-"`
+	// Elapsed is the time from the first event of a stage to the last one.
+	// Stages overlap, since items go through each stage one at a time.
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// Summarize makes a Summary of the events so far, with stages in the order of
+// the display. The elapsed time of the run is since Init or the first event.
+func Summarize() (out Summary) {
+	t := theTracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.start.IsZero() {
+		out.Elapsed = t.now().Sub(t.start)
+	}
+	out.Stages = make([]StageSummary, len(t.stages))
+	for i, s := range t.stages {
+		out.Stages[i] = StageSummary{
+			Name:    s.name,
+			Items:   make(map[string]int, len(s.counts)),
+			Skipped: make(map[string]int, len(s.skipped)),
+			Types:   append([]string(nil), s.types...),
+			Elapsed: s.last.Sub(s.start),
+		}
+		for typ, n := range s.counts {
+			out.Stages[i].Items[typ] = n
+		}
+		for typ, n := range s.skipped {
+			out.Stages[i].Skipped[typ] = n
+		}
+	}
+	return
+}
+
+// WriteText writes the summary as a table, one stage per row.
+func (s Summary) WriteText(w io.Writer) (err error) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if _, err = fmt.Fprintln(tw, "stage\titems\tskipped\telapsed"); err != nil {
+		return
+	}
+	for _, stage := range s.Stages {
+		_, err = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			stage.Name,
+			countTypes(stage.Types, stage.Items),
+			countTypes(stage.Types, stage.Skipped),
+			stage.Elapsed.Round(time.Millisecond),
+		)
+		if err != nil {
+			return
+		}
+	}
+	if err = tw.Flush(); err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(w, "warnings: %d, elapsed: %s\n", s.Warnings, s.Elapsed.Round(time.Millisecond))
+	return
+}
+
+// countTypes is like "12 Note, 3 Tag", or "-" if there's nothing to count.
+func countTypes(types []string, counts map[string]int) string {
+	parts := make([]string, 0, len(types))
+	for _, typ := range types {
+		if n := counts[typ]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, typ))
+		}
+	}
+	if len(parts) < 1 {
+		return "-"
+	}
+	return strings.Join(parts, ", ")
+}
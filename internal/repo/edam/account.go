@@ -0,0 +1,40 @@
+package edam
+
+import (
+	"context"
+)
+
+// Account describes the Evernote account of a Client.
+type Account struct {
+	UserID     int32
+	Username   string
+	ServiceEnv string
+	// UpdateCount is the highest update sequence number in the account. Each
+	// change to the account's data increments it.
+	UpdateCount int32
+}
+
+// Account gets information about the user and the state of their data.
+func (c *Client) Account(ctx context.Context) (out *Account, err error) {
+	s, err := c.connect(ctx)
+	if err != nil {
+		return
+	}
+	user, err := s.userStore.GetUser(ctx, s.token)
+	if err != nil {
+		err = makeError(err)
+		return
+	}
+	state, err := s.GetSyncState(ctx, s.token)
+	if err != nil {
+		err = makeError(err)
+		return
+	}
+	out = &Account{
+		UserID:      int32(user.GetID()),
+		Username:    user.GetUsername(),
+		ServiceEnv:  c.conf.ServiceEnv.String(),
+		UpdateCount: state.GetUpdateCount(),
+	}
+	return
+}
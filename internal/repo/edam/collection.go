@@ -0,0 +1,150 @@
+package edam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
+)
+
+// These are names of files within a directory of data from an account, such
+// as the bundle written by "edam fetch-all". The data files hold the output of
+// the other edam subcommands. Attachments are in a subdirectory, named by the
+// MD5 hash of their data.
+const (
+	NotebooksFilename     = "edam_notebooks.json"
+	NotesFilename         = "edam_notes.json"
+	SavedSearchesFilename = "edam_searches.json"
+	TagsFilename          = "edam_tags.json"
+	AttachmentsDir        = "attachments"
+)
+
+// Service is the name of the service, as in entity.Collection.
+const Service = "evernote.com"
+
+func init() {
+	repo.RegisterFormat(repo.Format{
+		Name:        "edam",
+		Description: `directory of JSON files from the Evernote API, such as the output of "edam fetch-all"`,
+		Options: map[string]string{
+			"notebooks": "path to notebooks file, instead of the one in the directory",
+			"notes":     "path to notes file, instead of the one in the directory",
+			"tags":      "path to tags file, instead of the one in the directory",
+			"searches":  "path to saved searches file, instead of the one in the directory",
+		},
+		Read: func(ctx context.Context, path string, opts repo.Options) (*repo.Source, error) {
+			files := CollectionFiles{
+				Notebooks:     opts["notebooks"],
+				Notes:         opts["notes"],
+				Tags:          opts["tags"],
+				SavedSearches: opts["searches"],
+			}
+			if path != "" {
+				files.AttachmentsDir = filepath.Join(path, AttachmentsDir)
+			}
+			for _, file := range []struct {
+				name string
+				dst  *string
+			}{
+				{name: NotebooksFilename, dst: &files.Notebooks},
+				{name: NotesFilename, dst: &files.Notes},
+				{name: SavedSearchesFilename, dst: &files.SavedSearches},
+				{name: TagsFilename, dst: &files.Tags},
+			} {
+				if *file.dst != "" || path == "" {
+					continue
+				}
+				filename := filepath.Join(path, file.name)
+				if _, err := os.Stat(filename); err == nil {
+					*file.dst = filename
+				} else if !errors.Is(err, os.ErrNotExist) {
+					return nil, err
+				}
+			}
+			if files.Notes == "" {
+				return nil, fmt.Errorf("no %s in %q", NotesFilename, path)
+			}
+			return ReadSource(ctx, files)
+		},
+	})
+}
+
+// CollectionFiles names the files to read with ReadSource. Each one is
+// optional.
+type CollectionFiles struct {
+	Notebooks, Notes, Tags, SavedSearches string
+	// AttachmentsDir is where to find the data of attachments that only
+	// have a Hash.
+	AttachmentsDir string
+}
+
+// ReadSource reads local files of data from the Evernote API. Everything but
+// the notes is read at once, the notes are read as they're yielded.
+func ReadSource(ctx context.Context, files CollectionFiles) (out *repo.Source, err error) {
+	collection := &entity.Collection{Service: Service}
+	var resources []entity.LinkID
+
+	if files.Notebooks != "" {
+		if resources, err = repo.ReadLocalFile(ctx, &Notebooks{}, files.Notebooks); err != nil {
+			return
+		}
+		for _, item := range resources {
+			collection.Notebooks = append(collection.Notebooks, item.(*Notebook).Notebook)
+		}
+	}
+	if files.Tags != "" {
+		if resources, err = repo.ReadLocalFile(ctx, &Tags{}, files.Tags); err != nil {
+			return
+		}
+		for _, item := range resources {
+			collection.Tags = append(collection.Tags, item.(*Tag).Tag)
+		}
+	}
+	if files.SavedSearches != "" {
+		if resources, err = repo.ReadLocalFile(ctx, &SavedSearches{}, files.SavedSearches); err != nil {
+			return
+		}
+		for _, item := range resources {
+			collection.SavedSearches = append(collection.SavedSearches, item.(*SavedSearch).SavedSearch)
+		}
+	}
+	out = &repo.Source{
+		Collection: collection,
+		Notes: func(yield func(*entity.Note, error) bool) {
+			if files.Notes == "" {
+				return
+			}
+			for item, err := range repo.StreamLocalFile(ctx, &Notes{}, files.Notes) {
+				var note *entity.Note
+				if err == nil {
+					note = item.(*Note).Note
+					if aerr := readAttachments(files.AttachmentsDir, note.Attachments); aerr != nil {
+						note, err = nil, &entity.NoteError{ID: note.ID, Title: note.Title, Stage: entity.StageRead, Err: aerr}
+					}
+				}
+				if !yield(note, err) {
+					return
+				}
+			}
+		},
+	}
+	return
+}
+
+// readAttachments fills in the data of attachments that are stored in separate
+// files.
+func readAttachments(dir string, attachments []*entity.Attachment) (err error) {
+	for _, att := range attachments {
+		if att.Data != nil || att.Hash == "" || dir == "" {
+			continue
+		}
+		if att.Data, err = os.ReadFile(filepath.Join(dir, filepath.Base(att.Hash))); err != nil {
+			return
+		}
+	}
+	return
+}
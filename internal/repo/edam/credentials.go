@@ -0,0 +1,286 @@
+package edam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/joho/godotenv"
+)
+
+// CredentialNames are the names of the variables that hold credentials, such
+// as EVERNOTE_PRODUCTION_TOKEN. Each CredentialSource is asked for values by
+// these names.
+var CredentialNames = []string{
+	_ProductionTokenName,
+	_ProductionKeyName,
+	_ProductionSecretName,
+	_SandboxTokenName,
+	_SandboxKeyName,
+	_SandboxSecretName,
+}
+
+// A CredentialSource looks up a credential by name, which is one of the
+// CredentialNames. A source that doesn't have a value should return an empty
+// string rather than an error.
+type CredentialSource interface {
+	Credential(ctx context.Context, name string) (string, error)
+}
+
+// EnvFileSource reads credentials from a dotenv file, as created by
+// MakeEnvFile or MakeEncryptedEnvFile. An encrypted file is detected by its
+// contents, Passphrase is called to decrypt it. The file is read at most once.
+type EnvFileSource struct {
+	Filename   string
+	Passphrase func() (string, error)
+
+	once       sync.Once
+	mu         sync.Mutex
+	vars       map[string]string
+	passphrase string
+	err        error
+}
+
+func (s *EnvFileSource) Credential(ctx context.Context, name string) (string, error) {
+	if err := s.load(); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.vars[name], nil
+}
+
+// SetCredential rewrites the file with the new value. An encrypted file stays
+// encrypted with the same passphrase. Other content of the file, such as
+// comments, is not preserved.
+func (s *EnvFileSource) SetCredential(ctx context.Context, name, value string) (err error) {
+	if err = s.load(); err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vars := make(map[string]string, len(s.vars)+1)
+	for key, val := range s.vars {
+		vars[key] = val
+	}
+	vars[name] = value
+
+	// Write to a temporary file first so that a failure doesn't lose the
+	// existing credentials.
+	tmp := s.Filename + ".tmp"
+	_ = os.Remove(tmp)
+	if s.passphrase != "" {
+		err = writeEncryptedEnvFile(tmp, s.passphrase, vars)
+	} else {
+		err = writePlainEnvFile(tmp, vars)
+	}
+	if err != nil {
+		return
+	}
+	if err = os.Rename(tmp, s.Filename); err != nil {
+		return
+	}
+	s.vars = vars
+	return
+}
+
+func (s *EnvFileSource) load() error {
+	s.once.Do(func() { s.vars, s.err = s.read() })
+	return s.err
+}
+
+func (s *EnvFileSource) read() (map[string]string, error) {
+	data, err := os.ReadFile(s.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not load env vars; %v", err)
+	}
+	if bytes.HasPrefix(data, []byte(armor.Header)) {
+		if s.Passphrase == nil {
+			return nil, fmt.Errorf("env file %q is encrypted, a passphrase is required", s.Filename)
+		}
+		passphrase, err := s.Passphrase()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = decryptEnvFile(data, passphrase); err != nil {
+			return nil, fmt.Errorf("could not decrypt env file %q; %w", s.Filename, err)
+		}
+		s.passphrase = passphrase
+	}
+	vars, err := godotenv.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not load env vars; %v", err)
+	}
+	return vars, nil
+}
+
+func decryptEnvFile(data []byte, passphrase string) ([]byte, error) {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(armor.NewReader(bytes.NewReader(data)), identity)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// MakeEncryptedEnvFile is like MakeEnvFile, but the file is encrypted with a
+// passphrase and holds the given values rather than a template. Since
+// nobody can edit it in place, any of the CredentialNames missing from values
+// are written as empty. The format is an armored age file, so it can also be
+// decrypted with the age command, as in "age -d envfile".
+func MakeEncryptedEnvFile(filename, passphrase string, values map[string]string) (err error) {
+	if passphrase == "" {
+		return errors.New("passphrase is required")
+	}
+	vars := make(map[string]string, len(CredentialNames))
+	for _, name := range CredentialNames {
+		vars[name] = values[name]
+	}
+	err = writeEncryptedEnvFile(filename, passphrase, vars)
+	if errors.Is(err, os.ErrExist) {
+		err = fmt.Errorf("env file already present at %q", filename)
+	}
+	return
+}
+
+// writePlainEnvFile creates a file that only the user can read, before any
+// credentials are written to it.
+func writePlainEnvFile(filename string, vars map[string]string) (err error) {
+	content, err := godotenv.Marshal(vars)
+	if err != nil {
+		return
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.WriteString(file, content+"\n")
+	return
+}
+
+// writeEncryptedEnvFile creates a new file, it's an error if it exists.
+func writeEncryptedEnvFile(filename, passphrase string, vars map[string]string) (err error) {
+	content, err := godotenv.Marshal(vars)
+	if err != nil {
+		return
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	armored := armor.NewWriter(file)
+	w, err := age.Encrypt(armored, recipient)
+	if err != nil {
+		return
+	}
+	if _, err = io.WriteString(w, content+"\n"); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	err = armored.Close()
+	return
+}
+
+// CommandSource gets a token from the output of a shell command, such as a
+// password manager like "pass show evernote/prod". Only the first line of
+// output is used. The command is only run for the token of the selected
+// service environment, the consumer key and secret come from elsewhere.
+type CommandSource struct {
+	Command string
+}
+
+func (s *CommandSource) Credential(ctx context.Context, name string) (string, error) {
+	if !strings.HasSuffix(name, "_TOKEN") {
+		return "", nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.Command)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("token command failed; %w; %s", err, strings.TrimSpace(stderr.String()))
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(line), nil
+}
+
+// KeyringService is the default service attribute of keyring items.
+const KeyringService = "notexfr"
+
+// KeyringSource looks up credentials in the keyring of the desktop session,
+// via the Secret Service API, using the secret-tool command from libsecret.
+// Each item is identified by the attributes service and account, where the
+// account is the credential name. For example, store a token with:
+//
+//	secret-tool store --label="Evernote token" service notexfr account EVERNOTE_PRODUCTION_TOKEN
+type KeyringSource struct {
+	// Service is the service attribute of the items. When empty, it's
+	// KeyringService.
+	Service string
+}
+
+func (s *KeyringSource) Credential(ctx context.Context, name string) (string, error) {
+	service := s.service()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "secret-tool", "lookup", "service", service, "account", name)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && stderr.Len() == 0 {
+		// secret-tool exits non-zero without a message when there's no
+		// matching item.
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("keyring lookup failed; %w; %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func (s *KeyringSource) service() string {
+	if s.Service == "" {
+		return KeyringService
+	}
+	return s.Service
+}
+
+// SetCredential saves the value as an item in the keyring, replacing any item
+// with the same attributes.
+func (s *KeyringSource) SetCredential(ctx context.Context, name, value string) error {
+	service := s.service()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "secret-tool", "store", "--label", service+" "+name, "service", service, "account", name)
+	cmd.Stdin = strings.NewReader(value)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("keyring store failed; %w; %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -5,11 +5,14 @@ package edam
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"sync"
 	"time"
 
-	en "github.com/dreampuf/evernote-sdk-golang/client"
+	"github.com/apache/thrift/lib/go/thrift"
 	"github.com/dreampuf/evernote-sdk-golang/edam"
 	"github.com/joho/godotenv"
 	"github.com/rafaelespinoza/notexfr/internal/entity"
@@ -21,10 +24,6 @@ import (
 // with Evernote.
 type EvernoteService uint8
 
-// EvernoteServiceKey is the name of the context key that stores the EDAM
-// credential configuration values.
-const EvernoteServiceKey = "EVERNOTE_SERVICE"
-
 const (
 	// EvernoteSandboxService is a cautious default to operate on data in a
 	// development environment, entirely separate from the production service.
@@ -42,6 +41,14 @@ func (d EvernoteService) String() string {
 type CredentialsConfig struct {
 	EnvFilename string
 	ServiceEnv  EvernoteService
+	// Sources are where to look up credentials that aren't set as
+	// environment variables, they're tried in order before EnvFilename.
+	Sources []CredentialSource
+	// BaseURL optionally overrides the scheme and host of the Evernote
+	// service, such as "http://localhost:8080". It's for pointing at a
+	// stand-in service, like the one in the fake package. When empty, the
+	// host is selected by ServiceEnv.
+	BaseURL string
 }
 
 const (
@@ -78,38 +85,65 @@ func MakeEnvFile(filename string) (err error) {
 	return
 }
 
-var _TheStore *store
+// Client connects to the Evernote API on behalf of one account. It's safe for
+// concurrent use, although requests through one Client are made one at a
+// time, since they share a connection. Separate Clients are independent, so
+// they may be used to work with multiple accounts at the same time, such as a
+// personal and a business account.
+type Client struct {
+	conf  CredentialsConfig
+	mu    sync.Mutex
+	store *store
+}
+
+// NewClient constructs a Client. Credentials are not read until the first
+// request to the API.
+func NewClient(conf CredentialsConfig) *Client { return &Client{conf: conf} }
+
+var errNoClient = errors.New("an edam Client is required to fetch remote data")
+
+// connect returns the store for the account. When first called, it
+// authenticates with the Evernote EDAM API using the configured credentials.
+// Upon success, the store is cached for subsequent calls. Otherwise, the next
+// call tries again.
+func (c *Client) connect(ctx context.Context) (*store, error) {
+	if c == nil {
+		return nil, errNoClient
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.store != nil {
+		return c.store, nil
+	}
+	s, err := initStore(ctx, c.conf)
+	if err != nil {
+		return nil, err
+	}
+	c.store = s
+	return s, nil
+}
 
 type store struct {
 	edam.NoteStore
 	token string
+
+	// userStore is for reaching other NoteStores, such as ones for linked
+	// notebooks. It's unset for a store of a linked notebook.
+	userStore edam.UserStore
 }
 
-// initStore returns a pointer to the singleton store. When first called, it
-// authenticates with the Evernote EDAM API using the credentials in the .env
-// file. Upon success, it is initialized and cached for subsequent calls. An
-// error may be returned while setting it up the first time.
-func initStore(ctx context.Context) (*store, error) {
-	if _TheStore != nil {
-		return _TheStore, nil
-	}
+// initStore authenticates with the Evernote EDAM API using the credentials
+// described by credsConf and sets up a store for the account.
+func initStore(ctx context.Context, credsConf CredentialsConfig) (*store, error) {
 	var (
-		err          error
-		s            *store
-		credsConf    CredentialsConfig
-		credentials  userCredentials
-		baseENClient *en.EvernoteClient
-		userURLs     *edam.UserUrls
-		userClient   *edam.UserStoreClient
-		noteClient   *edam.NoteStoreClient
+		err         error
+		s           *store
+		credentials userCredentials
+		userURLs    *edam.UserUrls
+		userClient  *edam.UserStoreClient
+		noteClient  *edam.NoteStoreClient
 	)
-	if val, ok := ctx.Value(EvernoteServiceKey).(CredentialsConfig); ok {
-		credsConf = val
-	} else {
-		return nil, fmt.Errorf("could not read initial credentials config from context")
-	}
-
-	if credentials, err = loadEnv(credsConf); err != nil {
+	if credentials, err = loadEnv(ctx, credsConf); err != nil {
 		return nil, err
 	}
 	log.Debug(ctx, map[string]any{"filename": credsConf.EnvFilename, "service_env": credsConf.ServiceEnv}, "env loaded")
@@ -119,30 +153,72 @@ func initStore(ctx context.Context) (*store, error) {
 			"got_user_store": userClient != nil,
 			"got_user_urls":  userURLs != nil,
 			"got_note_store": noteClient != nil,
-			"complete":       _TheStore != nil,
+			"complete":       s != nil,
 		}, "initStore status")
 	}()
 
-	baseENClient = en.NewClient(
-		credentials.key,
-		credentials.secret,
-		en.EnvironmentType(credsConf.ServiceEnv),
-	)
-	if userClient, err = baseENClient.GetUserStore(); err != nil {
+	if userClient, err = newUserStoreClient(baseURL(credsConf) + "/edam/user"); err != nil {
 		return nil, makeError(err)
 	}
 	if userURLs, err = userClient.GetUserUrls(ctx, credentials.token); err != nil {
 		return nil, makeError(err)
 	}
-	if noteClient, err = baseENClient.GetNoteStoreWithURL(userURLs.GetNoteStoreUrl()); err != nil {
+	if noteClient, err = newNoteStoreClient(userURLs.GetNoteStoreUrl()); err != nil {
 		return nil, makeError(err)
 	}
 	s = &store{
 		NoteStore: noteClient,
 		token:     credentials.token,
+		userStore: userClient,
+	}
+	return s, nil
+}
+
+// newUserStoreClient connects to a UserStore. Unlike the client library, it
+// connects to any URL, such as of a stand-in service.
+func newUserStoreClient(userStoreURL string) (*edam.UserStoreClient, error) {
+	client, err := newThriftClient(userStoreURL)
+	if err != nil {
+		return nil, err
+	}
+	return edam.NewUserStoreClient(client), nil
+}
+
+// newNoteStoreClient connects to a NoteStore.
+func newNoteStoreClient(noteStoreURL string) (*edam.NoteStoreClient, error) {
+	client, err := newThriftClient(noteStoreURL)
+	if err != nil {
+		return nil, err
 	}
-	_TheStore = s
-	return _TheStore, nil
+	return edam.NewNoteStoreClient(client), nil
+}
+
+// newThriftClient makes a client for one of the services of the API. Its
+// requests are made one at a time, see lockedClient.
+func newThriftClient(serviceURL string) (thrift.TClient, error) {
+	transport, err := thrift.NewTHttpClient(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+	client := thrift.NewTStandardClient(
+		thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(transport),
+		thrift.NewTBinaryProtocolFactory(true, true).GetProtocol(transport),
+	)
+	return &lockedClient{client: client}, nil
+}
+
+// lockedClient serializes the requests of a thrift client, whose transport and
+// sequence numbers are shared by every request, so that it's safe for
+// concurrent use.
+type lockedClient struct {
+	mu     sync.Mutex
+	client thrift.TClient
+}
+
+func (c *lockedClient) Call(ctx context.Context, method string, args, result thrift.TStruct) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client.Call(ctx, method, args, result)
 }
 
 type userCredentials struct{ token, key, secret string }
@@ -156,23 +232,38 @@ type userCredentials struct{ token, key, secret string }
 // Furthermore, this is sensitive information, that should be managed by the
 // caller. Should the user choose to read from an env var file, force a
 // non-empty name.
-func loadEnv(credsConf CredentialsConfig) (userCredentials, error) {
-	var out userCredentials
+//
+// A variable that's already in the environment takes precedence. Otherwise,
+// each of the credential sources is tried in order, followed by the env file.
+// Values in the file are not put into the process environment, so that
+// multiple Clients with different env files don't see each other's values.
+func loadEnv(ctx context.Context, credsConf CredentialsConfig) (out userCredentials, err error) {
+	sources := credsConf.Sources
 	if credsConf.EnvFilename != "" {
-		if err := godotenv.Load(credsConf.EnvFilename); err != nil {
-			return out, fmt.Errorf("could not load env vars; %v", err)
+		sources = append(slices.Clip(sources), &EnvFileSource{Filename: credsConf.EnvFilename})
+	}
+	getenv := func(name string) (val string, err error) {
+		if val = os.Getenv(name); val != "" {
+			return
 		}
+		for _, src := range sources {
+			if val, err = src.Credential(ctx, name); err != nil || val != "" {
+				return
+			}
+		}
+		return
 	}
+
+	names := [3]string{_SandboxTokenName, _SandboxKeyName, _SandboxSecretName}
 	if credsConf.ServiceEnv == EvernoteProductionService {
-		out.token = os.Getenv(_ProductionTokenName)
-		out.key = os.Getenv(_ProductionKeyName)
-		out.secret = os.Getenv(_ProductionSecretName)
-	} else {
-		out.token = os.Getenv(_SandboxTokenName)
-		out.key = os.Getenv(_SandboxKeyName)
-		out.secret = os.Getenv(_SandboxSecretName)
+		names = [3]string{_ProductionTokenName, _ProductionKeyName, _ProductionSecretName}
 	}
-	return out, nil
+	for i, dst := range [3]*string{&out.token, &out.key, &out.secret} {
+		if *dst, err = getenv(names[i]); err != nil {
+			return
+		}
+	}
+	return
 }
 
 // makeTimestamp converts an edam Timestamp to a regular golang time.Time in
@@ -182,6 +273,12 @@ func makeTimestamp(in edam.Timestamp) time.Time {
 	return time.Unix(int64(in)/1000, 0).UTC()
 }
 
+// toTimestamp is the inverse of makeTimestamp.
+func toTimestamp(in time.Time) *edam.Timestamp {
+	out := edam.Timestamp(in.UnixMilli())
+	return &out
+}
+
 func fmtTime(t time.Time) string { return t.Format(entity.Timeformat) }
 
 // makeError does some error wrapping for the EDAM API. See for details:
@@ -190,15 +287,15 @@ func fmtTime(t time.Time) string { return t.Format(entity.Timeformat) }
 func makeError(in error) (out error) {
 	switch err := in.(type) {
 	case *edam.EDAMUserException:
-		out = fmt.Errorf(
+		out = &clientError{fmt.Errorf(
 			"client-side %w; type: %T, code: %q, parameter: %q",
 			repo.Error, err, err.GetErrorCode().String(), err.GetParameter(),
-		)
+		)}
 	case *edam.EDAMNotFoundException:
-		out = fmt.Errorf(
+		out = &clientError{fmt.Errorf(
 			"client-side %w; type: %T, identifier: %q, key: %q",
 			repo.Error, err, err.GetIdentifier(), err.GetKey(),
-		)
+		)}
 	case *edam.EDAMSystemException:
 		out = fmt.Errorf(
 			"server-side %w; type: %T, code: %q, message: %q, rate limit duration: %d",
@@ -209,3 +306,10 @@ func makeError(in error) (out error) {
 	}
 	return
 }
+
+// A clientError is a failure of one request, such as for something that was not
+// found, rather than of the service or the connection to it.
+type clientError struct{ err error }
+
+func (e *clientError) Error() string { return e.err.Error() }
+func (e *clientError) Unwrap() error { return e.err }
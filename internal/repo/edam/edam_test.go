@@ -2,13 +2,23 @@ package edam_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	edamapi "github.com/dreampuf/evernote-sdk-golang/edam"
 	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
 	"github.com/rafaelespinoza/notexfr/internal/repo/edam"
+	"github.com/rafaelespinoza/notexfr/internal/repo/edam/fake"
 )
 
 const (
@@ -16,6 +26,7 @@ const (
 	_FixturesDir       = "../../../internal/fixtures"
 	_StubNotebooksFile = "edam_notebooks.json"
 	_StubNotesFile     = "edam_notes.json"
+	_StubSearchesFile  = "edam_searches.json"
 	_StubTagsFile      = "edam_tags.json"
 )
 
@@ -24,6 +35,7 @@ func TestInterfaceImplementations(t *testing.T) {
 		implementations := []interface{}{
 			new(edam.Notebooks),
 			new(edam.Notes),
+			new(edam.SavedSearches),
 			new(edam.Tags),
 		}
 		for i, val := range implementations {
@@ -36,10 +48,21 @@ func TestInterfaceImplementations(t *testing.T) {
 		}
 	})
 
+	t.Run("streams", func(t *testing.T) {
+		val := new(edam.Notes)
+		if _, ok := interface{}(val).(entity.RepoLocalStream); !ok {
+			t.Errorf("expected value of type %T to implement entity.RepoLocalStream", val)
+		}
+		if _, ok := interface{}(val).(entity.RepoRemoteStream); !ok {
+			t.Errorf("expected value of type %T to implement entity.RepoRemoteStream", val)
+		}
+	})
+
 	t.Run("members", func(t *testing.T) {
 		implementations := []interface{}{
 			new(edam.Notebook),
 			new(edam.Note),
+			new(edam.SavedSearch),
 			new(edam.Tag),
 		}
 		for i, val := range implementations {
@@ -63,7 +86,7 @@ func TestNotebooks(t *testing.T) {
 			resource        *edam.Notebook
 			ok              bool
 		)
-		repo, _ = edam.NewNotebooksRepo()
+		repo, _ = edam.NewNotebooksRepo(nil, nil)
 		if actualResources, err = readLocalFile(repo, _FixturesDir+"/"+_StubNotebooksFile); err != nil {
 			t.Fatal(err)
 		}
@@ -145,6 +168,28 @@ func TestNotebooks(t *testing.T) {
 	})
 }
 
+func TestLinkedOrigin(t *testing.T) {
+	const input = `[
+		{"ID": "nb-own", "Name": "Mine"},
+		{"ID": "nb-linked", "Name": "Team", "Origin": {"LinkedNotebookID": "ln-1", "ShareName": "Team Notes", "Username": "alice", "BusinessID": 7}}
+	]`
+	repo, _ := edam.NewNotebooksRepo(nil, nil)
+	resources, err := repo.ReadLocal(context.TODO(), strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("wrong length; got %d, expected %d", len(resources), 2)
+	}
+	if origin := resources[0].(*edam.Notebook).Origin; origin != nil {
+		t.Errorf("expected own notebook to have nil origin; got %+v", origin)
+	}
+	expected := entity.Origin{LinkedNotebookID: "ln-1", ShareName: "Team Notes", Username: "alice", BusinessID: 7}
+	if origin := resources[1].(*edam.Notebook).Origin; origin == nil || *origin != expected {
+		t.Errorf("wrong origin; got %+v, expected %+v", origin, expected)
+	}
+}
+
 func TestNotes(t *testing.T) {
 	t.Run("Read", func(t *testing.T) {
 		var (
@@ -154,7 +199,7 @@ func TestNotes(t *testing.T) {
 			resource        *edam.Note
 			ok              bool
 		)
-		repo, _ = edam.NewNotesRepo(nil)
+		repo, _ = edam.NewNotesRepo(nil, nil)
 		if actualResources, err = readLocalFile(repo, _FixturesDir+"/"+_StubNotesFile); err != nil {
 			t.Fatal(err)
 		}
@@ -403,6 +448,68 @@ func TestNotes(t *testing.T) {
 	})
 }
 
+func TestNotesRemoteQueryParams(t *testing.T) {
+	t.Run("Validate", func(t *testing.T) {
+		day := func(d int) time.Time { return time.Date(2020, 3, d, 0, 0, 0, 0, time.UTC) }
+		tests := []struct {
+			params edam.NotesRemoteQueryParams
+			ok     bool
+		}{
+			{params: edam.NotesRemoteQueryParams{}, ok: true},
+			{params: edam.NotesRemoteQueryParams{SortOrder: "updated", Descending: true}, ok: true},
+			{params: edam.NotesRemoteQueryParams{SortOrder: "TITLE"}, ok: true},
+			{params: edam.NotesRemoteQueryParams{SortOrder: "size"}, ok: false},
+			{params: edam.NotesRemoteQueryParams{CreatedAfter: day(1), CreatedBefore: day(7)}, ok: true},
+			{params: edam.NotesRemoteQueryParams{CreatedAfter: day(7), CreatedBefore: day(1)}, ok: false},
+			{params: edam.NotesRemoteQueryParams{UpdatedAfter: day(7)}, ok: true},
+			{params: edam.NotesRemoteQueryParams{UpdatedAfter: day(7), UpdatedBefore: day(7)}, ok: false},
+		}
+		for i, test := range tests {
+			err := test.params.Validate()
+			if test.ok && err != nil {
+				t.Errorf("test %d; unexpected error %v", i, err)
+			} else if !test.ok && err == nil {
+				t.Errorf("test %d; expected error but got none", i)
+			}
+		}
+	})
+}
+
+func TestSavedSearches(t *testing.T) {
+	t.Run("Read", func(t *testing.T) {
+		repo, _ := edam.NewSavedSearchesRepo(nil)
+		actualResources, err := readLocalFile(repo, _FixturesDir+"/"+_StubSearchesFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedResources := []*entity.SavedSearch{
+			{ID: "5d0ad3a5-4f0b-4a43-a2a3-6f0c1a3d5a11", Name: "Foo movies", Query: "tag:foo notebook:Movies"},
+			{ID: "0c6f0e8b-3b1d-4a8e-9d4d-8b1f2c1e7a22", Name: "Recent", Query: "created:day-7"},
+		}
+		if len(actualResources) < len(expectedResources) {
+			t.Fatalf(
+				"wrong length; got %d, expected at least %d",
+				len(actualResources), len(expectedResources),
+			)
+		}
+		for i, expected := range expectedResources {
+			resource, ok := actualResources[i].(*edam.SavedSearch)
+			if !ok {
+				t.Fatalf(
+					"test %d; wrong type; got %T, expected %T",
+					i, actualResources[i], &edam.SavedSearch{},
+				)
+			}
+			if *resource.SavedSearch != *expected {
+				t.Errorf("test %d; got %+v, expected %+v", i, *resource.SavedSearch, *expected)
+			}
+			if resource.GetID() != expected.ID {
+				t.Errorf("test %d; wrong service ID; got %q, expected %q", i, resource.GetID(), expected.ID)
+			}
+		}
+	})
+}
+
 func TestTags(t *testing.T) {
 	t.Run("Read", func(t *testing.T) {
 		var (
@@ -412,7 +519,7 @@ func TestTags(t *testing.T) {
 			resource        *edam.Tag
 			ok              bool
 		)
-		repo, _ = edam.NewTagsRepo()
+		repo, _ = edam.NewTagsRepo(nil, nil)
 		if actualResources, err = readLocalFile(repo, _FixturesDir+"/"+_StubTagsFile); err != nil {
 			t.Fatal(err)
 		}
@@ -488,3 +595,681 @@ func readLocalFile(repository entity.RepoLocal, filename string) (out []entity.L
 	out, err = repository.ReadLocal(context.TODO(), file)
 	return
 }
+
+func TestFetchRemote(t *testing.T) {
+	const token = "fake-token"
+
+	fixtures, err := fake.LoadFixtures(_FixturesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures.Linked = []*fake.LinkedFixtures{
+		{
+			ShareName: "Team Notes",
+			Username:  "alice",
+			Notebook:  &entity.Notebook{ID: "nb-linked", Name: "Team"},
+			Notes:     []*entity.Note{{ID: "note-linked", Title: "Shared note", NotebookID: "nb-linked", TagIDs: []string{"tag-linked"}, Content: "<en-note>hi</en-note>"}},
+			Tags:      []*entity.Tag{{ID: "tag-linked", Name: "shared"}},
+		},
+	}
+
+	// newClient starts a fake server with the fixtures and returns a Client
+	// connected to it.
+	newClient := func(t *testing.T, fixtures *fake.Fixtures) (*edam.Client, *fake.Server) {
+		t.Helper()
+		t.Setenv("EVERNOTE_SANDBOX_TOKEN", token)
+		srv := fake.NewServer(fixtures, token)
+		httpSrv := httptest.NewServer(srv)
+		t.Cleanup(httpSrv.Close)
+		return edam.NewClient(edam.CredentialsConfig{BaseURL: httpSrv.URL}), srv
+	}
+	ctx := context.Background()
+
+	t.Run("Notebooks", func(t *testing.T) {
+		client, _ := newClient(t, fixtures)
+		notebooks, _ := edam.NewNotebooksRepo(client, nil)
+		resources, err := notebooks.FetchRemote(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != len(fixtures.Notebooks) {
+			t.Fatalf("wrong length; got %d, expected %d", len(resources), len(fixtures.Notebooks))
+		}
+
+		notebooks, _ = edam.NewNotebooksRepo(client, &edam.NotebooksRemoteQueryParams{IncludeLinked: true})
+		if resources, err = notebooks.FetchRemote(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != len(fixtures.Notebooks)+1 {
+			t.Fatalf("wrong length; got %d, expected %d", len(resources), len(fixtures.Notebooks)+1)
+		}
+		linked := resources[len(resources)-1].(*edam.Notebook)
+		if linked.Origin == nil || linked.Origin.ShareName != "Team Notes" || linked.Origin.Username != "alice" {
+			t.Errorf("wrong origin for linked notebook; got %+v", linked.Origin)
+		}
+	})
+
+	t.Run("Notes", func(t *testing.T) {
+		client, srv := newClient(t, fixtures)
+		const pageSize = 5
+		notes, _ := edam.NewNotesRepo(client, &edam.NotesRemoteQueryParams{HiIndex: -1, PageSize: pageSize})
+		resources, err := notes.FetchRemote(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != len(fixtures.Notes) {
+			t.Fatalf("wrong length; got %d, expected %d", len(resources), len(fixtures.Notes))
+		}
+		seen := make(map[string]bool)
+		for i, res := range resources {
+			id := res.GetID()
+			if seen[id] {
+				t.Errorf("item[%d]; duplicate ID %q", i, id)
+			}
+			seen[id] = true
+			if res.(*edam.Note).Content == "" {
+				t.Errorf("item[%d]; expected non-empty Content", i)
+			}
+		}
+		// 1 call each for the user urls, each page of metadata, each note. The
+		// last page of metadata is empty, that's how the end is detected.
+		numPages := (len(fixtures.Notes)+pageSize-1)/pageSize + 1
+		if expected := 1 + numPages + len(fixtures.Notes); srv.NumCalls() != expected {
+			t.Errorf("wrong number of API calls; got %d, expected %d", srv.NumCalls(), expected)
+		}
+	})
+
+	t.Run("StreamNotes", func(t *testing.T) {
+		client, srv := newClient(t, fixtures)
+		const pageSize = 5
+		notes, _ := edam.NewNotesRepo(client, &edam.NotesRemoteQueryParams{HiIndex: -1, PageSize: pageSize})
+		var count int
+		for item, err := range notes.(entity.RepoRemoteStream).StreamRemote(ctx) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if item.(*edam.Note).Content == "" {
+				t.Errorf("item[%d]; expected non-empty Content", count)
+			}
+			if count++; count == 2 {
+				break
+			}
+		}
+		if count != 2 {
+			t.Fatalf("wrong count; got %d, expected %d", count, 2)
+		}
+		// Stopping early means that the rest of the notes aren't fetched: 1 call
+		// for the user urls, 1 for the first page of metadata, 1 per note.
+		if expected := 1 + 1 + count; srv.NumCalls() != expected {
+			t.Errorf("wrong number of API calls; got %d, expected %d", srv.NumCalls(), expected)
+		}
+	})
+
+	t.Run("NoteFilters", func(t *testing.T) {
+		const (
+			movies = "932d7c12-bb87-4b41-895a-5d30fa178688"
+			foo    = "ed18a1cf-e1f7-4d51-8d9b-f7201e60f564"
+			baker  = "53f1fdb5-4140-4ff4-8590-21e8cc2b4338"
+			batman = "8c44eeb1-7e50-4edb-95c4-12cf90d1017e"
+		)
+		withTrash := *fixtures
+		withTrash.Trashed = []*entity.Note{{ID: "note-trashed", Title: "Gone", NotebookID: movies, TagIDs: []string{foo, baker}, Content: "<en-note>bye</en-note>"}}
+		client, srv := newClient(t, &withTrash)
+
+		params := edam.NotesRemoteQueryParams{
+			HiIndex:        -1,
+			PageSize:       5,
+			Notebook:       "MOVIES",
+			Tags:           []string{"foo", baker},
+			Query:          "intitle:b",
+			CreatedAfter:   time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedBefore:  time.Date(2020, 3, 8, 12, 0, 0, 0, time.UTC),
+			IncludeTrashed: true,
+		}
+		notes, _ := edam.NewNotesRepo(client, &params)
+		// Names are resolved on each fetch, without piling up.
+		for run := 0; run < 2; run++ {
+			resources, err := notes.FetchRemote(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resources) != 2 || resources[0].GetID() != batman || resources[1].GetID() != "note-trashed" {
+				t.Errorf("run %d; expected a note, then a trashed note; got %v", run, resources)
+			}
+		}
+		if params.NotebookID != "" || params.TagIDs != nil {
+			t.Errorf("expected params to stay as they are; got %q, %q", params.NotebookID, params.TagIDs)
+		}
+
+		// Each search takes 2 pages, the second one is empty. The trash is
+		// searched after the active notes.
+		filters := srv.Filters()
+		if len(filters) != 8 {
+			t.Fatalf("wrong number of searches; got %d, expected %d", len(filters), 8)
+		}
+		const words = "intitle:b created:20200301T000000Z -updated:20200308T120000Z"
+		for i, filter := range filters {
+			if inactive := i%4 >= 2; filter.GetInactive() != inactive {
+				t.Errorf("filter %d; wrong inactive; got %t, expected %t", i, filter.GetInactive(), inactive)
+			}
+			if got := string(filter.GetNotebookGuid()); got != movies {
+				t.Errorf("filter %d; wrong notebook; got %q, expected %q", i, got, movies)
+			}
+			if got := fmt.Sprint(filter.GetTagGuids()); got != fmt.Sprint([]string{foo, baker}) {
+				t.Errorf("filter %d; wrong tags; got %s", i, got)
+			}
+			if filter.GetWords() != words {
+				t.Errorf("filter %d; wrong words; got %q, expected %q", i, filter.GetWords(), words)
+			}
+		}
+
+		notes, _ = edam.NewNotesRepo(client, &edam.NotesRemoteQueryParams{HiIndex: -1, PageSize: 5, Tags: []string{"nope"}})
+		if _, err := notes.FetchRemote(ctx); err == nil {
+			t.Error("expected an error for a tag name that doesn't exist")
+		}
+	})
+
+	t.Run("NoteVersions", func(t *testing.T) {
+		versions := []*entity.NoteVersion{
+			{UpdateSequenceNum: 12, Title: "Draft 2", Content: "<en-note>two</en-note>", UpdatedAt: time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC), SavedAt: time.Date(2020, 3, 2, 1, 0, 0, 0, time.UTC)},
+			{UpdateSequenceNum: 7, Title: "Draft 1", Content: "<en-note>one</en-note>", UpdatedAt: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), SavedAt: time.Date(2020, 3, 1, 1, 0, 0, 0, time.UTC)},
+		}
+		client, srv := newClient(t, &fake.Fixtures{
+			Notebooks: []*entity.Notebook{{ID: "nb", Name: "Audit"}},
+			Notes: []*entity.Note{
+				{ID: "note-1", Title: "Final", NotebookID: "nb", Content: "<en-note>three</en-note>", Versions: versions},
+				{ID: "note-2", Title: "Unedited", NotebookID: "nb", Content: "<en-note>once</en-note>"},
+			},
+		})
+
+		notes, _ := edam.NewNotesRepo(client, &edam.NotesRemoteQueryParams{HiIndex: -1, PageSize: 10, IncludeVersions: true})
+		resources, err := notes.FetchRemote(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != 2 {
+			t.Fatalf("wrong length; got %d, expected %d", len(resources), 2)
+		}
+		actual := resources[0].(*edam.Note).Versions
+		if len(actual) != len(versions) {
+			t.Fatalf("wrong number of versions; got %d, expected %d", len(actual), len(versions))
+		}
+		for i, version := range actual {
+			if *version != *versions[i] {
+				t.Errorf("version[%d] wrong\ngot      %+v\nexpected %+v", i, *version, *versions[i])
+			}
+		}
+		if got := resources[1].(*edam.Note).Versions; len(got) != 0 {
+			t.Errorf("expected no versions for unedited note; got %d", len(got))
+		}
+
+		// Without note history, the notes are fetched without versions, and
+		// versions are only asked for once.
+		withoutVersions, _ := edam.NewNotesRepo(client, &edam.NotesRemoteQueryParams{HiIndex: -1, PageSize: 10})
+		numCalls := srv.NumCalls()
+		if _, err = withoutVersions.FetchRemote(ctx); err != nil {
+			t.Fatal(err)
+		}
+		numCallsWithoutVersions := srv.NumCalls() - numCalls
+
+		srv.SetError("ListNoteVersions", &edamapi.EDAMUserException{ErrorCode: edamapi.EDAMErrorCode_PERMISSION_DENIED})
+		numCalls = srv.NumCalls()
+		if resources, err = notes.FetchRemote(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for i, res := range resources {
+			if got := res.(*edam.Note).Versions; len(got) != 0 {
+				t.Errorf("item[%d]; expected no versions; got %d", i, len(got))
+			}
+		}
+		if got, expected := srv.NumCalls()-numCalls, numCallsWithoutVersions+1; got != expected {
+			t.Errorf("wrong number of API calls; got %d, expected %d", got, expected)
+		}
+
+		srv.SetError("ListNoteVersions", &edamapi.EDAMSystemException{ErrorCode: edamapi.EDAMErrorCode_INTERNAL_ERROR})
+		if _, err = notes.FetchRemote(ctx); !errors.Is(err, repo.Error) {
+			t.Errorf("expected error %v, got %v", repo.Error, err)
+		}
+	})
+
+	t.Run("SavedSearches", func(t *testing.T) {
+		client, _ := newClient(t, fixtures)
+		searches, _ := edam.NewSavedSearchesRepo(client)
+		resources, err := searches.FetchRemote(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != len(fixtures.SavedSearches) {
+			t.Fatalf("wrong length; got %d, expected %d", len(resources), len(fixtures.SavedSearches))
+		}
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		client, _ := newClient(t, fixtures)
+		tags, _ := edam.NewTagsRepo(client, &edam.TagsRemoteQueryParams{IncludeLinked: true})
+		resources, err := tags.FetchRemote(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != len(fixtures.Tags)+1 {
+			t.Fatalf("wrong length; got %d, expected %d", len(resources), len(fixtures.Tags)+1)
+		}
+	})
+
+	t.Run("LinkedNotebooks", func(t *testing.T) {
+		// One of each kind of linked notebook, which are reached differently.
+		linkedFixtures := &fake.Fixtures{
+			Linked: []*fake.LinkedFixtures{
+				{ShareName: "Shared", Username: "alice", Notebook: &entity.Notebook{ID: "nb-shared", Name: "Shared"}},
+				{ShareName: "Public", Username: "bob", Public: true, Notebook: &entity.Notebook{ID: "nb-public", Name: "Public"}},
+				{ShareName: "Business", Username: "carol", BusinessID: 7, Notebook: &entity.Notebook{ID: "nb-business", Name: "Business"}},
+			},
+		}
+		for _, linked := range linkedFixtures.Linked {
+			id := linked.Notebook.ID
+			linked.Notes = []*entity.Note{{ID: "note-" + id, Title: linked.ShareName, NotebookID: id, Content: "<en-note>hi</en-note>"}}
+		}
+		linkedFixtures.Linked[0].Tags = []*entity.Tag{{ID: "tag-team", Name: "team"}}
+		linkedFixtures.Linked[0].Notes[0].TagIDs = []string{"tag-team"}
+		client, srv := newClient(t, linkedFixtures)
+
+		notebooks, _ := edam.NewNotebooksRepo(client, &edam.NotebooksRemoteQueryParams{IncludeLinked: true})
+		resources, err := notebooks.FetchRemote(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != len(linkedFixtures.Linked) {
+			t.Fatalf("wrong length; got %d, expected %d", len(resources), len(linkedFixtures.Linked))
+		}
+		for i, res := range resources {
+			linked := linkedFixtures.Linked[i]
+			origin := res.(*edam.Notebook).Origin
+			if res.GetID() != linked.Notebook.ID {
+				t.Errorf("item[%d]; wrong ID; got %q, expected %q", i, res.GetID(), linked.Notebook.ID)
+			}
+			if origin == nil || origin.Username != linked.Username || origin.BusinessID != linked.BusinessID {
+				t.Errorf("item[%d]; wrong origin; got %+v", i, origin)
+			}
+		}
+
+		notes, _ := edam.NewNotesRepo(client, &edam.NotesRemoteQueryParams{HiIndex: -1, PageSize: 10, IncludeLinked: true})
+		if resources, err = notes.FetchRemote(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != len(linkedFixtures.Linked) {
+			t.Fatalf("wrong number of notes; got %d, expected %d", len(resources), len(linkedFixtures.Linked))
+		}
+		// The search of the user's own notes comes first, then each linked
+		// notebook has a search of its own. A search that finds notes takes a
+		// second, empty page with the same filter.
+		filters := slices.CompactFunc(srv.Filters(), func(a, b *edamapi.NoteFilter) bool {
+			return a.GetNotebookGuid() == b.GetNotebookGuid()
+		})
+		if len(filters) != 1+len(linkedFixtures.Linked) {
+			t.Fatalf("wrong number of searches; got %d, expected %d", len(filters), 1+len(linkedFixtures.Linked))
+		}
+		if filters[0].IsSetNotebookGuid() {
+			t.Errorf("expected no notebook in search of own notes; got %q", filters[0].GetNotebookGuid())
+		}
+		for i, linked := range linkedFixtures.Linked {
+			if got := string(filters[i+1].GetNotebookGuid()); got != linked.Notebook.ID {
+				t.Errorf("filter %d; wrong notebook; got %q, expected %q", i+1, got, linked.Notebook.ID)
+			}
+		}
+
+		// Names of linked notebooks and their tags are resolved too. A
+		// linked notebook is searched when it's named, even without
+		// IncludeLinked.
+		for _, test := range []struct {
+			name     string
+			params   edam.NotesRemoteQueryParams
+			expected string
+		}{
+			{name: "notebook", params: edam.NotesRemoteQueryParams{Notebook: "BUSINESS"}, expected: "note-nb-business"},
+			{name: "tag", params: edam.NotesRemoteQueryParams{Tags: []string{"Team"}, IncludeLinked: true}, expected: "note-nb-shared"},
+			{name: "notebook and tag", params: edam.NotesRemoteQueryParams{Notebook: "Shared", Tags: []string{"team"}}, expected: "note-nb-shared"},
+		} {
+			test.params.HiIndex, test.params.PageSize = -1, 10
+			notes, _ := edam.NewNotesRepo(client, &test.params)
+			resources, err := notes.FetchRemote(ctx)
+			if err != nil {
+				t.Errorf("%s; %v", test.name, err)
+				continue
+			}
+			if len(resources) != 1 || resources[0].GetID() != test.expected {
+				t.Errorf("%s; expected only %q; got %v", test.name, test.expected, resources)
+			}
+		}
+		for _, params := range []edam.NotesRemoteQueryParams{
+			{Notebook: "nope", IncludeLinked: true},
+			{Notebook: "Public", Tags: []string{"team"}},
+		} {
+			params.HiIndex, params.PageSize = -1, 10
+			notes, _ := edam.NewNotesRepo(client, &params)
+			if _, err := notes.FetchRemote(ctx); err == nil {
+				t.Errorf("expected an error for names that don't exist together; %q, %q", params.Notebook, params.Tags)
+			}
+		}
+
+		// Without a business, the business notebook is skipped and the
+		// others are still reached.
+		srv.SetError("AuthenticateToBusiness", &edamapi.EDAMUserException{ErrorCode: edamapi.EDAMErrorCode_PERMISSION_DENIED})
+		if resources, err = notebooks.FetchRemote(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != len(linkedFixtures.Linked)-1 {
+			t.Errorf("wrong length; got %d, expected %d", len(resources), len(linkedFixtures.Linked)-1)
+		}
+	})
+
+	t.Run("multiple accounts", func(t *testing.T) {
+		// Credentials come only from the env files.
+		t.Setenv("EVERNOTE_SANDBOX_TOKEN", "")
+		os.Unsetenv("EVERNOTE_SANDBOX_TOKEN")
+
+		accounts := []struct {
+			token    string
+			fixtures *fake.Fixtures
+		}{
+			{token: "personal", fixtures: fixtures},
+			{token: "business", fixtures: &fake.Fixtures{Notebooks: fixtures.Notebooks[:1]}},
+		}
+		clients := make([]*edam.Client, len(accounts))
+		for i, acct := range accounts {
+			httpSrv := httptest.NewServer(fake.NewServer(acct.fixtures, acct.token))
+			t.Cleanup(httpSrv.Close)
+			envfile := filepath.Join(t.TempDir(), "env")
+			if err := os.WriteFile(envfile, []byte("EVERNOTE_SANDBOX_TOKEN="+acct.token+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			clients[i] = edam.NewClient(edam.CredentialsConfig{EnvFilename: envfile, BaseURL: httpSrv.URL})
+		}
+
+		var wg sync.WaitGroup
+		results := make([][]entity.LinkID, len(clients))
+		errs := make([]error, len(clients))
+		for i, client := range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				notebooks, _ := edam.NewNotebooksRepo(client, nil)
+				results[i], errs[i] = notebooks.FetchRemote(ctx)
+			}()
+		}
+		wg.Wait()
+		for i, acct := range accounts {
+			if errs[i] != nil {
+				t.Errorf("account %q; %v", acct.token, errs[i])
+				continue
+			}
+			if len(results[i]) != len(acct.fixtures.Notebooks) {
+				t.Errorf("account %q; wrong length; got %d, expected %d", acct.token, len(results[i]), len(acct.fixtures.Notebooks))
+			}
+		}
+	})
+
+	t.Run("one client, concurrent requests", func(t *testing.T) {
+		client, _ := newClient(t, fixtures)
+		const numRequests = 8
+		var wg sync.WaitGroup
+		results := make([]int, numRequests)
+		errs := make([]error, numRequests)
+		for i := range numRequests {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var resources []entity.LinkID
+				if i%2 == 0 {
+					notebooks, _ := edam.NewNotebooksRepo(client, nil)
+					resources, errs[i] = notebooks.FetchRemote(ctx)
+				} else {
+					tags, _ := edam.NewTagsRepo(client, nil)
+					resources, errs[i] = tags.FetchRemote(ctx)
+				}
+				results[i] = len(resources)
+			}()
+		}
+		wg.Wait()
+		for i := range numRequests {
+			expected := len(fixtures.Notebooks)
+			if i%2 != 0 {
+				expected = len(fixtures.Tags)
+			}
+			if errs[i] != nil {
+				t.Errorf("request %d; %v", i, errs[i])
+			} else if results[i] != expected {
+				t.Errorf("request %d; wrong length; got %d, expected %d", i, results[i], expected)
+			}
+		}
+	})
+
+	t.Run("credential sources", func(t *testing.T) {
+		const secretToken = "secret-token"
+		httpSrv := httptest.NewServer(fake.NewServer(&fake.Fixtures{Notebooks: fixtures.Notebooks}, secretToken))
+		t.Cleanup(httpSrv.Close)
+
+		dir := t.TempDir()
+		encryptedFile := filepath.Join(dir, "envfile.age")
+		err := edam.MakeEncryptedEnvFile(encryptedFile, "hunter2", map[string]string{"EVERNOTE_SANDBOX_TOKEN": secretToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = edam.MakeEncryptedEnvFile(encryptedFile, "hunter2", nil); err == nil {
+			t.Error("expected error when env file already exists")
+		}
+		// A stand-in for secret-tool, which prints the token for the expected
+		// attributes and otherwise exits non-zero without output.
+		script := "#!/bin/sh\n[ \"$*\" = \"lookup service notexfr account EVERNOTE_SANDBOX_TOKEN\" ] && printf '" + secretToken + "\\n' || exit 1\n"
+		if err = os.WriteFile(filepath.Join(dir, "secret-tool"), []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		passphrase := func(val string) func() (string, error) {
+			return func() (string, error) { return val, nil }
+		}
+		tests := []struct {
+			name   string
+			source edam.CredentialSource
+			expErr bool
+		}{
+			{name: "encrypted env file", source: &edam.EnvFileSource{Filename: encryptedFile, Passphrase: passphrase("hunter2")}},
+			{name: "wrong passphrase", source: &edam.EnvFileSource{Filename: encryptedFile, Passphrase: passphrase("nope")}, expErr: true},
+			{name: "no passphrase", source: &edam.EnvFileSource{Filename: encryptedFile}, expErr: true},
+			{name: "token command", source: &edam.CommandSource{Command: "printf '" + secretToken + "\\nmore stuff\\n'"}},
+			{name: "failed token command", source: &edam.CommandSource{Command: "exit 1"}, expErr: true},
+			{name: "keyring", source: &edam.KeyringSource{}},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				t.Setenv("EVERNOTE_SANDBOX_TOKEN", "")
+				t.Setenv("PATH", dir+string(filepath.ListSeparator)+os.Getenv("PATH"))
+				client := edam.NewClient(edam.CredentialsConfig{
+					BaseURL: httpSrv.URL,
+					Sources: []edam.CredentialSource{test.source},
+				})
+				notebooks, _ := edam.NewNotebooksRepo(client, nil)
+				resources, err := notebooks.FetchRemote(ctx)
+				if test.expErr {
+					if err == nil {
+						t.Fatal("expected an error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(resources) != len(fixtures.Notebooks) {
+					t.Errorf("wrong length; got %d, expected %d", len(resources), len(fixtures.Notebooks))
+				}
+			})
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		t.Run("no client", func(t *testing.T) {
+			notebooks, _ := edam.NewNotebooksRepo(nil, nil)
+			if _, err := notebooks.FetchRemote(ctx); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+
+		t.Run("rate limit", func(t *testing.T) {
+			client, srv := newClient(t, fixtures)
+			srv.SetRateLimit(1, 30*time.Second)
+			notebooks, _ := edam.NewNotebooksRepo(client, nil)
+			_, err := notebooks.FetchRemote(ctx)
+			if !errors.Is(err, repo.Error) {
+				t.Fatalf("expected error to wrap %v; got %v", repo.Error, err)
+			}
+			if !strings.Contains(err.Error(), "RATE_LIMIT_REACHED") {
+				t.Errorf("expected error to mention rate limit; got %v", err)
+			}
+		})
+
+		t.Run("note errors", func(t *testing.T) {
+			client, srv := newClient(t, fixtures)
+			key := "guid"
+			srv.SetError("GetNoteWithResultSpec", &edamapi.EDAMNotFoundException{Identifier: &key})
+			notes, _ := edam.NewNotesRepo(client, &edam.NotesRemoteQueryParams{HiIndex: -1, PageSize: 10})
+			var ids []string
+			for _, err := range notes.(entity.RepoRemoteStream).StreamRemote(ctx) {
+				var nerr *entity.NoteError
+				if !errors.As(err, &nerr) {
+					t.Fatalf("expected a %T; got %v", nerr, err)
+				}
+				if nerr.Stage != entity.StageFetch || nerr.Title == "" {
+					t.Errorf("wrong note error; got %+v", nerr)
+				}
+				ids = append(ids, nerr.ID)
+			}
+			if len(ids) != len(fixtures.Notes) || ids[0] != fixtures.Notes[0].ID {
+				t.Errorf("expected an error for each note; got %q", ids)
+			}
+
+			// A server-side error is the same for every note.
+			srv.SetError("GetNoteWithResultSpec", &edamapi.EDAMSystemException{ErrorCode: edamapi.EDAMErrorCode_INTERNAL_ERROR})
+			var numErrs int
+			for _, err := range notes.(entity.RepoRemoteStream).StreamRemote(ctx) {
+				var nerr *entity.NoteError
+				if errors.As(err, &nerr) || !errors.Is(err, repo.Error) {
+					t.Errorf("expected a server-side error; got %v", err)
+				}
+				numErrs++
+			}
+			if numErrs != 1 {
+				t.Errorf("expected stream to stop after first error; got %d errors", numErrs)
+			}
+		})
+
+		t.Run("invalid token", func(t *testing.T) {
+			client, _ := newClient(t, fixtures)
+			t.Setenv("EVERNOTE_SANDBOX_TOKEN", "wrong")
+			notebooks, _ := edam.NewNotebooksRepo(client, nil)
+			_, err := notebooks.FetchRemote(ctx)
+			if !errors.Is(err, repo.Error) {
+				t.Fatalf("expected error to wrap %v; got %v", repo.Error, err)
+			}
+			if !strings.Contains(err.Error(), "INVALID_AUTH") {
+				t.Errorf("expected error to mention auth; got %v", err)
+			}
+		})
+
+		t.Run("injected", func(t *testing.T) {
+			client, srv := newClient(t, fixtures)
+			srv.SetError("ListSearches", &edamapi.EDAMUserException{ErrorCode: edamapi.EDAMErrorCode_PERMISSION_DENIED})
+			searches, _ := edam.NewSavedSearchesRepo(client)
+			_, err := searches.FetchRemote(ctx)
+			if !errors.Is(err, repo.Error) {
+				t.Fatalf("expected error to wrap %v; got %v", repo.Error, err)
+			}
+			if !strings.Contains(err.Error(), "PERMISSION_DENIED") {
+				t.Errorf("expected error to mention permission; got %v", err)
+			}
+		})
+	})
+}
+
+func TestLogin(t *testing.T) {
+	const token = "oauth-token"
+	ctx := context.Background()
+
+	// authorize plays the part of the user, who visits the authorization
+	// page and is redirected to the callback.
+	authorize := func(ctx context.Context, authorizeURL string) error {
+		resp, err := http.Get(authorizeURL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	}
+	setup := func(t *testing.T) (*fake.Server, edam.CredentialsConfig) {
+		t.Helper()
+		t.Setenv("EVERNOTE_SANDBOX_TOKEN", "")
+		srv := fake.NewServer(nil, token)
+		httpSrv := httptest.NewServer(srv)
+		t.Cleanup(httpSrv.Close)
+		envfile := filepath.Join(t.TempDir(), "env")
+		if err := os.WriteFile(envfile, []byte("EVERNOTE_SANDBOX_KEY=key\nEVERNOTE_SANDBOX_SECRET=secret\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return srv, edam.CredentialsConfig{
+			BaseURL: httpSrv.URL,
+			Sources: []edam.CredentialSource{&edam.EnvFileSource{Filename: envfile}},
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		_, conf := setup(t)
+		result, err := edam.Login(ctx, conf, edam.LoginParams{Authorize: authorize})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.TokenName != "EVERNOTE_SANDBOX_TOKEN" {
+			t.Errorf("wrong TokenName; got %q", result.TokenName)
+		}
+		if !result.Expires.After(time.Now()) {
+			t.Errorf("expected Expires to be in the future; got %v", result.Expires)
+		}
+
+		// The token is in the env file and it works.
+		envfile := conf.Sources[0].(*edam.EnvFileSource).Filename
+		if info, err := os.Stat(envfile); err != nil {
+			t.Fatal(err)
+		} else if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("wrong permissions of env file; got %o, expected %o", perm, 0600)
+		}
+		client := edam.NewClient(edam.CredentialsConfig{BaseURL: conf.BaseURL, EnvFilename: envfile})
+		notebooks, _ := edam.NewNotebooksRepo(client, nil)
+		if _, err = notebooks.FetchRemote(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		srv, conf := setup(t)
+		srv.SetOAuthDenied(true)
+		if _, err := edam.Login(ctx, conf, edam.LoginParams{Authorize: authorize}); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("no credential store", func(t *testing.T) {
+		_, conf := setup(t)
+		conf.Sources = []edam.CredentialSource{&edam.CommandSource{Command: "echo nope"}}
+		called := false
+		_, err := edam.Login(ctx, conf, edam.LoginParams{Authorize: func(context.Context, string) error {
+			called = true
+			return nil
+		}})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if called {
+			t.Error("should not ask for authorization without a place to store the token")
+		}
+	})
+}
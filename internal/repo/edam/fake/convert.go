@@ -0,0 +1,91 @@
+package fake
+
+import (
+	"crypto/md5"
+	"time"
+
+	"github.com/dreampuf/evernote-sdk-golang/edam"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+)
+
+// These functions convert entity types to the equivalent EDAM types. They're
+// the inverse of the conversions in the parent package.
+
+func toTimestamp(t time.Time) *edam.Timestamp {
+	out := edam.Timestamp(t.UnixMilli())
+	return &out
+}
+
+func fromTimestamp(t edam.Timestamp) time.Time { return time.UnixMilli(int64(t)).UTC() }
+
+func toNotebook(in *entity.Notebook) *edam.Notebook {
+	guid := edam.GUID(in.ID)
+	out := &edam.Notebook{
+		GUID:           &guid,
+		Name:           &in.Name,
+		ServiceCreated: toTimestamp(in.CreatedAt),
+		ServiceUpdated: toTimestamp(in.UpdatedAt),
+	}
+	if in.Stack != "" {
+		out.Stack = &in.Stack
+	}
+	return out
+}
+
+func toTag(in *entity.Tag) *edam.Tag {
+	guid := edam.GUID(in.ID)
+	out := &edam.Tag{GUID: &guid, Name: &in.Name}
+	if in.ParentID != "" {
+		parent := edam.GUID(in.ParentID)
+		out.ParentGuid = &parent
+	}
+	return out
+}
+
+func toNoteMetadata(in *entity.Note) *edam.NoteMetadata {
+	contentLength := int32(len(in.Content))
+	notebookID := in.NotebookID
+	out := &edam.NoteMetadata{
+		GUID:          edam.GUID(in.ID),
+		Title:         &in.Title,
+		ContentLength: &contentLength,
+		Created:       toTimestamp(in.CreatedAt),
+		Updated:       toTimestamp(in.UpdatedAt),
+		NotebookGuid:  &notebookID,
+		TagGuids:      make([]edam.GUID, len(in.TagIDs)),
+		Attributes:    &edam.NoteAttributes{},
+	}
+	for i, id := range in.TagIDs {
+		out.TagGuids[i] = edam.GUID(id)
+	}
+	if attrs := in.Attributes; attrs != nil {
+		out.Attributes = &edam.NoteAttributes{
+			ContentClass:      optional(attrs.ContentClass),
+			Source:            optional(attrs.Source),
+			SourceApplication: optional(attrs.SourceApplication),
+			SourceURL:         optional(attrs.SourceURL),
+		}
+	}
+	return out
+}
+
+func toResource(in *entity.Attachment) *edam.Resource {
+	size := int32(len(in.Data))
+	hash := md5.Sum(in.Data)
+	out := &edam.Resource{
+		Data: &edam.Data{Body: in.Data, BodyHash: hash[:], Size: &size},
+		Mime: &in.MIME,
+	}
+	if in.Filename != "" {
+		out.Attributes = &edam.ResourceAttributes{FileName: &in.Filename}
+	}
+	return out
+}
+
+// optional is for optional string fields, which are unset when empty.
+func optional(in string) *string {
+	if in == "" {
+		return nil
+	}
+	return &in
+}
@@ -0,0 +1,146 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dreampuf/evernote-sdk-golang/edam"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+)
+
+// These methods create data in the NoteStore. New data is kept in the
+// Fixtures of the Server, so it's visible to subsequent requests.
+
+func (n *noteStore) CreateNotebook(ctx context.Context, authenticationToken string, notebook *edam.Notebook) (*edam.Notebook, error) {
+	if err := n.check("CreateNotebook", authenticationToken); err != nil {
+		return nil, err
+	}
+	if n.linkedIndex >= 0 {
+		return nil, permissionDenied("Notebook")
+	}
+	n.srv.mu.Lock()
+	defer n.srv.mu.Unlock()
+	fixtures := n.srv.fixtures
+	for _, existing := range fixtures.Notebooks {
+		if strings.EqualFold(existing.Name, notebook.GetName()) {
+			return nil, dataConflict("Notebook.name")
+		}
+	}
+	created := &entity.Notebook{
+		ID:    n.srv.newGUID("notebook"),
+		Name:  notebook.GetName(),
+		Stack: notebook.GetStack(),
+	}
+	fixtures.Notebooks = append(fixtures.Notebooks, created)
+	return toNotebook(created), nil
+}
+
+func (n *noteStore) CreateTag(ctx context.Context, authenticationToken string, tag *edam.Tag) (*edam.Tag, error) {
+	if err := n.check("CreateTag", authenticationToken); err != nil {
+		return nil, err
+	}
+	n.srv.mu.Lock()
+	defer n.srv.mu.Unlock()
+	tags := &n.srv.fixtures.Tags
+	if n.linkedIndex >= 0 {
+		tags = &n.srv.fixtures.Linked[n.linkedIndex].Tags
+	}
+	for _, existing := range *tags {
+		if strings.EqualFold(existing.Name, tag.GetName()) {
+			return nil, dataConflict("Tag.name")
+		}
+	}
+	created := &entity.Tag{ID: n.srv.newGUID("tag"), Name: tag.GetName(), ParentID: string(tag.GetParentGuid())}
+	*tags = append(*tags, created)
+	return toTag(created), nil
+}
+
+var enMediaHash = regexp.MustCompile(`<en-media[^>]*\shash="([0-9a-fA-F]{32})"`)
+
+// CreateNote checks the content loosely. It must have the ENML prolog and
+// root element, and each en-media element must refer to a resource.
+func (n *noteStore) CreateNote(ctx context.Context, authenticationToken string, note *edam.Note) (*edam.Note, error) {
+	if err := n.check("CreateNote", authenticationToken); err != nil {
+		return nil, err
+	}
+	content := note.GetContent()
+	if !strings.HasPrefix(content, "<?xml") || !strings.Contains(content, "<!DOCTYPE en-note") || !strings.Contains(content, "<en-note") {
+		return nil, enmlInvalid("missing ENML prolog or en-note element")
+	}
+	hashes := make(map[string]bool)
+	attachments := make([]*entity.Attachment, len(note.GetResources()))
+	for i, res := range note.GetResources() {
+		hashes[fmt.Sprintf("%x", res.GetData().GetBodyHash())] = true
+		attachments[i] = &entity.Attachment{
+			Filename: res.GetAttributes().GetFileName(),
+			MIME:     res.GetMime(),
+			Data:     res.GetData().GetBody(),
+		}
+	}
+	for _, match := range enMediaHash.FindAllStringSubmatch(content, -1) {
+		if !hashes[strings.ToLower(match[1])] {
+			return nil, enmlInvalid("en-media hash " + match[1] + " has no resource")
+		}
+	}
+
+	n.srv.mu.Lock()
+	defer n.srv.mu.Unlock()
+	notes := &n.srv.fixtures.Notes
+	notebooks := n.srv.fixtures.Notebooks
+	if n.linkedIndex >= 0 {
+		notes = &n.srv.fixtures.Linked[n.linkedIndex].Notes
+		notebooks = []*entity.Notebook{n.srv.fixtures.Linked[n.linkedIndex].Notebook}
+	}
+	notebookID := note.GetNotebookGuid()
+	if notebookID == "" && len(notebooks) > 0 {
+		notebookID = notebooks[0].ID
+	}
+	found := false
+	for _, notebook := range notebooks {
+		found = found || notebook.ID == notebookID
+	}
+	if !found {
+		return nil, notFound("Note.notebookGuid", notebookID)
+	}
+
+	created := &entity.Note{
+		ID:          n.srv.newGUID("note"),
+		Title:       note.GetTitle(),
+		NotebookID:  notebookID,
+		TagIDs:      make([]string, len(note.GetTagGuids())),
+		Content:     content,
+		Attachments: attachments,
+	}
+	if note.IsSetCreated() {
+		created.CreatedAt = fromTimestamp(note.GetCreated())
+	}
+	if note.IsSetUpdated() {
+		created.UpdatedAt = fromTimestamp(note.GetUpdated())
+	}
+	for i, id := range note.GetTagGuids() {
+		created.TagIDs[i] = string(id)
+	}
+	*notes = append(*notes, created)
+	guid := edam.GUID(created.ID)
+	return &edam.Note{GUID: &guid, Title: &created.Title, NotebookGuid: &notebookID}, nil
+}
+
+// newGUID makes up an identifier for created data. Callers must hold the lock.
+func (s *Server) newGUID(kind string) string {
+	s.numCreated++
+	return fmt.Sprintf("created-%s-%d", kind, s.numCreated)
+}
+
+func dataConflict(parameter string) error {
+	return &edam.EDAMUserException{ErrorCode: edam.EDAMErrorCode_DATA_CONFLICT, Parameter: &parameter}
+}
+
+func enmlInvalid(parameter string) error {
+	return &edam.EDAMUserException{ErrorCode: edam.EDAMErrorCode_ENML_VALIDATION, Parameter: &parameter}
+}
+
+func permissionDenied(parameter string) error {
+	return &edam.EDAMUserException{ErrorCode: edam.EDAMErrorCode_PERMISSION_DENIED, Parameter: &parameter}
+}
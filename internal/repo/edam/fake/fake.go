@@ -0,0 +1,722 @@
+// Package fake is an in-process stand-in for the Evernote API, otherwise known
+// as EDAM. It speaks the same Thrift-over-HTTP protocol as the UserStore and
+// NoteStore services, but serves fixture data from memory. Use it to exercise
+// code that talks to Evernote without any network access or credentials, for
+// example in tests or for a dry run of a command. It also stands in for the
+// OAuth endpoints, where the user always grants access right away.
+//
+// Only the parts of the API used by this module are implemented. Calling
+// anything else responds with an HTTP error. Searches do not evaluate the
+// Evernote search grammar, so the words of a NoteFilter are ignored.
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/dreampuf/evernote-sdk-golang/edam"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	edamrepo "github.com/rafaelespinoza/notexfr/internal/repo/edam"
+)
+
+// Fixtures is the data served by a Server.
+type Fixtures struct {
+	// UserID identifies the user of the account. It's 1 when it's zero.
+	UserID        int32
+	Notebooks     []*entity.Notebook
+	Notes         []*entity.Note
+	Tags          []*entity.Tag
+	SavedSearches []*entity.SavedSearch
+	// Linked are notebooks owned by other accounts, which are readable by
+	// the user through LinkedNotebooks.
+	Linked []*LinkedFixtures
+	// Trashed are notes in the trash of the user's account. Only searches for
+	// inactive notes find them.
+	Trashed []*entity.Note
+}
+
+// LinkedFixtures is the data for one notebook that is owned by another
+// account and shared with the user.
+type LinkedFixtures struct {
+	ShareName string
+	Username  string
+	// Public is for a published notebook, which is read by its URI without
+	// authentication, rather than shared with the user.
+	Public bool
+	// BusinessID is set for a notebook of a business. It's only reachable
+	// with the token from authenticating to the business.
+	BusinessID int32
+	Notebook   *entity.Notebook
+	Notes      []*entity.Note
+	Tags       []*entity.Tag
+}
+
+// LoadFixtures reads fixture files in dir, which are named like the output of
+// the "edam" subcommands, such as edamrepo.NotesFilename. A file that doesn't
+// exist is treated as an empty list.
+func LoadFixtures(dir string) (out *Fixtures, err error) {
+	out = &Fixtures{}
+	inputs := []struct {
+		filename string
+		target   any
+	}{
+		{filename: edamrepo.NotebooksFilename, target: &out.Notebooks},
+		{filename: edamrepo.NotesFilename, target: &out.Notes},
+		{filename: edamrepo.SavedSearchesFilename, target: &out.SavedSearches},
+		{filename: edamrepo.TagsFilename, target: &out.Tags},
+	}
+	for _, input := range inputs {
+		data, rerr := os.ReadFile(filepath.Join(dir, input.filename))
+		if errors.Is(rerr, os.ErrNotExist) {
+			continue
+		} else if rerr != nil {
+			err = rerr
+			return
+		}
+		if err = json.Unmarshal(data, input.target); err != nil {
+			err = fmt.Errorf("%w; file %q", err, input.filename)
+			return
+		}
+	}
+	return
+}
+
+// These are URL paths served by a Server.
+const (
+	UserStorePath = "/edam/user"
+	NoteStorePath = "/shard/s1/notestore"
+	// OAuthPath is for temporary credentials and access tokens.
+	OAuthPath = "/oauth"
+	// OAuthAuthorizePath is where the user would grant access.
+	OAuthAuthorizePath = "/OAuth.action"
+)
+
+// Server is an http.Handler for the fake UserStore and NoteStore services,
+// and for the OAuth flow.
+// Point an EDAM client at it by using the base URL of the listener, such as
+// the URL field of an httptest.Server. It's safe for concurrent use.
+type Server struct {
+	mu       sync.Mutex
+	fixtures *Fixtures
+	token    string
+	numCalls int
+	// numCreated is for making up GUIDs of created data.
+	numCreated int
+	rateLimit  struct {
+		after    int
+		duration time.Duration
+	}
+	errs  map[string]error
+	oauth oauthState
+	// filters are of the searches for notes, in order.
+	filters []*edam.NoteFilter
+}
+
+// NewServer constructs a Server. If token is non-empty, then each request
+// must authenticate with it. Otherwise, any non-empty token is accepted.
+func NewServer(fixtures *Fixtures, token string) *Server {
+	if fixtures == nil {
+		fixtures = &Fixtures{}
+	}
+	out := Server{fixtures: fixtures, token: token, errs: make(map[string]error)}
+	out.oauth.callbacks = make(map[string]string)
+	out.oauth.verifiers = make(map[string]string)
+	out.rateLimit.after = -1
+	return &out
+}
+
+// SetRateLimit makes each API call after the first n calls fail with an
+// EDAMSystemException, with an error code of RATE_LIMIT_REACHED. A negative n
+// removes the rate limit.
+func (s *Server) SetRateLimit(n int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimit.after, s.rateLimit.duration = n, retryAfter
+}
+
+// SetError makes each call to the named API method, such as "ListNotebooks",
+// fail with err. A nil err removes it.
+func (s *Server) SetError(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.errs, method)
+	} else {
+		s.errs[method] = err
+	}
+}
+
+// Filters are the NoteFilters of the searches for notes made so far, in
+// order.
+func (s *Server) Filters() []*edam.NoteFilter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.filters)
+}
+
+// NumCalls is the number of API calls made so far.
+func (s *Server) NumCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.numCalls
+}
+
+type baseURLKey struct{}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var processor thrift.TProcessor
+	switch path := r.URL.Path; {
+	case path == UserStorePath:
+		processor = edam.NewUserStoreProcessor(&userStore{srv: s})
+	case path == NoteStorePath:
+		processor = edam.NewNoteStoreProcessor(s.ownNoteStore())
+	case path == OAuthPath:
+		s.serveOAuth(w, r)
+		return
+	case path == OAuthAuthorizePath:
+		s.serveOAuthAuthorize(w, r)
+		return
+	case strings.HasPrefix(path, "/shard/linked"):
+		var ind int
+		if _, err := fmt.Sscanf(path, linkedNoteStorePath, &ind); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		linked, ok := s.linkedNoteStore(ind)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		processor = edam.NewNoteStoreProcessor(linked)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	defer func() {
+		// The embedded interfaces of the handlers are nil, so calling an
+		// unimplemented method panics.
+		if rec := recover(); rec != nil {
+			http.Error(w, fmt.Sprintf("not implemented by fake: %v", rec), http.StatusNotImplemented)
+		}
+	}()
+	baseURL := "http://" + r.Host
+	ctx := context.WithValue(r.Context(), baseURLKey{}, baseURL)
+	w.Header().Set("Content-Type", "application/x-thrift")
+	transport := thrift.NewStreamTransport(r.Body, w)
+	protocols := thrift.NewTBinaryProtocolFactoryDefault()
+	_, _ = processor.Process(ctx, protocols.GetProtocol(transport), protocols.GetProtocol(transport))
+}
+
+const linkedNoteStorePath = "/shard/linked%d/notestore"
+
+func linkedToken(ind int) string     { return fmt.Sprintf("linked-token-%d", ind) }
+func linkedGlobalID(ind int) string  { return fmt.Sprintf("shared-notebook-%d", ind) }
+func linkedPublicURI(ind int) string { return fmt.Sprintf("public-notebook-%d", ind) }
+
+// linkedUserID is the user ID of the owner of a public linked notebook.
+func linkedUserID(ind int) edam.UserID { return edam.UserID(100 + ind) }
+
+// businessToken is the token from authenticating to a business. It's the
+// same for each business.
+const businessToken = "business-token"
+
+// check is called at the start of each API call. It counts the call and
+// returns an error when it should fail.
+func (s *Server) check(method, token, expectedToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.numCalls++
+
+	if s.rateLimit.after >= 0 && s.numCalls > s.rateLimit.after {
+		secs := int32(s.rateLimit.duration.Seconds())
+		return &edam.EDAMSystemException{ErrorCode: edam.EDAMErrorCode_RATE_LIMIT_REACHED, RateLimitDuration: &secs}
+	}
+	if err, ok := s.errs[method]; ok {
+		return err
+	}
+	if token == "" || (expectedToken != "" && token != expectedToken) {
+		param := "authenticationToken"
+		return &edam.EDAMUserException{ErrorCode: edam.EDAMErrorCode_INVALID_AUTH, Parameter: &param}
+	}
+	return nil
+}
+
+// userStore implements the parts of edam.UserStore that are used.
+type userStore struct {
+	edam.UserStore
+	srv *Server
+}
+
+func (u *userStore) GetUserUrls(ctx context.Context, authenticationToken string) (*edam.UserUrls, error) {
+	if err := u.srv.check("GetUserUrls", authenticationToken, u.srv.token); err != nil {
+		return nil, err
+	}
+	baseURL, _ := ctx.Value(baseURLKey{}).(string)
+	noteStoreURL := baseURL + NoteStorePath
+	userStoreURL := baseURL + UserStorePath
+	return &edam.UserUrls{NoteStoreUrl: &noteStoreURL, UserStoreUrl: &userStoreURL}, nil
+}
+
+// Username is the name of the user of the fake account.
+const Username = "user"
+
+func (u *userStore) GetUser(ctx context.Context, authenticationToken string) (*edam.User, error) {
+	if err := u.srv.check("GetUser", authenticationToken, u.srv.token); err != nil {
+		return nil, err
+	}
+	id, username := edam.UserID(1), Username
+	if u.srv.fixtures.UserID != 0 {
+		id = edam.UserID(u.srv.fixtures.UserID)
+	}
+	return &edam.User{ID: &id, Username: &username}, nil
+}
+
+// GetPublicUserInfo finds the owner of a public linked notebook by username.
+// Each one is a different user on a different shard.
+func (u *userStore) GetPublicUserInfo(ctx context.Context, username string) (*edam.PublicUserInfo, error) {
+	// public notebooks don't require authentication.
+	if err := u.srv.check("GetPublicUserInfo", "public", ""); err != nil {
+		return nil, err
+	}
+	baseURL, _ := ctx.Value(baseURLKey{}).(string)
+	u.srv.mu.Lock()
+	defer u.srv.mu.Unlock()
+	for i, linked := range u.srv.fixtures.Linked {
+		if !linked.Public || linked.Username != username {
+			continue
+		}
+		noteStoreURL := baseURL + fmt.Sprintf(linkedNoteStorePath, i)
+		return &edam.PublicUserInfo{UserId: linkedUserID(i), Username: &username, NoteStoreUrl: &noteStoreURL}, nil
+	}
+	return nil, notFound("User.username", username)
+}
+
+// AuthenticateToBusiness exchanges the user's token for a token that is good
+// for the notebooks of a business. It fails when there's no business linked
+// notebook, as it does for a user who isn't in a business.
+func (u *userStore) AuthenticateToBusiness(ctx context.Context, authenticationToken string) (*edam.AuthenticationResult_, error) {
+	if err := u.srv.check("AuthenticateToBusiness", authenticationToken, u.srv.token); err != nil {
+		return nil, err
+	}
+	u.srv.mu.Lock()
+	defer u.srv.mu.Unlock()
+	for _, linked := range u.srv.fixtures.Linked {
+		if linked.BusinessID == 0 {
+			continue
+		}
+		now := edam.Timestamp(time.Now().UnixMilli())
+		return &edam.AuthenticationResult_{
+			CurrentTime:         now,
+			AuthenticationToken: businessToken,
+			Expiration:          now + edam.Timestamp(time.Hour.Milliseconds()),
+		}, nil
+	}
+	param := "authenticationToken"
+	return nil, &edam.EDAMUserException{ErrorCode: edam.EDAMErrorCode_PERMISSION_DENIED, Parameter: &param}
+}
+
+// noteStore implements the parts of edam.NoteStore that are used. It serves
+// either the user's own data or the data of one linked notebook.
+type noteStore struct {
+	edam.NoteStore
+	srv       *Server
+	token     string
+	notebooks []*entity.Notebook
+	notes     []*entity.Note
+	trashed   []*entity.Note
+	tags      []*entity.Tag
+	searches  []*entity.SavedSearch
+	// linked is only set for the user's own NoteStore.
+	linked []*LinkedFixtures
+	// linkedIndex is the index of the linked notebook, or -1 for the user's
+	// own NoteStore.
+	linkedIndex int
+	// public is set for the NoteStore of a public linked notebook, which
+	// doesn't require a token.
+	public bool
+	// business is set for the NoteStore of a business linked notebook.
+	business bool
+}
+
+func (s *Server) ownNoteStore() *noteStore {
+	// Take a snapshot of the data, since the create methods may append to it.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &noteStore{
+		srv:         s,
+		token:       s.token,
+		notebooks:   s.fixtures.Notebooks,
+		notes:       s.fixtures.Notes,
+		trashed:     s.fixtures.Trashed,
+		tags:        s.fixtures.Tags,
+		searches:    s.fixtures.SavedSearches,
+		linked:      s.fixtures.Linked,
+		linkedIndex: -1,
+	}
+}
+
+// linkedNoteStore takes a snapshot of the data of a linked notebook, like
+// ownNoteStore. It's false if there's no linked notebook at ind.
+func (s *Server) linkedNoteStore(ind int) (*noteStore, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ind < 0 || ind >= len(s.fixtures.Linked) {
+		return nil, false
+	}
+	linked := s.fixtures.Linked[ind]
+	return &noteStore{
+		srv:         s,
+		token:       linkedToken(ind),
+		notebooks:   []*entity.Notebook{linked.Notebook},
+		notes:       linked.Notes,
+		tags:        linked.Tags,
+		linkedIndex: ind,
+		public:      linked.Public,
+		business:    linked.BusinessID != 0,
+	}, true
+}
+
+// check calls Server.check with the token of the NoteStore. Any token, or
+// none, is accepted by a public NoteStore.
+func (n *noteStore) check(method, token string) error {
+	if n.public {
+		return n.srv.check(method, "public", "")
+	}
+	return n.srv.check(method, token, n.token)
+}
+
+func (n *noteStore) ListNotebooks(ctx context.Context, authenticationToken string) ([]*edam.Notebook, error) {
+	if err := n.check("ListNotebooks", authenticationToken); err != nil {
+		return nil, err
+	}
+	out := make([]*edam.Notebook, len(n.notebooks))
+	for i, notebook := range n.notebooks {
+		out[i] = toNotebook(notebook)
+	}
+	if len(out) > 0 && n.linkedIndex < 0 {
+		// The first of the user's notebooks is the default notebook.
+		yes := true
+		out[0].DefaultNotebook = &yes
+	}
+	return out, nil
+}
+
+func (n *noteStore) GetNotebook(ctx context.Context, authenticationToken string, guid edam.GUID) (*edam.Notebook, error) {
+	if err := n.check("GetNotebook", authenticationToken); err != nil {
+		return nil, err
+	}
+	for _, notebook := range n.notebooks {
+		if notebook.ID == string(guid) {
+			return toNotebook(notebook), nil
+		}
+	}
+	return nil, notFound("Notebook.guid", string(guid))
+}
+
+func (n *noteStore) ListTags(ctx context.Context, authenticationToken string) ([]*edam.Tag, error) {
+	if err := n.check("ListTags", authenticationToken); err != nil {
+		return nil, err
+	}
+	out := make([]*edam.Tag, len(n.tags))
+	for i, tag := range n.tags {
+		out[i] = toTag(tag)
+	}
+	return out, nil
+}
+
+func (n *noteStore) ListTagsByNotebook(ctx context.Context, authenticationToken string, notebookGuid edam.GUID) ([]*edam.Tag, error) {
+	if err := n.check("ListTagsByNotebook", authenticationToken); err != nil {
+		return nil, err
+	}
+	used := make(map[string]struct{})
+	for _, note := range n.notes {
+		if note.NotebookID != string(notebookGuid) {
+			continue
+		}
+		for _, id := range note.TagIDs {
+			used[id] = struct{}{}
+		}
+	}
+	out := make([]*edam.Tag, 0, len(used))
+	for _, tag := range n.tags {
+		if _, ok := used[tag.ID]; ok {
+			out = append(out, toTag(tag))
+		}
+	}
+	return out, nil
+}
+
+func (n *noteStore) ListSearches(ctx context.Context, authenticationToken string) ([]*edam.SavedSearch, error) {
+	if err := n.check("ListSearches", authenticationToken); err != nil {
+		return nil, err
+	}
+	out := make([]*edam.SavedSearch, len(n.searches))
+	for i, search := range n.searches {
+		guid := edam.GUID(search.ID)
+		out[i] = &edam.SavedSearch{GUID: &guid, Name: &search.Name, Query: &search.Query}
+	}
+	return out, nil
+}
+
+func (n *noteStore) FindNotesMetadata(ctx context.Context, authenticationToken string, filter *edam.NoteFilter, offset int32, maxNotes int32, resultSpec *edam.NotesMetadataResultSpec) (*edam.NotesMetadataList, error) {
+	if err := n.check("FindNotesMetadata", authenticationToken); err != nil {
+		return nil, err
+	}
+	n.srv.mu.Lock()
+	n.srv.filters = append(n.srv.filters, filter)
+	n.srv.mu.Unlock()
+	notes := n.notes
+	if filter.GetInactive() {
+		notes = n.trashed
+	}
+	matches := filterNotes(notes, filter)
+	out := &edam.NotesMetadataList{
+		StartIndex: offset,
+		TotalNotes: int32(len(matches)),
+		Notes:      make([]*edam.NoteMetadata, 0),
+	}
+	for i := offset; i >= 0 && i < int32(len(matches)) && i < offset+maxNotes; i++ {
+		out.Notes = append(out.Notes, toNoteMetadata(matches[i]))
+	}
+	return out, nil
+}
+
+func (n *noteStore) GetNoteWithResultSpec(ctx context.Context, authenticationToken string, guid edam.GUID, resultSpec *edam.NoteResultSpec) (*edam.Note, error) {
+	if err := n.check("GetNoteWithResultSpec", authenticationToken); err != nil {
+		return nil, err
+	}
+	if note := n.findNote(guid); note != nil {
+		meta := toNoteMetadata(note)
+		out := &edam.Note{
+			GUID:         &meta.GUID,
+			Title:        meta.Title,
+			Created:      meta.Created,
+			Updated:      meta.Updated,
+			NotebookGuid: meta.NotebookGuid,
+			TagGuids:     meta.TagGuids,
+			Attributes:   meta.Attributes,
+		}
+		if resultSpec.GetIncludeContent() {
+			content := note.Content
+			out.Content = &content
+		}
+		if resultSpec.GetIncludeResourcesData() {
+			for _, att := range note.Attachments {
+				out.Resources = append(out.Resources, toResource(att))
+			}
+		}
+		return out, nil
+	}
+	return nil, notFound("Note.guid", string(guid))
+}
+
+// ListNoteVersions lists the Versions of a fixture note.
+func (n *noteStore) ListNoteVersions(ctx context.Context, authenticationToken string, noteGuid edam.GUID) ([]*edam.NoteVersionId, error) {
+	if err := n.check("ListNoteVersions", authenticationToken); err != nil {
+		return nil, err
+	}
+	note := n.findNote(noteGuid)
+	if note == nil {
+		return nil, notFound("Note.guid", string(noteGuid))
+	}
+	out := make([]*edam.NoteVersionId, len(note.Versions))
+	for i, version := range note.Versions {
+		out[i] = &edam.NoteVersionId{
+			UpdateSequenceNum: version.UpdateSequenceNum,
+			Updated:           *toTimestamp(version.UpdatedAt),
+			Saved:             *toTimestamp(version.SavedAt),
+			Title:             version.Title,
+		}
+	}
+	return out, nil
+}
+
+func (n *noteStore) GetNoteVersion(ctx context.Context, authenticationToken string, noteGuid edam.GUID, updateSequenceNum int32, withResourcesData bool, withResourcesRecognition bool, withResourcesAlternateData bool) (*edam.Note, error) {
+	if err := n.check("GetNoteVersion", authenticationToken); err != nil {
+		return nil, err
+	}
+	note := n.findNote(noteGuid)
+	if note == nil {
+		return nil, notFound("Note.guid", string(noteGuid))
+	}
+	for _, version := range note.Versions {
+		if version.UpdateSequenceNum != updateSequenceNum {
+			continue
+		}
+		guid := edam.GUID(note.ID)
+		return &edam.Note{
+			GUID:              &guid,
+			Title:             &version.Title,
+			Content:           &version.Content,
+			Updated:           toTimestamp(version.UpdatedAt),
+			UpdateSequenceNum: &version.UpdateSequenceNum,
+		}, nil
+	}
+	return nil, notFound("Note.updateSequenceNum", fmt.Sprint(updateSequenceNum))
+}
+
+func (n *noteStore) findNote(guid edam.GUID) *entity.Note {
+	for _, note := range slices.Concat(n.notes, n.trashed) {
+		if note.ID == string(guid) {
+			return note
+		}
+	}
+	return nil
+}
+
+// GetSyncState makes up an update count, which is the number of items of
+// fixture data plus the number of items created, as if each was one update.
+func (n *noteStore) GetSyncState(ctx context.Context, authenticationToken string) (*edam.SyncState, error) {
+	if err := n.check("GetSyncState", authenticationToken); err != nil {
+		return nil, err
+	}
+	n.srv.mu.Lock()
+	defer n.srv.mu.Unlock()
+	count := len(n.notebooks) + len(n.notes) + len(n.tags) + len(n.searches) + n.srv.numCreated
+	return &edam.SyncState{UpdateCount: int32(count)}, nil
+}
+
+func (n *noteStore) ListLinkedNotebooks(ctx context.Context, authenticationToken string) ([]*edam.LinkedNotebook, error) {
+	if err := n.check("ListLinkedNotebooks", authenticationToken); err != nil {
+		return nil, err
+	}
+	baseURL, _ := ctx.Value(baseURLKey{}).(string)
+	out := make([]*edam.LinkedNotebook, len(n.linked))
+	for i, linked := range n.linked {
+		guid := edam.GUID(fmt.Sprintf("linked-notebook-%d", i))
+		shareName, username := linked.ShareName, linked.Username
+		noteStoreURL := baseURL + fmt.Sprintf(linkedNoteStorePath, i)
+		out[i] = &edam.LinkedNotebook{
+			GUID:         &guid,
+			ShareName:    &shareName,
+			Username:     &username,
+			NoteStoreUrl: &noteStoreURL,
+		}
+		if linked.Public {
+			uri := linkedPublicURI(i)
+			out[i].URI = &uri
+		} else {
+			globalID := linkedGlobalID(i)
+			out[i].SharedNotebookGlobalId = &globalID
+		}
+		if linked.BusinessID != 0 {
+			businessID := linked.BusinessID
+			out[i].BusinessId = &businessID
+		}
+	}
+	return out, nil
+}
+
+// AuthenticateToSharedNotebook exchanges the user's token for a token that is
+// good for the NoteStore of a linked notebook. For a business notebook, it
+// takes the token from authenticating to the business instead.
+func (n *noteStore) AuthenticateToSharedNotebook(ctx context.Context, shareKeyOrGlobalId string, authenticationToken string) (*edam.AuthenticationResult_, error) {
+	expectedToken := n.srv.token
+	if n.business {
+		expectedToken = businessToken
+	}
+	if err := n.srv.check("AuthenticateToSharedNotebook", authenticationToken, expectedToken); err != nil {
+		return nil, err
+	}
+	if n.linkedIndex < 0 || n.public || shareKeyOrGlobalId != linkedGlobalID(n.linkedIndex) {
+		return nil, notFound("SharedNotebook.id", shareKeyOrGlobalId)
+	}
+	now := edam.Timestamp(time.Now().UnixMilli())
+	return &edam.AuthenticationResult_{
+		CurrentTime:         now,
+		AuthenticationToken: n.token,
+		Expiration:          now + edam.Timestamp(time.Hour.Milliseconds()),
+	}, nil
+}
+
+// GetPublicNotebook looks up a public linked notebook by the user ID of its
+// owner and its URI.
+func (n *noteStore) GetPublicNotebook(ctx context.Context, userId edam.UserID, publicUri string) (*edam.Notebook, error) {
+	// public notebooks don't require authentication.
+	if err := n.srv.check("GetPublicNotebook", "public", ""); err != nil {
+		return nil, err
+	}
+	if !n.public || userId != linkedUserID(n.linkedIndex) || publicUri != linkedPublicURI(n.linkedIndex) {
+		return nil, notFound("Publishing.uri", publicUri)
+	}
+	out := toNotebook(n.notebooks[0])
+	out.Publishing = &edam.Publishing{URI: &publicUri}
+	return out, nil
+}
+
+func (n *noteStore) GetSharedNotebookByAuth(ctx context.Context, authenticationToken string) (*edam.SharedNotebook, error) {
+	if err := n.check("GetSharedNotebookByAuth", authenticationToken); err != nil {
+		return nil, err
+	}
+	if n.linkedIndex < 0 {
+		return nil, notFound("SharedNotebook.id", authenticationToken)
+	}
+	guid := edam.GUID(n.notebooks[0].ID)
+	globalID := linkedGlobalID(n.linkedIndex)
+	return &edam.SharedNotebook{NotebookGuid: &guid, GlobalId: &globalID}, nil
+}
+
+// filterNotes applies the parts of a NoteFilter that can be evaluated without
+// a search engine: notebook, tags, active state and sort order.
+func filterNotes(notes []*entity.Note, filter *edam.NoteFilter) []*entity.Note {
+	out := make([]*entity.Note, 0, len(notes))
+	for _, note := range notes {
+		if filter.IsSetNotebookGuid() && note.NotebookID != string(filter.GetNotebookGuid()) {
+			continue
+		}
+		if !hasTags(note, filter.GetTagGuids()) {
+			continue
+		}
+		out = append(out, note)
+	}
+
+	var cmp func(a, b *entity.Note) int
+	switch edam.NoteSortOrder(filter.GetOrder()) {
+	case edam.NoteSortOrder_UPDATED:
+		cmp = func(a, b *entity.Note) int { return a.UpdatedAt.Compare(b.UpdatedAt) }
+	case edam.NoteSortOrder_TITLE:
+		cmp = func(a, b *entity.Note) int { return strings.Compare(a.Title, b.Title) }
+	case edam.NoteSortOrder_CREATED:
+		cmp = func(a, b *entity.Note) int { return a.CreatedAt.Compare(b.CreatedAt) }
+	}
+	if cmp == nil {
+		return out
+	}
+	if filter.IsSetAscending() && !filter.GetAscending() {
+		slices.SortStableFunc(out, func(a, b *entity.Note) int { return cmp(b, a) })
+	} else {
+		slices.SortStableFunc(out, cmp)
+	}
+	return out
+}
+
+func hasTags(note *entity.Note, tagGUIDs []edam.GUID) bool {
+	for _, guid := range tagGUIDs {
+		var found bool
+		for _, id := range note.TagIDs {
+			if id == string(guid) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func notFound(identifier, key string) error {
+	return &edam.EDAMNotFoundException{Identifier: &identifier, Key: &key}
+}
@@ -0,0 +1,111 @@
+package fake
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type oauthState struct {
+	// callbacks are the callback URLs of temporary tokens.
+	callbacks map[string]string
+	// verifiers are the verifiers of temporary tokens that were authorized.
+	verifiers map[string]string
+	deny      bool
+	count     int
+}
+
+// SetOAuthDenied makes the user decline to grant access in the OAuth flow.
+func (s *Server) SetOAuthDenied(deny bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.oauth.deny = deny
+}
+
+// serveOAuth responds with temporary credentials, or with an access token
+// when the request has a verifier. Signatures are not checked, but there must
+// be a consumer key. The access token is the token of the Server, or a
+// made-up one if it accepts any token.
+func (s *Server) serveOAuth(w http.ResponseWriter, r *http.Request) {
+	params := oauthParams(r)
+	if params.Get("oauth_consumer_key") == "" {
+		http.Error(w, "oauth_consumer_key is required", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := make(url.Values)
+	if verifier := params.Get("oauth_verifier"); verifier == "" {
+		s.oauth.count++
+		tempToken := fmt.Sprintf("temp-token-%d", s.oauth.count)
+		s.oauth.callbacks[tempToken] = params.Get("oauth_callback")
+		resp.Set("oauth_token", tempToken)
+		resp.Set("oauth_token_secret", "temp-secret")
+		resp.Set("oauth_callback_confirmed", "true")
+	} else {
+		tempToken := params.Get("oauth_token")
+		if expected, ok := s.oauth.verifiers[tempToken]; !ok || verifier != expected {
+			http.Error(w, "invalid oauth_token or oauth_verifier", http.StatusUnauthorized)
+			return
+		}
+		delete(s.oauth.verifiers, tempToken)
+		token := s.token
+		if token == "" {
+			token = "oauth-access-token"
+		}
+		resp.Set("oauth_token", token)
+		resp.Set("oauth_token_secret", "")
+		resp.Set("edam_shard", "s1")
+		resp.Set("edam_userId", "1")
+		resp.Set("edam_expires", strconv.FormatInt(time.Now().AddDate(1, 0, 0).UnixMilli(), 10))
+		resp.Set("edam_noteStoreUrl", "http://"+r.Host+NoteStorePath)
+	}
+	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+	fmt.Fprint(w, resp.Encode())
+}
+
+// serveOAuthAuthorize stands in for the page where the user grants access. It
+// immediately redirects to the callback, as if the user made a choice.
+func (s *Server) serveOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	tempToken := r.URL.Query().Get("oauth_token")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	callback, ok := s.oauth.callbacks[tempToken]
+	if !ok {
+		http.Error(w, "unknown oauth_token", http.StatusBadRequest)
+		return
+	}
+	delete(s.oauth.callbacks, tempToken)
+
+	query := url.Values{"oauth_token": {tempToken}}
+	if !s.oauth.deny {
+		verifier := "verifier-" + tempToken
+		s.oauth.verifiers[tempToken] = verifier
+		query.Set("oauth_verifier", verifier)
+	}
+	http.Redirect(w, r, callback+"?"+query.Encode(), http.StatusFound)
+}
+
+// oauthParams collects the protocol parameters from the Authorization header
+// and the query string.
+func oauthParams(r *http.Request) url.Values {
+	out := r.URL.Query()
+	header, ok := strings.CutPrefix(r.Header.Get("Authorization"), "OAuth ")
+	if !ok {
+		return out
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		if val, err := url.QueryUnescape(strings.Trim(val, `"`)); err == nil {
+			out.Set(key, val)
+		}
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+package edam
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dreampuf/evernote-sdk-golang/edam"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/log"
+)
+
+// A linkedStore is a NoteStore for one notebook owned by another account. It
+// could be shared by another user, be a public notebook or belong to a
+// business account. The notebook may live on a different shard than the
+// user's own NoteStore, so it has its own connection and authentication token.
+type linkedStore struct {
+	*store
+	notebook *edam.Notebook
+	origin   *entity.Origin
+}
+
+// listLinkedStores connects to the NoteStore of each LinkedNotebook in the
+// user's account. A linked notebook that can't be reached, for example when
+// the owner stopped sharing it, is logged and skipped rather than failing
+// everything else.
+func listLinkedStores(ctx context.Context, s *store) (out []*linkedStore, err error) {
+	linkedNotebooks, err := s.ListLinkedNotebooks(ctx, s.token)
+	if err != nil {
+		err = makeError(err)
+		return
+	}
+
+	// All business notebooks are reached with the same business token, so
+	// only authenticate to the business once, whether or not it works.
+	var (
+		business    *edam.AuthenticationResult_
+		businessErr error
+		once        bool
+	)
+	authenticateToBusiness := func(ctx context.Context) (*edam.AuthenticationResult_, error) {
+		if !once {
+			once = true
+			if business, businessErr = s.userStore.AuthenticateToBusiness(ctx, s.token); businessErr != nil {
+				businessErr = makeError(businessErr)
+			}
+		}
+		return business, businessErr
+	}
+
+	out = make([]*linkedStore, 0, len(linkedNotebooks))
+	for _, linked := range linkedNotebooks {
+		fields := map[string]any{
+			"linked_notebook_id": linked.GetGUID(),
+			"share_name":         linked.GetShareName(),
+			"username":           linked.GetUsername(),
+		}
+		ls, ierr := newLinkedStore(ctx, s, linked, authenticateToBusiness)
+		if ierr != nil {
+			log.Warn(ctx, fields, fmt.Sprintf("skipping linked notebook: %v", ierr))
+			continue
+		}
+		log.Debug(ctx, fields, "connected to linked notebook")
+		out = append(out, ls)
+	}
+	return
+}
+
+// newLinkedStore connects to the NoteStore of a LinkedNotebook. For a business
+// notebook, authenticateToBusiness exchanges the user's token for a business
+// token, which is then used in place of the user's token.
+func newLinkedStore(
+	ctx context.Context,
+	s *store,
+	linked *edam.LinkedNotebook,
+	authenticateToBusiness func(context.Context) (*edam.AuthenticationResult_, error),
+) (out *linkedStore, err error) {
+	if s.userStore == nil {
+		err = fmt.Errorf("store is not able to connect to other NoteStores")
+		return
+	}
+	userToken, noteStoreURL := s.token, linked.GetNoteStoreUrl()
+	if linked.IsSetBusinessId() {
+		var business *edam.AuthenticationResult_
+		if business, err = authenticateToBusiness(ctx); err != nil {
+			err = fmt.Errorf("%w; could not authenticate to business %d", err, linked.GetBusinessId())
+			return
+		}
+		userToken = business.GetAuthenticationToken()
+		if noteStoreURL == "" {
+			noteStoreURL = business.GetNoteStoreUrl()
+		}
+	}
+	noteStore, err := newNoteStoreClient(noteStoreURL)
+	if err != nil {
+		err = makeError(err)
+		return
+	}
+
+	var (
+		token    string
+		notebook *edam.Notebook
+	)
+	if globalID := linked.GetSharedNotebookGlobalId(); globalID != "" {
+		// A notebook shared with the user, or a business notebook. The
+		// user's token, or the business token, is exchanged for one that's
+		// good on the owner's shard.
+		var auth *edam.AuthenticationResult_
+		if auth, err = noteStore.AuthenticateToSharedNotebook(ctx, globalID, userToken); err != nil {
+			err = makeError(err)
+			return
+		}
+		token = auth.GetAuthenticationToken()
+
+		var shared *edam.SharedNotebook
+		if shared, err = noteStore.GetSharedNotebookByAuth(ctx, token); err != nil {
+			err = makeError(err)
+			return
+		}
+		if notebook, err = noteStore.GetNotebook(ctx, token, shared.GetNotebookGuid()); err != nil {
+			err = makeError(err)
+			return
+		}
+	} else if uri := linked.GetURI(); uri != "" {
+		// A public notebook, which doesn't require authentication to read.
+		var info *edam.PublicUserInfo
+		if info, err = s.userStore.GetPublicUserInfo(ctx, linked.GetUsername()); err != nil {
+			err = makeError(err)
+			return
+		}
+		if notebook, err = noteStore.GetPublicNotebook(ctx, info.GetUserId(), uri); err != nil {
+			err = makeError(err)
+			return
+		}
+	} else {
+		err = fmt.Errorf("linked notebook has neither a shared notebook global id nor a public uri")
+		return
+	}
+
+	out = &linkedStore{
+		store:    &store{NoteStore: noteStore, token: token},
+		notebook: notebook,
+		origin: &entity.Origin{
+			LinkedNotebookID: string(linked.GetGUID()),
+			ShareName:        linked.GetShareName(),
+			Username:         linked.GetUsername(),
+			BusinessID:       linked.GetBusinessId(),
+		},
+	}
+	return
+}
@@ -0,0 +1,165 @@
+package edam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrjones/oauth"
+	"github.com/rafaelespinoza/notexfr/internal/log"
+)
+
+// A CredentialStore is a CredentialSource that can also save credentials.
+type CredentialStore interface {
+	CredentialSource
+	SetCredential(ctx context.Context, name, value string) error
+}
+
+// LoginParams is a set of named options for Login.
+type LoginParams struct {
+	// CallbackAddr is the local address to listen on for the redirect back
+	// from Evernote after the user grants access. The default is a random
+	// port on the loopback interface.
+	CallbackAddr string
+	// Authorize is called with the URL that the user must visit to grant
+	// access. It could open a browser, or print the URL. Login then waits for
+	// the redirect back, or for ctx to be done.
+	Authorize func(ctx context.Context, authorizeURL string) error
+}
+
+// LoginResult describes the access token obtained by Login.
+type LoginResult struct {
+	// TokenName is the name of the credential the token was stored as.
+	TokenName string
+	// Expires is when the token stops working, if known.
+	Expires time.Time
+}
+
+var (
+	errNoCredentialStore = errors.New("none of the credential sources can store a token")
+	errAccessDenied      = errors.New("access was not granted")
+)
+
+// Login gets an access token with the OAuth 1.0a flow, rather than using a
+// developer token. It needs the consumer key and secret, which are looked up
+// like any other credential. The token is stored in the first of the
+// configured credential sources that is a CredentialStore.
+// See https://dev.evernote.com/doc/articles/authentication.php.
+func Login(ctx context.Context, credsConf CredentialsConfig, params LoginParams) (out *LoginResult, err error) {
+	var store CredentialStore
+	for _, src := range credsConf.Sources {
+		if cs, ok := src.(CredentialStore); ok {
+			store = cs
+			break
+		}
+	}
+	if store == nil {
+		err = errNoCredentialStore
+		return
+	}
+	credentials, err := loadEnv(ctx, credsConf)
+	if err != nil {
+		return
+	}
+	if credentials.key == "" || credentials.secret == "" {
+		err = fmt.Errorf("consumer key and secret are required to log in")
+		return
+	}
+
+	addr := params.CallbackAddr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	callbacks := make(chan callbackResult, 1)
+	srv := http.Server{Handler: callbackHandler(callbacks)}
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Close()
+
+	base := baseURL(credsConf)
+	consumer := oauth.NewConsumer(credentials.key, credentials.secret, oauth.ServiceProvider{
+		RequestTokenUrl:   base + "/oauth",
+		AuthorizeTokenUrl: base + "/OAuth.action",
+		AccessTokenUrl:    base + "/oauth",
+	})
+	requestToken, authorizeURL, err := consumer.GetRequestTokenAndUrl("http://" + listener.Addr().String() + "/callback")
+	if err != nil {
+		err = fmt.Errorf("could not get temporary credentials; %w", err)
+		return
+	}
+	log.Info(ctx, map[string]any{"callback_addr": listener.Addr().String()}, "waiting for authorization")
+	if err = params.Authorize(ctx, authorizeURL); err != nil {
+		return
+	}
+
+	var cb callbackResult
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	case cb = <-callbacks:
+	}
+	if cb.token != requestToken.Token {
+		err = fmt.Errorf("callback has unexpected oauth_token %q", cb.token)
+		return
+	}
+	if cb.verifier == "" {
+		err = errAccessDenied
+		return
+	}
+
+	accessToken, err := consumer.AuthorizeToken(requestToken, cb.verifier)
+	if err != nil {
+		err = fmt.Errorf("could not get access token; %w", err)
+		return
+	}
+	out = &LoginResult{TokenName: _SandboxTokenName}
+	if credsConf.ServiceEnv == EvernoteProductionService {
+		out.TokenName = _ProductionTokenName
+	}
+	if ms, perr := strconv.ParseInt(accessToken.AdditionalData["edam_expires"], 10, 64); perr == nil {
+		out.Expires = time.UnixMilli(ms).UTC()
+	}
+	if err = store.SetCredential(ctx, out.TokenName, accessToken.Token); err != nil {
+		out = nil
+	}
+	return
+}
+
+// baseURL is the scheme and host of the service, for the OAuth endpoints and
+// the UserStore.
+func baseURL(credsConf CredentialsConfig) string {
+	if credsConf.BaseURL != "" {
+		return strings.TrimSuffix(credsConf.BaseURL, "/")
+	}
+	if credsConf.ServiceEnv == EvernoteProductionService {
+		return "https://www.evernote.com"
+	}
+	return "https://sandbox.evernote.com"
+}
+
+type callbackResult struct{ token, verifier string }
+
+// callbackHandler receives the redirect after the user grants, or declines,
+// access. When declined, there is no oauth_verifier.
+func callbackHandler(out chan<- callbackResult) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		select {
+		case out <- callbackResult{token: query.Get("oauth_token"), verifier: query.Get("oauth_verifier")}:
+			fmt.Fprintln(w, "Done. You may close this window and return to notexfr.")
+		default:
+			http.Error(w, "already received a callback", http.StatusConflict)
+		}
+	})
+	return mux
+}
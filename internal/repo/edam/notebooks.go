@@ -5,20 +5,37 @@ import (
 	"encoding/json"
 	"io"
 
+	"github.com/dreampuf/evernote-sdk-golang/edam"
 	"github.com/rafaelespinoza/notexfr/internal/entity"
 )
 
 // Notebooks handles input/output for notebooks from the Evernote EDAM API.
-type Notebooks struct{}
+type Notebooks struct {
+	client *Client
+	rqp    NotebooksRemoteQueryParams
+}
+
+// NotebooksRemoteQueryParams is a set of named options for listing Evernote
+// notebooks.
+type NotebooksRemoteQueryParams struct {
+	// IncludeLinked also lists notebooks owned by other accounts, such as
+	// ones shared with the user and business notebooks.
+	IncludeLinked bool
+}
 
-// NewNotebooksRepo constructs a Notebooks repository.
-func NewNotebooksRepo() (entity.LocalRemoteRepo, error) { return &Notebooks{}, nil }
+// NewNotebooksRepo constructs a Notebooks repository. The client is only
+// needed to fetch remote data, it may be nil to only read local data.
+func NewNotebooksRepo(client *Client, rqp *NotebooksRemoteQueryParams) (entity.LocalRemoteRepo, error) {
+	if rqp == nil {
+		rqp = &NotebooksRemoteQueryParams{}
+	}
+	return &Notebooks{client: client, rqp: *rqp}, nil
+}
 
 // FetchRemote gets Notebooks from the Evernote EDAM API.
 func (n *Notebooks) FetchRemote(ctx context.Context) (out []entity.LinkID, err error) {
 	var s *store
-	var id string
-	if s, err = initStore(ctx); err != nil {
+	if s, err = n.client.connect(ctx); err != nil {
 		return
 	}
 	notebooks, err := s.ListNotebooks(ctx, s.token)
@@ -28,21 +45,37 @@ func (n *Notebooks) FetchRemote(ctx context.Context) (out []entity.LinkID, err e
 	}
 	out = make([]entity.LinkID, len(notebooks))
 	for i, notebook := range notebooks {
-		id = string(notebook.GetGUID())
-		out[i] = &Notebook{
-			Notebook: &entity.Notebook{
-				ID:        id,
-				Name:      notebook.GetName(),
-				Stack:     notebook.GetStack(),
-				CreatedAt: makeTimestamp(notebook.GetServiceCreated()),
-				UpdatedAt: makeTimestamp(notebook.GetServiceUpdated()),
-			},
-			ServiceID: &entity.ServiceID{Value: id},
-		}
+		out[i] = newNotebook(notebook, nil)
+	}
+	if !n.rqp.IncludeLinked {
+		return
+	}
+
+	linkedStores, err := listLinkedStores(ctx, s)
+	if err != nil {
+		return
+	}
+	for _, ls := range linkedStores {
+		out = append(out, newNotebook(ls.notebook, ls.origin))
 	}
 	return
 }
 
+func newNotebook(notebook *edam.Notebook, origin *entity.Origin) *Notebook {
+	id := string(notebook.GetGUID())
+	return &Notebook{
+		Notebook: &entity.Notebook{
+			ID:        id,
+			Name:      notebook.GetName(),
+			Stack:     notebook.GetStack(),
+			CreatedAt: makeTimestamp(notebook.GetServiceCreated()),
+			UpdatedAt: makeTimestamp(notebook.GetServiceUpdated()),
+			Origin:    origin,
+		},
+		ServiceID: &entity.ServiceID{Value: id},
+	}
+}
+
 // ReadLocal reads and parses notebooks saved in a local JSON file.
 func (n *Notebooks) ReadLocal(ctx context.Context, r io.Reader) (out []entity.LinkID, err error) {
 	decoder := json.NewDecoder(r)
@@ -1,31 +1,39 @@
 package edam
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/rafaelespinoza/notexfr/internal/enml"
 	"github.com/rafaelespinoza/notexfr/internal/entity"
 	"github.com/rafaelespinoza/notexfr/internal/log"
-	"golang.org/x/net/html"
+	"github.com/rafaelespinoza/notexfr/internal/progress"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
 
 	"github.com/dreampuf/evernote-sdk-golang/edam"
 )
 
 // Notes handles input/output for notes from the Evernote EDAM API.
 type Notes struct {
-	rqp NotesRemoteQueryParams
+	client *Client
+	rqp    NotesRemoteQueryParams
 }
 
-// NewNotesRepo constructs a Notes repository.
-func NewNotesRepo(rqp *NotesRemoteQueryParams) (entity.LocalRemoteRepo, error) {
+// NewNotesRepo constructs a Notes repository. The client is only needed to
+// fetch remote data, it may be nil to only read local data.
+func NewNotesRepo(client *Client, rqp *NotesRemoteQueryParams) (entity.LocalRemoteRepo, error) {
 	if rqp == nil {
 		rqp = &NotesRemoteQueryParams{TagIDs: make([]string, 0)}
 	}
-	return &Notes{rqp: *rqp}, nil
+	return &Notes{client: client, rqp: *rqp}, nil
 }
 
 // FetchRemote gets Notes from the Evernote EDAM API. It can automatically
@@ -33,15 +41,86 @@ func NewNotesRepo(rqp *NotesRemoteQueryParams) (entity.LocalRemoteRepo, error) {
 // concrete type, NotesQuery. Multiple API calls will be made until there are no
 // more remaining results.
 func (n *Notes) FetchRemote(ctx context.Context) (out []entity.LinkID, err error) {
-	var (
-		s *store
-	)
-	if s, err = initStore(ctx); err != nil {
-		return
+	return entity.Collect(n.StreamRemote(ctx))
+}
+
+// StreamRemote is like FetchRemote, but yields each note as soon as its content
+// is fetched, so only one page of metadata and one note are in memory at once.
+// Notes are yielded in the order of the searches: active notes, then trashed
+// notes if IncludeTrashed is set, then likewise for each linked notebook if
+// IncludeLinked is set. Within a search, notes are in the requested sort order.
+func (n *Notes) StreamRemote(ctx context.Context) iter.Seq2[entity.LinkID, error] {
+	return func(yield func(entity.LinkID, error) bool) {
+		s, err := n.client.connect(ctx)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		rqp, err := n.rqp.resolve(ctx, s)
+		ownMatches := err == nil
+		if errors.Is(err, errNameNotFound) && (rqp.IncludeLinked || rqp.Notebook != "") {
+			// The name may be of a linked notebook, or of a tag in one, so
+			// only linked notebooks are searched.
+			log.Info(ctx, nil, fmt.Sprintf("searching linked notebooks only: %v", err))
+		} else if err != nil {
+			yield(nil, err)
+			return
+		}
+		notFound := err
+
+		if ownMatches {
+			for _, filter := range rqp.toFilters() {
+				if !n.streamPages(ctx, s, &rqp, filter, nil, yield) {
+					return
+				}
+			}
+			if !rqp.IncludeLinked {
+				return
+			}
+		}
+
+		linkedStores, err := listLinkedStores(ctx, s)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		// The notebook and tags of a linked notebook are set for each one,
+		// on filters of its own.
+		base := rqp
+		base.NotebookID, base.TagIDs = "", nil
+		var numLinked int
+		for _, ls := range linkedStores {
+			tagIDs, ok, err := n.rqp.resolveLinked(ctx, ls)
+			if err != nil {
+				yield(nil, err)
+				return
+			} else if !ok {
+				log.Debug(ctx, map[string]any{"share_name": ls.origin.ShareName}, "skipping linked notebook that can't match notebook or tags")
+				continue
+			}
+			numLinked++
+			guid := ls.notebook.GetGUID()
+			for _, filter := range base.toFilters() {
+				filter.NotebookGuid = &guid
+				filter.TagGuids = tagIDs
+				if !n.streamPages(ctx, ls.store, &rqp, filter, ls.origin, yield) {
+					return
+				}
+			}
+		}
+		if !ownMatches && numLinked < 1 {
+			yield(nil, notFound)
+		}
 	}
-	filter := n.rqp.toFilter()
-	pageSize := n.rqp.PageSize
-	pagination := newPaginator(n.rqp.LoIndex, n.rqp.HiIndex)
+}
+
+// streamPages yields the notes matching a filter, page by page. The output is
+// false if iteration should stop, because of an error or because the consumer
+// is done. A note that can't be fetched is yielded as a *entity.NoteError,
+// after which the next note is fetched if the consumer goes on.
+func (n *Notes) streamPages(ctx context.Context, s *store, rqp *NotesRemoteQueryParams, filter *edam.NoteFilter, origin *entity.Origin, yield func(entity.LinkID, error) bool) bool {
+	pageSize := rqp.PageSize
+	pagination := newPaginator(rqp.LoIndex, rqp.HiIndex)
 
 	yes := true
 	// resultSpec tells evernote which fields to include in the search. By
@@ -55,12 +134,11 @@ func (n *Notes) FetchRemote(ctx context.Context) (out []entity.LinkID, err error
 		IncludeTitle:        &yes,
 		IncludeUpdated:      &yes,
 	}
-	out = make([]entity.LinkID, 0)
+	var count int
 
 	for !pagination.done {
-		var ierr error
-		log.Info(ctx, map[string]any{"running_total": len(out)}, "fetching note metadata...")
-		notesMetadataList, ierr := s.FindNotesMetadata(
+		log.Info(ctx, map[string]any{"running_total": count, "inactive": filter.GetInactive()}, "fetching note metadata...")
+		notesMetadataList, err := s.FindNotesMetadata(
 			ctx,
 			s.token,
 			filter,
@@ -68,57 +146,142 @@ func (n *Notes) FetchRemote(ctx context.Context) (out []entity.LinkID, err error
 			pageSize,
 			resultSpec,
 		)
-		if ierr != nil {
-			err = makeError(ierr)
-			return
+		if err != nil {
+			yield(nil, makeError(err))
+			return false
 		}
 		notesMetadata := notesMetadataList.GetNotes()
 		numResultsInRange := len(notesMetadata)
 		numTotalResults := notesMetadataList.GetTotalNotes()
-		subList := make([]entity.LinkID, numResultsInRange)
-		ierr = pagination.update(
+		if pagination.currOffset == pagination.lo {
+			progress.Expect(progress.Fetch, max(0, min(int(numTotalResults), int(pagination.hi)+1)-int(pagination.lo)))
+		}
+		err = pagination.update(
 			notesMetadataList.GetStartIndex(),
 			int32(numResultsInRange),
 		)
-		if ierr != nil {
-			err = ierr
-			return
+		if err != nil {
+			yield(nil, err)
+			return false
 		}
 
 		resultSpec := &edam.NoteResultSpec{IncludeContent: &yes}
+		if rqp.IncludeAttachments {
+			resultSpec.IncludeResourcesData = &yes
+		}
 		log.Info(ctx, map[string]any{"num_results": len(notesMetadata)}, "done fetching metadata")
 		for i, noteMeta := range notesMetadata {
 			noteID := noteMeta.GetGUID()
 			if numResultsInRange > 1 && i%(numResultsInRange/2) == 0 {
-				log.Info(ctx, map[string]any{
-					"curr_position":     len(out) + i,
+				log.Debug(ctx, map[string]any{
+					"curr_position":     count,
 					"num_total_results": numTotalResults,
 				}, "fetching note content")
 			}
-			result, ierr := s.GetNoteWithResultSpec(
+			result, err := s.GetNoteWithResultSpec(
 				ctx,
 				s.token,
 				noteID,
 				resultSpec,
 			)
-			if ierr != nil {
+			if err != nil {
 				err = fmt.Errorf(
 					"%w, noteID: %q, noteContentLength %d",
-					ierr, noteID, noteMeta.GetContentLength(),
+					makeError(err), noteID, noteMeta.GetContentLength(),
 				)
-				return
+				if err = noteError(noteMeta, err); !yield(nil, err) || !isNoteError(err) {
+					return false
+				}
+				continue
 			}
-			note, ierr := newNote(noteMeta, result.GetContent())
-			if ierr != nil {
-				err = ierr
-				return
+			note, err := newNote(noteMeta, result.GetContent())
+			if err != nil {
+				err = &entity.NoteError{ID: string(noteID), Title: noteMeta.GetTitle(), Stage: entity.StageFetch, Err: err}
+				if !yield(nil, err) {
+					return false
+				}
+				continue
+			}
+			note.(*Note).Origin = origin
+			if rqp.IncludeAttachments {
+				note.(*Note).Attachments = newAttachments(result.GetResources())
 			}
+			if rqp.IncludeVersions {
+				note.(*Note).Versions, err = fetchVersions(ctx, s, noteID)
+				if errors.Is(err, errNoNoteHistory) {
+					// It's the same for every note, so warn once and go on
+					// without versions for the rest of the notes.
+					log.Warn(ctx, nil, fmt.Sprintf("not fetching versions of notes: %v", err))
+					rqp.IncludeVersions = false
+				} else if err != nil {
+					if err = noteError(noteMeta, err); !yield(nil, err) || !isNoteError(err) {
+						return false
+					}
+					continue
+				}
+			}
+			progress.Add(progress.Fetch, progress.TypeName(note), 1)
+			if !yield(note, nil) {
+				return false
+			}
+			count++
+		}
+		log.Info(ctx, map[string]any{"count": count}, "fetched contents")
+	}
+	return true
+}
+
+// noteError makes a client-side failure to fetch one note into an
+// *entity.NoteError, so that the other notes may still be fetched. Any other
+// error, such as reaching the rate limit, is the same for every note, so it's
+// the output as is.
+func noteError(noteMeta *edam.NoteMetadata, err error) error {
+	var cerr *clientError
+	if !errors.As(err, &cerr) {
+		return err
+	}
+	return &entity.NoteError{ID: string(noteMeta.GetGUID()), Title: noteMeta.GetTitle(), Stage: entity.StageFetch, Err: err}
+}
 
-			subList[i] = note
+func isNoteError(err error) bool {
+	var nerr *entity.NoteError
+	return errors.As(err, &nerr)
+}
+
+// errNoNoteHistory is for an account that can't list versions of notes, which
+// is any account other than a premium or business account.
+var errNoNoteHistory = errors.New("note history is only kept for premium and business accounts")
+
+// fetchVersions gets the earlier revisions of a note, along with their
+// content, in the order listed by the API, which is most recent first.
+// Resources of each revision are not fetched.
+func fetchVersions(ctx context.Context, s *store, noteID edam.GUID) (out []*entity.NoteVersion, err error) {
+	versionIDs, err := s.ListNoteVersions(ctx, s.token, noteID)
+	var uerr *edam.EDAMUserException
+	if errors.As(err, &uerr) && uerr.GetErrorCode() == edam.EDAMErrorCode_PERMISSION_DENIED {
+		err = errNoNoteHistory
+		return
+	} else if err != nil {
+		err = fmt.Errorf("%w; could not list versions of note %q", makeError(err), noteID)
+		return
+	}
+	out = make([]*entity.NoteVersion, len(versionIDs))
+	for i, versionID := range versionIDs {
+		usn := versionID.GetUpdateSequenceNum()
+		version, ierr := s.GetNoteVersion(ctx, s.token, noteID, usn, false, false, false)
+		if ierr != nil {
+			err = fmt.Errorf("%w; could not get version %d of note %q", makeError(ierr), usn, noteID)
+			return
+		}
+		out[i] = &entity.NoteVersion{
+			UpdateSequenceNum: usn,
+			Title:             versionID.GetTitle(),
+			Content:           version.GetContent(),
+			UpdatedAt:         makeTimestamp(versionID.GetUpdated()),
+			SavedAt:           makeTimestamp(versionID.GetSaved()),
 		}
-		out = append(out, subList...)
-		log.Info(ctx, map[string]any{"count": len(out)}, "fetched contents")
 	}
+	log.Debug(ctx, map[string]any{"note_id": noteID, "num_versions": len(out)}, "fetched note versions")
 	return
 }
 
@@ -129,13 +292,197 @@ type NotesRemoteQueryParams struct {
 	PageSize   int32
 	TagIDs     []string
 	NotebookID string
+
+	// Notebook is the name or GUID of a notebook to search within. A name is
+	// resolved to a GUID before searching and takes precedence over
+	// NotebookID. It may be a linked notebook, which is then searched even
+	// if IncludeLinked is not set.
+	Notebook string
+	// Tags are names or GUIDs of tags that each note must have. Names are
+	// resolved to GUIDs before searching and added to TagIDs. With
+	// IncludeLinked, they're also resolved against the tags used in each
+	// linked notebook, which is skipped if any of them isn't there.
+	Tags []string
+	// Query is a search expression in the Evernote search grammar. See
+	// https://dev.evernote.com/doc/articles/search_grammar.php.
+	Query string
+	// CreatedAfter, CreatedBefore, UpdatedAfter, UpdatedBefore limit results
+	// to a date range. A zero value means there is no limit.
+	CreatedAfter, CreatedBefore time.Time
+	UpdatedAfter, UpdatedBefore time.Time
+	// SortOrder is one of the values in NoteSortOrders. The default is to sort
+	// by created at.
+	SortOrder string
+	// Descending reverses the sort order.
+	Descending bool
+	// IncludeTrashed also fetches notes in the trash.
+	IncludeTrashed bool
+	// IncludeLinked also fetches notes in notebooks owned by other accounts,
+	// such as ones shared with the user and business notebooks.
+	IncludeLinked bool
+	// IncludeVersions also fetches the earlier revisions of each note. It
+	// takes one ListNoteVersions call per note, plus one GetNoteVersion call
+	// per revision, so a note with 10 revisions costs 11 extra calls. Evernote
+	// only keeps note history for premium and business accounts. For any
+	// other account, there's a warning and the notes have no versions.
+	IncludeVersions bool
+	// IncludeAttachments also fetches the files embedded in each note.
+	IncludeAttachments bool
+}
+
+// NoteSortOrders lists valid values for NotesRemoteQueryParams.SortOrder.
+var NoteSortOrders = []string{"created", "updated", "title", "relevance"}
+
+// Validate checks the params for values that would otherwise be rejected by
+// the Evernote API, or silently ignored.
+func (p *NotesRemoteQueryParams) Validate() error {
+	if _, err := p.sortOrder(); err != nil {
+		return err
+	}
+	if !p.CreatedAfter.IsZero() && !p.CreatedBefore.IsZero() && !p.CreatedAfter.Before(p.CreatedBefore) {
+		return fmt.Errorf("created after (%s) should be before created before (%s)", fmtTime(p.CreatedAfter), fmtTime(p.CreatedBefore))
+	}
+	if !p.UpdatedAfter.IsZero() && !p.UpdatedBefore.IsZero() && !p.UpdatedAfter.Before(p.UpdatedBefore) {
+		return fmt.Errorf("updated after (%s) should be before updated before (%s)", fmtTime(p.UpdatedAfter), fmtTime(p.UpdatedBefore))
+	}
+	return nil
+}
+
+func (p *NotesRemoteQueryParams) sortOrder() (edam.NoteSortOrder, error) {
+	switch strings.ToLower(p.SortOrder) {
+	case "", "created":
+		return edam.NoteSortOrder_CREATED, nil
+	case "updated":
+		return edam.NoteSortOrder_UPDATED, nil
+	case "title":
+		return edam.NoteSortOrder_TITLE, nil
+	case "relevance":
+		return edam.NoteSortOrder_RELEVANCE, nil
+	default:
+		return 0, fmt.Errorf("invalid sort order %q, should be one of %q", p.SortOrder, NoteSortOrders)
+	}
+}
+
+// resolve looks up the GUIDs of the Notebook and Tags fields when they are
+// specified by name. API calls are only made when a name needs resolving. The
+// output is a copy of the params with the GUIDs in NotebookID and TagIDs, so
+// that resolving again doesn't add to them.
+func (p *NotesRemoteQueryParams) resolve(ctx context.Context, s *store) (out NotesRemoteQueryParams, err error) {
+	out = *p
+	out.TagIDs = slices.Clone(p.TagIDs)
+	if p.Notebook != "" {
+		if guidPattern.MatchString(p.Notebook) {
+			out.NotebookID = p.Notebook
+		} else if out.NotebookID, err = resolveNotebookName(ctx, s, p.Notebook); err != nil {
+			return
+		}
+	}
+
+	var tagsByName map[string]string
+	for _, tag := range p.Tags {
+		if guidPattern.MatchString(tag) {
+			out.TagIDs = append(out.TagIDs, tag)
+			continue
+		}
+		if tagsByName == nil {
+			tags, ierr := s.ListTags(ctx, s.token)
+			if ierr != nil {
+				err = makeError(ierr)
+				return
+			}
+			tagsByName = make(map[string]string, len(tags))
+			for _, t := range tags {
+				tagsByName[strings.ToLower(t.GetName())] = string(t.GetGUID())
+			}
+		}
+		id, ok := tagsByName[strings.ToLower(tag)]
+		if !ok {
+			err = fmt.Errorf("%w; tag %q", errNameNotFound, tag)
+			return
+		}
+		out.TagIDs = append(out.TagIDs, id)
+	}
+	return
+}
+
+// resolveLinked checks whether the notebook of the params could be the linked
+// notebook, and resolves names or GUIDs of tags against the tags used in it.
+// The output is false when the notebook or any tag isn't there, because then
+// nothing in the linked notebook can match.
+func (p *NotesRemoteQueryParams) resolveLinked(ctx context.Context, ls *linkedStore) (tagIDs []edam.GUID, ok bool, err error) {
+	guid := ls.notebook.GetGUID()
+	if notebook := cmp.Or(p.Notebook, p.NotebookID); notebook != "" && notebook != string(guid) && !strings.EqualFold(notebook, ls.notebook.GetName()) {
+		return
+	}
+
+	if tags := slices.Concat(p.TagIDs, p.Tags); len(tags) > 0 {
+		// Tags belong to the owner's account, so only the ones applied to
+		// notes in the linked notebook are visible.
+		linkedTags, lerr := ls.ListTagsByNotebook(ctx, ls.token, guid)
+		if lerr != nil {
+			err = makeError(lerr)
+			return
+		}
+		for _, tag := range tags {
+			ind := slices.IndexFunc(linkedTags, func(t *edam.Tag) bool {
+				return string(t.GetGUID()) == tag || strings.EqualFold(t.GetName(), tag)
+			})
+			if ind < 0 {
+				return nil, false, nil
+			}
+			tagIDs = append(tagIDs, linkedTags[ind].GetGUID())
+		}
+	}
+	ok = true
+	return
+}
+
+func resolveNotebookName(ctx context.Context, s *store, name string) (id string, err error) {
+	notebooks, err := s.ListNotebooks(ctx, s.token)
+	if err != nil {
+		err = makeError(err)
+		return
+	}
+	// Evernote notebook names are unique, without regard for case.
+	for _, notebook := range notebooks {
+		if strings.EqualFold(notebook.GetName(), name) {
+			id = string(notebook.GetGUID())
+			return
+		}
+	}
+	err = fmt.Errorf("%w; notebook %q", errNameNotFound, name)
+	return
+}
+
+var (
+	errNameNotFound = errors.New("name not found")
+	guidPattern     = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+// toFilters converts the params to a filter for active notes, followed by a
+// filter for inactive notes if IncludeTrashed is set. A NoteFilter matches
+// either active notes or inactive notes, but not both at once, so the trash is
+// searched in a separate pass.
+func (p *NotesRemoteQueryParams) toFilters() []*edam.NoteFilter {
+	filters := []*edam.NoteFilter{p.toFilter()}
+	if p.IncludeTrashed {
+		trashed := p.toFilter()
+		inactive := true
+		trashed.Inactive = &inactive
+		filters = append(filters, trashed)
+	}
+	return filters
 }
 
 // toFilter converts the params note search options. The default order is by
 // created at, which is good because it doesn't change.
 func (p *NotesRemoteQueryParams) toFilter() *edam.NoteFilter {
-	order := int32(edam.NoteSortOrder_CREATED)
-	ascending := true
+	sortOrder, err := p.sortOrder()
+	if err != nil {
+		sortOrder = edam.NoteSortOrder_CREATED
+	}
+	order := int32(sortOrder)
+	ascending := !p.Descending
 	filt := &edam.NoteFilter{
 		Order:     &order,
 		Ascending: &ascending,
@@ -151,9 +498,38 @@ func (p *NotesRemoteQueryParams) toFilter() *edam.NoteFilter {
 		guid := edam.GUID(p.NotebookID)
 		filt.NotebookGuid = &guid
 	}
+	if words := p.words(); words != "" {
+		filt.Words = &words
+	}
 	return filt
 }
 
+// words combines the Query with the date ranges, which are only expressible in
+// the search grammar. A negated term, such as -created:X, matches values
+// before X.
+func (p *NotesRemoteQueryParams) words() string {
+	const layout = "20060102T150405Z"
+	terms := make([]string, 0, 5)
+	if q := strings.TrimSpace(p.Query); q != "" {
+		terms = append(terms, q)
+	}
+	for _, rng := range []struct {
+		term string
+		val  time.Time
+	}{
+		{"created:", p.CreatedAfter},
+		{"-created:", p.CreatedBefore},
+		{"updated:", p.UpdatedAfter},
+		{"-updated:", p.UpdatedBefore},
+	} {
+		if rng.val.IsZero() {
+			continue
+		}
+		terms = append(terms, rng.term+rng.val.UTC().Format(layout))
+	}
+	return strings.Join(terms, " ")
+}
+
 var errPaginationOrdering = errors.New("pagination ordering probably messed up")
 
 // A paginator helps manage pagination state.
@@ -212,6 +588,11 @@ func newNote(noteMeta *edam.NoteMetadata, content string) (resource entity.LinkI
 		Source:            attrs.GetSource(),
 		SourceApplication: attrs.GetSourceApplication(),
 		SourceURL:         attrs.GetSourceURL(),
+		ReminderOrder:     attrs.GetReminderOrder(),
+	}
+	if attrs.IsSetReminderTime() {
+		reminderTime := makeTimestamp(attrs.GetReminderTime())
+		note.Attributes.ReminderTime = &reminderTime
 	}
 	resource = &Note{
 		Note:      &note,
@@ -220,21 +601,43 @@ func newNote(noteMeta *edam.NoteMetadata, content string) (resource entity.LinkI
 	return
 }
 
-// ReadLocal reads and parses notes saved in a local JSON file.
-func (n *Notes) ReadLocal(ctx context.Context, r io.Reader) (out []entity.LinkID, err error) {
-	decoder := json.NewDecoder(r)
-	var resources []*Note
-	if err = decoder.Decode(&resources); err != nil {
+func newAttachments(resources []*edam.Resource) (out []*entity.Attachment) {
+	if len(resources) < 1 {
 		return
 	}
-	out = make([]entity.LinkID, len(resources))
+	out = make([]*entity.Attachment, len(resources))
 	for i, res := range resources {
-		res.ServiceID = &entity.ServiceID{Value: res.ID}
-		out[i] = res
+		out[i] = &entity.Attachment{
+			Filename: res.GetAttributes().GetFileName(),
+			MIME:     res.GetMime(),
+			Data:     res.GetData().GetBody(),
+		}
 	}
 	return
 }
 
+// ReadLocal reads and parses notes saved in a local JSON file.
+func (n *Notes) ReadLocal(ctx context.Context, r io.Reader) (out []entity.LinkID, err error) {
+	return entity.Collect(n.StreamLocal(ctx, r))
+}
+
+// StreamLocal is like ReadLocal, but yields each note as soon as it's parsed,
+// in the order of the file.
+func (n *Notes) StreamLocal(ctx context.Context, r io.Reader) iter.Seq2[entity.LinkID, error] {
+	return func(yield func(entity.LinkID, error) bool) {
+		for res, err := range repo.DecodeArray[*Note](r) {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			res.ServiceID = &entity.ServiceID{Value: res.ID}
+			if !yield(res, nil) {
+				return
+			}
+		}
+	}
+}
+
 // Note represents a note in an Evernote EDAM API call. It also provides methods
 // to match with a Note in StandardNotes.
 type Note struct {
@@ -250,30 +653,5 @@ func (n *Note) LinkValues() []string {
 	}
 }
 
-func (n *Note) HTMLContent() (string, error) {
-	root, err := html.Parse(strings.NewReader(n.Content))
-	if err != nil {
-		return "", err
-	}
-	// descend to <en-note> and capture its children.
-	var curr *html.Node
-	curr = root.LastChild
-	if curr == nil || curr.Data != "html" {
-		return "", fmt.Errorf("could not find node: html")
-	}
-	curr = curr.LastChild
-	if curr == nil || curr.Data != "body" {
-		return "", fmt.Errorf("could not find node: html.body")
-	}
-	curr = curr.FirstChild
-	if curr == nil || curr.Data != "en-note" {
-		return "", fmt.Errorf("could not find node: html.body.en-note")
-	}
-	var bld strings.Builder
-	for curr = curr.FirstChild; curr != nil; curr = curr.NextSibling {
-		if err = html.Render(&bld, curr); err != nil {
-			return "", err
-		}
-	}
-	return bld.String(), nil
-}
+// HTMLContent extracts the HTML from the note content.
+func (n *Note) HTMLContent() (string, error) { return enml.HTML(n.Content) }
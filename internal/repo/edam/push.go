@@ -0,0 +1,165 @@
+package edam
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dreampuf/evernote-sdk-golang/edam"
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+)
+
+// Writer creates notes, and the tags on them, in one notebook of an Evernote
+// account. The notebook may also be one that's owned by another account and
+// shared with the user, such as a business notebook. Call UseNotebook before
+// creating anything.
+type Writer struct {
+	client       *Client
+	store        *store
+	notebookGUID string
+}
+
+// NewWriter constructs a Writer.
+func NewWriter(client *Client) *Writer { return &Writer{client: client} }
+
+var errNoNotebook = errors.New("call UseNotebook before creating tags or notes")
+
+// UseNotebook selects the notebook to create notes in, by name. It's looked up
+// among the user's notebooks, then among linked notebooks. When there's no
+// such notebook, it's created in the user's account. An empty name selects
+// the user's default notebook.
+func (w *Writer) UseNotebook(ctx context.Context, name string) (guid string, err error) {
+	s, err := w.client.connect(ctx)
+	if err != nil {
+		return
+	}
+	notebooks, err := s.ListNotebooks(ctx, s.token)
+	if err != nil {
+		err = makeError(err)
+		return
+	}
+	for _, notebook := range notebooks {
+		if (name == "" && notebook.GetDefaultNotebook()) || (name != "" && strings.EqualFold(notebook.GetName(), name)) {
+			w.store, w.notebookGUID = s, string(notebook.GetGUID())
+			return w.notebookGUID, nil
+		}
+	}
+	if name == "" {
+		err = fmt.Errorf("could not find default notebook")
+		return
+	}
+
+	linkedStores, err := listLinkedStores(ctx, s)
+	if err != nil {
+		return
+	}
+	for _, ls := range linkedStores {
+		if strings.EqualFold(ls.notebook.GetName(), name) || strings.EqualFold(ls.origin.ShareName, name) {
+			w.store, w.notebookGUID = ls.store, string(ls.notebook.GetGUID())
+			return w.notebookGUID, nil
+		}
+	}
+
+	created, err := s.CreateNotebook(ctx, s.token, &edam.Notebook{Name: &name})
+	if err != nil {
+		err = makeError(err)
+		return
+	}
+	w.store, w.notebookGUID = s, string(created.GetGUID())
+	return w.notebookGUID, nil
+}
+
+// EnsureTag creates a tag in the account that owns the selected notebook. If
+// there's already a tag with the name, then that one is used and created is
+// false.
+func (w *Writer) EnsureTag(ctx context.Context, name string) (guid string, created bool, err error) {
+	if w.store == nil {
+		err = errNoNotebook
+		return
+	}
+	tag, err := w.store.CreateTag(ctx, w.store.token, &edam.Tag{Name: &name})
+	if err == nil {
+		return string(tag.GetGUID()), true, nil
+	}
+	var userErr *edam.EDAMUserException
+	if !errors.As(err, &userErr) || userErr.GetErrorCode() != edam.EDAMErrorCode_DATA_CONFLICT {
+		err = makeError(err)
+		return
+	}
+
+	tags, err := w.store.ListTags(ctx, w.store.token)
+	if err != nil {
+		err = makeError(err)
+		return
+	}
+	for _, tag := range tags {
+		if strings.EqualFold(tag.GetName(), name) {
+			return string(tag.GetGUID()), false, nil
+		}
+	}
+	err = fmt.Errorf("tag %q conflicts with an existing tag, but it could not be found", name)
+	return
+}
+
+// CreateNote creates a note in the selected notebook. The content is converted
+// to ENML, attachments are uploaded as resources. The note's NotebookID and
+// TagIDs are ignored, it's tagged with tagGUIDs instead.
+func (w *Writer) CreateNote(ctx context.Context, note *entity.Note, tagGUIDs []string) (guid string, err error) {
+	if w.store == nil {
+		err = errNoNotebook
+		return
+	}
+	content := enml.Document(note.Content, note.Attachments)
+	in := &edam.Note{
+		Title:        &note.Title,
+		Content:      &content,
+		NotebookGuid: &w.notebookGUID,
+		TagGuids:     make([]edam.GUID, len(tagGUIDs)),
+		Resources:    make([]*edam.Resource, len(note.Attachments)),
+	}
+	if !note.CreatedAt.IsZero() {
+		in.Created = toTimestamp(note.CreatedAt)
+	}
+	if !note.UpdatedAt.IsZero() {
+		in.Updated = toTimestamp(note.UpdatedAt)
+	}
+	for i, id := range tagGUIDs {
+		in.TagGuids[i] = edam.GUID(id)
+	}
+	if attrs := note.Attributes; attrs != nil && (attrs.Source != "" || attrs.SourceURL != "") {
+		in.Attributes = &edam.NoteAttributes{}
+		if attrs.Source != "" {
+			in.Attributes.Source = &attrs.Source
+		}
+		if attrs.SourceURL != "" {
+			in.Attributes.SourceURL = &attrs.SourceURL
+		}
+	}
+	for i, att := range note.Attachments {
+		in.Resources[i] = newResource(att)
+	}
+
+	created, err := w.store.CreateNote(ctx, w.store.token, in)
+	if err != nil {
+		err = fmt.Errorf("%w; title: %q", makeError(err), note.Title)
+		return
+	}
+	guid = string(created.GetGUID())
+	return
+}
+
+func newResource(att *entity.Attachment) *edam.Resource {
+	size := int32(len(att.Data))
+	hash := md5.Sum(att.Data)
+	out := &edam.Resource{
+		Data: &edam.Data{Body: att.Data, BodyHash: hash[:], Size: &size},
+		Mime: &att.MIME,
+	}
+	if att.Filename != "" {
+		out.Attributes = &edam.ResourceAttributes{FileName: &att.Filename}
+	}
+	return out
+}
@@ -0,0 +1,72 @@
+package edam
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/dreampuf/evernote-sdk-golang/edam"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+)
+
+// SavedSearches handles input/output for saved searches from the Evernote EDAM
+// API.
+type SavedSearches struct{ client *Client }
+
+// NewSavedSearchesRepo constructs a SavedSearches repository. The client is
+// only needed to fetch remote data, it may be nil to only read local data.
+func NewSavedSearchesRepo(client *Client) (entity.LocalRemoteRepo, error) {
+	return &SavedSearches{client: client}, nil
+}
+
+// FetchRemote gets SavedSearches from the Evernote EDAM API.
+func (n *SavedSearches) FetchRemote(ctx context.Context) (out []entity.LinkID, err error) {
+	var (
+		s        *store
+		searches []*edam.SavedSearch
+	)
+	if s, err = n.client.connect(ctx); err != nil {
+		return
+	}
+	if searches, err = s.ListSearches(ctx, s.token); err != nil {
+		err = makeError(err)
+		return
+	}
+	out = make([]entity.LinkID, len(searches))
+	for i, search := range searches {
+		id := string(search.GetGUID())
+		out[i] = &SavedSearch{
+			SavedSearch: &entity.SavedSearch{
+				Name:  search.GetName(),
+				Query: search.GetQuery(),
+				ID:    id,
+			},
+			ServiceID: &entity.ServiceID{Value: id},
+		}
+	}
+	return
+}
+
+// ReadLocal reads and parses saved searches saved in a local JSON file.
+func (n *SavedSearches) ReadLocal(ctx context.Context, r io.Reader) (out []entity.LinkID, err error) {
+	decoder := json.NewDecoder(r)
+	var resources []*SavedSearch
+	if err = decoder.Decode(&resources); err != nil {
+		return
+	}
+	out = make([]entity.LinkID, len(resources))
+	for i, res := range resources {
+		res.ServiceID = &entity.ServiceID{Value: res.ID}
+		out[i] = res
+	}
+	return
+}
+
+// SavedSearch represents a saved search in an Evernote EDAM API call. The
+// closest thing in StandardNotes is a smart view.
+type SavedSearch struct {
+	*entity.SavedSearch
+	*entity.ServiceID
+}
+
+func (s *SavedSearch) LinkValues() []string { return []string{s.Name} }
@@ -10,21 +10,33 @@ import (
 )
 
 // Tags handles input/output for tags from the Evernote EDAM API.
-type Tags struct{}
+type Tags struct {
+	client *Client
+	rqp    TagsRemoteQueryParams
+}
+
+// TagsRemoteQueryParams is a set of named options for listing Evernote tags.
+type TagsRemoteQueryParams struct {
+	// IncludeLinked also lists tags used in notebooks owned by other accounts.
+	IncludeLinked bool
+}
 
-// NewTagsRepo constructs a Tags repository.
-func NewTagsRepo() (entity.LocalRemoteRepo, error) { return &Tags{}, nil }
+// NewTagsRepo constructs a Tags repository. The client is only needed to fetch
+// remote data, it may be nil to only read local data.
+func NewTagsRepo(client *Client, rqp *TagsRemoteQueryParams) (entity.LocalRemoteRepo, error) {
+	if rqp == nil {
+		rqp = &TagsRemoteQueryParams{}
+	}
+	return &Tags{client: client, rqp: *rqp}, nil
+}
 
 // FetchRemote gets Tags from the Evernote EDAM API.
 func (n *Tags) FetchRemote(ctx context.Context) (out []entity.LinkID, err error) {
 	var (
 		s    *store
 		tags []*edam.Tag
-		i    int
-		tag  *edam.Tag
-		id   string
 	)
-	if s, err = initStore(ctx); err != nil {
+	if s, err = n.client.connect(ctx); err != nil {
 		return
 	}
 	if tags, err = s.ListTags(ctx, s.token); err != nil {
@@ -32,20 +44,44 @@ func (n *Tags) FetchRemote(ctx context.Context) (out []entity.LinkID, err error)
 		return
 	}
 	out = make([]entity.LinkID, len(tags))
-	for i, tag = range tags {
-		id = string(tag.GetGUID())
-		out[i] = &Tag{
-			Tag: &entity.Tag{
-				Name:     tag.GetName(),
-				ID:       id,
-				ParentID: string(tag.GetParentGuid()),
-			},
-			ServiceID: &entity.ServiceID{Value: id},
+	for i, tag := range tags {
+		out[i] = newTag(tag, nil)
+	}
+	if !n.rqp.IncludeLinked {
+		return
+	}
+
+	linkedStores, err := listLinkedStores(ctx, s)
+	if err != nil {
+		return
+	}
+	for _, ls := range linkedStores {
+		// Tags belong to the owner's account, so only the ones applied to
+		// notes in the linked notebook are visible.
+		if tags, err = ls.ListTagsByNotebook(ctx, ls.token, ls.notebook.GetGUID()); err != nil {
+			err = makeError(err)
+			return
+		}
+		for _, tag := range tags {
+			out = append(out, newTag(tag, ls.origin))
 		}
 	}
 	return
 }
 
+func newTag(tag *edam.Tag, origin *entity.Origin) *Tag {
+	id := string(tag.GetGUID())
+	return &Tag{
+		Tag: &entity.Tag{
+			Name:     tag.GetName(),
+			ID:       id,
+			ParentID: string(tag.GetParentGuid()),
+			Origin:   origin,
+		},
+		ServiceID: &entity.ServiceID{Value: id},
+	}
+}
+
 // ReadLocal reads and parses tags saved in a local JSON file.
 func (n *Tags) ReadLocal(ctx context.Context, r io.Reader) (out []entity.LinkID, err error) {
 	decoder := json.NewDecoder(r)
@@ -0,0 +1,206 @@
+package enex
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
+)
+
+func init() {
+	repo.RegisterFormat(repo.Format{
+		Name:        "enex",
+		Description: "Evernote export file",
+		Read: func(ctx context.Context, path string, opts repo.Options) (*repo.Source, error) {
+			out := &repo.Source{Collection: &entity.Collection{Service: "evernote.com"}}
+			var tags TagCollector
+			out.Notes = func(yield func(*entity.Note, error) bool) {
+				for item, err := range repo.StreamLocalFile(ctx, &File{Attachments: true}, path) {
+					var note *entity.Note
+					if err == nil {
+						note = tags.Add(item.(*Note).Note)
+						out.Collection.Tags = tags.Tags
+					}
+					if !yield(note, err) {
+						return
+					}
+				}
+			}
+			return out, nil
+		},
+		NewEncoder: func(ctx context.Context, w io.Writer, in *entity.Collection, params *repo.WriteParams) (repo.Encoder, error) {
+			return NewEncoder(w, in, params)
+		},
+	})
+}
+
+// NewCollection makes a Collection of notes read from an export file. The file
+// only has the names of tags, so tags are identified by name. It doesn't have
+// notebooks or saved searches.
+func NewCollection(notes []entity.LinkID) *entity.Collection {
+	out := &entity.Collection{Service: "evernote.com", Notes: make([]*entity.Note, len(notes))}
+	var tags TagCollector
+	for i, item := range notes {
+		out.Notes[i] = tags.Add(item.(*Note).Note)
+	}
+	out.Tags = tags.Tags
+	return out
+}
+
+// A TagCollector makes tags from the tag names of notes, as they're read one at
+// a time. The zero value is ready to use.
+type TagCollector struct {
+	// Tags are identified by name, in the order they're first seen.
+	Tags []*entity.Tag
+	seen map[string]bool
+}
+
+// Add sets the TagIDs of a note to the names of its tags, and keeps any tags
+// that haven't been seen yet. The output is the input.
+func (c *TagCollector) Add(note *entity.Note) *entity.Note {
+	if c.seen == nil {
+		c.seen = make(map[string]bool)
+	}
+	note.TagIDs = make([]string, len(note.Tags))
+	for j, name := range note.Tags {
+		note.TagIDs[j] = name
+		if !c.seen[name] {
+			c.seen[name] = true
+			c.Tags = append(c.Tags, &entity.Tag{ID: name, Name: name})
+		}
+	}
+	return note
+}
+
+// These types are the parts of the export format that are written. See
+// http://xml.evernote.com/pub/evernote-export3.dtd.
+type (
+	noteXML struct {
+		XMLName xml.Name `xml:"note"`
+		Title   string   `xml:"title"`
+		Content struct {
+			XML string `xml:",cdata"`
+		} `xml:"content"`
+		Created    string             `xml:"created,omitempty"`
+		Updated    string             `xml:"updated,omitempty"`
+		Tags       []string           `xml:"tag"`
+		Attributes *noteAttributesXML `xml:"note-attributes,omitempty"`
+		Resources  []resourceXML      `xml:"resource"`
+	}
+	noteAttributesXML struct {
+		Source    string `xml:"source,omitempty"`
+		SourceURL string `xml:"source-url,omitempty"`
+	}
+	resourceXML struct {
+		Data struct {
+			Encoding string `xml:"encoding,attr"`
+			Body     string `xml:",chardata"`
+		} `xml:"data"`
+		MIME       string                 `xml:"mime"`
+		Attributes *resourceAttributesXML `xml:"resource-attributes,omitempty"`
+	}
+	resourceAttributesXML struct {
+		FileName string `xml:"file-name"`
+	}
+)
+
+const exportTimeformat = "20060102T150405Z"
+
+// An Encoder writes an export file one note at a time. Notes are tagged by
+// name and attachments are embedded. Notebooks and saved searches are not part
+// of the format, so they're left out.
+type Encoder struct {
+	w       io.Writer
+	enc     *xml.Encoder
+	in      *entity.Collection
+	keyring *enml.Keyring
+	// tagNames are the names of the tags of the Collection by ID, as of when
+	// it had numTags tags.
+	tagNames map[string]string
+	numTags  int
+}
+
+var exportElement = xml.Name{Local: "en-export"}
+
+// NewEncoder starts an export file of a Collection. Of the params, only the
+// Keyring applies, it decrypts the encrypted text of notes.
+func NewEncoder(w io.Writer, in *entity.Collection, params *repo.WriteParams) (out *Encoder, err error) {
+	out = &Encoder{w: w, enc: xml.NewEncoder(w), in: in}
+	if params != nil {
+		out.keyring = params.Keyring
+	}
+	out.enc.Indent("", "  ")
+	if _, err = io.WriteString(w, xml.Header+`<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">`+"\n"); err != nil {
+		return
+	}
+	err = out.enc.EncodeToken(xml.StartElement{Name: exportElement, Attr: []xml.Attr{
+		{Name: xml.Name{Local: "export-date"}, Value: time.Now().UTC().Format(exportTimeformat)},
+		{Name: xml.Name{Local: "application"}, Value: "notexfr"},
+	}})
+	return
+}
+
+// WriteNote writes a note. Its tags are named by the note itself or else by the
+// tags of the Collection.
+func (e *Encoder) WriteNote(note *entity.Note) error {
+	content, _ := e.keyring.Decrypt(note.Content)
+	item := noteXML{Title: note.Title, Tags: note.Tags}
+	item.Content.XML = enml.Document(content, note.Attachments)
+	if !note.CreatedAt.IsZero() {
+		item.Created = note.CreatedAt.UTC().Format(exportTimeformat)
+	}
+	if !note.UpdatedAt.IsZero() {
+		item.Updated = note.UpdatedAt.UTC().Format(exportTimeformat)
+	}
+	if len(item.Tags) < 1 {
+		for _, tagID := range note.TagIDs {
+			if name, ok := e.tagName(tagID); ok {
+				item.Tags = append(item.Tags, name)
+			}
+		}
+	}
+	if attrs := note.Attributes; attrs != nil && (attrs.Source != "" || attrs.SourceURL != "") {
+		item.Attributes = &noteAttributesXML{Source: attrs.Source, SourceURL: attrs.SourceURL}
+	}
+	for _, att := range note.Attachments {
+		res := resourceXML{MIME: att.MIME}
+		res.Data.Encoding = "base64"
+		res.Data.Body = base64.StdEncoding.EncodeToString(att.Data)
+		if att.Filename != "" {
+			res.Attributes = &resourceAttributesXML{FileName: att.Filename}
+		}
+		item.Resources = append(item.Resources, res)
+	}
+	return e.enc.Encode(item)
+}
+
+// tagName looks up a tag of the Collection, which may have gained tags since
+// the last lookup.
+func (e *Encoder) tagName(tagID string) (name string, ok bool) {
+	if len(e.in.Tags) != e.numTags {
+		e.tagNames = make(map[string]string, len(e.in.Tags))
+		for _, tag := range e.in.Tags {
+			e.tagNames[tag.ID] = tag.Name
+		}
+		e.numTags = len(e.in.Tags)
+	}
+	name, ok = e.tagNames[tagID]
+	return
+}
+
+// Close ends the file.
+func (e *Encoder) Close() (err error) {
+	if err = e.enc.EncodeToken(xml.EndElement{Name: exportElement}); err != nil {
+		return
+	}
+	if err = e.enc.Flush(); err != nil {
+		return
+	}
+	_, err = io.WriteString(e.w, "\n")
+	return
+}
@@ -5,20 +5,27 @@ package enex
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/macrat/go-enex"
+	"github.com/rafaelespinoza/notexfr/internal/enml"
 	"github.com/rafaelespinoza/notexfr/internal/entity"
-	"golang.org/x/net/html"
 )
 
 // File implements the local repository interface for enex files.
-type File struct{}
+type File struct {
+	// Attachments also decodes the data of each attachment of a note.
+	// Otherwise, only the filename and type of each one are kept, which keeps
+	// notes small when the data isn't needed, such as when writing JSON.
+	Attachments bool
+}
 
 // NewFileRepo constructs a File.
 func NewFileRepo() (entity.RepoLocal, error) { return &File{}, nil }
@@ -27,25 +34,55 @@ const timeformat = "2006-01-02T15:04:05Z"
 
 // ReadLocal reads and parses an enex file.
 func (f *File) ReadLocal(ctx context.Context, r io.Reader) (out []entity.LinkID, err error) {
-	var (
-		parsed enex.EvernoteExportedXML
-		note   entity.LinkID
-	)
-
-	if parsed, err = enex.ParseFromReader(r); err != nil {
-		return
-	}
+	return entity.Collect(f.StreamLocal(ctx, r))
+}
 
-	resources := make([]entity.LinkID, len(parsed.Notes))
-	for i := range parsed.Notes {
-		enexNote := parsed.Notes[i]
-		if note, err = newNoteFromEnex(&enexNote); err != nil {
-			return
+// StreamLocal is like ReadLocal, but yields each note as soon as it's parsed,
+// in the order of the file. Only one note, with its attachments, is in memory
+// at once. A note with invalid values, such as a date, is yielded as a
+// *entity.NoteError, after which the sequence may continue.
+func (f *File) StreamLocal(ctx context.Context, r io.Reader) iter.Seq2[entity.LinkID, error] {
+	return func(yield func(entity.LinkID, error) bool) {
+		decoder := xml.NewDecoder(r)
+		for {
+			tok, err := decoder.Token()
+			if errors.Is(err, io.EOF) {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			}
+			start, ok := tok.(xml.StartElement)
+			if !ok || start.Name.Local != "note" {
+				continue
+			}
+			var enexNote noteXMLIn
+			var note entity.LinkID
+			var serr *xml.SyntaxError
+			if err = decoder.DecodeElement(&enexNote, &start); errors.As(err, &serr) {
+				yield(nil, err)
+				return
+			} else if err == nil {
+				note, err = newNoteFromEnex(&enexNote, f.Attachments)
+			}
+			// Otherwise, it's an invalid value, such as a date. The rest of
+			// the note is passed over while looking for the next one.
+			if err != nil {
+				err = &entity.NoteError{Title: enexNote.Title, Stage: entity.StageRead, Err: err}
+			}
+			if !yield(note, err) {
+				return
+			}
 		}
-		resources[i] = note
 	}
-	out = resources
-	return
+}
+
+// noteXMLIn is a note as it's read, with the attributes that enex.Note leaves
+// out.
+type noteXMLIn struct {
+	enex.Note
+	ReminderOrder int64          `xml:"note-attributes>reminder-order"`
+	ReminderTime  *enex.DateTime `xml:"note-attributes>reminder-time"`
 }
 
 // A Note is a note entity in an enex file.
@@ -63,35 +100,9 @@ func (n *Note) LinkValues() []string {
 }
 
 // HTMLContent extracts the HTML from the note content.
-func (n *Note) HTMLContent() (string, error) {
-	root, err := html.Parse(strings.NewReader(n.Content))
-	if err != nil {
-		return "", err
-	}
-	// descend to <en-note> and capture its children.
-	var curr *html.Node
-	curr = root.LastChild
-	if curr.Data != "html" {
-		return "", fmt.Errorf("could not find node: html")
-	}
-	curr = curr.LastChild
-	if curr.Data != "body" {
-		return "", fmt.Errorf("could not find node: html.body")
-	}
-	curr = curr.FirstChild
-	if curr.Data != "en-note" {
-		return "", fmt.Errorf("could not find node: html.body.en-note")
-	}
-	var bld strings.Builder
-	for curr = curr.FirstChild; curr != nil; curr = curr.NextSibling {
-		if err = html.Render(&bld, curr); err != nil {
-			return "", err
-		}
-	}
-	return bld.String(), nil
-}
+func (n *Note) HTMLContent() (string, error) { return enml.HTML(n.Content) }
 
-func newNoteFromEnex(enexNote *enex.Note) (resource entity.LinkID, err error) {
+func newNoteFromEnex(enexNote *noteXMLIn, withData bool) (resource entity.LinkID, err error) {
 	var createdAt, updatedAt time.Time
 	if createdAt, err = time.Parse(timeformat, enexNote.CreatedAt.String()); err != nil {
 		return
@@ -103,17 +114,40 @@ func newNoteFromEnex(enexNote *enex.Note) (resource entity.LinkID, err error) {
 	if enexNote.SourceURL != nil {
 		sourceURL = enexNote.SourceURL.String()
 	}
+	var attachments []*entity.Attachment
+	for _, res := range enexNote.Resources {
+		if !withData {
+			attachments = append(attachments, &entity.Attachment{Filename: res.Name, MIME: res.Type})
+			continue
+		}
+		// The decoded data is unexported, so encode it again.
+		var encoded, data []byte
+		if encoded, err = res.Data.MarshalText(); err != nil {
+			return
+		}
+		if data, err = base64.StdEncoding.DecodeString(string(encoded)); err != nil {
+			return
+		}
+		attachments = append(attachments, &entity.Attachment{Filename: res.Name, MIME: res.Type, Data: data})
+	}
+	attributes := &entity.Attributes{
+		Source:        enexNote.Source,
+		SourceURL:     sourceURL,
+		ReminderOrder: enexNote.ReminderOrder,
+	}
+	if enexNote.ReminderTime != nil {
+		reminderTime := time.Time(*enexNote.ReminderTime).UTC()
+		attributes.ReminderTime = &reminderTime
+	}
 	resource = &Note{
 		Note: &entity.Note{
-			Title:     enexNote.Title,
-			Tags:      enexNote.Tags,
-			CreatedAt: createdAt,
-			UpdatedAt: updatedAt,
-			Content:   enexNote.Content.XML,
-			Attributes: &entity.Attributes{
-				Source:    enexNote.Source,
-				SourceURL: sourceURL,
-			},
+			Title:       enexNote.Title,
+			Tags:        enexNote.Tags,
+			CreatedAt:   createdAt,
+			UpdatedAt:   updatedAt,
+			Content:     enexNote.Content.XML,
+			Attributes:  attributes,
+			Attachments: attachments,
 		},
 	}
 	return
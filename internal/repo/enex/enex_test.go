@@ -1,6 +1,8 @@
 package enex_test
 
 import (
+	"context"
+	"os"
 	"testing"
 
 	"github.com/rafaelespinoza/notexfr/internal/entity"
@@ -19,6 +21,12 @@ func TestInterfaceImplementations(t *testing.T) {
 					i, val,
 				)
 			}
+			if _, ok := val.(entity.RepoLocalStream); !ok {
+				t.Errorf(
+					"test %d; expected value of type %T to implement entity.RepoLocalStream",
+					i, val,
+				)
+			}
 		}
 	})
 
@@ -36,3 +44,32 @@ func TestInterfaceImplementations(t *testing.T) {
 		}
 	})
 }
+
+func TestReadLocal(t *testing.T) {
+	for _, withData := range []bool{false, true} {
+		file, err := os.Open("../../fixtures/test_export_attachments.enex")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		notes, err := (&enex.File{Attachments: withData}).ReadLocal(context.TODO(), file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var numAttachments int
+		for _, item := range notes {
+			for _, att := range item.(*enex.Note).Attachments {
+				numAttachments++
+				if att.Filename == "" || att.MIME == "" {
+					t.Errorf("data %t; expected filename and type; got %q, %q", withData, att.Filename, att.MIME)
+				}
+				if (len(att.Data) > 0) != withData {
+					t.Errorf("data %t; got %d bytes of data for %q", withData, len(att.Data), att.Filename)
+				}
+			}
+		}
+		if numAttachments != 2 {
+			t.Errorf("data %t; wrong number of attachments; got %d, expected %d", withData, numAttachments, 2)
+		}
+	}
+}
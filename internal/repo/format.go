@@ -0,0 +1,195 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"sort"
+	"sync"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/repo/ledger"
+)
+
+// A Format is a way of representing notes and related data, such as the files
+// of a particular service. Each Format converts to and from the common model,
+// an entity.Collection, so that any readable Format can be converted to any
+// writable Format. Notes are read and written one at a time, so that they
+// don't all have to be in memory at once. A package implementing a Format
+// should register it in an init function.
+type Format struct {
+	// Name identifies the Format, such as "enex".
+	Name string
+	// Description is a short explanation of what the input or output is.
+	Description string
+	// Options are the names of the options understood by Read or
+	// NewEncoder, along with a description of each.
+	Options map[string]string
+	// Read opens the data at path, which may be a file or a directory. It's
+	// nil when the Format can't be read.
+	Read func(ctx context.Context, path string, opts Options) (*Source, error)
+	// NewEncoder starts writing the Format to w. The Collection has
+	// everything but the notes, which are passed to the Encoder one at a
+	// time. It's nil when the Format can't be written.
+	NewEncoder func(ctx context.Context, w io.Writer, in *entity.Collection, params *WriteParams) (Encoder, error)
+}
+
+// A Source is data read in a Format. The Collection has everything but the
+// notes, which are yielded by Notes as they're read. Some formats only have the
+// names of the tags of each note, so those tags are added to the Collection as
+// the notes are read. It's complete once all of the notes are.
+type Source struct {
+	Collection *entity.Collection
+	Notes      iter.Seq2[*entity.Note, error]
+}
+
+// NewSource makes a Source of a Collection that's already been read, for
+// formats that can't be read one note at a time. The notes are moved from the
+// Collection to the Source.
+func NewSource(in *entity.Collection) *Source {
+	notes := in.Notes
+	in.Notes = nil
+	return &Source{
+		Collection: in,
+		Notes: func(yield func(*entity.Note, error) bool) {
+			for _, note := range notes {
+				if !yield(note, nil) {
+					return
+				}
+			}
+		},
+	}
+}
+
+// ReadGraph reads all of the data at path, notes included, into a Graph.
+func ReadGraph(ctx context.Context, format Format, path string, opts Options) (out *entity.Graph, err error) {
+	if format.Read == nil {
+		err = fmt.Errorf("format %q can not be read", format.Name)
+		return
+	}
+	src, err := format.Read(ctx, path, opts)
+	if err != nil {
+		return
+	}
+	for note, nerr := range src.Notes {
+		if nerr != nil {
+			err = nerr
+			return
+		}
+		src.Collection.Notes = append(src.Collection.Notes, note)
+	}
+	return entity.NewGraph(src.Collection)
+}
+
+// An Encoder writes a Format one note at a time.
+type Encoder interface {
+	// WriteNote writes a note. A note that can't be written doesn't stop
+	// others from being written, so the error may be skipped.
+	WriteNote(note *entity.Note) error
+	// Close writes everything else in the Collection passed to NewEncoder,
+	// as it is by then, and ends the output.
+	Close() error
+}
+
+// WriteParams are settings for NewEncoder. The zero value writes the Format
+// with its defaults. A Format ignores the ones that don't apply to it.
+type WriteParams struct {
+	// Options are understood by the Format, see Format.Options.
+	Options Options
+	// Keyring, if set, decrypts the encrypted text of notes, which is
+	// inlined. Text that no passphrase decrypts is left as it is.
+	Keyring *enml.Keyring
+	// Ledger, if set, records the ID that each resource is written as, so
+	// that writing it again keeps the ID. A note that hasn't changed since it
+	// was recorded may be left out.
+	Ledger *ledger.Ledger
+	// OnItem, if set, is called with each item as it's written, for formats
+	// that are written as items.
+	OnItem func(item entity.LinkID)
+	// OnLossy, if set, is called with each note that has features that the
+	// Format doesn't have.
+	OnLossy func(note LossyNote)
+}
+
+// A LossyFeature is something in a note that a Format doesn't have, so it's
+// dropped or approximated when the note is written.
+type LossyFeature struct {
+	// Name is what the feature is, such as "attachment".
+	Name string `json:"name"`
+	// Count is how many times it's in the note, such as the number of
+	// attachments.
+	Count int `json:"count"`
+	// Detail says what happens to it.
+	Detail string `json:"detail"`
+}
+
+// A LossyNote is a note with LossyFeatures.
+type LossyNote struct {
+	ID       string         `json:"id,omitempty"`
+	Title    string         `json:"title"`
+	Features []LossyFeature `json:"features"`
+}
+
+// Options are named settings for reading or writing a Format.
+type Options map[string]string
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[string]Format)
+
+	// ErrFormatUnknown means that no Format is registered by a name.
+	ErrFormatUnknown = errors.New("unknown format")
+)
+
+// RegisterFormat makes a Format available by its name. It panics if the name
+// is empty or already taken, or if the Format can be neither read nor written.
+func RegisterFormat(format Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if format.Name == "" {
+		panic("repo: format name is required")
+	}
+	if format.Read == nil && format.NewEncoder == nil {
+		panic("repo: format " + format.Name + " can be neither read nor written")
+	}
+	if _, ok := formats[format.Name]; ok {
+		panic("repo: format " + format.Name + " registered twice")
+	}
+	formats[format.Name] = format
+}
+
+// LookupFormat gets a registered Format by name.
+func LookupFormat(name string) (out Format, err error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	out, ok := formats[name]
+	if !ok {
+		err = fmt.Errorf("%w %q, should be one of %q", ErrFormatUnknown, name, formatNames())
+	}
+	return
+}
+
+// Formats lists the registered Formats, sorted by name.
+func Formats() []Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	out := make([]Format, 0, len(formats))
+	for _, name := range formatNames() {
+		out = append(out, formats[name])
+	}
+	return out
+}
+
+// formatNames is the sorted names of registered Formats. Callers must hold the
+// lock.
+func formatNames() []string {
+	out := make([]string, 0, len(formats))
+	for name := range formats {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,215 @@
+// Package ledger keeps a record, across runs, of which resource in one service
+// became which resource in another. The record is a file of JSON lines, one
+// per Entry, which is only ever appended to. The last line for a Key is the
+// current one.
+package ledger
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+)
+
+// Key identifies a resource in the source service, and the destination service
+// it's converted to.
+type Key struct {
+	SourceService string `json:"source_service"`
+	// ContentType is the kind of resource, such as Note or Tag.
+	ContentType string `json:"content_type"`
+	SourceID    string `json:"source_id"`
+	DestService string `json:"destination_service"`
+}
+
+// An Entry records that a resource was converted.
+type Entry struct {
+	Key
+	DestID string `json:"destination_id"`
+	// ContentHash identifies the content of the source resource when it was
+	// converted, so that changes can be detected. See NoteHash.
+	ContentHash string `json:"content_hash"`
+	// Time is when the entry was recorded.
+	Time time.Time `json:"time"`
+}
+
+// A Ledger is a file of entries, which are all read when it's opened. Entries
+// recorded since then are only written by Commit, so that a run that fails
+// partway leaves the file as it was. Call Close when done.
+type Ledger struct {
+	// file is nil when the Ledger is only read, see Read.
+	file    *os.File
+	entries map[Key]Entry
+	// order is the keys in the order they first appear, so that output is
+	// consistent.
+	order []Key
+	// seen is the keys that were recorded since the Ledger was opened.
+	seen map[Key]bool
+	// pending are the entries to write on Commit.
+	pending []Entry
+}
+
+// ErrLedger means that the ledger file could not be read.
+var ErrLedger = errors.New("ledger error")
+
+// Open reads the ledger file, creating it if it doesn't exist yet. Entries
+// recorded later are appended to it by Commit.
+func Open(filename string) (out *Ledger, err error) {
+	file, err := os.OpenFile(filepath.Clean(filename), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	out = &Ledger{file: file}
+	if err = out.read(filename); err != nil {
+		_ = file.Close()
+		out = nil
+	}
+	return
+}
+
+// Read is like Open, but entries recorded later are only kept in memory, and
+// the file is not created if it doesn't exist. Use it to preview changes.
+func Read(filename string) (out *Ledger, err error) {
+	file, err := os.Open(filepath.Clean(filename))
+	if errors.Is(err, os.ErrNotExist) {
+		out, err = &Ledger{entries: make(map[Key]Entry), seen: make(map[Key]bool)}, nil
+		return
+	} else if err != nil {
+		return
+	}
+	defer func() { _ = file.Close() }()
+	out = &Ledger{file: file}
+	err = out.read(filename)
+	out.file = nil
+	if err != nil {
+		out = nil
+	}
+	return
+}
+
+func (l *Ledger) read(filename string) (err error) {
+	l.entries, l.seen = make(map[Key]Entry), make(map[Key]bool)
+	scanner := bufio.NewScanner(l.file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry Entry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			err = fmt.Errorf("%w; %s line %d; %v", ErrLedger, filename, line, err)
+			return
+		}
+		l.put(entry)
+	}
+	err = scanner.Err()
+	return
+}
+
+func (l *Ledger) put(entry Entry) {
+	if _, ok := l.entries[entry.Key]; !ok {
+		l.order = append(l.order, entry.Key)
+	}
+	l.entries[entry.Key] = entry
+}
+
+// Lookup gets the current entry for a resource.
+func (l *Ledger) Lookup(key Key) (out Entry, ok bool) {
+	out, ok = l.entries[key]
+	return
+}
+
+// Record notes that a resource was converted. It's only written to the file
+// when it's new, or the destination ID or the content hash has changed, so
+// recording an unchanged resource only marks it as seen. The Time is set if
+// it's empty. It's kept in memory until Commit.
+func (l *Ledger) Record(entry Entry) (err error) {
+	l.seen[entry.Key] = true
+	if prev, ok := l.entries[entry.Key]; ok && prev.DestID == entry.DestID && prev.ContentHash == entry.ContentHash {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	if l.file != nil {
+		l.pending = append(l.pending, entry)
+	}
+	l.put(entry)
+	return
+}
+
+// Commit writes the entries recorded since the last Commit to the file. Call
+// it once the output that they refer to is complete. If the Ledger was only
+// read, nothing is written.
+func (l *Ledger) Commit() (err error) {
+	if l.file == nil || len(l.pending) < 1 {
+		return
+	}
+	var data []byte
+	for _, entry := range l.pending {
+		line, merr := json.Marshal(entry)
+		if merr != nil {
+			return merr
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if _, err = l.file.Write(data); err != nil {
+		return
+	}
+	l.pending = nil
+	return
+}
+
+// Orphans lists the entries between two services that weren't recorded since
+// the Ledger was opened. After converting everything from the source service,
+// these are resources that were converted before, but may have since been
+// deleted.
+func (l *Ledger) Orphans(sourceService, destService string) (out []Entry) {
+	for _, key := range l.order {
+		if key.SourceService != sourceService || key.DestService != destService || l.seen[key] {
+			continue
+		}
+		out = append(out, l.entries[key])
+	}
+	return
+}
+
+// Close closes the file, if there is one. Entries that weren't committed are
+// discarded.
+func (l *Ledger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Hash makes a content hash of some values.
+func Hash(values ...string) string {
+	hash := sha256.New()
+	for _, val := range values {
+		_, _ = hash.Write([]byte(val))
+		_, _ = hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// NoteHash makes a content hash of what's converted from a note: its title,
+// content, notebook, tags and when it was last updated. The settings, if any,
+// are whatever else changes how the note is converted, so that converting it
+// with other settings isn't mistaken for no change.
+func NoteHash(note *entity.Note, settings ...string) string {
+	values := []string{note.Title, note.Content, note.NotebookID, note.UpdatedAt.UTC().Format(time.RFC3339Nano)}
+	values = append(values, note.TagIDs...)
+	if len(settings) > 0 {
+		// The separator keeps settings apart from tag IDs.
+		values = append(append(values, ""), settings...)
+	}
+	return Hash(values...)
+}
@@ -0,0 +1,124 @@
+package ledger_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/repo/ledger"
+)
+
+func TestLedger(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ledger.jsonl")
+	keyA := ledger.Key{SourceService: "evernote.com", ContentType: "Note", SourceID: "a", DestService: "standardnotes.com"}
+	keyB := ledger.Key{SourceService: "evernote.com", ContentType: "Note", SourceID: "b", DestService: "standardnotes.com"}
+	keyC := ledger.Key{SourceService: "other", ContentType: "Note", SourceID: "c", DestService: "standardnotes.com"}
+
+	book, err := ledger.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []ledger.Entry{
+		{Key: keyA, DestID: "uuid-a", ContentHash: "1"},
+		{Key: keyB, DestID: "uuid-b", ContentHash: "1"},
+		{Key: keyC, DestID: "uuid-c", ContentHash: "1"},
+		{Key: keyA, DestID: "uuid-a", ContentHash: "2"},
+		{Key: keyA, DestID: "uuid-a", ContentHash: "2"},
+	} {
+		if err = book.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if orphans := book.Orphans("evernote.com", "standardnotes.com"); len(orphans) != 0 {
+		t.Errorf("expected no orphans after recording everything; got %+v", orphans)
+	}
+	if err = book.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = book.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An unchanged entry isn't written again.
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Errorf("wrong number of lines; got %d, expected %d\n%s", lines, 4, data)
+	}
+
+	book, err = ledger.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := book.Lookup(keyA)
+	if !ok || entry.DestID != "uuid-a" || entry.ContentHash != "2" || entry.Time.IsZero() {
+		t.Errorf("expected last entry for key; got %+v, %t", entry, ok)
+	}
+	if _, ok = book.Lookup(ledger.Key{SourceID: "nope"}); ok {
+		t.Error("expected no entry for unknown key")
+	}
+	if err = book.Record(ledger.Entry{Key: keyA, DestID: "uuid-a", ContentHash: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	orphans := book.Orphans("evernote.com", "standardnotes.com")
+	if len(orphans) != 1 || orphans[0].Key != keyB {
+		t.Errorf("wrong orphans; got %+v", orphans)
+	}
+
+	// Entries that aren't committed are discarded.
+	if err = book.Record(ledger.Entry{Key: keyB, DestID: "uuid-b", ContentHash: "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = book.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := os.ReadFile(filename); err != nil {
+		t.Fatal(err)
+	} else if string(again) != string(data) {
+		t.Errorf("expected file not to change without Commit; got\n%s", again)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ledger.jsonl")
+	if err := os.WriteFile(filename, []byte(`{"source_id":"a"}`+"\n\nnope\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ledger.Open(filename)
+	if !errors.Is(err, ledger.ErrLedger) {
+		t.Errorf("expected error %v; got %v", ledger.ErrLedger, err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected error to have line number; got %v", err)
+	}
+}
+
+func TestNoteHash(t *testing.T) {
+	note := entity.Note{Title: "a", Content: "b", TagIDs: []string{"c"}, UpdatedAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	hash := ledger.NoteHash(&note)
+	if again := note; ledger.NoteHash(&again) != hash {
+		t.Error("expected same hash for same note")
+	}
+	for _, changed := range []entity.Note{
+		{Title: "a", Content: "b!", TagIDs: note.TagIDs, UpdatedAt: note.UpdatedAt},
+		{Title: "a", Content: "b", UpdatedAt: note.UpdatedAt},
+		{Title: "a", Content: "b", TagIDs: note.TagIDs},
+		{Title: "ab", TagIDs: note.TagIDs, UpdatedAt: note.UpdatedAt},
+	} {
+		if ledger.NoteHash(&changed) == hash {
+			t.Errorf("expected different hash for %+v", changed)
+		}
+	}
+	if ledger.NoteHash(&note, "note-text=markdown") == hash {
+		t.Error("expected different hash for other settings")
+	}
+	if ledger.NoteHash(&note, "c") == ledger.NoteHash(&entity.Note{Title: "a", Content: "b", TagIDs: []string{"c", "c"}, UpdatedAt: note.UpdatedAt}) {
+		t.Error("expected settings not to be mistaken for tag IDs")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/progress"
 )
 
 // Error should wrap the error from a third party library.
@@ -28,7 +29,11 @@ func ReadLocalFile(ctx context.Context, repository entity.RepoLocal, filename st
 		return nil, err
 	}
 	defer func() { _ = file.Close() }()
-	return repository.ReadLocal(ctx, file)
+	out, err := repository.ReadLocal(ctx, file)
+	for _, item := range out {
+		progress.Add(progress.Read, progress.TypeName(item), 1)
+	}
+	return out, err
 }
 
 // NewServiceID creates a ServiceID.
@@ -0,0 +1,145 @@
+package sn
+
+import (
+	"context"
+	"io"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/log"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
+)
+
+func init() {
+	repo.RegisterFormat(repo.Format{
+		Name:        "sn",
+		Description: "StandardNotes import, export file",
+		Options: map[string]string{
+			"note-versions":  "how to write earlier revisions of notes, one of appdata, archive",
+			"note-text":      "format of the text of notes, one of html, markdown, text",
+			"uuid-namespace": "a UUID, from which UUIDs of items are derived so that conversions are repeatable",
+			"collisions":     "how to write a notebook with the name of a tag, one of keep, prefix, nest, merge",
+		},
+		Read: func(ctx context.Context, path string, opts repo.Options) (*repo.Source, error) {
+			notes, tags, err := ReadConversionFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return repo.NewSource(NewCollection(notes, tags)), nil
+		},
+		NewEncoder: func(ctx context.Context, w io.Writer, in *entity.Collection, params *repo.WriteParams) (repo.Encoder, error) {
+			return NewFormatEncoder(ctx, w, in, params)
+		},
+	})
+}
+
+// A FormatEncoder is the repo.Encoder of the sn format. It writes the notes of
+// a Collection with an Encoder, then its tags, notebooks and saved searches.
+// The options of the format are the ExportParams of the same names.
+// Collisions are found when it's made, and saved searches that couldn't be
+// translated are found when it's closed. Both are logged.
+type FormatEncoder struct {
+	*Encoder
+	Collisions   []Collision
+	Untranslated []UntranslatedSearch
+
+	ctx context.Context
+	w   io.Writer
+	in  *entity.Collection
+}
+
+// NewFormatEncoder starts a StandardNotes export file of a Collection.
+func NewFormatEncoder(ctx context.Context, w io.Writer, in *entity.Collection, params *repo.WriteParams) (out *FormatEncoder, err error) {
+	if params == nil {
+		params = &repo.WriteParams{}
+	}
+	opts := params.Options
+	out = &FormatEncoder{Collisions: FindCollisions(in.Tags, in.Notebooks), ctx: ctx, w: w, in: in}
+	out.Encoder, err = NewEncoder(w, in.Service, &ExportParams{
+		NoteVersions:    opts["note-versions"],
+		NoteText:        opts["note-text"],
+		UUIDNamespace:   opts["uuid-namespace"],
+		Ledger:          params.Ledger,
+		Collisions:      out.Collisions,
+		CollisionPolicy: opts["collisions"],
+		OnItem:          params.OnItem,
+		OnLossy:         params.OnLossy,
+		Keyring:         params.Keyring,
+	})
+	if err != nil {
+		return
+	}
+	LogCollisions(ctx, out.Collisions, opts["collisions"])
+	return
+}
+
+// Close writes the tags, notebooks and saved searches of the Collection, then
+// ends the file with a newline.
+func (e *FormatEncoder) Close() (err error) {
+	if e.Untranslated, err = e.Encoder.Close(e.in.Tags, e.in.Notebooks, e.in.SavedSearches); err != nil {
+		return
+	}
+	for _, search := range e.Untranslated {
+		log.Warn(e.ctx, map[string]any{
+			"name":   search.Name,
+			"query":  search.Query,
+			"reason": search.Reason,
+		}, "could not translate saved search to smart view")
+	}
+	_, err = io.WriteString(e.w, "\n")
+	return
+}
+
+// NewCollection makes a Collection from the output of ReadConversionFile. The
+// text of each note becomes the content of a note, and references between
+// notes and tags, in either direction, are tags of the note. StandardNotes
+// doesn't have notebooks, so they're read as tags.
+func NewCollection(notes, tags []entity.LinkID) *entity.Collection {
+	out := &entity.Collection{
+		Notes: make([]*entity.Note, len(notes)),
+		Tags:  make([]*entity.Tag, len(tags)),
+	}
+	tagIDsByNoteID := make(map[string][]string)
+	tagged := make(map[[2]string]bool)
+	addTag := func(noteID, tagID string) {
+		if tagged[[2]string{noteID, tagID}] {
+			return
+		}
+		tagged[[2]string{noteID, tagID}] = true
+		tagIDsByNoteID[noteID] = append(tagIDsByNoteID[noteID], tagID)
+	}
+	for _, item := range notes {
+		note := item.(*Note)
+		for _, ref := range note.Content.References {
+			if ref.ContentType == ContentTypeTag || ref.ContentType == ContentTypeNotebook {
+				addTag(note.UUID, ref.UUID)
+			}
+		}
+	}
+	for i, item := range tags {
+		tag := item.(*Tag)
+		for _, ref := range tag.Content.References {
+			if ref.ContentType == ContentTypeNote {
+				addTag(ref.UUID, tag.UUID)
+			}
+		}
+		out.Tags[i] = &entity.Tag{ID: tag.UUID, Name: tag.Content.Title}
+		for _, ref := range tag.Content.References {
+			if ref.ReferenceType == ReferenceTypeParentTag {
+				out.Tags[i].ParentID = ref.UUID
+			}
+		}
+	}
+	for i, item := range notes {
+		note := item.(*Note)
+		out.Notes[i] = &entity.Note{
+			ID:        note.UUID,
+			Title:     note.Content.Title,
+			Content:   enml.FromText(note.Content.Text),
+			CreatedAt: note.CreatedAt,
+			UpdatedAt: note.UpdatedAt,
+			TagIDs:    tagIDsByNoteID[note.UUID],
+		}
+	}
+	return out
+}
@@ -0,0 +1,169 @@
+package sn
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/log"
+)
+
+// CollisionPolicies are the values of ExportParams.CollisionPolicy. A notebook
+// and a tag both become tags, so when they have the same name, there are two
+// tags that look alike. With "keep", they're left that way. With "prefix", the
+// title of the notebook tag starts with NotebookPrefix. With "nest", the
+// notebook tag is nested under a tag titled NotebooksTagTitle, which is made if
+// there isn't one. With "merge", the notebook and the tag become one tag.
+var CollisionPolicies = []string{"keep", "prefix", "nest", "merge"}
+
+const (
+	// NotebookPrefix starts the title of a notebook tag with the "prefix"
+	// collision policy.
+	NotebookPrefix = "Notebook: "
+	// NotebooksTagTitle is the parent of notebook tags with the "nest"
+	// collision policy.
+	NotebooksTagTitle = "Notebooks"
+)
+
+// ReferenceTypeParentTag is the ReferenceType from a tag to its parent tag.
+const ReferenceTypeParentTag = "TagToParentTag"
+
+// notebooksTagID is the source ID of the tag made for the "nest" collision
+// policy, since it's not from the source service.
+const notebooksTagID = "notexfr:" + NotebooksTagTitle
+
+// A Collision is a notebook and a tag with the same name, ignoring case.
+type Collision struct {
+	Name       string `json:"name"`
+	NotebookID string `json:"notebook_id"`
+	TagID      string `json:"tag_id"`
+}
+
+// FindCollisions lists the notebooks that have the same name as a tag, in the
+// order of the notebooks. If several tags have the name, the first one is
+// used.
+func FindCollisions(tags []*entity.Tag, notebooks []*entity.Notebook) (out []Collision) {
+	tagsByName := make(map[string]*entity.Tag, len(tags))
+	for _, tag := range tags {
+		if key := strings.ToLower(tag.Name); tagsByName[key] == nil {
+			tagsByName[key] = tag
+		}
+	}
+	for _, notebook := range notebooks {
+		if tag, ok := tagsByName[strings.ToLower(notebook.Name)]; ok {
+			out = append(out, Collision{Name: notebook.Name, NotebookID: notebook.ID, TagID: tag.ID})
+		}
+	}
+	return
+}
+
+// LogCollisions warns about each collision, and how it's resolved with the
+// policy.
+func LogCollisions(ctx context.Context, collisions []Collision, policy string) {
+	if policy == "" {
+		policy = CollisionPolicies[0]
+	}
+	for _, collision := range collisions {
+		log.Warn(ctx, map[string]any{
+			"name":        collision.Name,
+			"notebook_id": collision.NotebookID,
+			"tag_id":      collision.TagID,
+			"policy":      policy,
+		}, "notebook has the same name as a tag")
+	}
+}
+
+// notebooksParent finds the tag titled NotebooksTagTitle, if there is one.
+func notebooksParent(tags []*entity.Tag) *entity.Tag {
+	for _, tag := range tags {
+		if strings.EqualFold(tag.Name, NotebooksTagTitle) {
+			return tag
+		}
+	}
+	return nil
+}
+
+// NewNotebookTags converts notebooks to tags, for when notes already refer to
+// notebooks by their ID, as with a backfill. The UUID of each tag is the ID of
+// its notebook. The members are the notes in each notebook, with their UUIDs
+// as IDs. Collisions with the tags are resolved with the policy, except for
+// "merge", which is up to the notes: a merged notebook is left out. With
+// "nest", the parent tag is made if it's not one of the tags. Its UUID is
+// derived from DefaultUUIDNamespace, so it's the same every time.
+func NewNotebookTags(notebooks []*entity.Notebook, tags []*entity.Tag, members *entity.Graph, collisions []Collision, policy string) (out []entity.LinkID) {
+	collided := make(map[string]bool, len(collisions))
+	for _, collision := range collisions {
+		collided[collision.NotebookID] = true
+	}
+	var parentUUID string
+	if policy == "nest" && len(collisions) > 0 {
+		if parent := notebooksParent(tags); parent != nil {
+			parentUUID = parent.ID
+		} else {
+			parent := NewTag(NotebooksTagTitle, time.Now().UTC(), time.Now().UTC())
+			parent.ServiceID = nil
+			parent.UUID = uuid.NewSHA1(uuid.MustParse(DefaultUUIDNamespace), []byte(ContentTypeTag.String()+":"+notebooksTagID)).String()
+			parentUUID = parent.UUID
+			out = append(out, parent)
+		}
+	}
+	for _, item := range notebooks {
+		if collided[item.ID] && policy == "merge" {
+			continue
+		}
+		notebook := NewTag(item.Name, item.CreatedAt, item.UpdatedAt)
+		notebook.ServiceID = nil
+		notebook.UUID = item.ID
+		notebook.ContentType = ContentTypeNotebook
+		notebook.Content.References = noteReferences(members.NotesInNotebook(item.ID))
+		notebook.Content.AppData = nil
+		if collided[item.ID] {
+			resolveCollision(notebook, policy, parentUUID)
+		}
+		out = append(out, notebook)
+	}
+	return
+}
+
+// resolveCollision changes a notebook tag for the "prefix" or "nest" policy.
+func resolveCollision(notebook *Tag, policy, parentUUID string) {
+	switch policy {
+	case "prefix":
+		notebook.Content.Title = NotebookPrefix + notebook.Content.Title
+	case "nest":
+		notebook.Content.References = append(notebook.Content.References, Reference{
+			UUID:          parentUUID,
+			ContentType:   ContentTypeTag,
+			ReferenceType: ReferenceTypeParentTag,
+		})
+	}
+}
+
+// IsNotebook tells whether a tag was converted from a notebook, by its content
+// type or, once it's been read from a file, by its appData.
+func IsNotebook(tag *Tag) bool {
+	if tag.ContentType == ContentTypeNotebook {
+		return true
+	}
+	for _, data := range tag.Content.AppData {
+		switch data := data.(type) {
+		case *AppData:
+			if data.OriginalContentType == "Notebook" {
+				return true
+			}
+		case map[string]any:
+			if typ, _ := data["original_content_type"].(string); typ == "Notebook" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NotebookName is the name of the notebook that a notebook tag was converted
+// from, which is its title without NotebookPrefix.
+func NotebookName(tag *Tag) string {
+	return strings.TrimPrefix(tag.Content.Title, NotebookPrefix)
+}
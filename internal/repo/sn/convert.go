@@ -0,0 +1,751 @@
+package sn
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/progress"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
+	"github.com/rafaelespinoza/notexfr/internal/repo/ledger"
+)
+
+// Service names StandardNotes, as a destination in a ledger.
+const Service = "standardnotes.com"
+
+// Export is the import, export format for StandardNotes, as written by an
+// Encoder. The item schema is described at:
+// https://docs.standardnotes.org/specification/sync/#items
+type Export struct {
+	Items []entity.LinkID `json:"items"`
+	// UntranslatedSearches are saved searches from the source service that
+	// could not be converted to a smart view. They're not part of the output.
+	UntranslatedSearches []UntranslatedSearch `json:"-"`
+	// Skipped is the number of notes left out because they haven't changed
+	// since they were recorded in a ledger.
+	Skipped int `json:"-"`
+	// Orphans are resources in a ledger that weren't converted this time. They
+	// may have been deleted from the source service.
+	Orphans []ledger.Entry `json:"-"`
+	// Diff is set by a dry run, it's what importing the items would change.
+	Diff *Diff `json:"-"`
+	// Collisions are the notebooks with the same name as a tag, see
+	// ExportParams.CollisionPolicy.
+	Collisions []Collision `json:"-"`
+	// Lossy is the number of notes with features that were dropped or
+	// approximated, see FindLossyFeatures.
+	Lossy int `json:"-"`
+}
+
+// NoteVersionsOptions are the values of ExportParams.NoteVersions. With
+// "appdata", the revisions are kept in the appData of the note. With
+// "archive", each revision becomes a separate, archived note.
+var NoteVersionsOptions = []string{"appdata", "archive"}
+
+// NoteTextFormats are the values of ExportParams.NoteText. With "html", the
+// text of a note is its ENML content as HTML. With "markdown", it's Markdown,
+// see enml.Markdown, and with "text" it's plain text, see enml.Text. Each
+// checkbox is a task list item in Markdown, and a checkbox character otherwise.
+var NoteTextFormats = []string{"html", "markdown", "text"}
+
+// DefaultUUIDNamespace is a value for ExportParams.UUIDNamespace, for when
+// there's no need for a particular namespace.
+var DefaultUUIDNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/rafaelespinoza/notexfr")).String()
+
+// ExportParams are optional settings for NewEncoder.
+type ExportParams struct {
+	// NoteVersions is how earlier revisions of notes are converted, it's one
+	// of NoteVersionsOptions. The default is "appdata".
+	NoteVersions string
+	// NoteText is the format of the text of notes, it's one of
+	// NoteTextFormats. The default is "html".
+	NoteText string
+	// UUIDNamespace, if set, makes conversions repeatable. It's a UUID, from
+	// which the UUID of each item is derived, along with a key from the
+	// source data. Converting the same data again makes the same UUIDs, so
+	// importing it again updates items in StandardNotes rather than
+	// duplicating them. Otherwise, UUIDs are random. See DefaultUUIDNamespace.
+	UUIDNamespace string
+	// Ledger, if set, records the UUID that each resource is converted to,
+	// keyed by its ID in the source service. A resource that's already in the
+	// ledger keeps its UUID, so that importing it again updates the item in
+	// StandardNotes. A note that hasn't changed since it was recorded, and
+	// that's converted with the same NoteText, NoteVersions, CollisionPolicy
+	// and Keyring, is left out. Notes are compared after any rules are
+	// applied. Tags, notebooks and smart views are always written, since the
+	// notes they refer to may have changed.
+	Ledger *ledger.Ledger
+	// Collisions are notebooks and tags with the same name, see
+	// FindCollisions. They're converted with the CollisionPolicy, which is one
+	// of CollisionPolicies. The default is "keep".
+	Collisions      []Collision
+	CollisionPolicy string
+	// OnItem, if set, is called with each item as it's converted, in the
+	// order of the output.
+	OnItem func(item entity.LinkID)
+	// OnLossy, if set, is called with each note that's written, if it has
+	// features that StandardNotes doesn't have, see FindLossyFeatures.
+	OnLossy func(note LossyNote)
+	// Keyring, if set, decrypts the encrypted text of notes and their earlier
+	// revisions, which is inlined. Text that no passphrase decrypts is left as
+	// it is, and it's a lossy feature.
+	Keyring *enml.Keyring
+}
+
+// Validate checks the params, so that problems are found before anything is
+// written. A nil value is valid.
+func (p *ExportParams) Validate() (err error) {
+	if p == nil {
+		return
+	}
+	if p.NoteVersions != "" && !slices.Contains(NoteVersionsOptions, p.NoteVersions) {
+		err = fmt.Errorf("invalid note versions option %q, should be one of %q", p.NoteVersions, NoteVersionsOptions)
+		return
+	}
+	if p.NoteText != "" && !slices.Contains(NoteTextFormats, p.NoteText) {
+		err = fmt.Errorf("invalid note text format %q, should be one of %q", p.NoteText, NoteTextFormats)
+		return
+	}
+	if p.CollisionPolicy != "" && !slices.Contains(CollisionPolicies, p.CollisionPolicy) {
+		err = fmt.Errorf("invalid collision policy %q, should be one of %q", p.CollisionPolicy, CollisionPolicies)
+		return
+	}
+	if p.UUIDNamespace != "" {
+		if _, perr := uuid.Parse(p.UUIDNamespace); perr != nil {
+			err = fmt.Errorf("invalid UUID namespace %q; %w", p.UUIDNamespace, perr)
+		}
+	}
+	return
+}
+
+// An Encoder converts data from another service to StandardNotes items, and
+// writes them as an import, export file. It replicates the existing data
+// conversion tools at https://dashboard.standardnotes.org/tools. Notebooks
+// become tags, with an original content type of Notebook. Saved searches
+// become smart views when they can be translated.
+//
+// IDs that are already UUIDs are kept, others are replaced by a new UUID. With
+// a UUID namespace, every UUID is derived from a key instead: the content type
+// and ID of the resource in the source service. A note without an ID, such as
+// from an export file, is keyed by its created time, title and a hash of its
+// content. Tags from an export file are identified by name, so they're keyed
+// by name. An archived revision is keyed by its note and update sequence
+// number, and a smart view by its name. Resources with the same key, such as
+// two identical notes, are numbered in the order they're written. A UUID in a
+// ledger takes precedence over all of these.
+// Extra metadata from the source service is kept in the appData of each item,
+// under the name of the service.
+//
+// Items are written one at a time, so that converting many notes doesn't need
+// all of them in memory at once. Only the IDs of notes are kept, so that tags
+// and notebooks can refer to them. The output has notes in the order they're
+// written, each preceded by its archived revisions, if any. Then tags,
+// notebooks and smart views follow, in the order passed to Close.
+type Encoder struct {
+	w     io.Writer
+	items *repo.ArrayEncoder
+	conv  *exporter
+}
+
+// NewEncoder starts a StandardNotes export file. The service is where the data
+// is from, as in entity.Collection.
+func NewEncoder(w io.Writer, service string, params *ExportParams) (out *Encoder, err error) {
+	out = &Encoder{w: w, items: repo.NewArrayEncoder(w)}
+	out.conv, err = newExporter(service, params, func(item entity.LinkID) error { return out.items.Encode(item) })
+	if err != nil {
+		return
+	}
+	_, err = io.WriteString(w, `{"items":`)
+	return
+}
+
+// WriteNote converts and writes one note. Its tags and notebook are referred to
+// by their ID in the source service.
+func (e *Encoder) WriteNote(note *entity.Note) error { return e.conv.note(note) }
+
+// Skipped is the number of notes left out so far, see ExportParams.Ledger.
+func (e *Encoder) Skipped() int { return e.conv.skipped }
+
+// Close writes the tags, notebooks and smart views, then ends the file.
+func (e *Encoder) Close(tags []*entity.Tag, notebooks []*entity.Notebook, searches []*entity.SavedSearch) (untranslated []UntranslatedSearch, err error) {
+	if untranslated, err = e.conv.finish(tags, notebooks, searches); err != nil {
+		return
+	}
+	if err = e.items.Close(); err != nil {
+		return
+	}
+	_, err = io.WriteString(e.w, "}")
+	return
+}
+
+// exporter converts data to StandardNotes items, passing each one to emit.
+type exporter struct {
+	service      string
+	noteVersions string
+	textFormat   string
+	onItem       func(entity.LinkID)
+	onLossy      func(LossyNote)
+	keyring      *enml.Keyring
+	emit         func(entity.LinkID) error
+	// ids maps the ID of a resource in the source service to its UUID. The
+	// key is prefixed by the content type, since a source service may not
+	// have IDs that are unique across types.
+	ids map[string]string
+	// members has a note for each one that's converted, with its UUID and
+	// the IDs of the tags and notebook that it's in. It's for looking up the
+	// notes of each tag and notebook, in the order of the notes.
+	members *entity.Graph
+	// namespace is set when UUIDs are derived from keys, which are tracked by
+	// usedKeys so that each UUID is only derived once.
+	namespace *uuid.UUID
+	usedKeys  map[string]bool
+	// contentKeys counts the notes without an ID that have each content key.
+	contentKeys map[string]int
+	ledger      *ledger.Ledger
+	skipped     int
+	// collisions are by notebook ID, they're resolved with collisionPolicy.
+	collisions      map[string]Collision
+	collisionPolicy string
+}
+
+func newExporter(service string, params *ExportParams, emit func(entity.LinkID) error) (out *exporter, err error) {
+	out = &exporter{
+		service:         service,
+		noteVersions:    NoteVersionsOptions[0],
+		textFormat:      NoteTextFormats[0],
+		emit:            emit,
+		ids:             make(map[string]string),
+		contentKeys:     make(map[string]int),
+		collisions:      make(map[string]Collision),
+		collisionPolicy: CollisionPolicies[0],
+	}
+	if out.members, err = entity.NewGraph(&entity.Collection{}); err != nil {
+		return
+	}
+	if err = params.Validate(); err != nil || params == nil {
+		return
+	}
+	for _, collision := range params.Collisions {
+		out.collisions[collision.NotebookID] = collision
+	}
+	if params.CollisionPolicy != "" {
+		out.collisionPolicy = params.CollisionPolicy
+	}
+	if params.NoteVersions != "" {
+		out.noteVersions = params.NoteVersions
+	}
+	if params.NoteText != "" {
+		out.textFormat = params.NoteText
+	}
+	out.onItem = params.OnItem
+	out.onLossy = params.OnLossy
+	out.keyring = params.Keyring
+	out.ledger = params.Ledger
+	if params.UUIDNamespace != "" {
+		namespace := uuid.MustParse(params.UUIDNamespace)
+		out.namespace = &namespace
+		out.usedKeys = make(map[string]bool)
+	}
+	return
+}
+
+func (e *exporter) write(item entity.LinkID) error {
+	if e.onItem != nil {
+		e.onItem(item)
+	}
+	return e.emit(item)
+}
+
+// uuidFor gets the UUID of a resource, generating it if necessary.
+func (e *exporter) uuidFor(typ ContentType, id string) (out string, err error) {
+	key := typ.String() + ":" + id
+	if id == "" {
+		return e.newUUID(key)
+	}
+	if out = e.ids[key]; out != "" {
+		return
+	}
+	if entry, ok := e.lookup(typ, id); ok {
+		out = entry.DestID
+		e.ids[key] = out
+		return
+	}
+	if _, perr := uuid.Parse(id); perr == nil && len(id) == 36 && e.namespace == nil {
+		out = id
+	} else if out, err = e.newUUID(key); err != nil {
+		return
+	}
+	e.ids[key] = out
+	return
+}
+
+// knownUUID is like uuidFor, but only when the UUID isn't random: it's in the
+// ledger, it's derived from the namespace, or it's the ID itself.
+func (e *exporter) knownUUID(typ ContentType, id string) (out string, ok bool) {
+	if _, inLedger := e.lookup(typ, id); !inLedger && e.namespace == nil {
+		if _, perr := uuid.Parse(id); perr != nil || len(id) != 36 {
+			return
+		}
+	}
+	out, err := e.uuidFor(typ, id)
+	ok = err == nil
+	return
+}
+
+// newUUID makes a random UUID or, with a namespace, one derived from the key.
+// A key that's already been used is numbered, so that each UUID is unique.
+func (e *exporter) newUUID(key string) (string, error) {
+	if e.namespace == nil {
+		out, err := uuid.NewRandom()
+		if err != nil {
+			return "", err
+		}
+		return out.String(), nil
+	}
+	name := key
+	for n := 2; e.usedKeys[name]; n++ {
+		name = key + "#" + strconv.Itoa(n)
+	}
+	e.usedKeys[name] = true
+	return uuid.NewSHA1(*e.namespace, []byte(name)).String(), nil
+}
+
+// sourceID identifies a note in the source service, by its ID if it has one.
+func (e *exporter) sourceID(note *entity.Note) string {
+	if note.ID != "" {
+		return note.ID
+	}
+	return e.contentKey(note)
+}
+
+// contentKey identifies a note without an ID by what's in it. Notes that look
+// the same are numbered in the order they're seen.
+func (e *exporter) contentKey(note *entity.Note) string {
+	sum := sha256.Sum256([]byte(note.Content))
+	key := note.CreatedAt.UTC().Format(time.RFC3339) + "|" + note.Title + "|" + hex.EncodeToString(sum[:])
+	e.contentKeys[key]++
+	if n := e.contentKeys[key]; n > 1 {
+		key += "#" + strconv.Itoa(n)
+	}
+	return key
+}
+
+func (e *exporter) ledgerKey(typ ContentType, id string) ledger.Key {
+	return ledger.Key{SourceService: e.service, ContentType: typ.String(), SourceID: id, DestService: Service}
+}
+
+func (e *exporter) lookup(typ ContentType, id string) (out ledger.Entry, ok bool) {
+	if e.ledger == nil || id == "" {
+		return
+	}
+	return e.ledger.Lookup(e.ledgerKey(typ, id))
+}
+
+// record writes down the UUID of a resource in the ledger, if there is one.
+func (e *exporter) record(typ ContentType, id, uuid, hash string) error {
+	if e.ledger == nil || id == "" {
+		return nil
+	}
+	return e.ledger.Record(ledger.Entry{Key: e.ledgerKey(typ, id), DestID: uuid, ContentHash: hash})
+}
+
+// appData makes a place for the metadata of the source service. It's nil if
+// the source service is unknown.
+func (e *exporter) appData(data *AppData) map[string]interface{} {
+	if e.service == "" {
+		return nil
+	}
+	return map[string]interface{}{e.service: data}
+}
+
+func (e *exporter) note(item *entity.Note) (err error) {
+	// The content is converted before anything is recorded, so that a note
+	// that can't be converted leaves nothing behind.
+	content, _ := e.keyring.Decrypt(item.Content)
+	text, err := noteText(content, e.textFormat)
+	if err != nil {
+		err = &entity.NoteError{ID: item.ID, Title: item.Title, Stage: entity.StageConvert, Err: err}
+		return
+	}
+	versions, err := convertNoteVersions(item.Versions, e.keyring, e.textFormat)
+	if err != nil {
+		err = &entity.NoteError{ID: item.ID, Title: item.Title, Stage: entity.StageConvert, Err: err}
+		return
+	}
+
+	var noteID, refID string
+	sourceID := e.sourceID(item)
+	// The earlier entry is compared after this one is recorded, to see if the
+	// note has changed.
+	prior, inLedger := e.lookup(ContentTypeNote, sourceID)
+	if noteID, err = e.uuidFor(ContentTypeNote, sourceID); err != nil {
+		return
+	}
+	references := make([]Reference, 0, len(item.TagIDs)+1)
+	member := &entity.Note{ID: noteID, TagIDs: slices.Clone(item.TagIDs)}
+	for _, tagID := range item.TagIDs {
+		if refID, err = e.uuidFor(ContentTypeTag, tagID); err != nil {
+			return
+		}
+		references = append(references, Reference{UUID: refID, ContentType: ContentTypeTag})
+	}
+	if collision, ok := e.merged(item.NotebookID); ok {
+		// The notebook is the tag, which the note may already refer to.
+		if !slices.Contains(item.TagIDs, collision.TagID) {
+			if refID, err = e.uuidFor(ContentTypeTag, collision.TagID); err != nil {
+				return
+			}
+			references = append(references, Reference{UUID: refID, ContentType: ContentTypeTag})
+			member.TagIDs = append(member.TagIDs, collision.TagID)
+		}
+	} else if item.NotebookID != "" {
+		if refID, err = e.uuidFor(ContentTypeNotebook, item.NotebookID); err != nil {
+			return
+		}
+		references = append(references, Reference{UUID: refID, ContentType: ContentTypeNotebook})
+		member.NotebookID = item.NotebookID
+	}
+	if err = e.members.AddNote(member); err != nil {
+		return
+	}
+	hash := ledger.NoteHash(item, e.settings()...)
+	if err = e.record(ContentTypeNote, sourceID, noteID, hash); err != nil {
+		return
+	}
+	if inLedger && prior.DestID == noteID && prior.ContentHash == hash {
+		e.skipped++
+		progress.Skip(progress.Convert, progress.TypeName(item), 1)
+		return
+	}
+
+	updatedAt := item.UpdatedAt
+	appData := map[string]interface{}{
+		"org.standardnotes.sn": &AppData{ClientUpdatedAt: &updatedAt},
+	}
+	var serviceData *AppData
+	if item.Origin != nil {
+		serviceData = &AppData{Origin: item.Origin}
+	}
+	if open, done := enml.CountTodos(content); open+done > 0 {
+		if serviceData == nil {
+			serviceData = &AppData{}
+		}
+		serviceData.Todos = &TodoCount{Open: open, Done: done}
+	}
+	if len(versions) > 0 && e.noteVersions == "appdata" {
+		if serviceData == nil {
+			serviceData = &AppData{}
+		}
+		serviceData.Versions = versions
+	} else if len(versions) > 0 {
+		archived, verr := e.archiveNoteVersions(item, sourceID, versions)
+		if verr != nil {
+			err = fmt.Errorf("%w; note %q", verr, item.ID)
+			return
+		}
+		for _, version := range archived {
+			if err = e.write(version); err != nil {
+				return
+			}
+		}
+	}
+	if serviceData != nil && e.service != "" {
+		appData[e.service] = serviceData
+	}
+
+	note := &Note{Item: Item{
+		CreatedAt:   item.CreatedAt,
+		UpdatedAt:   item.UpdatedAt,
+		ContentType: ContentTypeNote,
+		UUID:        noteID,
+	}}
+	note.Content.Title = item.Title
+	note.Content.References = references
+	note.Content.Text = text
+	note.Content.AppData = appData
+	if err = e.write(note); err != nil {
+		return
+	}
+	if e.onLossy != nil {
+		decrypted := *item
+		decrypted.Content = content
+		if features := e.lossyFeatures(&decrypted); len(features) > 0 {
+			e.onLossy(LossyNote{ID: item.ID, Title: item.Title, Features: features})
+		}
+	}
+	progress.Add(progress.Convert, progress.TypeName(item), 1)
+	return
+}
+
+// settings are the params that change how notes are converted, for the
+// hash of a note in the ledger. Defaults are left out, so that the hash of a
+// note converted with all of them is the same as it's always been.
+func (e *exporter) settings() (out []string) {
+	if e.textFormat != NoteTextFormats[0] {
+		out = append(out, "note-text="+e.textFormat)
+	}
+	if e.noteVersions != NoteVersionsOptions[0] {
+		out = append(out, "note-versions="+e.noteVersions)
+	}
+	if e.collisionPolicy != CollisionPolicies[0] {
+		out = append(out, "collisions="+e.collisionPolicy)
+	}
+	if e.keyring != nil {
+		out = append(out, "keyring")
+	}
+	return
+}
+
+// merged tells whether a notebook is merged with a tag, for the "merge"
+// collision policy.
+func (e *exporter) merged(notebookID string) (out Collision, ok bool) {
+	if e.collisionPolicy != "merge" || notebookID == "" {
+		return
+	}
+	out, ok = e.collisions[notebookID]
+	return
+}
+
+// notebooksParent writes the parent of notebook tags for the "nest" collision
+// policy, unless there's a tag for it already. The output is its UUID.
+func (e *exporter) notebooksParent(tags []*entity.Tag, notebooks []*entity.Notebook) (out string, err error) {
+	if e.collisionPolicy != "nest" || !slices.ContainsFunc(notebooks, func(item *entity.Notebook) bool {
+		_, ok := e.collisions[item.ID]
+		return ok
+	}) {
+		return
+	}
+	if parent := notebooksParent(tags); parent != nil {
+		return e.uuidFor(ContentTypeTag, parent.ID)
+	}
+	parent := NewTag(NotebooksTagTitle, time.Now().UTC(), time.Now().UTC())
+	parent.ServiceID = nil
+	if parent.UUID, err = e.uuidFor(ContentTypeTag, notebooksTagID); err != nil {
+		return
+	}
+	if err = e.record(ContentTypeTag, notebooksTagID, parent.UUID, ledger.Hash(NotebooksTagTitle)); err != nil {
+		return
+	}
+	out = parent.UUID
+	err = e.write(parent)
+	return
+}
+
+// finish converts everything that refers to notes, after all of the notes.
+func (e *exporter) finish(tags []*entity.Tag, notebooks []*entity.Notebook, searches []*entity.SavedSearch) (untranslated []UntranslatedSearch, err error) {
+	written := make(map[string]bool, len(tags))
+	for _, item := range tags {
+		written[item.ID] = true
+		tag := NewTag(item.Name, time.Now().UTC(), time.Now().UTC())
+		tag.ServiceID = nil
+		if tag.UUID, err = e.uuidFor(ContentTypeTag, item.ID); err != nil {
+			return
+		}
+		if err = e.record(ContentTypeTag, item.ID, tag.UUID, ledger.Hash(item.Name, item.ParentID)); err != nil {
+			return
+		}
+		tag.Content.References = noteReferences(e.members.NotesWithTag(item.ID))
+		tag.Content.AppData = e.appData(&AppData{ParentID: item.ParentID, Origin: item.Origin})
+		if err = e.write(tag); err != nil {
+			return
+		}
+	}
+	parentUUID, err := e.notebooksParent(tags, notebooks)
+	if err != nil {
+		return
+	}
+	for _, item := range notebooks {
+		notebook := NewTag(item.Name, item.CreatedAt, item.UpdatedAt)
+		notebook.ServiceID = nil
+		notebook.ContentType = ContentTypeNotebook
+		typ, id, references := ContentTypeNotebook, item.ID, e.members.NotesInNotebook(item.ID)
+		collision, merged := e.merged(item.ID)
+		if merged {
+			// The notebook is the tag, which has the notes of both.
+			typ, id, references = ContentTypeTag, collision.TagID, e.members.NotesWithTag(collision.TagID)
+		}
+		if notebook.UUID, err = e.uuidFor(typ, id); err != nil {
+			return
+		}
+		if err = e.record(ContentTypeNotebook, item.ID, notebook.UUID, ledger.Hash(item.Name, item.Stack)); err != nil {
+			return
+		}
+		if merged && written[collision.TagID] {
+			continue
+		}
+		notebook.Content.References = noteReferences(references)
+		notebook.Content.AppData = e.appData(&AppData{OriginalContentType: "Notebook", Origin: item.Origin})
+		if _, ok := e.collisions[item.ID]; ok {
+			resolveCollision(notebook, e.collisionPolicy, parentUUID)
+		}
+		if err = e.write(notebook); err != nil {
+			return
+		}
+	}
+	// A search is either translated entirely or not at all.
+	for _, item := range searches {
+		predicate, perr := searchToPredicate(item.Query)
+		if perr != nil {
+			untranslated = append(untranslated, UntranslatedSearch{
+				Name:   item.Name,
+				Query:  item.Query,
+				Reason: perr.Error(),
+			})
+			continue
+		}
+		view := NewSmartView(item.Name, predicate, time.Now().UTC(), time.Now().UTC())
+		if view.UUID, err = e.uuidFor(ContentTypeSmartView, item.Name); err != nil {
+			return
+		}
+		if err = e.record(ContentTypeSmartView, item.Name, view.UUID, ledger.Hash(item.Query)); err != nil {
+			return
+		}
+		if err = e.write(view); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// noteReferences refers to notes by their IDs, which are UUIDs.
+func noteReferences(notes []*entity.Note) []Reference {
+	out := make([]Reference, len(notes))
+	for i, note := range notes {
+		out[i] = Reference{UUID: note.ID, ContentType: ContentTypeNote}
+	}
+	return out
+}
+
+func convertNoteVersions(in []*entity.NoteVersion, keyring *enml.Keyring, format string) (out []NoteVersion, err error) {
+	out = make([]NoteVersion, len(in))
+	for i, version := range in {
+		content, _ := keyring.Decrypt(version.Content)
+		text, xerr := noteText(content, format)
+		if xerr != nil {
+			err = fmt.Errorf("%w; version %d", xerr, version.UpdateSequenceNum)
+			return
+		}
+		out[i] = NoteVersion{
+			UpdateSequenceNum: version.UpdateSequenceNum,
+			Title:             version.Title,
+			Text:              text,
+			UpdatedAt:         version.UpdatedAt,
+			SavedAt:           version.SavedAt,
+		}
+	}
+	return
+}
+
+// archiveNoteVersions makes an archived note of each earlier revision of a
+// note. They don't reference any tags or notebooks, so that they don't show
+// up alongside the current revision. The appData relates them to the note,
+// whose ID in the source service is sourceID. The versions are the converted
+// revisions of the note.
+func (e *exporter) archiveNoteVersions(item *entity.Note, sourceID string, versions []NoteVersion) (out []entity.LinkID, err error) {
+	out = make([]entity.LinkID, len(versions))
+	for i, version := range versions {
+		note := &Note{Item: Item{
+			CreatedAt:   item.CreatedAt,
+			UpdatedAt:   version.UpdatedAt,
+			ContentType: ContentTypeNote,
+		}}
+		versionID := sourceID + "@" + strconv.Itoa(int(version.UpdateSequenceNum))
+		if note.UUID, err = e.uuidFor(ContentTypeNote, versionID); err != nil {
+			return
+		}
+		if err = e.record(ContentTypeNote, versionID, note.UUID, ledger.Hash(version.Title, version.Text)); err != nil {
+			return
+		}
+		updatedAt := version.UpdatedAt
+		note.Content.Title = version.Title
+		note.Content.References = make([]Reference, 0)
+		note.Content.Text = version.Text
+		note.Content.AppData = map[string]interface{}{
+			"org.standardnotes.sn": &AppData{ClientUpdatedAt: &updatedAt, Archived: true},
+		}
+		if e.service != "" {
+			note.Content.AppData[e.service] = &AppData{
+				VersionOf:         item.ID,
+				UpdateSequenceNum: version.UpdateSequenceNum,
+				Origin:            item.Origin,
+			}
+		}
+		out[i] = note
+	}
+	return
+}
+
+var (
+	enexLineBreakPattern = regexp.MustCompile(`/<br[^>]*>/g`)
+	enexListItemPattern  = regexp.MustCompile(`/<li[^>]*>/g`)
+)
+
+// noteText converts the ENML content of a note to the text of a StandardNotes
+// note, in one of NoteTextFormats.
+func noteText(content, format string) (string, error) {
+	switch format {
+	case "markdown":
+		return enml.Markdown(content)
+	case "text":
+		return enml.Text(content)
+	}
+	out, err := enml.HTML(enml.Checkboxes(content))
+	if err != nil {
+		return "", err
+	}
+	out = enexLineBreakPattern.ReplaceAllString(out, "\n\n")
+	out = enexListItemPattern.ReplaceAllString(out, "\n")
+	return out, nil
+}
+
+// AppData is extra metadata attached to a StandardNotes Item that should be
+// preserved between platforms or services.
+type AppData struct {
+	// ClientUpdatedAt is a pointer rather than value because of the omitempty
+	// field tag. If it was a value, then omitempty would have no effect.
+	ClientUpdatedAt *time.Time `json:"client_updated_at,omitempty"`
+	// OriginalContentType is the kind of data in the origin service. For
+	// example, StandardNotes does not have a Notebook type, the closest thing
+	// is a Tag. This field is available to preserve this kind of metadata.
+	OriginalContentType string `json:"original_content_type,omitempty"`
+	// ParentID could be the ID of a parent resource in the original service.
+	ParentID string `json:"parent_id,omitempty"`
+	// Origin is set when the resource belongs to another account in the
+	// original service, such as a notebook shared by another user. It keeps
+	// those resources distinguishable from the user's own.
+	Origin *entity.Origin `json:"origin,omitempty"`
+	// Archived is understood by StandardNotes, it hides a note from the main
+	// list of notes.
+	Archived bool `json:"archived,omitempty"`
+	// Versions are earlier revisions of a note in the original service.
+	Versions []NoteVersion `json:"versions,omitempty"`
+	// VersionOf is the ID of the note in the original service, when this note
+	// is an earlier revision of it. UpdateSequenceNum identifies the revision.
+	VersionOf         string `json:"version_of,omitempty"`
+	UpdateSequenceNum int32  `json:"update_sequence_num,omitempty"`
+	// Todos counts the checkboxes of a note, if it has any.
+	Todos *TodoCount `json:"todos,omitempty"`
+}
+
+// TodoCount is the number of checkboxes in a note, by whether they're checked.
+type TodoCount struct {
+	Open int `json:"open"`
+	Done int `json:"done"`
+}
+
+// NoteVersion is an earlier revision of a note, kept in AppData.
+type NoteVersion struct {
+	UpdateSequenceNum int32     `json:"update_sequence_num"`
+	Title             string    `json:"title"`
+	Text              string    `json:"text"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	SavedAt           time.Time `json:"saved_at"`
+}
@@ -0,0 +1,221 @@
+package sn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+)
+
+// A Diff describes what importing items would do to existing StandardNotes
+// data: which items would be created, and how others would change. Items are
+// matched by UUID. Build one with NewDiff, then Add each item to import.
+type Diff struct {
+	Created []ItemDiff `json:"created"`
+	Changed []ItemDiff `json:"changed"`
+	// Unchanged is the number of items that are already there, as they are.
+	Unchanged int `json:"unchanged"`
+
+	before map[string]diffItem
+	// titles are the titles of items before and after, to describe
+	// references.
+	titles map[string]string
+}
+
+// An ItemDiff is an item that would be created or changed.
+type ItemDiff struct {
+	UUID        string      `json:"uuid"`
+	ContentType ContentType `json:"content_type"`
+	Title       string      `json:"title"`
+	// TitleChanged means that the title would change from PreviousTitle,
+	// which may be empty.
+	TitleChanged  bool   `json:"title_changed,omitempty"`
+	PreviousTitle string `json:"previous_title,omitempty"`
+	// TextChanged means that the text of a note would change.
+	TextChanged bool `json:"text_changed,omitempty"`
+	// AddedReferences and RemovedReferences are the changes to references to
+	// other items. For a created item, all of its references are added.
+	AddedReferences   []ReferenceDiff `json:"added_references,omitempty"`
+	RemovedReferences []ReferenceDiff `json:"removed_references,omitempty"`
+}
+
+// A ReferenceDiff is a reference, along with the title of the item it refers
+// to, if it's known.
+type ReferenceDiff struct {
+	Reference
+	Title string `json:"title,omitempty"`
+}
+
+// diffItem is what's compared between items.
+type diffItem struct {
+	contentType ContentType
+	title, text string
+	references  []Reference
+}
+
+// NewDiff starts a Diff against existing items, such as those from an export
+// of a StandardNotes account. What's compared is copied, so the items may be
+// changed afterwards.
+func NewDiff(existing []entity.LinkID) *Diff {
+	out := &Diff{
+		Created: make([]ItemDiff, 0),
+		Changed: make([]ItemDiff, 0),
+		before:  make(map[string]diffItem),
+		titles:  make(map[string]string),
+	}
+	for _, item := range existing {
+		uuid, val, ok := toDiffItem(item)
+		if !ok {
+			continue
+		}
+		val.references = slices.Clone(val.references)
+		out.before[uuid] = val
+		out.titles[uuid] = val.title
+	}
+	return out
+}
+
+func toDiffItem(item entity.LinkID) (uuid string, out diffItem, ok bool) {
+	switch val := item.(type) {
+	case *Note:
+		return val.UUID, diffItem{val.ContentType, val.Content.Title, val.Content.Text, val.Content.References}, true
+	case *Tag:
+		return val.UUID, diffItem{val.ContentType, val.Content.Title, val.Content.Text, val.Content.References}, true
+	case *SmartView:
+		return val.UUID, diffItem{val.ContentType, val.Content.Title, "", val.Content.References}, true
+	}
+	return
+}
+
+// Add compares an item to import with the existing item of the same UUID, if
+// there is one. Items of other types are ignored.
+func (d *Diff) Add(item entity.LinkID) {
+	uuid, after, ok := toDiffItem(item)
+	if !ok {
+		return
+	}
+	d.titles[uuid] = after.title
+	before, ok := d.before[uuid]
+	if !ok {
+		d.Created = append(d.Created, ItemDiff{
+			UUID:            uuid,
+			ContentType:     after.contentType,
+			Title:           after.title,
+			AddedReferences: d.references(after.references, nil),
+		})
+		return
+	}
+	out := ItemDiff{
+		UUID:              uuid,
+		ContentType:       after.contentType,
+		Title:             after.title,
+		TitleChanged:      before.title != after.title,
+		TextChanged:       before.text != after.text,
+		AddedReferences:   d.references(after.references, before.references),
+		RemovedReferences: d.references(before.references, after.references),
+	}
+	if out.TitleChanged {
+		out.PreviousTitle = before.title
+	}
+	if !out.TitleChanged && !out.TextChanged && len(out.AddedReferences) == 0 && len(out.RemovedReferences) == 0 {
+		d.Unchanged++
+		return
+	}
+	d.Changed = append(d.Changed, out)
+}
+
+// SetTitle names an item that's referred to, but isn't in the existing items
+// or those added, such as a notebook from another service.
+func (d *Diff) SetTitle(uuid, title string) {
+	if _, ok := d.titles[uuid]; !ok {
+		d.titles[uuid] = title
+	}
+}
+
+// references lists the references in refs that aren't in others.
+func (d *Diff) references(refs, others []Reference) (out []ReferenceDiff) {
+	for _, ref := range refs {
+		if slices.ContainsFunc(others, func(other Reference) bool { return other.UUID == ref.UUID }) {
+			continue
+		}
+		out = append(out, ReferenceDiff{Reference: ref})
+	}
+	return
+}
+
+// resolve fills in the titles of referenced items. It's done when writing,
+// since items may refer to items added after them.
+func (d *Diff) resolve() {
+	for _, list := range [][]ItemDiff{d.Created, d.Changed} {
+		for i := range list {
+			for j := range list[i].AddedReferences {
+				list[i].AddedReferences[j].Title = d.titles[list[i].AddedReferences[j].UUID]
+			}
+			for j := range list[i].RemovedReferences {
+				list[i].RemovedReferences[j].Title = d.titles[list[i].RemovedReferences[j].UUID]
+			}
+		}
+	}
+}
+
+// WriteJSON writes the Diff as JSON.
+func (d *Diff) WriteJSON(w io.Writer) error {
+	d.resolve()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
+
+// WriteText writes the Diff for people to read, one item per line, followed
+// by the changes to it.
+func (d *Diff) WriteText(w io.Writer) (err error) {
+	d.resolve()
+	for _, item := range d.Created {
+		if err = writeItemDiff(w, "create", &item); err != nil {
+			return
+		}
+	}
+	for _, item := range d.Changed {
+		if err = writeItemDiff(w, "change", &item); err != nil {
+			return
+		}
+	}
+	_, err = fmt.Fprintf(w, "%d to create, %d to change, %d unchanged\n", len(d.Created), len(d.Changed), d.Unchanged)
+	return
+}
+
+func writeItemDiff(w io.Writer, action string, item *ItemDiff) (err error) {
+	if _, err = fmt.Fprintf(w, "%s %s %q (%s)\n", action, item.ContentType, item.Title, item.UUID); err != nil {
+		return
+	}
+	if item.TitleChanged {
+		if _, err = fmt.Fprintf(w, "  title was %q\n", item.PreviousTitle); err != nil {
+			return
+		}
+	}
+	if item.TextChanged {
+		if _, err = fmt.Fprintln(w, "  text changed"); err != nil {
+			return
+		}
+	}
+	for _, ref := range item.AddedReferences {
+		if _, err = fmt.Fprintf(w, "  + %s\n", ref); err != nil {
+			return
+		}
+	}
+	for _, ref := range item.RemovedReferences {
+		if _, err = fmt.Fprintf(w, "  - %s\n", ref); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (r ReferenceDiff) String() string {
+	if r.Title == "" {
+		return fmt.Sprintf("%s (%s)", r.ContentType, r.UUID)
+	}
+	return fmt.Sprintf("%s %q (%s)", r.ContentType, r.Title, r.UUID)
+}
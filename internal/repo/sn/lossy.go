@@ -0,0 +1,164 @@
+package sn
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/log"
+	"github.com/rafaelespinoza/notexfr/internal/repo"
+	xhtml "golang.org/x/net/html"
+)
+
+// These are the names of LossyFeatures.
+const (
+	LossyEncryption = "encryption"
+	LossyAttachment = "attachment"
+	LossyInk        = "ink"
+	LossyTodo       = "todo"
+	LossyTask       = "task"
+	LossyReminder   = "reminder"
+	LossyTable      = "table"
+)
+
+// inkMIME is the type of an attachment, or an en-media element, of
+// handwriting.
+const inkMIME = "application/vnd.evernote.ink"
+
+// A LossyFeature is something in a note that StandardNotes doesn't have, so
+// it's dropped or approximated when the note is converted. Its Name is one of
+// the Lossy constants. A LossyNote is a note with LossyFeatures.
+type (
+	LossyFeature = repo.LossyFeature
+	LossyNote    = repo.LossyNote
+)
+
+// FindLossyFeatures looks for features in the content and attributes of a note
+// that don't convert to StandardNotes: encrypted text, attachments,
+// handwriting, checkboxes, tasks, reminders, and tables with merged cells or
+// nested tables. The output is in that order, without the features that
+// aren't there.
+func FindLossyFeatures(note *entity.Note) (out []LossyFeature) {
+	var counts struct{ crypt, media, ink, todo, task, table int }
+	var tableDepth int
+	var complexTable bool
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(note.Content))
+	for {
+		typ := tokenizer.Next()
+		if typ == xhtml.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		if typ == xhtml.EndTagToken {
+			if token.Data == "table" && tableDepth > 0 {
+				if tableDepth--; tableDepth == 0 && complexTable {
+					counts.table++
+					complexTable = false
+				}
+			}
+			continue
+		} else if typ != xhtml.StartTagToken && typ != xhtml.SelfClosingTagToken {
+			continue
+		}
+		switch token.Data {
+		case "en-crypt":
+			counts.crypt++
+		case "en-media":
+			if attr(token, "type") == inkMIME {
+				counts.ink++
+			} else {
+				counts.media++
+			}
+		case "en-todo":
+			counts.todo++
+		case "table":
+			if tableDepth > 0 {
+				complexTable = true
+			}
+			if typ == xhtml.StartTagToken {
+				tableDepth++
+			}
+		case "td", "th":
+			for _, key := range []string{"colspan", "rowspan"} {
+				if n, err := strconv.Atoi(attr(token, key)); err == nil && n > 1 {
+					complexTable = true
+				}
+			}
+		}
+		if strings.Contains(strings.ReplaceAll(attr(token, "style"), " ", ""), "--en-task-group:true") {
+			counts.task++
+		}
+	}
+
+	// Attachments may not all be referenced in the content, or the content
+	// may refer to attachments that weren't fetched.
+	var attachments, inks int
+	for _, att := range note.Attachments {
+		if att.MIME == inkMIME {
+			inks++
+		} else {
+			attachments++
+		}
+	}
+	counts.media, counts.ink = max(counts.media, attachments), max(counts.ink, inks)
+
+	add := func(name string, count int, detail string) {
+		if count > 0 {
+			out = append(out, LossyFeature{Name: name, Count: count, Detail: detail})
+		}
+	}
+	add(LossyEncryption, counts.crypt, "encrypted text is kept as ciphertext, which StandardNotes can't decrypt")
+	add(LossyAttachment, counts.media, "attachments are dropped")
+	add(LossyInk, counts.ink, "handwriting is dropped")
+	add(LossyTodo, counts.todo, "checkboxes become "+enml.CheckboxOpen+" and "+enml.CheckboxDone+" characters, they can't be checked")
+	add(LossyTask, counts.task, "tasks are dropped, only an empty placeholder is kept")
+	if attrs := note.Attributes; attrs != nil && (attrs.ReminderOrder != 0 || attrs.ReminderTime != nil) {
+		detail := "the reminder is dropped"
+		if attrs.ReminderTime != nil {
+			detail = "the reminder, due " + fmtTime(*attrs.ReminderTime) + ", is dropped"
+		}
+		add(LossyReminder, 1, detail)
+	}
+	add(LossyTable, counts.table, "tables with merged cells or nested tables are kept as markup, which may not display as they did")
+	return
+}
+
+// lossyFeatures is FindLossyFeatures, except that encrypted text, with a
+// keyring, is what no passphrase decrypted, and that checkboxes aren't lost in
+// Markdown, where they're task lists.
+func (e *exporter) lossyFeatures(note *entity.Note) (out []LossyFeature) {
+	for _, feature := range FindLossyFeatures(note) {
+		if feature.Name == LossyTodo && e.textFormat == "markdown" {
+			continue
+		}
+		if feature.Name == LossyEncryption && e.keyring != nil {
+			feature.Detail = "no passphrase decrypts the encrypted text, it's kept as ciphertext"
+		}
+		out = append(out, feature)
+	}
+	return
+}
+
+func attr(token xhtml.Token, key string) string {
+	for _, a := range token.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// LogLossy warns about each feature of a note that's dropped or approximated.
+func LogLossy(ctx context.Context, note LossyNote) {
+	for _, feature := range note.Features {
+		log.Warn(ctx, map[string]any{
+			"id":      note.ID,
+			"title":   note.Title,
+			"feature": feature.Name,
+			"count":   feature.Count,
+			"detail":  feature.Detail,
+		}, "lossy conversion")
+	}
+}
@@ -0,0 +1,171 @@
+package sn
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UntranslatedSearch is an Evernote saved search that could not be expressed
+// as a StandardNotes smart view.
+type UntranslatedSearch struct {
+	Name   string
+	Query  string
+	Reason string
+}
+
+var errSearchUnsupported = errors.New("unsupported search term")
+
+// searchToPredicate translates a query in the Evernote search grammar into a
+// StandardNotes predicate. Only a subset of the grammar is supported: the
+// tag:, notebook:, intitle:, created:, updated: terms, their negations and the
+// any: modifier. A date term matches the date and after, its negation matches
+// before the date, as in Evernote. Evernote notebooks become StandardNotes
+// tags, so notebook: is a condition on tags. Anything else is an error because
+// silently dropping part of a search would produce a smart view with different
+// results.
+// See https://dev.evernote.com/doc/articles/search_grammar.php.
+func searchToPredicate(query string) (out *Predicate, err error) {
+	terms, err := tokenizeSearch(query)
+	if err != nil {
+		return
+	}
+	operator := "and"
+	if len(terms) > 0 && strings.EqualFold(terms[0], "any:") {
+		operator = "or"
+		terms = terms[1:]
+	}
+	if len(terms) < 1 {
+		err = fmt.Errorf("%w; empty query", errSearchUnsupported)
+		return
+	}
+
+	predicates := make([]*Predicate, len(terms))
+	for i, term := range terms {
+		if predicates[i], err = searchTermToPredicate(term); err != nil {
+			return
+		}
+	}
+	if len(predicates) == 1 {
+		out = predicates[0]
+		return
+	}
+	out = &Predicate{Operator: operator, Value: predicates}
+	return
+}
+
+// tokenizeSearch splits a query on whitespace, except for whitespace within
+// double quotes. The quotes themselves are removed.
+func tokenizeSearch(query string) (out []string, err error) {
+	var (
+		bld    strings.Builder
+		quoted bool
+	)
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if bld.Len() > 0 {
+				out = append(out, bld.String())
+				bld.Reset()
+			}
+		default:
+			bld.WriteRune(r)
+		}
+	}
+	if quoted {
+		err = fmt.Errorf("%w; unterminated quote in %q", errSearchUnsupported, query)
+		return
+	}
+	if bld.Len() > 0 {
+		out = append(out, bld.String())
+	}
+	return
+}
+
+func searchTermToPredicate(term string) (out *Predicate, err error) {
+	negated := strings.HasPrefix(term, "-")
+	term = strings.TrimPrefix(term, "-")
+	name, val, ok := strings.Cut(term, ":")
+	if !ok || val == "" {
+		err = fmt.Errorf("%w; %q", errSearchUnsupported, term)
+		return
+	}
+
+	switch strings.ToLower(name) {
+	case "tag", "notebook":
+		out = &Predicate{
+			KeyPath:  "tags",
+			Operator: "includes",
+			Value:    titlePredicate(val),
+		}
+	case "intitle":
+		out = &Predicate{KeyPath: "title", Operator: "includes", Value: strings.TrimSuffix(val, "*")}
+	case "created", "updated":
+		var date string
+		if date, err = searchDateToPredicateValue(val); err != nil {
+			return
+		}
+		operator := ">="
+		if negated {
+			// a negated date term means before the date, rather than a
+			// generic negation.
+			operator, negated = "<", false
+		}
+		out = &Predicate{KeyPath: strings.ToLower(name) + "_at", Operator: operator, Value: date}
+	default:
+		err = fmt.Errorf("%w; %q", errSearchUnsupported, term)
+		return
+	}
+
+	if negated {
+		out = &Predicate{Operator: "not", Value: out}
+	}
+	return
+}
+
+// titlePredicate matches a title exactly, or by prefix when the value ends in
+// a wildcard.
+func titlePredicate(val string) *Predicate {
+	if prefix, ok := strings.CutSuffix(val, "*"); ok {
+		return &Predicate{KeyPath: "title", Operator: "startsWith", Value: prefix}
+	}
+	return &Predicate{KeyPath: "title", Operator: "=", Value: val}
+}
+
+var relativeSearchDate = regexp.MustCompile(`^(?i)day(-(\d+))?$`)
+
+// absoluteSearchDateLayouts are the forms of an absolute date in the Evernote
+// search grammar: a date, optionally followed by a time in UTC.
+var absoluteSearchDateLayouts = []string{"20060102", "20060102T150405Z", "20060102T150405"}
+
+// searchDateToPredicateValue converts an absolute date, such as 20200131, or
+// a relative one, such as day-7, to a StandardNotes date value. The time of
+// an absolute date is dropped, since StandardNotes compares dates. Relative
+// weeks, months and years start on a calendar boundary in Evernote, such as
+// the start of the week, which has no equivalent, so they are unsupported.
+func searchDateToPredicateValue(val string) (out string, err error) {
+	for _, layout := range absoluteSearchDateLayouts {
+		if t, perr := time.Parse(layout, val); perr == nil {
+			out = t.Format(time.DateOnly)
+			return
+		}
+	}
+	match := relativeSearchDate.FindStringSubmatch(val)
+	if match == nil {
+		err = fmt.Errorf("%w; date %q", errSearchUnsupported, val)
+		return
+	}
+	var offset int
+	if match[2] != "" {
+		if offset, err = strconv.Atoi(match[2]); err != nil {
+			return
+		}
+	}
+	out = strconv.Itoa(offset) + ".days.ago"
+	return
+}
@@ -16,7 +16,7 @@ import (
 // https://dashboard.standardnotes.org/tools and transforms the resources. The
 // conversion file input is a flat array of items as JSON, where the content
 // type is one of a few enumerable values, such as "Note", "Tag". This function
-// groups items by content type into separate lists.
+// groups items by content type into separate lists. Smart views are left out.
 func ReadConversionFile(filename string) (notes, tags []entity.LinkID, err error) {
 	var (
 		file      *os.File
@@ -40,6 +40,8 @@ func ReadConversionFile(filename string) (notes, tags []entity.LinkID, err error
 		case ContentTypeTag:
 			item.Tag.ServiceID = &entity.ServiceID{Value: item.Tag.UUID}
 			tags = append(tags, item.Tag)
+		case ContentTypeSmartView:
+			// Smart views are only written, they aren't needed for reading.
 		default:
 			err = fmt.Errorf("%w; got: %q", errContentTypeInvalid, typ)
 			return
@@ -53,11 +55,13 @@ type contentTypeOption interface {
 }
 
 // A convfileItem helps parse an item in an input file, which contains an items
-// field. Depending on the content_type, an item is either a Note or a Tag.
+// field. Depending on the content_type, an item is either a Note, a Tag or a
+// SmartView.
 type convfileItem struct {
 	contentTypeOption
 	*Note
 	*Tag
+	*SmartView
 }
 
 var (
@@ -71,6 +75,8 @@ func (c *convfileItem) contentType() (out ContentType) {
 		out = ContentTypeNote
 	} else if c.Tag != nil {
 		out = ContentTypeTag
+	} else if c.SmartView != nil {
+		out = ContentTypeSmartView
 	}
 	return
 }
@@ -80,6 +86,8 @@ func (c *convfileItem) MarshalJSON() (data []byte, err error) {
 		data, err = json.Marshal(c.Note)
 	} else if c.Tag != nil {
 		data, err = json.Marshal(c.Tag)
+	} else if c.SmartView != nil {
+		data, err = json.Marshal(c.SmartView)
 	} else {
 		err = errContentTypeInvalid
 	}
@@ -106,6 +114,12 @@ func (c *convfileItem) UnmarshalJSON(data []byte) (err error) {
 		}
 		tag.truncateTimes(time.Second)
 		c.Tag = &tag
+	case ContentTypeSmartView:
+		var view SmartView
+		if err = json.Unmarshal(data, &view); err != nil {
+			return
+		}
+		c.SmartView = &view
 	default:
 		err = errContentTypeInvalid
 	}
@@ -202,10 +216,55 @@ func NewTag(title string, created, updated time.Time) *Tag {
 
 func (t *Tag) LinkValues() []string { return []string{t.Content.Title} }
 
+// SmartView is an item with a content type of SN|SmartView. It's a saved
+// filter over notes, described by a predicate. See
+// https://docs.standardnotes.com/usage/smart-views/ for details.
+type SmartView struct {
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	ContentType ContentType `json:"content_type"`
+	UUID        string      `json:"uuid"`
+	Content     struct {
+		Title      string      `json:"title"`
+		Predicate  *Predicate  `json:"predicate"`
+		References []Reference `json:"references"`
+	} `json:"content"`
+	*entity.ServiceID `json:"-"`
+}
+
+// NewSmartView constructs a basic *SmartView.
+func NewSmartView(title string, predicate *Predicate, created, updated time.Time) *SmartView {
+	out := SmartView{
+		CreatedAt:   created,
+		UpdatedAt:   updated,
+		ContentType: ContentTypeSmartView,
+		ServiceID:   &entity.ServiceID{Value: ""},
+	}
+	out.Content.Title = title
+	out.Content.Predicate = predicate
+	out.Content.References = make([]Reference, 0)
+	return &out
+}
+
+func (v *SmartView) LinkValues() []string { return []string{v.Content.Title} }
+
+// A Predicate is a condition on the attributes of an item. A simple predicate
+// compares the value at KeyPath to Value using Operator. A compound predicate
+// has no KeyPath, an Operator of "and", "or", "not", and a Value which is a
+// list of *Predicate.
+type Predicate struct {
+	KeyPath  string `json:"keypath,omitempty"`
+	Operator string `json:"operator"`
+	Value    any    `json:"value"`
+}
+
 // A Reference is additional metadata for associating items.
 type Reference struct {
 	UUID        string      `json:"uuid"`
 	ContentType ContentType `json:"content_type"`
+	// ReferenceType is set for some kinds of references, such as
+	// ReferenceTypeParentTag.
+	ReferenceType string `json:"reference_type,omitempty"`
 }
 
 var errContentTypeInvalid = errors.New("content_type invalid")
@@ -219,10 +278,11 @@ const (
 	ContentTypeNote
 	ContentTypeTag
 	ContentTypeNotebook
+	ContentTypeSmartView
 )
 
 func (c ContentType) String() string {
-	return [...]string{"", "Note", "Tag", "Notebook"}[c]
+	return [...]string{"", "Note", "Tag", "Notebook", "SN|SmartView"}[c]
 }
 
 func (c *ContentType) MarshalJSON() (data []byte, err error) {
@@ -242,6 +302,8 @@ func (c *ContentType) UnmarshalJSON(data []byte) (err error) {
 		*c = ContentTypeTag
 	case "Notebook", "notebook":
 		*c = ContentTypeTag
+	case "SN|SmartView":
+		*c = ContentTypeSmartView
 	default:
 		err = fmt.Errorf("%w; got %q", errContentTypeInvalid, s)
 	}
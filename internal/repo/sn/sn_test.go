@@ -1,7 +1,11 @@
 package sn_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -20,6 +24,7 @@ func TestInterfaceImplementations(t *testing.T) {
 
 	implementations = []interface{}{
 		new(sn.Note),
+		new(sn.SmartView),
 		new(sn.Tag),
 	}
 	for i, val := range implementations {
@@ -233,3 +238,573 @@ func mustPrettyPrintJSON(t *testing.T, in interface{}) {
 	}
 	t.Logf("%+v\n", string(out))
 }
+
+func TestEncoder(t *testing.T) {
+	const (
+		noteID     = "0a0a0a0a-0000-4000-8000-00000000000a"
+		notebookID = "0b0b0b0b-0000-4000-8000-00000000000b"
+	)
+	created := time.Date(2020, 3, 7, 20, 21, 56, 0, time.UTC)
+	note := &entity.Note{
+		ID:         noteID,
+		Title:      "Trip",
+		NotebookID: notebookID,
+		TagIDs:     []string{"travel"},
+		Content:    `<en-note><div>hello</div></en-note>`,
+		CreatedAt:  created,
+		UpdatedAt:  created.Add(time.Hour),
+		Versions: []*entity.NoteVersion{
+			{UpdateSequenceNum: 3, Title: "Draft", Content: `<en-note><div>draft</div></en-note>`, UpdatedAt: created},
+		},
+	}
+
+	var buf bytes.Buffer
+	var visited []entity.LinkID
+	encoder, err := sn.NewEncoder(&buf, "evernote.com", &sn.ExportParams{
+		NoteVersions: "archive",
+		OnItem:       func(item entity.LinkID) { visited = append(visited, item) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = encoder.WriteNote(note); err != nil {
+		t.Fatal(err)
+	}
+	untranslated, err := encoder.Close(
+		[]*entity.Tag{{ID: "travel", Name: "travel"}},
+		[]*entity.Notebook{{ID: notebookID, Name: "Personal", CreatedAt: created, UpdatedAt: created}},
+		[]*entity.SavedSearch{{Name: "places", Query: "tag:travel"}, {Name: "nope", Query: "todo:true"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(untranslated) != 1 || untranslated[0].Name != "nope" {
+		t.Errorf("wrong untranslated searches; got %+v", untranslated)
+	}
+
+	var out struct {
+		Items []struct {
+			ContentType string `json:"content_type"`
+			UUID        string `json:"uuid"`
+			Content     struct {
+				Title      string
+				References []struct {
+					UUID        string `json:"uuid"`
+					ContentType string `json:"content_type"`
+				}
+			}
+		}
+	}
+	if err = json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON; %v\n%s", err, buf.String())
+	}
+	expected := []struct{ contentType, title string }{
+		{"Note", "Draft"},
+		{"Note", "Trip"},
+		{"Tag", "travel"},
+		{"Notebook", "Personal"},
+		{"SN|SmartView", "places"},
+	}
+	if len(out.Items) != len(expected) || len(visited) != len(expected) {
+		t.Fatalf("wrong number of items; got %d, visited %d, expected %d", len(out.Items), len(visited), len(expected))
+	}
+	for i, item := range out.Items {
+		if item.ContentType != expected[i].contentType || item.Content.Title != expected[i].title {
+			t.Errorf("test %d; got %q %q, expected %q %q", i, item.ContentType, item.Content.Title, expected[i].contentType, expected[i].title)
+		}
+	}
+	if out.Items[1].UUID != noteID || out.Items[3].UUID != notebookID {
+		t.Errorf("expected UUIDs to be kept; got %q, %q", out.Items[1].UUID, out.Items[3].UUID)
+	}
+	tagRefs := out.Items[2].Content.References
+	if len(tagRefs) != 1 || tagRefs[0].UUID != noteID {
+		t.Errorf("wrong references from tag; got %+v", tagRefs)
+	}
+	noteRefs := out.Items[1].Content.References
+	if len(noteRefs) != 2 || noteRefs[0].UUID != out.Items[2].UUID || noteRefs[1].UUID != notebookID {
+		t.Errorf("wrong references from note; got %+v", noteRefs)
+	}
+
+	if _, err = sn.NewEncoder(&bytes.Buffer{}, "", &sn.ExportParams{NoteVersions: "nope"}); err == nil {
+		t.Error("expected an error for an invalid option")
+	}
+}
+
+func TestEncoderUUIDNamespace(t *testing.T) {
+	const guid = "0a0a0a0a-0000-4000-8000-00000000000a"
+	created := time.Date(2020, 3, 7, 20, 21, 56, 0, time.UTC)
+	encode := func(t *testing.T, namespace string) (out []string) {
+		t.Helper()
+		notes := []*entity.Note{
+			{ID: guid, Title: "Trip", Content: `<en-note>hello</en-note>`, CreatedAt: created},
+			// These look the same, as could happen in an export file.
+			{Title: "Copy", Content: `<en-note>same</en-note>`, CreatedAt: created, TagIDs: []string{"travel"}},
+			{Title: "Copy", Content: `<en-note>same</en-note>`, CreatedAt: created, TagIDs: []string{"travel"}},
+		}
+		var buf bytes.Buffer
+		encoder, err := sn.NewEncoder(&buf, "", &sn.ExportParams{UUIDNamespace: namespace})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, note := range notes {
+			if err = encoder.WriteNote(note); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tags := []*entity.Tag{{ID: "travel", Name: "travel"}}
+		searches := []*entity.SavedSearch{{Name: "places", Query: "tag:travel"}}
+		if _, err = encoder.Close(tags, nil, searches); err != nil {
+			t.Fatal(err)
+		}
+		var export struct {
+			Items []struct {
+				UUID string `json:"uuid"`
+			}
+		}
+		if err = json.Unmarshal(buf.Bytes(), &export); err != nil {
+			t.Fatalf("invalid JSON; %v\n%s", err, buf.String())
+		}
+		for _, item := range export.Items {
+			out = append(out, item.UUID)
+		}
+		return
+	}
+
+	first := encode(t, sn.DefaultUUIDNamespace)
+	if len(first) != 5 {
+		t.Fatalf("wrong number of items; got %d, expected %d", len(first), 5)
+	}
+	seen := make(map[string]bool)
+	for i, id := range first {
+		if seen[id] {
+			t.Errorf("item[%d]; duplicate UUID %q", i, id)
+		}
+		seen[id] = true
+	}
+	if first[0] == guid {
+		t.Errorf("expected UUID to be derived rather than kept")
+	}
+
+	second := encode(t, sn.DefaultUUIDNamespace)
+	for i, id := range first {
+		if second[i] != id {
+			t.Errorf("item[%d]; expected same UUID on repeat; got %q, expected %q", i, second[i], id)
+		}
+	}
+
+	other := encode(t, "6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	for i, id := range first {
+		if other[i] == id {
+			t.Errorf("item[%d]; expected different UUID with other namespace; got %q", i, id)
+		}
+	}
+
+	random := encode(t, "")
+	if random[0] != guid {
+		t.Errorf("expected UUID to be kept without a namespace; got %q", random[0])
+	}
+	if random[1] == first[1] {
+		t.Errorf("expected random UUID without a namespace")
+	}
+
+	if _, err := sn.NewEncoder(&bytes.Buffer{}, "", &sn.ExportParams{UUIDNamespace: "nope"}); err == nil {
+		t.Error("expected an error for an invalid namespace")
+	}
+}
+
+func TestEncoderCollisions(t *testing.T) {
+	tags := []*entity.Tag{{ID: "t1", Name: "Work"}}
+	notebooks := []*entity.Notebook{{ID: "nb1", Name: "work"}, {ID: "nb2", Name: "Home"}}
+	collisions := sn.FindCollisions(tags, notebooks)
+	if len(collisions) != 1 || collisions[0] != (sn.Collision{Name: "work", NotebookID: "nb1", TagID: "t1"}) {
+		t.Fatalf("wrong collisions; got %+v", collisions)
+	}
+
+	type item struct {
+		UUID        string `json:"uuid"`
+		ContentType string `json:"content_type"`
+		Content     struct {
+			Title      string         `json:"title"`
+			References []sn.Reference `json:"references"`
+		} `json:"content"`
+	}
+	encode := func(t *testing.T, policy string) (out []item) {
+		t.Helper()
+		var buf bytes.Buffer
+		encoder, err := sn.NewEncoder(&buf, "", &sn.ExportParams{
+			UUIDNamespace:   sn.DefaultUUIDNamespace,
+			Collisions:      collisions,
+			CollisionPolicy: policy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, note := range []*entity.Note{
+			{ID: "n1", Title: "both", Content: `<en-note>a</en-note>`, NotebookID: "nb1", TagIDs: []string{"t1"}},
+			{ID: "n2", Title: "notebook", Content: `<en-note>b</en-note>`, NotebookID: "nb1"},
+		} {
+			if err = encoder.WriteNote(note); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err = encoder.Close(tags, notebooks, nil); err != nil {
+			t.Fatal(err)
+		}
+		var export struct{ Items []item }
+		if err = json.Unmarshal(buf.Bytes(), &export); err != nil {
+			t.Fatalf("invalid JSON; %v\n%s", err, buf.String())
+		}
+		return export.Items
+	}
+	titles := func(items []item) (out []string) {
+		for _, item := range items {
+			out = append(out, item.ContentType+":"+item.Content.Title)
+		}
+		return
+	}
+
+	t.Run("keep", func(t *testing.T) {
+		got := titles(encode(t, ""))
+		if expected := "Note:both Note:notebook Tag:Work Notebook:work Notebook:Home"; strings.Join(got, " ") != expected {
+			t.Errorf("wrong items; got %q, expected %q", got, expected)
+		}
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		got := titles(encode(t, "prefix"))
+		if expected := "Note:both Note:notebook Tag:Work Notebook:Notebook: work Notebook:Home"; strings.Join(got, " ") != expected {
+			t.Errorf("wrong items; got %q, expected %q", got, expected)
+		}
+	})
+
+	t.Run("nest", func(t *testing.T) {
+		items := encode(t, "nest")
+		if expected := "Note:both Note:notebook Tag:Work Tag:Notebooks Notebook:work Notebook:Home"; strings.Join(titles(items), " ") != expected {
+			t.Fatalf("wrong items; got %q, expected %q", titles(items), expected)
+		}
+		parent, notebook := items[3], items[4]
+		refs := notebook.Content.References
+		if last := refs[len(refs)-1]; last.UUID != parent.UUID || last.ReferenceType != sn.ReferenceTypeParentTag {
+			t.Errorf("expected notebook tag to refer to its parent; got %+v", refs)
+		}
+		if refs := items[5].Content.References; len(refs) != 0 {
+			t.Errorf("expected other notebooks to stay as they are; got %+v", refs)
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		items := encode(t, "merge")
+		if expected := "Note:both Note:notebook Tag:Work Notebook:Home"; strings.Join(titles(items), " ") != expected {
+			t.Fatalf("wrong items; got %q, expected %q", titles(items), expected)
+		}
+		tag := items[2]
+		for _, note := range items[:2] {
+			if refs := note.Content.References; len(refs) != 1 || refs[0].UUID != tag.UUID {
+				t.Errorf("expected note %q to refer to the tag once; got %+v", note.Content.Title, refs)
+			}
+		}
+		if refs := tag.Content.References; len(refs) != 2 {
+			t.Errorf("expected tag to refer to both notes; got %+v", refs)
+		}
+	})
+
+	if _, err := sn.NewEncoder(&bytes.Buffer{}, "", &sn.ExportParams{CollisionPolicy: "nope"}); err == nil {
+		t.Error("expected an error for an invalid collision policy")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	newNote := func(uuid, title, text string, tagUUIDs ...string) *sn.Note {
+		note := &sn.Note{Item: sn.Item{UUID: uuid, ContentType: sn.ContentTypeNote}}
+		note.Content.Title, note.Content.Text = title, text
+		for _, tagUUID := range tagUUIDs {
+			note.Content.References = append(note.Content.References, sn.Reference{UUID: tagUUID, ContentType: sn.ContentTypeTag})
+		}
+		return note
+	}
+	newTag := func(uuid, title string) *sn.Tag {
+		tag := sn.NewTag(title, time.Time{}, time.Time{})
+		tag.UUID = uuid
+		return tag
+	}
+
+	existing := []entity.LinkID{
+		newNote("n1", "Trip", "hello", "t1"),
+		newNote("n2", "Same", "same"),
+		newTag("t1", "travel"),
+	}
+	diff := sn.NewDiff(existing)
+	// Changing existing items afterwards doesn't affect the diff.
+	existing[0].(*sn.Note).AppendTags("t2")
+
+	diff.Add(newNote("n1", "Trip", "hello, again", "t2"))
+	diff.Add(newNote("n2", "Same", "same"))
+	diff.Add(newTag("t2", "places"))
+	// A title that's already known is kept.
+	diff.SetTitle("t1", "ignored")
+
+	if len(diff.Created) != 1 || diff.Created[0].UUID != "t2" {
+		t.Errorf("wrong created items; got %+v", diff.Created)
+	}
+	if diff.Unchanged != 1 {
+		t.Errorf("wrong number of unchanged items; got %d, expected %d", diff.Unchanged, 1)
+	}
+	if len(diff.Changed) != 1 {
+		t.Fatalf("wrong changed items; got %+v", diff.Changed)
+	}
+	changed := diff.Changed[0]
+	if !changed.TextChanged || changed.TitleChanged {
+		t.Errorf("wrong changes; got %+v", changed)
+	}
+	if len(changed.AddedReferences) != 1 || changed.AddedReferences[0].UUID != "t2" {
+		t.Errorf("wrong added references; got %+v", changed.AddedReferences)
+	}
+	if len(changed.RemovedReferences) != 1 || changed.RemovedReferences[0].UUID != "t1" {
+		t.Errorf("wrong removed references; got %+v", changed.RemovedReferences)
+	}
+
+	var text bytes.Buffer
+	if err := diff.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	expectedText := `create Tag "places" (t2)
+change Note "Trip" (n1)
+  text changed
+  + Tag "places" (t2)
+  - Tag "travel" (t1)
+1 to create, 1 to change, 1 unchanged
+`
+	if text.String() != expectedText {
+		t.Errorf("wrong text\ngot\n%s\nexpected\n%s", text.String(), expectedText)
+	}
+
+	var data bytes.Buffer
+	if err := diff.WriteJSON(&data); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Created []struct{ UUID, Title string }
+		Changed []struct {
+			ContentType     string `json:"content_type"`
+			AddedReferences []struct {
+				UUID  string `json:"uuid"`
+				Title string `json:"title"`
+			} `json:"added_references"`
+		}
+		Unchanged int
+	}
+	if err := json.Unmarshal(data.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON; %v\n%s", err, data.String())
+	}
+	if len(out.Changed) != 1 || out.Changed[0].ContentType != "Note" || out.Changed[0].AddedReferences[0].Title != "places" {
+		t.Errorf("wrong JSON\n%s", data.String())
+	}
+
+	// A title that was empty is a change too.
+	untitled := sn.NewDiff([]entity.LinkID{newNote("n3", "", "text")})
+	untitled.Add(newNote("n3", "Named", "text"))
+	if len(untitled.Changed) != 1 || !untitled.Changed[0].TitleChanged || untitled.Unchanged != 0 {
+		t.Errorf("expected the title to change; got %+v, %d unchanged", untitled.Changed, untitled.Unchanged)
+	}
+	text.Reset()
+	if err := untitled.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), `title was ""`) {
+		t.Errorf("expected the previous title; got\n%s", text.String())
+	}
+}
+
+func TestVerify(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	source, err := entity.NewGraph(&entity.Collection{
+		Notebooks: []*entity.Notebook{{ID: "nb1", Name: "Work"}},
+		Tags:      []*entity.Tag{{ID: "t1", Name: "work"}, {ID: "t2", Name: "urgent"}},
+		Notes: []*entity.Note{
+			{ID: "n1", Title: "Plan", Content: `<en-note><div>step&nbsp;one</div><div>step two</div></en-note>`, NotebookID: "nb1", TagIDs: []string{"t1", "t2"}, CreatedAt: created, UpdatedAt: created},
+			{ID: "n2", Title: "Plan", Content: `<en-note>later</en-note>`, CreatedAt: created.Add(time.Hour), UpdatedAt: created.Add(time.Hour)},
+			{ID: "n3", Title: "Other", Content: `<en-note>other</en-note>`, CreatedAt: created, UpdatedAt: created},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	convert := func(t *testing.T, namespace string) (notes, tags []entity.LinkID) {
+		t.Helper()
+		var buf bytes.Buffer
+		collisions := sn.FindCollisions(source.Tags, source.Notebooks)
+		encoder, err := sn.NewEncoder(&buf, "", &sn.ExportParams{Collisions: collisions, CollisionPolicy: "prefix", UUIDNamespace: namespace})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, note := range source.Notes {
+			if err = encoder.WriteNote(note); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err = encoder.Close(source.Tags, source.Notebooks, []*entity.SavedSearch{{Name: "todo", Query: "tag:urgent"}}); err != nil {
+			t.Fatal(err)
+		}
+		filename := t.TempDir() + "/sn.json"
+		if err = os.WriteFile(filename, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if notes, tags, err = sn.ReadConversionFile(filename); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	t.Run("pass", func(t *testing.T) {
+		notes, tags := convert(t, "")
+		got, err := sn.Verify(source, notes, tags, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Passed() || got.Matched != 3 {
+			t.Errorf("expected verification to pass; got %+v", got)
+		}
+		var text bytes.Buffer
+		if err := got.WriteText(&text); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(text.String(), "PASS: 3 source notes") {
+			t.Errorf("wrong text; got %q", text.String())
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		notes, tags := convert(t, "")
+		for _, item := range notes {
+			note := item.(*sn.Note)
+			switch {
+			case note.Content.Title == "Plan" && note.CreatedAt.Equal(created):
+				note.Content.Text = "step one"
+			case note.Content.Title == "Plan":
+				note.UpdatedAt = note.UpdatedAt.Add(time.Minute)
+			case note.Content.Title == "Other":
+				note.Content.Title = "Renamed"
+			}
+		}
+		for _, item := range tags {
+			if tag := item.(*sn.Tag); tag.Content.Title == "urgent" {
+				tag.Content.Title = "someday"
+			}
+		}
+		got, err := sn.Verify(source, notes, tags, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Passed() {
+			t.Fatal("expected verification to fail")
+		}
+		if len(got.Missing) != 1 || got.Missing[0].ID != "n3" {
+			t.Errorf("wrong missing notes; got %+v", got.Missing)
+		}
+		if len(got.Extra) != 1 || got.Extra[0].Title != "Renamed" {
+			t.Errorf("wrong extra notes; got %+v", got.Extra)
+		}
+		var fields []string
+		for _, d := range got.Discrepancies {
+			fields = append(fields, d.SourceID+":"+d.Field)
+		}
+		if expected := "n1:text n1:memberships n2:updated_at"; strings.Join(fields, " ") != expected {
+			t.Errorf("wrong discrepancies; got %q, expected %q", fields, expected)
+		}
+
+		var data bytes.Buffer
+		if err := got.WriteJSON(&data); err != nil {
+			t.Fatal(err)
+		}
+		var out struct{ Discrepancies []sn.Discrepancy }
+		if err := json.Unmarshal(data.Bytes(), &out); err != nil || len(out.Discrepancies) != 3 {
+			t.Errorf("wrong JSON; %v\n%s", err, data.String())
+		}
+	})
+
+	t.Run("by UUID", func(t *testing.T) {
+		notes, tags := convert(t, sn.DefaultUUIDNamespace)
+		for _, item := range notes {
+			if note := item.(*sn.Note); note.Content.Title == "Other" {
+				note.Content.Title = "Renamed"
+				note.CreatedAt = note.CreatedAt.Add(time.Hour)
+			}
+		}
+		got, err := sn.Verify(source, notes, tags, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Missing) != 1 || len(got.Extra) != 1 {
+			t.Errorf("expected the renamed note to be unpaired without the namespace; got %+v", got)
+		}
+
+		if got, err = sn.Verify(source, notes, tags, &sn.ExportParams{UUIDNamespace: sn.DefaultUUIDNamespace}); err != nil {
+			t.Fatal(err)
+		}
+		var fields []string
+		for _, d := range got.Discrepancies {
+			fields = append(fields, d.SourceID+":"+d.Field)
+		}
+		if expected := "n3:title n3:created_at"; got.Matched != 3 || strings.Join(fields, " ") != expected {
+			t.Errorf("expected the notes to be paired by UUID; got %d matched, discrepancies %q, expected %q", got.Matched, fields, expected)
+		}
+
+		if _, err = sn.Verify(source, notes, tags, &sn.ExportParams{UUIDNamespace: "nope"}); err == nil {
+			t.Error("expected an error for an invalid namespace")
+		}
+	})
+}
+
+func TestFindLossyFeatures(t *testing.T) {
+	reminder := time.Date(2020, 4, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		note     entity.Note
+		expected string
+	}{
+		{
+			name:     "plain",
+			note:     entity.Note{Content: `<en-note><div>plain</div><table><tr><td>x</td></tr></table></en-note>`},
+			expected: "",
+		},
+		{
+			name: "attachments",
+			note: entity.Note{
+				Content: `<en-note><en-media type="image/png" hash="aa"/><en-media type="application/vnd.evernote.ink" hash="bb"/></en-note>`,
+				Attachments: []*entity.Attachment{
+					{MIME: "image/png"}, {MIME: "application/pdf"}, {MIME: "application/vnd.evernote.ink"},
+				},
+			},
+			expected: "attachment:2 ink:1",
+		},
+		{
+			name:     "tasks",
+			note:     entity.Note{Content: `<en-note><div style="--en-task-group: true; --en-id: x;"></div><en-todo/><en-todo checked="true"/></en-note>`},
+			expected: "todo:2 task:1",
+		},
+		{
+			name:     "merged cells",
+			note:     entity.Note{Content: `<en-note><table><tr><td colspan="2">x</td></tr></table><table><tr><td rowspan="1">y</td></tr></table></en-note>`},
+			expected: "table:1",
+		},
+		{
+			name:     "reminder",
+			note:     entity.Note{Content: `<en-note><en-crypt>abc=</en-crypt></en-note>`, Attributes: &entity.Attributes{ReminderOrder: 1, ReminderTime: &reminder}},
+			expected: "encryption:1 reminder:1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, feature := range sn.FindLossyFeatures(&test.note) {
+				got = append(got, fmt.Sprintf("%s:%d", feature.Name, feature.Count))
+				if feature.Detail == "" {
+					t.Errorf("expected a detail for %q", feature.Name)
+				}
+			}
+			if strings.Join(got, " ") != test.expected {
+				t.Errorf("wrong features; got %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package sn
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rafaelespinoza/notexfr/internal/enml"
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+)
+
+// A Verification is the outcome of checking converted StandardNotes data
+// against the source it came from. Each source note should have exactly one
+// converted note, with the same text, tags, notebook and timestamps.
+type Verification struct {
+	// SourceNotes, ConvertedNotes are the number of notes on each side.
+	// Archived notes, which are earlier revisions, aren't counted.
+	SourceNotes    int `json:"source_notes"`
+	ConvertedNotes int `json:"converted_notes"`
+	Matched        int `json:"matched"`
+	// Missing are source notes without a converted note.
+	Missing []VerifiedNote `json:"missing"`
+	// Extra are converted notes without a source note.
+	Extra         []VerifiedNote `json:"extra"`
+	Discrepancies []Discrepancy  `json:"discrepancies"`
+}
+
+// A VerifiedNote identifies a note on either side of a Verification. The ID is
+// empty for source notes that don't have one, such as from an ENEX file.
+type VerifiedNote struct {
+	ID        string    `json:"id,omitempty"`
+	UUID      string    `json:"uuid,omitempty"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A Discrepancy is a difference in one field of a source note and its
+// converted note. The Field is one of "title", "text", "memberships",
+// "created_at", "updated_at". For text, the values are hashes of the normalized text.
+type Discrepancy struct {
+	SourceID string `json:"source_id,omitempty"`
+	UUID     string `json:"uuid"`
+	Title    string `json:"title"`
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// Passed tells whether every note has a counterpart, without discrepancies.
+func (v *Verification) Passed() bool {
+	return len(v.Missing) == 0 && len(v.Extra) == 0 && len(v.Discrepancies) == 0
+}
+
+// Verify checks converted notes and tags, such as from ReadConversionFile,
+// against the source Graph. The params should be those of the conversion. A
+// note is paired with the converted note of the UUID that it was given, when
+// the UUID can be known: it's in the Ledger, it's derived from the
+// UUIDNamespace, or it's the ID of the note. The rest are paired by title and
+// creation time, then by title alone when there are as many notes with the
+// title on each side, in which case the creation time is a discrepancy.
+//
+// Memberships are the names of tags and the notebook, ignoring case. A
+// notebook tag may start with NotebookPrefix. Text is compared after removing
+// markup and collapsing whitespace, and timestamps are compared to the
+// second. The Keyring decrypts the source notes as it would for a conversion.
+// The params may be nil. The error is from invalid params.
+func Verify(source *entity.Graph, notes, tags []entity.LinkID, params *ExportParams) (out *Verification, err error) {
+	conv, err := newExporter(source.Service, params, nil)
+	if err != nil {
+		return
+	}
+	out = &Verification{
+		SourceNotes:   len(source.Notes),
+		Missing:       make([]VerifiedNote, 0),
+		Extra:         make([]VerifiedNote, 0),
+		Discrepancies: make([]Discrepancy, 0),
+	}
+
+	converted := make([]*Note, 0, len(notes))
+	for _, item := range notes {
+		if note := item.(*Note); !IsArchived(note) {
+			converted = append(converted, note)
+		}
+	}
+	out.ConvertedNotes = len(converted)
+	// The collection resolves references in either direction, from a note to
+	// a tag or from a tag to a note.
+	collection := NewCollection(notes, tags)
+	tagNames := make(map[string]string, len(collection.Tags))
+	for _, tag := range collection.Tags {
+		tagNames[tag.ID] = tag.Name
+	}
+	tagIDs := make(map[string][]string, len(collection.Notes))
+	for _, note := range collection.Notes {
+		tagIDs[note.ID] = note.TagIDs
+	}
+
+	pairs, sources, converted := pairNotesByUUID(conv, source.Notes, converted)
+	byTime, sources, converted := pairNotes(sources, converted, func(note *entity.Note) string {
+		return note.Title + "\x00" + note.CreatedAt.UTC().Truncate(time.Second).Format(time.RFC3339)
+	}, func(note *Note) string {
+		return note.Content.Title + "\x00" + note.CreatedAt.UTC().Truncate(time.Second).Format(time.RFC3339)
+	})
+	pairs = append(pairs, byTime...)
+	byTitle, sources, converted := pairNotes(sources, converted, func(note *entity.Note) string {
+		return note.Title
+	}, func(note *Note) string {
+		return note.Content.Title
+	})
+	pairs = append(pairs, byTitle...)
+	out.Matched = len(pairs)
+
+	for _, note := range sources {
+		out.Missing = append(out.Missing, VerifiedNote{ID: note.ID, Title: note.Title, CreatedAt: note.CreatedAt})
+	}
+	for _, note := range converted {
+		out.Extra = append(out.Extra, VerifiedNote{UUID: note.UUID, Title: note.Content.Title, CreatedAt: note.CreatedAt})
+	}
+	for _, pair := range pairs {
+		memberships := make([]string, 0)
+		for _, id := range tagIDs[pair.converted.UUID] {
+			if name, ok := tagNames[id]; ok {
+				memberships = append(memberships, name)
+			}
+		}
+		out.Discrepancies = append(out.Discrepancies, compareNotes(source, pair.source, pair.converted, memberships, conv.keyring)...)
+	}
+	return
+}
+
+type notePair struct {
+	source    *entity.Note
+	converted *Note
+}
+
+// pairNotesByUUID pairs notes by the UUID that each source note was given in
+// the conversion, if it can be known. The notes that aren't paired are left
+// over, in order.
+func pairNotesByUUID(conv *exporter, sources []*entity.Note, converted []*Note) (pairs []notePair, leftSources []*entity.Note, leftConverted []*Note) {
+	convertedByUUID := make(map[string]*Note, len(converted))
+	for _, note := range converted {
+		convertedByUUID[note.UUID] = note
+	}
+	for _, source := range sources {
+		uuid, ok := conv.knownUUID(ContentTypeNote, conv.sourceID(source))
+		if note := convertedByUUID[uuid]; ok && note != nil {
+			pairs = append(pairs, notePair{source: source, converted: note})
+			delete(convertedByUUID, uuid)
+		} else {
+			leftSources = append(leftSources, source)
+		}
+	}
+	for _, note := range converted {
+		if _, ok := convertedByUUID[note.UUID]; ok {
+			leftConverted = append(leftConverted, note)
+		}
+	}
+	return
+}
+
+// pairNotes pairs notes with the same key, in order, when there are as many of
+// them on each side. The notes that aren't paired are left over.
+func pairNotes(sources []*entity.Note, converted []*Note, sourceKey func(*entity.Note) string, convertedKey func(*Note) string) (pairs []notePair, leftSources []*entity.Note, leftConverted []*Note) {
+	sourcesByKey := make(map[string][]*entity.Note)
+	for _, note := range sources {
+		key := sourceKey(note)
+		sourcesByKey[key] = append(sourcesByKey[key], note)
+	}
+	convertedByKey := make(map[string][]*Note)
+	for _, note := range converted {
+		key := convertedKey(note)
+		convertedByKey[key] = append(convertedByKey[key], note)
+	}
+	paired := make(map[string]bool)
+	for _, note := range sources {
+		key := sourceKey(note)
+		if paired[key] || len(sourcesByKey[key]) != len(convertedByKey[key]) {
+			continue
+		}
+		paired[key] = true
+		for i, source := range sourcesByKey[key] {
+			pairs = append(pairs, notePair{source: source, converted: convertedByKey[key][i]})
+		}
+	}
+	for _, note := range sources {
+		if !paired[sourceKey(note)] {
+			leftSources = append(leftSources, note)
+		}
+	}
+	for _, note := range converted {
+		if !paired[convertedKey(note)] {
+			leftConverted = append(leftConverted, note)
+		}
+	}
+	return
+}
+
+func compareNotes(source *entity.Graph, expected *entity.Note, actual *Note, memberships []string, keyring *enml.Keyring) (out []Discrepancy) {
+	add := func(field, exp, act string) {
+		out = append(out, Discrepancy{
+			SourceID: expected.ID,
+			UUID:     actual.UUID,
+			Title:    expected.Title,
+			Field:    field,
+			Expected: exp,
+			Actual:   act,
+		})
+	}
+
+	// Notes paired by UUID may have different titles.
+	if expected.Title != actual.Content.Title {
+		add("title", expected.Title, actual.Content.Title)
+	}
+
+	// The text may be in any of NoteTextFormats. A source note that can't be
+	// read as ENML is compared by its raw content, which is bound to differ.
+	content, _ := keyring.Decrypt(expected.Content)
+	var exp string
+	act := textHash(actual.Content.Text)
+	for _, format := range NoteTextFormats {
+		text, err := noteText(content, format)
+		if err != nil {
+			text = content
+		}
+		if hash := textHash(text); exp == "" || hash == act {
+			exp = hash
+		}
+	}
+	if exp != act {
+		add("text", exp, act)
+	}
+
+	var notebook string
+	if nb := source.Notebook(expected.NotebookID); nb != nil {
+		notebook = nb.Name
+	}
+	var tags []string
+	for _, tag := range source.NoteTags(expected) {
+		tags = append(tags, tag.Name)
+	}
+	if exp, act, ok := compareMemberships(tags, notebook, memberships); !ok {
+		add("memberships", exp, act)
+	}
+
+	for _, field := range []struct {
+		name     string
+		exp, act time.Time
+	}{
+		{"created_at", expected.CreatedAt, actual.CreatedAt},
+		{"updated_at", expected.UpdatedAt, actual.UpdatedAt},
+	} {
+		exp, act := field.exp.UTC().Truncate(time.Second), field.act.UTC().Truncate(time.Second)
+		if !exp.Equal(act) {
+			add(field.name, exp.Format(time.RFC3339), act.Format(time.RFC3339))
+		}
+	}
+	return
+}
+
+// compareMemberships checks that the names of the converted tags are the names
+// of the source tags and notebook. The outputs are the sorted, lowercase names
+// on each side.
+func compareMemberships(tags []string, notebook string, converted []string) (expected, actual string, ok bool) {
+	unmatched := make(map[string]bool, len(converted))
+	for _, name := range converted {
+		unmatched[strings.ToLower(name)] = true
+	}
+	actualNames := slices.Sorted(maps.Keys(unmatched))
+
+	ok = true
+	expectedNames := make([]string, 0, len(tags)+1)
+	for _, name := range tags {
+		name = strings.ToLower(name)
+		expectedNames = append(expectedNames, name)
+		ok = ok && unmatched[name]
+		delete(unmatched, name)
+	}
+	if notebook != "" {
+		name, prefixed := strings.ToLower(notebook), strings.ToLower(NotebookPrefix+notebook)
+		expectedNames = append(expectedNames, name)
+		if !unmatched[name] && !unmatched[prefixed] && !slices.Contains(expectedNames[:len(expectedNames)-1], name) {
+			ok = false
+		}
+		delete(unmatched, name)
+		delete(unmatched, prefixed)
+	}
+	ok = ok && len(unmatched) == 0
+
+	slices.Sort(expectedNames)
+	expected, actual = strings.Join(slices.Compact(expectedNames), ", "), strings.Join(actualNames, ", ")
+	return
+}
+
+var (
+	markupPattern     = regexp.MustCompile(`<[^>]*>`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+)
+
+// textHash is a hash of text without markup, with whitespace collapsed, so
+// that differences in formatting aren't counted.
+func textHash(text string) string {
+	text = markupPattern.ReplaceAllString(text, " ")
+	text = html.UnescapeString(text)
+	text = strings.TrimSpace(whitespacePattern.ReplaceAllString(text, " "))
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsArchived tells whether a note is hidden in StandardNotes, such as an
+// earlier revision written with the "archive" note versions option.
+func IsArchived(note *Note) bool {
+	switch data := note.Content.AppData["org.standardnotes.sn"].(type) {
+	case *AppData:
+		return data.Archived
+	case map[string]any:
+		archived, _ := data["archived"].(bool)
+		return archived
+	}
+	return false
+}
+
+// WriteJSON writes the Verification as JSON.
+func (v *Verification) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// WriteText writes the Verification for people to read, one note per line,
+// followed by the discrepancies of matched notes and a line that says whether
+// it passed.
+func (v *Verification) WriteText(w io.Writer) (err error) {
+	for _, note := range v.Missing {
+		if _, err = fmt.Fprintf(w, "missing %q (%s)\n", note.Title, fmtTime(note.CreatedAt)); err != nil {
+			return
+		}
+	}
+	for _, note := range v.Extra {
+		if _, err = fmt.Fprintf(w, "extra %q (%s)\n", note.Title, note.UUID); err != nil {
+			return
+		}
+	}
+	for _, d := range v.Discrepancies {
+		if _, err = fmt.Fprintf(w, "mismatch %q (%s) %s\n  expected %s\n  actual   %s\n", d.Title, d.UUID, d.Field, d.Expected, d.Actual); err != nil {
+			return
+		}
+	}
+	result := "PASS"
+	if !v.Passed() {
+		result = "FAIL"
+	}
+	_, err = fmt.Fprintf(w, "%s: %d source notes, %d converted, %d matched, %d missing, %d extra, %d discrepancies\n",
+		result, v.SourceNotes, v.ConvertedNotes, v.Matched, len(v.Missing), len(v.Extra), len(v.Discrepancies))
+	return
+}
@@ -0,0 +1,115 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+
+	"github.com/rafaelespinoza/notexfr/internal/entity"
+	"github.com/rafaelespinoza/notexfr/internal/progress"
+)
+
+// StreamLocalFile is like ReadLocalFile, but yields resources one at a time.
+// The file is opened when the sequence is iterated, and closed when it stops.
+// Whether to go on after an error is up to the repository. The progress of the
+// read stage is tracked by the bytes read.
+func StreamLocalFile(ctx context.Context, repository entity.RepoLocalStream, filename string) iter.Seq2[entity.LinkID, error] {
+	return func(yield func(entity.LinkID, error) bool) {
+		file, err := os.Open(filepath.Clean(filename))
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer func() { _ = file.Close() }()
+		var r io.Reader = file
+		if info, serr := file.Stat(); serr == nil {
+			r = progress.Reader(progress.Read, file, info.Size())
+		}
+		for item, err := range repository.StreamLocal(ctx, r) {
+			if err == nil {
+				progress.Add(progress.Read, progress.TypeName(item), 1)
+			}
+			if !yield(item, err) {
+				return
+			}
+		}
+	}
+}
+
+// DecodeArray parses a JSON array one element at a time, in order, so that only
+// one element is in memory at once. The sequence stops after yielding an
+// error.
+func DecodeArray[T any](r io.Reader) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		decoder := json.NewDecoder(r)
+		if tok, err := decoder.Token(); err != nil {
+			yield(zero, err)
+			return
+		} else if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			yield(zero, fmt.Errorf("expected start of JSON array; got %v", tok))
+			return
+		}
+		for decoder.More() {
+			var elem T
+			if err := decoder.Decode(&elem); err != nil {
+				yield(zero, err)
+				return
+			}
+			if !yield(elem, nil) {
+				return
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+// An ArrayEncoder writes a JSON array one element at a time, so that only one
+// element is in memory at once. The output is the same as marshaling the whole
+// array. Call Close to end the array, even if there are no elements.
+type ArrayEncoder struct {
+	w     io.Writer
+	count int
+}
+
+// NewArrayEncoder constructs an ArrayEncoder that writes to w.
+func NewArrayEncoder(w io.Writer) *ArrayEncoder { return &ArrayEncoder{w: w} }
+
+// Encode writes the next element of the array.
+func (e *ArrayEncoder) Encode(elem any) (err error) {
+	data, err := json.Marshal(elem)
+	if err != nil {
+		return
+	}
+	sep := ","
+	if e.count == 0 {
+		sep = "["
+	}
+	if _, err = io.WriteString(e.w, sep); err != nil {
+		return
+	}
+	if _, err = e.w.Write(data); err != nil {
+		return
+	}
+	e.count++
+	return
+}
+
+// Count is the number of elements written so far.
+func (e *ArrayEncoder) Count() int { return e.count }
+
+// Close ends the array.
+func (e *ArrayEncoder) Close() (err error) {
+	end := "]"
+	if e.count == 0 {
+		end = "[]"
+	}
+	_, err = io.WriteString(e.w, end)
+	return
+}
@@ -14,6 +14,6 @@ func main() {
 	err := cmd.New().ExecuteContext(context.Background())
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }